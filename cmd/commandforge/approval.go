@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/config"
+)
+
+// approvalPolicy builds the tool approval policy from the configuration
+func approvalPolicy(cfg *config.Config) (*agent.ApprovalPolicy, error) {
+	defaultAction := agent.ApprovalAllow
+	if cfg.Approval.Default != "" {
+		action, err := agent.ParseApprovalAction(cfg.Approval.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approval default: %w", err)
+		}
+		defaultAction = action
+	}
+
+	rules := agent.DefaultApprovalRules()
+	if len(cfg.Approval.Rules) > 0 {
+		rules = make([]agent.ApprovalRule, 0, len(cfg.Approval.Rules))
+		for _, rule := range cfg.Approval.Rules {
+			rules = append(rules, agent.ApprovalRule{
+				Action:            agent.ApprovalAction(rule.Action),
+				Tool:              rule.Tool,
+				Agent:             rule.Agent,
+				Pattern:           rule.Pattern,
+				OutsideWorkingDir: rule.OutsideWorkingDir,
+				Reason:            rule.Reason,
+			})
+		}
+	}
+
+	return agent.NewApprovalPolicy(rules, defaultAction, cfg.WorkingDir)
+}
+
+// terminalApprover asks the user on the terminal whether a tool call may run
+func terminalApprover() agent.Approver {
+	return agent.ApproverFunc(func(ctx context.Context, request agent.ApprovalRequest) (agent.ApprovalDecision, error) {
+		arguments, err := json.MarshalIndent(request.Arguments, "  ", "  ")
+		if err != nil {
+			arguments = []byte(fmt.Sprintf("%v", request.Arguments))
+		}
+
+		fmt.Println()
+		fmt.Println(color.YellowString("Approval required: %s", request.Reason))
+		fmt.Printf("  Tool: %s\n", request.Tool)
+		fmt.Printf("  Arguments: %s\n", arguments)
+		fmt.Print(color.YellowString("Allow this call? [y/N] "))
+
+		answer, err := readLine()
+		if err != nil {
+			return agent.ApprovalDecision{}, err
+		}
+
+		switch strings.ToLower(answer) {
+		case "y", "yes":
+			return agent.ApprovalDecision{Approved: true}, nil
+		default:
+			return agent.ApprovalDecision{Approved: false, Reason: "rejected by the user"}, nil
+		}
+	})
+}
+
+// readLine reads a line from stdin a byte at a time, so that no input is
+// buffered away from the prompt loops
+func readLine() (string, error) {
+	var line []byte
+	buffer := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if n > 0 {
+			if buffer[0] == '\n' {
+				break
+			}
+			line = append(line, buffer[0])
+		}
+		if err != nil {
+			if len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+
+	return strings.TrimSpace(string(line)), nil
+}
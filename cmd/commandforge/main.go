@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -33,6 +34,11 @@ func main() {
 	serverURL := flag.String("server-url", "http://localhost:8080", "URL of the API server")
 	verbose := flag.Bool("verbose", false, "Enable verbose logging")
 	reactMode := flag.Bool("react", false, "Use ReAct agent instead of standard agent")
+	streamMode := flag.Bool("stream", false, "Stream assistant output as it is generated (uses the CommandForge agent)")
+	listModels := flag.Bool("list-models", false, "List the models available from the configured LLM provider and exit")
+	serverAPIKey := flag.String("api-key", os.Getenv("COMMANDFORGE_API_KEY"), "API key for the API server in client mode")
+	resumeID := flag.String("resume", "", "Resume the flow with this ID where it stopped, on the API server in client mode")
+	streamTransport := flag.String("stream-transport", "websocket", "How to stream command output in client mode: websocket or sse")
 	flag.Parse()
 
 	// Enable verbose logging if requested
@@ -63,104 +69,169 @@ func main() {
 		log.Fatalf("Failed to create working directory: %v", err)
 	}
 
+	// Ensure state directory exists, out of reach of sandboxed commands
+	if err := checkStateDir(cfg); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := os.MkdirAll(cfg.StateDir, 0o700); err != nil {
+		log.Fatalf("Failed to create state directory: %v", err)
+	}
+
 	// Determine client type
 	clientType := cfg.LLMProvider
-	if clientType != "openai" && clientType != "deepseek" {
-		log.Fatalf("Client type not specified or invalid. Please set it to 'openai' or 'deepseek' in the config file.")
+	if _, ok := llmProviders[clientType]; !ok {
+		log.Fatalf("Client type not specified or invalid. Please set it to 'openai', 'deepseek', 'anthropic', 'ollama' or 'local' in the config file.")
 	}
 
 	// Check for API key based on client type
-	var apiKey string
-	if clientType == "openai" {
-		apiKey, ok := cfg.APIKeys["openai"]
-		if !ok || apiKey == "" {
-			apiKey = os.Getenv("OPENAI_API_KEY")
-			if apiKey == "" {
-				log.Fatalf("OpenAI API key not found. Please set it in the config file or OPENAI_API_KEY environment variable")
-			}
-			if cfg.APIKeys == nil {
-				cfg.APIKeys = make(map[string]string)
-			}
-			cfg.APIKeys["openai"] = apiKey
-			config.SaveConfig(cfg, *configPath)
-		}
-	} else if clientType == "deepseek" {
-		apiKey, ok := cfg.APIKeys["deepseek"]
-		if !ok || apiKey == "" {
-			apiKey = os.Getenv("DEEPSEEK_API_KEY")
-			if apiKey == "" {
-				log.Fatalf("DeepSeek API key not found. Please set it in the config file or DEEPSEEK_API_KEY environment variable")
-			}
-			if cfg.APIKeys == nil {
-				cfg.APIKeys = make(map[string]string)
-			}
-			cfg.APIKeys["deepseek"] = apiKey
-			config.SaveConfig(cfg, *configPath)
-		}
+	apiKey, err := resolveAPIKey(cfg, *configPath, clientType)
+	if err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	// Initialize memory
-	memoryPath := filepath.Join(cfg.WorkingDir, "memory")
+	memoryPath := filepath.Join(cfg.StateDir, "memory")
 	mem, err := memory.NewFileMemory(memoryPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize memory: %v", err)
 	}
 
 	// Initialize LLM client
-	var llmClient llm.Client
-	if clientType == "openai" {
-		llmClient = llm.NewOpenAIClient(apiKey, "gpt-4o-mini")
-	} else if clientType == "deepseek" {
-		llmClient = llm.NewDeepSeekClient(apiKey, "deepseek-chat")
+	llmClient, err := newLLMClient(clientType, apiKey, cfg.Providers[clientType])
+	if err != nil {
+		log.Fatalf("Failed to create LLM client: %v", err)
 	}
+
 	// Create context
 	ctx := context.Background()
 
+	// List the provider's models if requested
+	if *listModels {
+		lister, ok := llmClient.(llm.ModelLister)
+		if !ok {
+			log.Fatalf("Provider %s does not support model discovery", clientType)
+		}
+		models, err := lister.ListModels(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list models: %v", err)
+		}
+		for _, model := range models {
+			fmt.Println(model)
+		}
+		return
+	}
+
+	// Add retries, circuit breaking and failover around the provider
+	llmClient = buildLLMClient(cfg, *configPath, llmClient)
+
+	// Select where commands run; commands created without a backend of
+	// their own use it too
+	backend, err := executor.NewBackend(cfg.Execution.Backend, sandboxConfig(cfg))
+	if err != nil {
+		log.Fatalf("Failed to create execution backend: %v", err)
+	}
+	executor.SetDefaultBackend(backend)
+
+	// Keep finished background commands within limits, and across restarts
+	executor.SetRetentionPolicy(executor.RetentionPolicy{
+		MaxCommands: cfg.Execution.Commands.MaxCommands,
+		MaxAge:      time.Duration(cfg.Execution.Commands.MaxAgeMinutes) * time.Minute,
+	})
+	if cfg.Execution.Commands.Persist {
+		store, err := executor.NewFileCommandStore(filepath.Join(cfg.StateDir, "commands"))
+		if err != nil {
+			log.Fatalf("Failed to create command store: %v", err)
+		}
+		if err := executor.PersistCommands(store); err != nil {
+			log.Printf("Warning: Failed to recover background commands: %v", err)
+		}
+	}
+
+	// Build the policy that decides which tool calls need approval
+	policy, err := approvalPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create approval policy: %v", err)
+	}
+
+	// Ask on the terminal in interactive mode; elsewhere calls that need
+	// approval are denied
+	var approver agent.Approver
+	if *interactive {
+		approver = terminalApprover()
+	}
+
 	// Run the application in the appropriate mode
 	if *serverMode {
 		// Run as API server
-		runServer(ctx, llmClient, mem, cfg.WorkingDir, *serverAddr)
+		runServer(ctx, llmClient, mem, cfg, *serverAddr, policy, backend)
 	} else if *clientMode {
 		// Run as API client
-		runClient(*serverURL, *interactive, *query)
+		runClient(*serverURL, *serverAPIKey, *streamTransport, *interactive, *query, *resumeID)
+	} else if *resumeID != "" {
+		// Resume a flow of the API server locally
+		flowManager := newFlowManager(ctx, llmClient, mem, cfg, policy, backend)
+		resumeFlow(ctx, flowManager, *resumeID, approver)
 	} else {
 		// Run the agent locally
 		if *reactMode {
 			// Use ReAct agent
-			runReActAgent(ctx, llmClient, mem, cfg.WorkingDir, *interactive, *query, cfg)
+			runReActAgent(ctx, llmClient, mem, cfg.WorkingDir, *interactive, *query, cfg, policy.Hook(approver), backend)
 		} else {
-			// Use standard Forge agent
-			// Create agent
-			forgeAgent := agent.NewForgeAgent("CommandForge", llmClient, mem)
+			var localAgent interactiveAgent
+			if *streamMode {
+				// Use the CommandForge agent with streamed assistant output
+				localAgent = agent.NewCommandForgeAgent("CommandForge", llmClient, mem).
+					WithStreamingEnabled(true).
+					WithStreamHandler(func(delta string) {
+						fmt.Print(delta)
+					})
+			} else {
+				// Use standard Forge agent
+				localAgent = agent.NewForgeAgent("CommandForge", llmClient, mem)
+			}
 
 			// Add tools
-			addTools(forgeAgent, cfg.WorkingDir, cfg)
+			addTools(localAgent, cfg.WorkingDir, cfg, backend)
+
+			// Apply the approval policy to tool calls
+			localAgent.AddHook(policy.Hook(approver))
 
 			// Initialize agent
-			if err := forgeAgent.Initialize(ctx); err != nil {
+			if err := localAgent.Initialize(ctx); err != nil {
 				log.Fatalf("Failed to initialize agent: %v", err)
 			}
 
 			// Run in interactive or query mode
 			if *interactive {
-				runInteractive(ctx, forgeAgent)
+				runInteractive(ctx, localAgent, *streamMode, usageLedger(llmClient))
 			} else if *query != "" {
-				runQuery(ctx, forgeAgent, *query)
+				runQuery(ctx, localAgent, *query, *streamMode)
 			} else {
 				fmt.Println("Please provide a query with -query flag or use -interactive mode")
 			}
+
+			// Close the agent's shell sessions
+			localAgent.Stop(ctx)
 		}
 	}
 }
 
+// interactiveAgent is an agent that can be given tools and run from the CLI
+type interactiveAgent interface {
+	agent.Agent
+	AddTool(tool tools.Tool) error
+	GetConversationHistory() []llm.Message
+	AddHook(hook agent.Hook)
+}
+
 // addTools adds tools to the agent
-func addTools(forgeAgent *agent.ForgeAgent, workingDir string, cfg *config.Config) {
+func addTools(forgeAgent interactiveAgent, workingDir string, cfg *config.Config, backend executor.Backend) {
 	// Add bash tool
-	bashTool := tools.NewBashTool(workingDir)
+	bashTool := tools.NewBashTool(workingDir).WithBackend(backend)
 	forgeAgent.AddTool(bashTool)
 
 	// Add Python tool
-	pythonTool := tools.NewPythonTool(workingDir)
+	pythonTool := tools.NewPythonTool(workingDir).WithBackend(backend)
 	forgeAgent.AddTool(pythonTool)
 
 	// Add file tool
@@ -171,10 +242,18 @@ func addTools(forgeAgent *agent.ForgeAgent, workingDir string, cfg *config.Confi
 	commandStatusTool := tools.NewCommandStatusTool()
 	forgeAgent.AddTool(commandStatusTool)
 
+	// Add command control tool
+	commandControlTool := tools.NewCommandControlTool()
+	forgeAgent.AddTool(commandControlTool)
+
 	// Add list commands tool
 	listCommandsTool := tools.NewListCommandsTool()
 	forgeAgent.AddTool(listCommandsTool)
 
+	// Add shell session tool
+	shellSessionTool := tools.NewShellSessionTool(workingDir).WithBackend(backend)
+	forgeAgent.AddTool(shellSessionTool)
+
 	// Add web search tool
 	// Check if Tavily API key is available
 	if tavilyAPIKey, ok := cfg.APIKeys["tavily"]; ok && tavilyAPIKey != "" {
@@ -195,11 +274,13 @@ func addTools(forgeAgent *agent.ForgeAgent, workingDir string, cfg *config.Confi
 }
 
 // runInteractive runs the agent in interactive mode
-func runInteractive(ctx context.Context, forgeAgent *agent.ForgeAgent) {
+func runInteractive(ctx context.Context, forgeAgent interactiveAgent, streamed bool, ledger *llm.Ledger) {
 	fmt.Println("Welcome to CommandForge!")
-	fmt.Println("Type 'exit' or 'quit' to exit")
+	fmt.Println("Type 'exit' or 'quit' to exit, or '/usage' to show token usage and cost")
 	fmt.Println()
 
+	lastRunID := ""
+
 	for {
 		// Get user input
 		fmt.Print("> ")
@@ -222,16 +303,27 @@ func runInteractive(ctx context.Context, forgeAgent *agent.ForgeAgent) {
 			continue
 		}
 
+		// Show usage for the session
+		if input == "/usage" {
+			printUsage(ledger, lastRunID)
+			continue
+		}
+
 		// Process the input
 		response, err := forgeAgent.Run(ctx, &agent.Request{Input: input})
 		if err != nil {
 			fmt.Printf("Error: %v\n", err)
 			continue
 		}
+		if runID, ok := response.Metadata["run_id"].(string); ok {
+			lastRunID = runID
+		}
 
-		// Display the response
+		// Display the response; streamed output has already been printed
 		if !response.Success {
 			fmt.Printf("Error: %s\n", response.Error)
+		} else if streamed {
+			fmt.Println()
 		} else {
 			fmt.Println(response.Output)
 		}
@@ -239,8 +331,92 @@ func runInteractive(ctx context.Context, forgeAgent *agent.ForgeAgent) {
 	}
 }
 
+// sandboxConfig converts the sandbox settings of the configuration
+func sandboxConfig(cfg *config.Config) executor.SandboxConfig {
+	sandbox := cfg.Execution.Sandbox
+	return executor.SandboxConfig{
+		AllowNetwork:   sandbox.AllowNetwork,
+		WritablePaths:  sandbox.WritablePaths,
+		MemoryLimit:    uint64(sandbox.MemoryMB) << 20,
+		CPULimit:       time.Duration(sandbox.CPUSeconds) * time.Second,
+		MaxProcesses:   uint64(sandbox.MaxProcesses),
+		WallClockLimit: time.Duration(sandbox.WallClockSeconds) * time.Second,
+	}
+}
+
+// checkStateDir makes sure that sandboxed commands cannot write to the state
+// directory. They may write to the working directory and the writable paths
+// of the sandbox, so the state directory must not overlap any of them.
+func checkStateDir(cfg *config.Config) error {
+	if cfg.StateDir == "" {
+		return fmt.Errorf("state_dir is not set in the config file")
+	}
+
+	stateDir, err := filepath.Abs(cfg.StateDir)
+	if err != nil {
+		return fmt.Errorf("invalid state directory %s: %w", cfg.StateDir, err)
+	}
+
+	writable := append([]string{cfg.WorkingDir}, cfg.Execution.Sandbox.WritablePaths...)
+	for _, path := range writable {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if pathWithin(stateDir, abs) || pathWithin(abs, stateDir) {
+			return fmt.Errorf("state_dir %s overlaps %s, which sandboxed commands may write to; set it to a separate directory", cfg.StateDir, path)
+		}
+	}
+
+	return nil
+}
+
+// pathWithin reports whether path is dir or inside it
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// usageLedger returns the ledger an LLM client records usage in, if any
+func usageLedger(llmClient llm.Client) *llm.Ledger {
+	if tracker, ok := llmClient.(llm.UsageTracker); ok {
+		return tracker.UsageLedger()
+	}
+	return nil
+}
+
+// printUsage prints the token usage and cost of the last run and the session
+func printUsage(ledger *llm.Ledger, lastRunID string) {
+	if ledger == nil {
+		fmt.Println("Usage accounting is not enabled")
+		return
+	}
+
+	printTotals := func(label string, totals llm.UsageTotals) {
+		fmt.Printf("%-28s %4d calls  %8d prompt  %8d completion  %9d total  $%.4f\n",
+			label, totals.Calls, totals.PromptTokens, totals.CompletionTokens, totals.TotalTokens, totals.Cost)
+	}
+
+	if lastRunID != "" {
+		printTotals("Last run", ledger.Totals(llm.UsageScope{RunID: lastRunID}))
+	}
+	printTotals("Session", ledger.Totals(llm.UsageScope{}))
+
+	// Break the session down by model
+	byModel := ledger.ModelTotals()
+	models := make([]string, 0, len(byModel))
+	for model := range byModel {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		printTotals("  "+model, byModel[model])
+	}
+	fmt.Println()
+}
+
 // runQuery runs a single query and exits
-func runQuery(ctx context.Context, forgeAgent *agent.ForgeAgent, query string) {
+func runQuery(ctx context.Context, forgeAgent interactiveAgent, query string, streamed bool) {
 	// Log the query being processed
 	log.Printf("Processing query: %s", query)
 
@@ -278,7 +454,7 @@ func runQuery(ctx context.Context, forgeAgent *agent.ForgeAgent, query string) {
 			log.Printf("Warning: Empty output received from agent")
 
 			// Access conversation history directly for debugging
-			history := forgeAgent.ConversationHistory
+			history := forgeAgent.GetConversationHistory()
 			log.Printf("Conversation history length: %d", len(history))
 
 			// Log the last few messages for context
@@ -294,6 +470,8 @@ func runQuery(ctx context.Context, forgeAgent *agent.ForgeAgent, query string) {
 			}
 
 			fmt.Println("No output received from the agent. Please try a more specific query or check the logs for details.")
+		} else if streamed {
+			fmt.Println()
 		} else {
 			fmt.Println(response.Output)
 		}
@@ -301,18 +479,37 @@ func runQuery(ctx context.Context, forgeAgent *agent.ForgeAgent, query string) {
 }
 
 // runServer runs the application as an API server
-func runServer(ctx context.Context, llmClient llm.Client, mem agent.Memory, workingDir, addr string) {
-	// Create agent factory
-	agentFactory := agent.NewFactory(llmClient, mem)
+func runServer(ctx context.Context, llmClient llm.Client, mem agent.Memory, cfg *config.Config, addr string, policy *agent.ApprovalPolicy, backend executor.Backend) {
+	flowManager := newFlowManager(ctx, llmClient, mem, cfg, policy, backend)
 
-	// Create flow factory
-	flowFactory := flow.NewFlowFactory(llmClient, mem, agentFactory)
+	// Create API server
+	server := api.NewServer(addr, flowManager).WithUsageLedger(usageLedger(llmClient))
 
-	// Create flow manager
-	flowManager := flow.NewFlowManager(flowFactory)
+	// Require API keys if any are configured
+	if len(cfg.Server.Credentials) > 0 {
+		auth, err := serverAuthenticator(cfg)
+		if err != nil {
+			log.Fatalf("Invalid server configuration: %v", err)
+		}
+		server.WithAuthenticator(auth)
+	} else {
+		log.Printf("Warning: No API keys are configured; anyone who can reach %s can run commands", addr)
+	}
 
-	// Create API server
-	server := api.NewServer(addr, flowManager)
+	// Process submitted runs in the background, keeping queued ones across
+	// restarts
+	runs := flow.NewRunQueue(flowManager).
+		WithLimits(cfg.Server.RunWorkers, cfg.Server.RunsPerUser).
+		WithRetention(cfg.Server.Runs.MaxRuns, time.Duration(cfg.Server.Runs.MaxAgeMinutes)*time.Minute)
+	runStore, err := flow.NewFileRunStore(filepath.Join(cfg.StateDir, "runs"))
+	if err != nil {
+		log.Fatalf("Failed to create run store: %v", err)
+	}
+	if err := runs.PersistRuns(runStore); err != nil {
+		log.Printf("Warning: Failed to recover runs: %v", err)
+	}
+	runs.Start(ctx)
+	server.WithRunQueue(runs)
 
 	// Start server
 	log.Printf("Starting API server on %s\n", addr)
@@ -321,13 +518,125 @@ func runServer(ctx context.Context, llmClient llm.Client, mem agent.Memory, work
 	}
 }
 
+// serverAuthenticator builds the authenticator of the API server from the
+// configured credentials
+func serverAuthenticator(cfg *config.Config) (*api.Authenticator, error) {
+	credentials := make([]api.Credential, 0, len(cfg.Server.Credentials))
+	for _, credential := range cfg.Server.Credentials {
+		role, err := api.ParseRole(credential.Role)
+		if err != nil {
+			return nil, fmt.Errorf("credential of %s: %w", credential.User, err)
+		}
+		credentials = append(credentials, api.Credential{
+			Key:     credential.Key,
+			KeyHash: credential.KeySHA256,
+			User:    credential.User,
+			Role:    role,
+		})
+	}
+
+	return api.NewAuthenticator(credentials)
+}
+
+// newFlowManager creates the manager of the server's flows, with the flows
+// recovered from earlier runs
+func newFlowManager(ctx context.Context, llmClient llm.Client, mem agent.Memory, cfg *config.Config, policy *agent.ApprovalPolicy, backend executor.Backend) *flow.FlowManager {
+	// Create agent factory
+	agentFactory := agent.NewFactory(llmClient, mem)
+
+	failurePolicy, err := flow.ParseFailurePolicy(cfg.Planning.FailurePolicy)
+	if err != nil {
+		log.Fatalf("Invalid planning configuration: %v", err)
+	}
+	replanBudget := cfg.Planning.ReplanBudget
+	if replanBudget == 0 {
+		replanBudget = -1
+	}
+
+	// Create flow factory; tool calls that need approval pause their flow
+	// until they are resolved through the API
+	flowFactory := flow.NewFlowFactory(llmClient, mem, agentFactory).
+		WithApprovalPolicy(policy).
+		WithBackend(backend).
+		WithPlanning(cfg.Planning.MaxParallelSteps, failurePolicy).
+		WithReplanBudget(replanBudget)
+
+	// Create flow manager
+	flowManager := flow.NewFlowManager(flowFactory)
+
+	// Keep flows across restarts, so that interrupted ones can be resumed
+	store, err := flow.NewFileFlowStore(filepath.Join(cfg.StateDir, "flows"))
+	if err != nil {
+		log.Fatalf("Failed to create flow store: %v", err)
+	}
+	if err := flowManager.PersistFlows(ctx, store); err != nil {
+		log.Printf("Warning: Failed to recover flows: %v", err)
+	}
+
+	return flowManager
+}
+
+// resumeFlow continues a flow of the API server where it stopped and prints
+// its result. Tool calls that need approval are decided by the approver, or
+// denied without one.
+func resumeFlow(ctx context.Context, flowManager *flow.FlowManager, flowID string, approver agent.Approver) {
+	unsubscribe, err := flowManager.Subscribe(flowID, func(event agent.Event) {
+		requested, ok := event.(agent.ApprovalRequestedEvent)
+		if !ok {
+			return
+		}
+		go func() {
+			decision := agent.ApprovalDecision{Reason: "no approver is available"}
+			if approver != nil {
+				var err error
+				if decision, err = approver.RequestApproval(ctx, requested.Request); err != nil {
+					decision = agent.ApprovalDecision{Reason: err.Error()}
+				}
+			}
+			if err := flowManager.ResolveApproval(flowID, requested.Request.ID, decision); err != nil {
+				log.Printf("Failed to resolve approval: %v", err)
+			}
+		}()
+	})
+	if err != nil {
+		log.Fatalf("Failed to resume flow: %v", err)
+	}
+	defer unsubscribe()
+
+	fmt.Printf("Resuming flow %s...\n", flowID)
+	response, err := flowManager.ResumeFlow(ctx, flowID)
+	if err != nil {
+		log.Fatalf("Failed to resume flow: %v", err)
+	}
+
+	if response.Output != "" {
+		fmt.Println(response.Output)
+	}
+	if !response.Success {
+		log.Fatalf("Flow failed: %s", response.Error)
+	}
+}
+
 // runClient runs the application as an API client
-func runClient(serverURL string, interactive bool, query string) {
+func runClient(serverURL string, apiKey string, streamTransport string, interactive bool, query string, resumeID string) {
 	// Create API client
 	client := api.NewClient(serverURL)
+	if apiKey != "" {
+		client.WithAPIKey(apiKey)
+	}
+	transport, err := api.ParseStreamTransport(streamTransport)
+	if err != nil {
+		log.Fatalf("Invalid -stream-transport: %v", err)
+	}
+	client.WithStreamTransport(transport)
 
 	// Run in the appropriate mode
-	if interactive {
+	if resumeID != "" {
+		if err := client.ResumeFlow(resumeID); err != nil {
+			log.Fatalf("Failed to resume flow: %v", err)
+		}
+		fmt.Printf("Resuming flow %s on the server\n", resumeID)
+	} else if interactive {
 		runInteractiveClient(client)
 	} else if query != "" {
 		runQueryClient(client, query)
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/config"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// llmProvider describes how to configure a supported LLM provider
+type llmProvider struct {
+	DisplayName    string
+	APIKeyEnvVar   string
+	DefaultModel   string
+	APIKeyOptional bool
+}
+
+// llmProviders lists the LLM providers that can be selected with llm_provider
+var llmProviders = map[string]llmProvider{
+	"openai": {
+		DisplayName:  "OpenAI",
+		APIKeyEnvVar: "OPENAI_API_KEY",
+		DefaultModel: "gpt-4o-mini",
+	},
+	"deepseek": {
+		DisplayName:  "DeepSeek",
+		APIKeyEnvVar: "DEEPSEEK_API_KEY",
+		DefaultModel: "deepseek-chat",
+	},
+	"anthropic": {
+		DisplayName:  "Anthropic",
+		APIKeyEnvVar: "ANTHROPIC_API_KEY",
+		DefaultModel: "claude-3-5-sonnet-latest",
+	},
+	"ollama": {
+		DisplayName:    "Ollama",
+		APIKeyEnvVar:   "OLLAMA_API_KEY",
+		DefaultModel:   "llama3.1",
+		APIKeyOptional: true,
+	},
+	"local": {
+		DisplayName:    "Local OpenAI-compatible server",
+		APIKeyEnvVar:   "LOCAL_LLM_API_KEY",
+		DefaultModel:   "local-model",
+		APIKeyOptional: true,
+	},
+}
+
+// resolveAPIKey returns the API key for a provider from the config file or
+// its environment variable, saving keys found in the environment to the config
+func resolveAPIKey(cfg *config.Config, configPath string, provider string) (string, error) {
+	info, ok := llmProviders[provider]
+	if !ok {
+		return "", fmt.Errorf("unknown LLM provider: %s", provider)
+	}
+
+	if apiKey := cfg.APIKeys[provider]; apiKey != "" {
+		return apiKey, nil
+	}
+
+	apiKey := os.Getenv(info.APIKeyEnvVar)
+	if apiKey == "" && info.APIKeyOptional {
+		return "", nil
+	}
+	if apiKey == "" {
+		return "", fmt.Errorf("%s API key not found. Please set it in the config file or %s environment variable", info.DisplayName, info.APIKeyEnvVar)
+	}
+
+	if cfg.APIKeys == nil {
+		cfg.APIKeys = make(map[string]string)
+	}
+	cfg.APIKeys[provider] = apiKey
+	config.SaveConfig(cfg, configPath)
+
+	return apiKey, nil
+}
+
+// newLLMClient creates the LLM client for a provider, applying any base URL,
+// model and tool support overrides from the provider's settings
+func newLLMClient(provider string, apiKey string, settings config.ProviderConfig) (llm.Client, error) {
+	info, ok := llmProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider: %s", provider)
+	}
+
+	model := info.DefaultModel
+	if settings.Model != "" {
+		model = settings.Model
+	}
+
+	switch provider {
+	case "openai":
+		client := llm.NewOpenAIClient(apiKey, model)
+		if settings.BaseURL != "" {
+			client.WithBaseURL(settings.BaseURL)
+		}
+		return client, nil
+	case "deepseek":
+		client := llm.NewDeepSeekClient(apiKey, model)
+		if settings.BaseURL != "" {
+			client.WithBaseURL(settings.BaseURL)
+		}
+		return client, nil
+	case "anthropic":
+		client := llm.NewAnthropicClient(apiKey, model)
+		if settings.BaseURL != "" {
+			client.WithBaseURL(settings.BaseURL)
+		}
+		return client, nil
+	case "ollama", "local":
+		var client *llm.LocalClient
+		if provider == "ollama" {
+			client = llm.NewOllamaClient(model)
+			if settings.BaseURL != "" {
+				client.WithBaseURL(settings.BaseURL)
+			}
+		} else {
+			if settings.BaseURL == "" {
+				return nil, fmt.Errorf("the local provider requires providers.local.base_url, e.g. http://localhost:8080/v1")
+			}
+			client = llm.NewLocalOpenAIClient(settings.BaseURL, model)
+		}
+		if settings.SupportsTools != nil {
+			client.WithToolSupport(*settings.SupportsTools)
+		}
+		return client.WithAPIKey(apiKey), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider: %s", provider)
+	}
+}
+
+// buildLLMClient creates the client for the configured provider with retries
+// and circuit breaking, failing over to the providers listed in failover.
+// Usage of the resulting client is recorded in a ledger priced from the
+// pricing table and limited by the configured budgets.
+func buildLLMClient(cfg *config.Config, configPath string, primary llm.Client) llm.Client {
+	policy := llm.RetryPolicy{
+		MaxRetries:     cfg.Retry.MaxRetries,
+		InitialBackoff: time.Duration(cfg.Retry.InitialBackoffMillis) * time.Millisecond,
+		MaxBackoff:     time.Duration(cfg.Retry.MaxBackoffMillis) * time.Millisecond,
+		Multiplier:     2,
+	}
+
+	wrap := func(client llm.Client) llm.Client {
+		middleware := []llm.Middleware{}
+		if cfg.Retry.CircuitBreakerFailures > 0 {
+			cooldown := time.Duration(cfg.Retry.CircuitBreakerCooldown) * time.Second
+			middleware = append(middleware, llm.WithCircuitBreaker(cfg.Retry.CircuitBreakerFailures, cooldown))
+		}
+		if policy.MaxRetries > 0 {
+			middleware = append(middleware, llm.WithRetry(policy))
+		}
+		return llm.Chain(client, middleware...)
+	}
+
+	clients := []llm.Client{wrap(primary)}
+	seen := map[string]bool{cfg.LLMProvider: true}
+	for _, provider := range cfg.Failover {
+		if seen[provider] {
+			continue
+		}
+		seen[provider] = true
+
+		// Failover providers that cannot be configured are skipped rather than fatal
+		apiKey, err := resolveAPIKey(cfg, configPath, provider)
+		if err != nil {
+			log.Printf("Warning: skipping failover provider %s: %v", provider, err)
+			continue
+		}
+		client, err := newLLMClient(provider, apiKey, cfg.Providers[provider])
+		if err != nil {
+			log.Printf("Warning: skipping failover provider %s: %v", provider, err)
+			continue
+		}
+		clients = append(clients, wrap(client))
+	}
+
+	var client llm.Client = clients[0]
+	if len(clients) > 1 {
+		client = llm.NewFailoverClient(clients...)
+	}
+
+	// Prices from the config override the defaults
+	pricing := llm.DefaultPricing()
+	for model, price := range cfg.Pricing {
+		pricing[model] = llm.ModelPricing{
+			PromptPerMillion:     price.PromptPerMillion,
+			CompletionPerMillion: price.CompletionPerMillion,
+		}
+	}
+
+	return llm.NewMeteredClient(client, llm.NewLedger(pricing)).
+		WithRunBudget(llm.Budget{MaxTokens: cfg.Budget.MaxRunTokens, MaxCost: cfg.Budget.MaxRunCost}).
+		WithFlowBudget(llm.Budget{MaxTokens: cfg.Budget.MaxFlowTokens, MaxCost: cfg.Budget.MaxFlowCost})
+}
@@ -12,17 +12,18 @@ import (
 	"github.com/fatih/color"
 	"github.com/prathyushnallamothu/commandforge/pkg/agent"
 	"github.com/prathyushnallamothu/commandforge/pkg/config"
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
 	"github.com/prathyushnallamothu/commandforge/pkg/llm"
 	"github.com/prathyushnallamothu/commandforge/pkg/tools"
 )
 
 // runReActAgent runs the application with the ReAct agent
-func runReActAgent(ctx context.Context, llmClient llm.Client, mem agent.Memory, workingDir string, interactive bool, query string, cfg *config.Config) {
+func runReActAgent(ctx context.Context, llmClient llm.Client, mem agent.Memory, workingDir string, interactive bool, query string, cfg *config.Config, approvalHook agent.Hook, backend executor.Backend) {
 	// Create ReAct agent
-	reactAgent := agent.NewReActAgent("CommandForge", llmClient, mem)
+	reactAgent := agent.NewReActAgent("CommandForge", llmClient, mem).WithHook(approvalHook)
 
 	// Add tools
-	addReActTools(reactAgent, workingDir, cfg)
+	addReActTools(reactAgent, workingDir, cfg, backend)
 
 	// Initialize agent
 	if err := reactAgent.Initialize(ctx); err != nil {
@@ -31,19 +32,24 @@ func runReActAgent(ctx context.Context, llmClient llm.Client, mem agent.Memory,
 
 	// Run in interactive or query mode
 	if interactive {
-		runReActInteractive(ctx, reactAgent)
+		runReActInteractive(ctx, reactAgent, usageLedger(llmClient))
 	} else {
 		runReActQuery(ctx, reactAgent, query)
 	}
+
+	// Close the agent's shell sessions
+	reactAgent.Stop(ctx)
 }
 
 // runReActInteractive runs the ReAct agent in interactive mode
-func runReActInteractive(ctx context.Context, reactAgent *agent.ReActAgent) {
+func runReActInteractive(ctx context.Context, reactAgent *agent.ReActAgent, ledger *llm.Ledger) {
 	// Print welcome message
 	fmt.Println("Welcome to CommandForge (ReAct Mode)!")
-	fmt.Println("Type 'exit' to quit.")
+	fmt.Println("Type 'exit' to quit, or '/usage' to show token usage and cost.")
 	fmt.Println()
 
+	lastRunID := ""
+
 	// Create a scanner for user input
 	scanner := bufio.NewScanner(os.Stdin)
 
@@ -68,6 +74,12 @@ func runReActInteractive(ctx context.Context, reactAgent *agent.ReActAgent) {
 			continue
 		}
 
+		// Show usage for the session
+		if input == "/usage" {
+			printUsage(ledger, lastRunID)
+			continue
+		}
+
 		// Create request
 		request := &agent.Request{
 			Input: input,
@@ -79,6 +91,9 @@ func runReActInteractive(ctx context.Context, reactAgent *agent.ReActAgent) {
 			fmt.Printf("Error: %v\n", err)
 			continue
 		}
+		if runID, ok := response.Metadata["run_id"].(string); ok {
+			lastRunID = runID
+		}
 
 		// Check for error in response
 		if !response.Success {
@@ -118,13 +133,13 @@ func runReActQuery(ctx context.Context, reactAgent *agent.ReActAgent, query stri
 }
 
 // addReActTools adds tools to the ReAct agent
-func addReActTools(reactAgent *agent.ReActAgent, workingDir string, cfg *config.Config) {
+func addReActTools(reactAgent *agent.ReActAgent, workingDir string, cfg *config.Config, backend executor.Backend) {
 	// Add bash tool
-	bashTool := tools.NewBashTool(workingDir)
+	bashTool := tools.NewBashTool(workingDir).WithBackend(backend)
 	reactAgent.AddTool(bashTool)
 
 	// Add Python tool
-	pythonTool := tools.NewPythonTool(workingDir)
+	pythonTool := tools.NewPythonTool(workingDir).WithBackend(backend)
 	reactAgent.AddTool(pythonTool)
 
 	// Add file tool
@@ -135,10 +150,18 @@ func addReActTools(reactAgent *agent.ReActAgent, workingDir string, cfg *config.
 	commandStatusTool := tools.NewCommandStatusTool()
 	reactAgent.AddTool(commandStatusTool)
 
+	// Add command control tool
+	commandControlTool := tools.NewCommandControlTool()
+	reactAgent.AddTool(commandControlTool)
+
 	// Add list commands tool
 	listCommandsTool := tools.NewListCommandsTool()
 	reactAgent.AddTool(listCommandsTool)
 
+	// Add shell session tool
+	shellSessionTool := tools.NewShellSessionTool(workingDir).WithBackend(backend)
+	reactAgent.AddTool(shellSessionTool)
+
 	// Add web search tool
 	// Check if Tavily API key is available
 	if tavilyAPIKey, ok := cfg.APIKeys["tavily"]; ok && tavilyAPIKey != "" {
@@ -0,0 +1,431 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// ApprovalAction is what an approval policy does with a tool call
+type ApprovalAction string
+
+// Approval actions
+const (
+	// ApprovalAllow runs the tool call
+	ApprovalAllow ApprovalAction = "allow"
+	// ApprovalDeny refuses the tool call
+	ApprovalDeny ApprovalAction = "deny"
+	// ApprovalAsk runs the tool call only if an approver approves it
+	ApprovalAsk ApprovalAction = "ask"
+)
+
+// ParseApprovalAction parses an approval action name
+func ParseApprovalAction(name string) (ApprovalAction, error) {
+	switch action := ApprovalAction(strings.ToLower(name)); action {
+	case ApprovalAllow, ApprovalDeny, ApprovalAsk:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown approval action: %s", name)
+	}
+}
+
+// ApprovalRule matches tool calls and says what to do with them. Every
+// condition that is set must match; an empty rule matches every call.
+type ApprovalRule struct {
+	Action ApprovalAction `json:"action"`
+	// Tool is the tool name, or a glob such as "file*"
+	Tool string `json:"tool,omitempty"`
+	// Agent is the agent name, or a glob
+	Agent string `json:"agent,omitempty"`
+	// Pattern is a regular expression matched against the call's arguments
+	Pattern string `json:"pattern,omitempty"`
+	// OutsideWorkingDir matches calls with a path outside the working directory
+	OutsideWorkingDir bool `json:"outside_working_dir,omitempty"`
+	// Reason explains the rule to the user and the model
+	Reason string `json:"reason,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// ApprovalPolicy decides whether tool calls may run. The first matching
+// rule wins; calls that match no rule get the default action.
+type ApprovalPolicy struct {
+	Rules      []ApprovalRule
+	Default    ApprovalAction
+	WorkingDir string
+}
+
+// NewApprovalPolicy creates a policy, compiling the rules' patterns
+func NewApprovalPolicy(rules []ApprovalRule, defaultAction ApprovalAction, workingDir string) (*ApprovalPolicy, error) {
+	if defaultAction == "" {
+		defaultAction = ApprovalAllow
+	}
+	if _, err := ParseApprovalAction(string(defaultAction)); err != nil {
+		return nil, err
+	}
+
+	compiled := make([]ApprovalRule, 0, len(rules))
+	for i, rule := range rules {
+		if _, err := ParseApprovalAction(string(rule.Action)); err != nil {
+			return nil, fmt.Errorf("approval rule %d: %w", i, err)
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("approval rule %d: invalid pattern: %w", i, err)
+			}
+			rule.pattern = pattern
+		}
+		compiled = append(compiled, rule)
+	}
+
+	if workingDir != "" {
+		if abs, err := filepath.Abs(workingDir); err == nil {
+			workingDir = abs
+		}
+	}
+
+	return &ApprovalPolicy{
+		Rules:      compiled,
+		Default:    defaultAction,
+		WorkingDir: workingDir,
+	}, nil
+}
+
+// DefaultApprovalRules returns rules that ask before recursive deletes,
+// sudo and changes outside the working directory. A delete is recursive if
+// any of its flags asks for it, not just the first.
+func DefaultApprovalRules() []ApprovalRule {
+	return []ApprovalRule{
+		{
+			Action:  ApprovalAsk,
+			Pattern: `\brm\b[^|;&]*\s(-[a-zA-Z]*[rR][a-zA-Z]*|--recursive)\b`,
+			Reason:  "recursive delete",
+		},
+		{
+			Action:  ApprovalAsk,
+			Pattern: `\bsudo\b`,
+			Reason:  "runs with elevated privileges",
+		},
+		{
+			Action:            ApprovalAsk,
+			OutsideWorkingDir: true,
+			Reason:            "touches a path outside the working directory",
+		},
+	}
+}
+
+// Evaluate returns the action for a tool call made by an agent, and the rule
+// that matched, if any
+func (p *ApprovalPolicy) Evaluate(agentName string, toolCall llm.ToolCall) (ApprovalAction, *ApprovalRule) {
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if p.matches(rule, agentName, toolCall) {
+			return rule.Action, rule
+		}
+	}
+
+	return p.Default, nil
+}
+
+// matches reports whether a rule matches a tool call
+func (p *ApprovalPolicy) matches(rule *ApprovalRule, agentName string, toolCall llm.ToolCall) bool {
+	if !globMatch(rule.Tool, toolCall.Function.Name) || !globMatch(rule.Agent, agentName) {
+		return false
+	}
+
+	if rule.pattern != nil && !rule.pattern.MatchString(toolCallArguments(toolCall)) {
+		return false
+	}
+
+	if rule.OutsideWorkingDir && !p.outsideWorkingDir(toolCall) {
+		return false
+	}
+
+	return true
+}
+
+// globMatch matches a name against an optional glob
+func globMatch(pattern, name string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// toolCallArguments returns the arguments of a tool call as text
+func toolCallArguments(toolCall llm.ToolCall) string {
+	if toolCall.Function.Arguments != "" {
+		return toolCall.Function.Arguments
+	}
+
+	arguments, err := json.Marshal(toolCall.Args)
+	if err != nil {
+		return ""
+	}
+	return string(arguments)
+}
+
+// pathArguments are the arguments that name a file or directory
+var pathArguments = []string{"path", "cwd", "working_dir", "directory", "file", "source", "destination"}
+
+// outsideWorkingDir reports whether a tool call names a path outside the
+// working directory, either as a path argument or as an absolute path in a
+// command
+func (p *ApprovalPolicy) outsideWorkingDir(toolCall llm.ToolCall) bool {
+	if p.WorkingDir == "" {
+		return false
+	}
+
+	for _, name := range pathArguments {
+		if value, ok := toolCall.Args[name].(string); ok && value != "" && !p.insideWorkingDir(value) {
+			return true
+		}
+	}
+
+	for _, name := range []string{"command", "code"} {
+		value, ok := toolCall.Args[name].(string)
+		if !ok {
+			continue
+		}
+		for _, field := range strings.Fields(value) {
+			field = strings.Trim(field, `"'();`)
+			if (strings.HasPrefix(field, "/") || strings.HasPrefix(field, "~")) && !p.insideWorkingDir(field) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// insideWorkingDir reports whether a path is inside the working directory
+func (p *ApprovalPolicy) insideWorkingDir(name string) bool {
+	if strings.HasPrefix(name, "~") {
+		return false
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(p.WorkingDir, name)
+	}
+
+	rel, err := filepath.Rel(p.WorkingDir, filepath.Clean(name))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ApprovalRequest describes a tool call waiting for approval
+type ApprovalRequest struct {
+	ID         string                 `json:"id"`
+	FlowID     string                 `json:"flow_id,omitempty"`
+	RunID      string                 `json:"run_id,omitempty"`
+	Agent      string                 `json:"agent,omitempty"`
+	ToolCallID string                 `json:"tool_call_id,omitempty"`
+	Tool       string                 `json:"tool"`
+	Arguments  map[string]interface{} `json:"arguments,omitempty"`
+	Reason     string                 `json:"reason,omitempty"`
+	Time       time.Time              `json:"time"`
+}
+
+// ApprovalDecision is the answer to an approval request
+type ApprovalDecision struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Approver decides approval requests, for example by asking the user
+type Approver interface {
+	// RequestApproval blocks until the request has been decided
+	RequestApproval(ctx context.Context, request ApprovalRequest) (ApprovalDecision, error)
+}
+
+// ApproverFunc adapts a function to the Approver interface
+type ApproverFunc func(ctx context.Context, request ApprovalRequest) (ApprovalDecision, error)
+
+// RequestApproval calls the function
+func (f ApproverFunc) RequestApproval(ctx context.Context, request ApprovalRequest) (ApprovalDecision, error) {
+	return f(ctx, request)
+}
+
+// ErrToolDenied is wrapped by the errors of tool calls refused by a policy
+var ErrToolDenied = errors.New("tool call denied")
+
+// ToolDeniedError is returned for a tool call that was denied or rejected
+type ToolDeniedError struct {
+	Tool   string
+	Action ApprovalAction
+	Reason string
+}
+
+// Error returns the error message
+func (e *ToolDeniedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s: %s", ErrToolDenied, e.Tool)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrToolDenied, e.Tool, e.Reason)
+}
+
+// Unwrap returns ErrToolDenied
+func (e *ToolDeniedError) Unwrap() error {
+	return ErrToolDenied
+}
+
+// Hook returns a hook that applies the policy before each tool call. Calls
+// that need approval are sent to the approver; without one they are denied.
+func (p *ApprovalPolicy) Hook(approver Approver) Hook {
+	return Hook{
+		BeforeToolCall: func(ctx context.Context, toolCall *llm.ToolCall) error {
+			scope := llm.UsageScopeFromContext(ctx)
+			action, rule := p.Evaluate(scope.Agent, *toolCall)
+
+			reason := ""
+			if rule != nil {
+				reason = rule.Reason
+			}
+
+			switch action {
+			case ApprovalAllow:
+				return nil
+			case ApprovalDeny:
+				if reason == "" {
+					reason = "denied by policy"
+				}
+				return &ToolDeniedError{Tool: toolCall.Function.Name, Action: action, Reason: reason}
+			}
+
+			if approver == nil {
+				return &ToolDeniedError{Tool: toolCall.Function.Name, Action: action, Reason: "approval required but no approver is available"}
+			}
+
+			decision, err := approver.RequestApproval(ctx, ApprovalRequest{
+				ID:         fmt.Sprintf("approval-%d", time.Now().UnixNano()),
+				FlowID:     scope.FlowID,
+				RunID:      scope.RunID,
+				Agent:      scope.Agent,
+				ToolCallID: toolCall.ID,
+				Tool:       toolCall.Function.Name,
+				Arguments:  toolCall.Args,
+				Reason:     reason,
+				Time:       time.Now(),
+			})
+			if err != nil {
+				return &ToolDeniedError{Tool: toolCall.Function.Name, Action: action, Reason: fmt.Sprintf("approval failed: %v", err)}
+			}
+			if !decision.Approved {
+				reason := decision.Reason
+				if reason == "" {
+					reason = "rejected by the user"
+				}
+				return &ToolDeniedError{Tool: toolCall.Function.Name, Action: action, Reason: reason}
+			}
+
+			return nil
+		},
+	}
+}
+
+// ApprovalQueue holds approval requests until they are resolved from
+// elsewhere, such as an API call. It publishes an event when a request is
+// queued and when it is resolved.
+type ApprovalQueue struct {
+	Events  *EventBus
+	mutex   sync.Mutex
+	pending map[string]*pendingApproval
+}
+
+// pendingApproval is a queued request and the channel its decision is sent on
+type pendingApproval struct {
+	request  ApprovalRequest
+	decision chan ApprovalDecision
+}
+
+// NewApprovalQueue creates a new approval queue publishing on the given bus,
+// which may be nil
+func NewApprovalQueue(events *EventBus) *ApprovalQueue {
+	return &ApprovalQueue{
+		Events:  events,
+		pending: make(map[string]*pendingApproval),
+	}
+}
+
+// RequestApproval queues a request and waits until it is resolved or the
+// context is done
+func (q *ApprovalQueue) RequestApproval(ctx context.Context, request ApprovalRequest) (ApprovalDecision, error) {
+	pending := &pendingApproval{
+		request:  request,
+		decision: make(chan ApprovalDecision, 1),
+	}
+
+	q.mutex.Lock()
+	q.pending[request.ID] = pending
+	q.mutex.Unlock()
+
+	q.publish(ApprovalRequestedEvent{
+		EventInfo: NewEventInfo(ctx, EventApprovalRequested),
+		Request:   request,
+	})
+
+	select {
+	case decision := <-pending.decision:
+		q.publish(ApprovalResolvedEvent{
+			EventInfo:  NewEventInfo(ctx, EventApprovalResolved),
+			ApprovalID: request.ID,
+			Tool:       request.Tool,
+			Decision:   decision,
+		})
+		return decision, nil
+	case <-ctx.Done():
+		q.mutex.Lock()
+		delete(q.pending, request.ID)
+		q.mutex.Unlock()
+		return ApprovalDecision{}, ctx.Err()
+	}
+}
+
+// Resolve decides a pending request
+func (q *ApprovalQueue) Resolve(id string, decision ApprovalDecision) error {
+	q.mutex.Lock()
+	pending, exists := q.pending[id]
+	delete(q.pending, id)
+	q.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("approval request not found: %s", id)
+	}
+
+	pending.decision <- decision
+	return nil
+}
+
+// Pending returns the requests waiting for a decision, oldest first
+func (q *ApprovalQueue) Pending() []ApprovalRequest {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	requests := make([]ApprovalRequest, 0, len(q.pending))
+	for _, pending := range q.pending {
+		requests = append(requests, pending.request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Time.Before(requests[j].Time)
+	})
+
+	return requests
+}
+
+// publish publishes an event if the queue has a bus
+func (q *ApprovalQueue) publish(event Event) {
+	if q.Events != nil {
+		q.Events.Publish(event)
+	}
+}
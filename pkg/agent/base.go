@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
 )
 
 // BaseAgent provides common functionality for all agents
@@ -16,6 +18,14 @@ type BaseAgent struct {
 	Tools       []Tool
 	StateMutex  sync.RWMutex
 	StartTime   time.Time
+	// StreamHandler receives assistant text as it is generated, if set
+	StreamHandler func(delta string)
+	// providers lists the LLM providers that served the current run
+	providers    []string
+	lastProvider string
+	// runID and usage identify the current run and total its LLM usage
+	runID string
+	usage llm.UsageTotals
 }
 
 // Memory interface for agent memory management
@@ -153,6 +163,101 @@ func (a *BaseAgent) DeleteMemory(ctx context.Context, key string) error {
 	return a.Memory.Delete(ctx, key)
 }
 
+// SetStreamHandler registers a handler that receives assistant text deltas
+// while the agent is waiting on the LLM
+func (a *BaseAgent) SetStreamHandler(handler func(delta string)) {
+	a.StateMutex.Lock()
+	defer a.StateMutex.Unlock()
+
+	a.StreamHandler = handler
+}
+
+// completeChat sends a chat completion request, streaming content deltas to
+// the stream handler and to onDelta when either is set
+func (a *BaseAgent) completeChat(ctx context.Context, client llm.Client, request *llm.ChatCompletionRequest, onDelta func(delta string)) (*llm.ChatCompletionResponse, error) {
+	a.StateMutex.RLock()
+	handler := a.StreamHandler
+	a.StateMutex.RUnlock()
+
+	var response *llm.ChatCompletionResponse
+	var err error
+	if handler == nil && onDelta == nil {
+		response, err = client.ChatCompletion(ctx, request)
+	} else {
+		response, err = llm.StreamChatCompletion(ctx, client, request, func(delta llm.StreamDelta) error {
+			if delta.Content == "" {
+				return nil
+			}
+			if handler != nil {
+				handler(delta.Content)
+			}
+			if onDelta != nil {
+				onDelta(delta.Content)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a.recordCompletion(client, response)
+	return response, nil
+}
+
+// recordCompletion notes the provider and usage of a completion made during the current run
+func (a *BaseAgent) recordCompletion(client llm.Client, response *llm.ChatCompletionResponse) {
+	provider := response.Provider
+	if provider == "" {
+		provider = client.GetProvider()
+	}
+
+	a.StateMutex.Lock()
+	defer a.StateMutex.Unlock()
+
+	a.usage.Add(response.Usage, response.Cost)
+	a.lastProvider = provider
+	for _, p := range a.providers {
+		if p == provider {
+			return
+		}
+	}
+	a.providers = append(a.providers, provider)
+}
+
+// beginRun starts a new run, clearing the metadata collected for the previous
+// one. The returned context attributes LLM usage to the run.
+func (a *BaseAgent) beginRun(ctx context.Context) context.Context {
+	a.StateMutex.Lock()
+	defer a.StateMutex.Unlock()
+
+	a.providers = nil
+	a.lastProvider = ""
+	a.usage = llm.UsageTotals{}
+	a.runID = fmt.Sprintf("run-%d", time.Now().UnixNano())
+
+	return llm.WithUsageScope(ctx, llm.UsageScope{RunID: a.runID, Agent: a.Name})
+}
+
+// runMetadata returns the metadata collected during the current run: its ID,
+// its token usage and cost, the provider of the last LLM call and every
+// provider used, in order of first use
+func (a *BaseAgent) runMetadata() map[string]interface{} {
+	a.StateMutex.RLock()
+	defer a.StateMutex.RUnlock()
+
+	metadata := map[string]interface{}{
+		"run_id": a.runID,
+		"usage":  a.usage,
+	}
+	if a.lastProvider != "" {
+		metadata["provider"] = a.lastProvider
+		metadata["providers"] = append([]string(nil), a.providers...)
+	}
+
+	return metadata
+}
+
 // Stop gracefully stops the agent
 func (a *BaseAgent) Stop(ctx context.Context) error {
 	a.setState(StateStopped)
@@ -0,0 +1,282 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// summaryPrefix starts the system message that carries the running summary
+// of turns evicted from the conversation
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+// summaryPrompt instructs the LLM to fold evicted turns into the running summary
+const summaryPrompt = `You maintain a running summary of a conversation between a user and an AI agent that uses tools.
+Update the summary with the messages below, which are being removed from the conversation.
+Keep the user's goals and requests, decisions made, important tool results (file paths, command IDs, URLs, errors) and anything still left to do.
+Be concise and factual. Reply with the updated summary only.`
+
+// ContextManager keeps a conversation within a model's context window. It
+// counts tokens for the client's model, evicts the oldest turns first while
+// keeping each assistant tool call together with its tool responses, and
+// folds evicted turns into a running summary written by the LLM.
+type ContextManager struct {
+	LLMClient llm.Client
+	// MaxTokens is the token budget for the conversation. Zero uses a share
+	// of the model's context window, leaving room for tools and the reply.
+	MaxTokens int
+	// MaxMessages optionally caps the number of messages. Zero means no cap.
+	MaxMessages int
+	// MaxSummaryTokens caps the length of the running summary
+	MaxSummaryTokens int
+	// SummarizationEnabled controls whether evicted turns are summarised
+	SummarizationEnabled bool
+
+	mutex   sync.Mutex
+	summary string
+}
+
+// NewContextManager creates a context manager for the client's model
+func NewContextManager(llmClient llm.Client) *ContextManager {
+	return &ContextManager{
+		LLMClient:            llmClient,
+		MaxSummaryTokens:     1000,
+		SummarizationEnabled: true,
+	}
+}
+
+// WithMaxTokens sets the token budget for the conversation
+func (m *ContextManager) WithMaxTokens(tokens int) *ContextManager {
+	m.MaxTokens = tokens
+	return m
+}
+
+// WithMaxMessages caps the number of messages in the conversation
+func (m *ContextManager) WithMaxMessages(messages int) *ContextManager {
+	m.MaxMessages = messages
+	return m
+}
+
+// WithSummarizationEnabled enables or disables summarising evicted turns
+func (m *ContextManager) WithSummarizationEnabled(enabled bool) *ContextManager {
+	m.SummarizationEnabled = enabled
+	return m
+}
+
+// Summary returns the running summary of evicted turns
+func (m *ContextManager) Summary() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.summary
+}
+
+// Reset clears the running summary
+func (m *ContextManager) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.summary = ""
+}
+
+// budget returns the token budget for the conversation
+func (m *ContextManager) budget() int {
+	if m.MaxTokens > 0 {
+		return m.MaxTokens
+	}
+
+	// Leave a quarter of the window for tool definitions and the reply
+	return llm.ContextWindow(m.LLMClient.GetModelName()) * 3 / 4
+}
+
+// Fit returns the conversation trimmed to the token budget. Leading system
+// messages and the latest user message are always kept; the oldest turns are
+// evicted first and summarised into a system message after the system prompt.
+// Tool responses too large for the budget are truncated.
+func (m *ContextManager) Fit(ctx context.Context, history []llm.Message) []llm.Message {
+	model := m.LLMClient.GetModelName()
+	budget := m.budget()
+
+	// Separate the system prompt and the previous summary from the turns. A
+	// summary in a conversation loaded from memory is adopted if there is none.
+	var system []llm.Message
+	rest := history
+	for len(rest) > 0 && rest[0].Role == "system" {
+		if strings.HasPrefix(rest[0].Content, summaryPrefix) {
+			m.mutex.Lock()
+			if m.summary == "" {
+				m.summary = strings.TrimPrefix(rest[0].Content, summaryPrefix)
+			}
+			m.mutex.Unlock()
+		} else {
+			system = append(system, rest[0])
+		}
+		rest = rest[1:]
+	}
+
+	// No single tool response may take more than a quarter of the budget
+	turns := groupTurns(rest)
+	for _, turn := range turns {
+		for i := range turn {
+			if turn[i].Role == "tool" {
+				turn[i].Content = llm.TruncateToTokens(model, turn[i].Content, budget/4)
+			}
+		}
+	}
+
+	summaryMessage := m.summaryMessage()
+	used := llm.CountMessagesTokens(model, system)
+	if summaryMessage != nil {
+		used += llm.CountMessageTokens(model, *summaryMessage)
+	}
+
+	// Keep the newest turns that fit, always keeping the last one
+	keepFrom := len(turns)
+	messages := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		tokens := llm.CountMessagesTokens(model, turns[i])
+		overTokens := used+tokens > budget
+		overMessages := m.MaxMessages > 0 && len(system)+1+messages+len(turns[i]) > m.MaxMessages
+		if i < len(turns)-1 && (overTokens || overMessages) {
+			break
+		}
+		used += tokens
+		messages += len(turns[i])
+		keepFrom = i
+	}
+
+	kept := turns[keepFrom:]
+	evicted := turns[:keepFrom]
+
+	// Keep the latest user request even if its turn was evicted
+	var pinned []llm.Message
+	if len(evicted) > 0 {
+		for i := len(turns) - 1; i >= 0; i-- {
+			if turns[i][0].Role == "user" {
+				if i < keepFrom {
+					pinned = turns[i]
+					evicted = append(turns[:i:i], turns[i+1:keepFrom]...)
+				}
+				break
+			}
+		}
+	}
+
+	if len(evicted) > 0 {
+		m.summarize(ctx, evicted)
+		summaryMessage = m.summaryMessage()
+	}
+
+	result := make([]llm.Message, 0, len(history))
+	result = append(result, system...)
+	if summaryMessage != nil {
+		result = append(result, *summaryMessage)
+	}
+	result = append(result, pinned...)
+	for _, turn := range kept {
+		result = append(result, turn...)
+	}
+
+	return result
+}
+
+// summaryMessage returns the running summary as a system message, if there is one
+func (m *ContextManager) summaryMessage() *llm.Message {
+	summary := m.Summary()
+	if summary == "" {
+		return nil
+	}
+
+	return &llm.Message{
+		Role:    "system",
+		Content: summaryPrefix + summary,
+	}
+}
+
+// summarize folds evicted turns into the running summary. If the LLM cannot
+// be reached the turns are dropped and the previous summary is kept.
+func (m *ContextManager) summarize(ctx context.Context, evicted [][]llm.Message) {
+	if !m.SummarizationEnabled {
+		return
+	}
+
+	model := m.LLMClient.GetModelName()
+
+	var transcript strings.Builder
+	for _, turn := range evicted {
+		for _, msg := range turn {
+			content := msg.Content
+			for _, tc := range msg.ToolCalls {
+				content += fmt.Sprintf("\n[called %s with %s]", tc.Function.Name, tc.Function.Arguments)
+			}
+			transcript.WriteString(fmt.Sprintf("%s: %s\n\n", msg.Role, strings.TrimSpace(content)))
+		}
+	}
+
+	// The transcript itself has to fit in the summarisation request
+	input := llm.TruncateToTokens(model, transcript.String(), m.budget()/2)
+
+	previous := m.Summary()
+	if previous == "" {
+		previous = "(none)"
+	}
+
+	response, err := m.LLMClient.ChatCompletion(ctx, &llm.ChatCompletionRequest{
+		Messages: []llm.Message{
+			{Role: "system", Content: summaryPrompt},
+			{Role: "user", Content: fmt.Sprintf("Current summary:\n%s\n\nMessages being removed:\n%s", previous, input)},
+		},
+		Temperature: 0.2,
+		MaxTokens:   m.MaxSummaryTokens,
+	})
+	if err != nil || len(response.Choices) == 0 {
+		log.Printf("Failed to summarise evicted conversation turns: %v", err)
+		return
+	}
+
+	summary := strings.TrimSpace(response.Choices[0].Message.Content)
+	if summary == "" {
+		return
+	}
+
+	m.mutex.Lock()
+	m.summary = llm.TruncateToTokens(model, summary, m.MaxSummaryTokens)
+	m.mutex.Unlock()
+}
+
+// groupTurns splits messages into units that must be kept or evicted
+// together: an assistant message with tool calls and the tool responses that
+// answer it form one unit, every other message is a unit of its own. Tool
+// responses whose call is no longer in the conversation are dropped, since
+// providers reject them.
+func groupTurns(messages []llm.Message) [][]llm.Message {
+	var turns [][]llm.Message
+	pending := make(map[string]bool)
+	current := -1
+
+	for _, msg := range messages {
+		if msg.Role == "tool" {
+			if current >= 0 && pending[msg.ToolCallID] {
+				turns[current] = append(turns[current], msg)
+				delete(pending, msg.ToolCallID)
+			}
+			continue
+		}
+
+		turns = append(turns, []llm.Message{msg})
+		current = -1
+		pending = make(map[string]bool)
+		if msg.Role == "assistant" && len(msg.ToolCalls) > 0 {
+			current = len(turns) - 1
+			for _, tc := range msg.ToolCalls {
+				pending[tc.ID] = true
+			}
+		}
+	}
+
+	return turns
+}
@@ -0,0 +1,495 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+	"github.com/prathyushnallamothu/commandforge/pkg/tools"
+)
+
+// Hook observes and adjusts an engine run. Any of its functions may be nil.
+type Hook struct {
+	// OnRunStart is called when a run starts
+	OnRunStart func(ctx context.Context, request *Request)
+	// BeforeLLMCall is called before each chat completion request is sent
+	BeforeLLMCall func(ctx context.Context, iteration int, request *llm.ChatCompletionRequest)
+	// AfterLLMCall is called with the outcome of each chat completion request
+	AfterLLMCall func(ctx context.Context, iteration int, response *llm.ChatCompletionResponse, err error)
+	// OnTextDelta receives assistant text as it is streamed from the LLM
+	OnTextDelta func(ctx context.Context, delta string)
+	// BeforeToolCall is called before a tool runs. Returning an error skips
+	// the tool and reports the error to the LLM as the tool's result.
+	BeforeToolCall func(ctx context.Context, toolCall *llm.ToolCall) error
+	// AfterToolCall is called after a tool has run and may adjust its result
+	AfterToolCall func(ctx context.Context, toolCall llm.ToolCall, result *ToolResult)
+	// OnIterationLimit is called when a run reaches its iteration limit
+	OnIterationLimit func(ctx context.Context, iterations int)
+	// OnRunFinish is called when a run ends
+	OnRunFinish func(ctx context.Context, output string, err error)
+}
+
+// ToolResult is the outcome of a tool call made by the engine
+type ToolResult struct {
+	// Output is the value returned by the tool
+	Output interface{}
+	// Err is the error returned by the tool, or by a hook that refused the call
+	Err error
+	// Content is the result as sent back to the LLM
+	Content string
+	// Duration is how long the tool took to run
+	Duration time.Duration
+	// FinalAnswer, when set by a hook, ends the run with this answer once the
+	// results of the current round of tool calls are recorded
+	FinalAnswer string
+}
+
+// Engine is the run loop shared by the agents. It sends the conversation to
+// the LLM, lets its strategy read the reply, runs the requested tools and
+// feeds their results back until the strategy finds a final answer.
+type Engine struct {
+	*BaseAgent
+	LLMClient           llm.Client
+	ToolHandler         *llm.ToolCallingHandler
+	ToolCollection      *tools.ToolCollection
+	ConversationHistory []llm.Message
+	ContextManager      *ContextManager
+	SystemPrompt        string
+	MaxIterations       int
+	StreamingEnabled    bool
+	Strategy            Strategy
+	Hooks               []Hook
+	// FailureGuidance, when set, is sent as a user message after a round of
+	// tool calls in which a tool failed
+	FailureGuidance string
+	// SummaryPrompt, when set, asks the LLM for a final answer without tools
+	// instead of failing when the iteration limit is reached or the reply is empty
+	SummaryPrompt string
+}
+
+// NewEngine creates an engine for an agent with the given strategy
+func NewEngine(baseAgent *BaseAgent, llmClient llm.Client, strategy Strategy) *Engine {
+	// Create tool collection
+	toolCollection := tools.NewToolCollection()
+
+	return &Engine{
+		BaseAgent:           baseAgent,
+		LLMClient:           llmClient,
+		ToolHandler:         llm.NewToolCallingHandler(toolCollection),
+		ToolCollection:      toolCollection,
+		ConversationHistory: make([]llm.Message, 0),
+		ContextManager:      NewContextManager(llmClient),
+		MaxIterations:       10, // Prevent infinite loops
+		StreamingEnabled:    true,
+		Strategy:            strategy,
+	}
+}
+
+// AddHook adds a hook to the engine
+func (e *Engine) AddHook(hook Hook) {
+	e.Hooks = append(e.Hooks, hook)
+}
+
+// SetStrategy replaces the engine's reasoning strategy
+func (e *Engine) SetStrategy(strategy Strategy) {
+	e.Strategy = strategy
+}
+
+// AddTool adds a tool to the agent
+func (e *Engine) AddTool(tool tools.Tool) error {
+	return e.ToolCollection.AddTool(tool)
+}
+
+// Stop stops the agent and closes the tools that hold resources, such as
+// the shell sessions of the shell_session tool
+func (e *Engine) Stop(ctx context.Context) error {
+	var errs []error
+	for _, tool := range e.ToolCollection.ListTools() {
+		if closer, ok := tool.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to close tool %s: %w", tool.GetName(), err))
+			}
+		}
+	}
+
+	if err := e.BaseAgent.Stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Initialize sets up the agent
+func (e *Engine) Initialize(ctx context.Context) error {
+	// Initialize the base agent
+	if err := e.BaseAgent.Initialize(ctx); err != nil {
+		return err
+	}
+
+	// Add the system message to the conversation history
+	e.ConversationHistory = append(e.ConversationHistory, llm.Message{
+		Role:    "system",
+		Content: e.SystemPrompt,
+	})
+
+	return nil
+}
+
+// Run processes a user request
+func (e *Engine) Run(ctx context.Context, request *Request) (*Response, error) {
+	// Set the agent state to running
+	e.setState(StateRunning)
+	ctx = e.beginRun(ctx)
+
+	// Add the user message to the conversation history
+	e.ConversationHistory = append(e.ConversationHistory, llm.Message{
+		Role:    "user",
+		Content: request.Input,
+	})
+
+	for _, hook := range e.Hooks {
+		if hook.OnRunStart != nil {
+			hook.OnRunStart(ctx, request)
+		}
+	}
+
+	// Process the request
+	output, err := e.processRequest(ctx)
+
+	for _, hook := range e.Hooks {
+		if hook.OnRunFinish != nil {
+			hook.OnRunFinish(ctx, output, err)
+		}
+	}
+
+	if err != nil {
+		// Set the agent state to error
+		e.setState(StateError)
+		return &Response{
+			Output:   "",
+			Success:  false,
+			Error:    err.Error(),
+			Metadata: e.runMetadata(),
+		}, nil
+	}
+
+	// Set the agent state back to idle
+	e.setState(StateIdle)
+
+	// Return the response
+	return &Response{
+		Output:   output,
+		Success:  true,
+		Metadata: e.runMetadata(),
+	}, nil
+}
+
+// processRequest runs the reasoning loop until the strategy finds a final answer
+func (e *Engine) processRequest(ctx context.Context) (string, error) {
+	for iteration := 1; iteration <= e.MaxIterations; iteration++ {
+		// Keep the conversation within the model's context window
+		e.ConversationHistory = e.ContextManager.Fit(ctx, e.ConversationHistory)
+
+		// Create a chat completion request
+		chatRequest := &llm.ChatCompletionRequest{
+			Messages:    e.ConversationHistory,
+			Temperature: 0.7,
+		}
+		e.Strategy.PrepareRequest(chatRequest, e.ToolHandler.GenerateToolDefinitions())
+
+		// Send the request to the LLM
+		completion, err := e.complete(ctx, iteration, chatRequest)
+		if err != nil {
+			return "", fmt.Errorf("failed to get chat completion: %w", err)
+		}
+
+		// Check if there are any choices
+		if len(completion.Choices) == 0 {
+			return "", fmt.Errorf("no completion choices returned")
+		}
+
+		// Get the assistant's message and add it to the conversation history
+		message := completion.Choices[0].Message
+		e.ConversationHistory = append(e.ConversationHistory, message)
+
+		decision := e.Strategy.Decide(message)
+		if len(decision.ToolCalls) == 0 {
+			if decision.Answer == "" && e.SummaryPrompt != "" {
+				return e.summarize(ctx)
+			}
+			return decision.Answer, nil
+		}
+
+		if answer, done := e.runTools(ctx, decision); done {
+			return answer, nil
+		}
+	}
+
+	for _, hook := range e.Hooks {
+		if hook.OnIterationLimit != nil {
+			hook.OnIterationLimit(ctx, e.MaxIterations)
+		}
+	}
+
+	if e.SummaryPrompt != "" {
+		return e.summarize(ctx)
+	}
+
+	// If we've reached the maximum number of iterations, return a timeout error
+	return "", fmt.Errorf("reached maximum number of iterations (%d) without completing the task", e.MaxIterations)
+}
+
+// complete sends a chat completion request, streaming the reply if enabled
+func (e *Engine) complete(ctx context.Context, iteration int, request *llm.ChatCompletionRequest) (*llm.ChatCompletionResponse, error) {
+	for _, hook := range e.Hooks {
+		if hook.BeforeLLMCall != nil {
+			hook.BeforeLLMCall(ctx, iteration, request)
+		}
+	}
+
+	var completion *llm.ChatCompletionResponse
+	var err error
+	if e.StreamingEnabled {
+		completion, err = e.completeChat(ctx, e.LLMClient, request, e.textDeltaHandler(ctx))
+	} else {
+		completion, err = e.LLMClient.ChatCompletion(ctx, request)
+		if err == nil {
+			e.recordCompletion(e.LLMClient, completion)
+		}
+	}
+
+	for _, hook := range e.Hooks {
+		if hook.AfterLLMCall != nil {
+			hook.AfterLLMCall(ctx, iteration, completion, err)
+		}
+	}
+
+	return completion, err
+}
+
+// textDeltaHandler returns a function that passes streamed assistant text to
+// the hooks, or nil if no hook wants it
+func (e *Engine) textDeltaHandler(ctx context.Context) func(delta string) {
+	var handlers []func(ctx context.Context, delta string)
+	for _, hook := range e.Hooks {
+		if hook.OnTextDelta != nil {
+			handlers = append(handlers, hook.OnTextDelta)
+		}
+	}
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	return func(delta string) {
+		for _, handler := range handlers {
+			handler(ctx, delta)
+		}
+	}
+}
+
+// runTools runs the tool calls of a decision and records their results in the
+// conversation. It reports whether a hook ended the run, and with what answer.
+func (e *Engine) runTools(ctx context.Context, decision Decision) (string, bool) {
+	failed := false
+	finalAnswer := ""
+
+	for _, toolCall := range decision.ToolCalls {
+		result := e.executeToolCall(ctx, toolCall)
+		if result.Err != nil {
+			failed = true
+		}
+		if result.FinalAnswer != "" {
+			finalAnswer = result.FinalAnswer
+		}
+
+		if decision.TextAction {
+			e.ConversationHistory = append(e.ConversationHistory, llm.Message{
+				Role:    "user",
+				Content: fmt.Sprintf("Observation: %s", result.Content),
+			})
+		} else {
+			e.ConversationHistory = append(e.ConversationHistory, llm.Message{
+				Role:       "tool",
+				Content:    result.Content,
+				ToolCallID: toolCall.ID,
+			})
+		}
+	}
+
+	// Guide the LLM past failed tools if asked to
+	if failed && e.FailureGuidance != "" {
+		e.ConversationHistory = append(e.ConversationHistory, llm.Message{
+			Role:    "user",
+			Content: e.FailureGuidance,
+		})
+	}
+
+	if finalAnswer != "" {
+		e.ConversationHistory = append(e.ConversationHistory, llm.Message{
+			Role:    "assistant",
+			Content: finalAnswer,
+		})
+		return finalAnswer, true
+	}
+
+	return "", false
+}
+
+// executeToolCall runs a single tool call through the hooks
+func (e *Engine) executeToolCall(ctx context.Context, toolCall llm.ToolCall) *ToolResult {
+	result := &ToolResult{}
+
+	for _, hook := range e.Hooks {
+		if hook.BeforeToolCall != nil {
+			if err := hook.BeforeToolCall(ctx, &toolCall); err != nil {
+				result.Err = err
+				break
+			}
+		}
+	}
+
+	start := time.Now()
+	if result.Err == nil {
+		result.Output, result.Err = e.ToolHandler.ExecuteToolCall(ctx, toolCall)
+	}
+	result.Duration = time.Since(start)
+	result.Content = formatToolResult(toolCall.Function.Name, result.Output, result.Err)
+
+	for _, hook := range e.Hooks {
+		if hook.AfterToolCall != nil {
+			hook.AfterToolCall(ctx, toolCall, result)
+		}
+	}
+
+	return result
+}
+
+// summarize asks the LLM for a final answer based on the conversation so far
+func (e *Engine) summarize(ctx context.Context) (string, error) {
+	summaryRequest := &llm.ChatCompletionRequest{
+		Messages: append(e.ConversationHistory, llm.Message{
+			Role:    "user",
+			Content: e.SummaryPrompt,
+		}),
+		Temperature: 0.7,
+	}
+
+	completion, err := e.complete(ctx, e.MaxIterations+1, summaryRequest)
+	if err != nil {
+		return "", fmt.Errorf("failed to get summary completion: %w", err)
+	}
+
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("no summary completion choices returned")
+	}
+
+	// Add the final message to the conversation history
+	message := completion.Choices[0].Message
+	e.ConversationHistory = append(e.ConversationHistory, message)
+
+	return message.Content, nil
+}
+
+// formatToolResult formats a tool's result for the LLM. Commands that ran
+// but exited with a non-zero code are marked as failed.
+func formatToolResult(name string, result interface{}, err error) string {
+	// Tell the model plainly that a policy refused the call
+	var denied *ToolDeniedError
+	if errors.As(err, &denied) {
+		deniedJSON, _ := json.MarshalIndent(map[string]interface{}{
+			"success": false,
+			"denied":  true,
+			"tool":    denied.Tool,
+			"error":   denied.Error(),
+			"reason":  denied.Reason,
+		}, "", "  ")
+		return string(deniedJSON)
+	}
+
+	if err != nil {
+		return fmt.Sprintf("Tool execution failed: %s\nError: %v", name, err)
+	}
+
+	resultJSON, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("%v", result)
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal(resultJSON, &fields) != nil {
+		return string(resultJSON)
+	}
+
+	for _, key := range []string{"exit_code", "exitCode"} {
+		exitCode, ok := fields[key].(float64)
+		if !ok {
+			continue
+		}
+
+		if exitCode != 0 {
+			fields["success"] = false
+			fields["error"] = fmt.Sprintf("Command failed with exit code %d", int(exitCode))
+		} else {
+			fields["success"] = true
+		}
+
+		if marked, err := json.MarshalIndent(fields, "", "  "); err == nil {
+			resultJSON = marked
+		}
+		break
+	}
+
+	return string(resultJSON)
+}
+
+// ExecuteTool executes a tool by name
+func (e *Engine) ExecuteTool(ctx context.Context, name string, params map[string]interface{}) (interface{}, error) {
+	return e.ToolHandler.ExecuteToolByName(ctx, name, params)
+}
+
+// GetConversationHistory returns the conversation history
+func (e *Engine) GetConversationHistory() []llm.Message {
+	return e.ConversationHistory
+}
+
+// SaveConversation saves the conversation history to memory
+func (e *Engine) SaveConversation(ctx context.Context, key string) error {
+	// Convert the conversation history to JSON
+	conversationJSON, err := json.Marshal(e.ConversationHistory)
+	if err != nil {
+		return fmt.Errorf("failed to marshal conversation history: %w", err)
+	}
+
+	// Save the conversation history to memory
+	return e.SaveMemory(ctx, key, string(conversationJSON))
+}
+
+// LoadConversation loads conversation history from memory
+func (e *Engine) LoadConversation(ctx context.Context, key string) error {
+	// Get the conversation history from memory
+	value, err := e.LoadMemory(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to get conversation history from memory: %w", err)
+	}
+
+	// Convert the value to a string
+	conversationJSON, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("conversation history is not a string")
+	}
+
+	// Parse the conversation history
+	var conversationHistory []llm.Message
+	if err := json.Unmarshal([]byte(conversationJSON), &conversationHistory); err != nil {
+		return fmt.Errorf("failed to unmarshal conversation history: %w", err)
+	}
+
+	// Set the conversation history
+	e.ConversationHistory = conversationHistory
+
+	return nil
+}
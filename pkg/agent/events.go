@@ -0,0 +1,292 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// EventType identifies the kind of an event
+type EventType string
+
+// Agent event types
+const (
+	EventRunStarted        EventType = "run_started"
+	EventLLMRequest        EventType = "llm_request"
+	EventLLMResponse       EventType = "llm_response"
+	EventTextDelta         EventType = "text_delta"
+	EventToolCallStarted   EventType = "tool_call_started"
+	EventToolCallFinished  EventType = "tool_call_finished"
+	EventIterationLimit    EventType = "iteration_limit"
+	EventRunFinished       EventType = "run_finished"
+	EventApprovalRequested EventType = "approval_requested"
+	EventApprovalResolved  EventType = "approval_resolved"
+)
+
+// Event is something that happened while an agent or flow was running
+type Event interface {
+	// Info returns the type, time and origin of the event
+	Info() EventInfo
+}
+
+// EventInfo describes an event and where it came from. It is embedded in
+// every event type.
+type EventInfo struct {
+	Type   EventType `json:"type"`
+	Time   time.Time `json:"time"`
+	FlowID string    `json:"flow_id,omitempty"`
+	StepID string    `json:"step_id,omitempty"`
+	RunID  string    `json:"run_id,omitempty"`
+	Agent  string    `json:"agent,omitempty"`
+}
+
+// Info returns the type, time and origin of the event
+func (i EventInfo) Info() EventInfo {
+	return i
+}
+
+// NewEventInfo returns the info for an event of the given type, taking its
+// origin from the usage scope of the context
+func NewEventInfo(ctx context.Context, eventType EventType) EventInfo {
+	scope := llm.UsageScopeFromContext(ctx)
+
+	return EventInfo{
+		Type:   eventType,
+		Time:   time.Now(),
+		FlowID: scope.FlowID,
+		StepID: scope.StepID,
+		RunID:  scope.RunID,
+		Agent:  scope.Agent,
+	}
+}
+
+// RunStartedEvent is published when an agent starts processing a request
+type RunStartedEvent struct {
+	EventInfo
+	Input string `json:"input"`
+}
+
+// LLMRequestEvent is published before a chat completion request is sent
+type LLMRequestEvent struct {
+	EventInfo
+	Iteration int `json:"iteration"`
+	Messages  int `json:"messages"`
+	Tools     int `json:"tools"`
+}
+
+// LLMResponseEvent is published when a chat completion request returns
+type LLMResponseEvent struct {
+	EventInfo
+	Iteration int       `json:"iteration"`
+	Provider  string    `json:"provider,omitempty"`
+	Model     string    `json:"model,omitempty"`
+	Content   string    `json:"content,omitempty"`
+	ToolCalls []string  `json:"tool_calls,omitempty"`
+	Usage     llm.Usage `json:"usage"`
+	Cost      float64   `json:"cost,omitempty"`
+	Duration  float64   `json:"duration"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// TextDeltaEvent carries assistant text as it is streamed from the LLM
+type TextDeltaEvent struct {
+	EventInfo
+	Content string `json:"content"`
+}
+
+// ToolCallStartedEvent is published before a tool runs
+type ToolCallStartedEvent struct {
+	EventInfo
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Tool       string `json:"tool"`
+	Arguments  string `json:"arguments,omitempty"`
+}
+
+// ToolCallFinishedEvent is published after a tool has run
+type ToolCallFinishedEvent struct {
+	EventInfo
+	ToolCallID string  `json:"tool_call_id,omitempty"`
+	Tool       string  `json:"tool"`
+	Result     string  `json:"result,omitempty"`
+	Duration   float64 `json:"duration"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// IterationLimitEvent is published when a run reaches its iteration limit
+type IterationLimitEvent struct {
+	EventInfo
+	Iterations int `json:"iterations"`
+}
+
+// RunFinishedEvent is published when an agent has finished a request
+type RunFinishedEvent struct {
+	EventInfo
+	Success bool   `json:"success"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ApprovalRequestedEvent is published when a tool call is waiting for approval
+type ApprovalRequestedEvent struct {
+	EventInfo
+	Request ApprovalRequest `json:"request"`
+}
+
+// ApprovalResolvedEvent is published when an approval request has been decided
+type ApprovalResolvedEvent struct {
+	EventInfo
+	ApprovalID string           `json:"approval_id"`
+	Tool       string           `json:"tool"`
+	Decision   ApprovalDecision `json:"decision"`
+}
+
+// Subscriber receives the events published on a bus. Subscribers are called
+// synchronously and should return quickly.
+type Subscriber func(event Event)
+
+// EventBus delivers events to its subscribers
+type EventBus struct {
+	mutex       sync.RWMutex
+	subscribers map[int]Subscriber
+	nextID      int
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[int]Subscriber),
+	}
+}
+
+// Subscribe registers a subscriber and returns a function that removes it
+func (b *EventBus) Subscribe(subscriber Subscriber) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = subscriber
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		delete(b.subscribers, id)
+	}
+}
+
+// Publish delivers an event to every subscriber
+func (b *EventBus) Publish(event Event) {
+	b.mutex.RLock()
+	subscribers := make([]Subscriber, 0, len(b.subscribers))
+	for _, subscriber := range b.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	b.mutex.RUnlock()
+
+	for _, subscriber := range subscribers {
+		subscriber(event)
+	}
+}
+
+// Hook returns a hook that publishes an agent's run events on the bus. Add
+// it to each agent whose events should be published.
+func (b *EventBus) Hook() Hook {
+	var mutex sync.Mutex
+	var requestStart time.Time
+
+	return Hook{
+		OnRunStart: func(ctx context.Context, request *Request) {
+			b.Publish(RunStartedEvent{
+				EventInfo: NewEventInfo(ctx, EventRunStarted),
+				Input:     request.Input,
+			})
+		},
+		BeforeLLMCall: func(ctx context.Context, iteration int, request *llm.ChatCompletionRequest) {
+			mutex.Lock()
+			requestStart = time.Now()
+			mutex.Unlock()
+
+			b.Publish(LLMRequestEvent{
+				EventInfo: NewEventInfo(ctx, EventLLMRequest),
+				Iteration: iteration,
+				Messages:  len(request.Messages),
+				Tools:     len(request.Tools),
+			})
+		},
+		AfterLLMCall: func(ctx context.Context, iteration int, response *llm.ChatCompletionResponse, err error) {
+			mutex.Lock()
+			duration := time.Since(requestStart)
+			mutex.Unlock()
+
+			event := LLMResponseEvent{
+				EventInfo: NewEventInfo(ctx, EventLLMResponse),
+				Iteration: iteration,
+				Duration:  duration.Seconds(),
+			}
+			if err != nil {
+				event.Error = err.Error()
+			} else {
+				event.Provider = response.Provider
+				event.Model = response.Model
+				event.Usage = response.Usage
+				event.Cost = response.Cost
+				if len(response.Choices) > 0 {
+					message := response.Choices[0].Message
+					event.Content = message.Content
+					for _, toolCall := range message.ToolCalls {
+						event.ToolCalls = append(event.ToolCalls, toolCall.Function.Name)
+					}
+				}
+			}
+			b.Publish(event)
+		},
+		OnTextDelta: func(ctx context.Context, delta string) {
+			b.Publish(TextDeltaEvent{
+				EventInfo: NewEventInfo(ctx, EventTextDelta),
+				Content:   delta,
+			})
+		},
+		BeforeToolCall: func(ctx context.Context, toolCall *llm.ToolCall) error {
+			b.Publish(ToolCallStartedEvent{
+				EventInfo:  NewEventInfo(ctx, EventToolCallStarted),
+				ToolCallID: toolCall.ID,
+				Tool:       toolCall.Function.Name,
+				Arguments:  toolCall.Function.Arguments,
+			})
+			return nil
+		},
+		AfterToolCall: func(ctx context.Context, toolCall llm.ToolCall, result *ToolResult) {
+			event := ToolCallFinishedEvent{
+				EventInfo:  NewEventInfo(ctx, EventToolCallFinished),
+				ToolCallID: toolCall.ID,
+				Tool:       toolCall.Function.Name,
+				Result:     result.Content,
+				Duration:   result.Duration.Seconds(),
+			}
+			if result.Err != nil {
+				event.Error = result.Err.Error()
+			}
+			b.Publish(event)
+		},
+		OnIterationLimit: func(ctx context.Context, iterations int) {
+			b.Publish(IterationLimitEvent{
+				EventInfo:  NewEventInfo(ctx, EventIterationLimit),
+				Iterations: iterations,
+			})
+		},
+		OnRunFinish: func(ctx context.Context, output string, err error) {
+			event := RunFinishedEvent{
+				EventInfo: NewEventInfo(ctx, EventRunFinished),
+				Success:   err == nil,
+				Output:    output,
+			}
+			if err != nil {
+				event.Error = err.Error()
+			}
+			b.Publish(event)
+		},
+	}
+}
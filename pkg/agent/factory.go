@@ -14,6 +14,8 @@ const (
 	AgentTypeReAct AgentType = "react"
 	// AgentTypeToolCall is an agent specialized for tool execution
 	AgentTypeToolCall AgentType = "toolcall"
+	// AgentTypeCommandForge combines structured tool calls with ReAct reasoning
+	AgentTypeCommandForge AgentType = "commandforge"
 )
 
 // Factory creates and configures agents
@@ -41,6 +43,8 @@ func (f *Factory) CreateAgent(agentType AgentType) Agent {
 	case AgentTypeToolCall:
 		// Create a ToolCall agent for executing tools
 		return NewToolCallAgent("toolcall", f.LLMClient, f.Memory)
+	case AgentTypeCommandForge:
+		return NewCommandForgeAgent("commandforge", f.LLMClient, f.Memory)
 	default:
 		// Default to forge agent
 		return NewForgeAgent("forge", f.LLMClient, f.Memory)
@@ -2,34 +2,20 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	uri "net/url"
 
 	"github.com/prathyushnallamothu/commandforge/pkg/llm"
-	"github.com/prathyushnallamothu/commandforge/pkg/tools"
 )
 
 // ForgeAgent is the main agent implementation
 type ForgeAgent struct {
-	*BaseAgent
-	LLMClient           llm.Client
-	ToolHandler         *llm.ToolCallingHandler
-	ToolCollection      *tools.ToolCollection
-	ConversationHistory []llm.Message
-	MaxHistorySize      int
-	SystemPrompt        string
+	*Engine
 }
 
 // NewForgeAgent creates a new forge agent
 func NewForgeAgent(name string, llmClient llm.Client, memory Memory) *ForgeAgent {
-	// Create tool collection
-	toolCollection := tools.NewToolCollection()
-
-	// Create tool handler
-	toolHandler := llm.NewToolCallingHandler(toolCollection)
-
 	// Create base agent
 	baseAgent := NewBaseAgent(
 		name,
@@ -37,20 +23,25 @@ func NewForgeAgent(name string, llmClient llm.Client, memory Memory) *ForgeAgent
 		memory,
 	)
 
-	// Create forge agent
-	agent := &ForgeAgent{
-		BaseAgent:           baseAgent,
-		LLMClient:           llmClient,
-		ToolHandler:         toolHandler,
-		ToolCollection:      toolCollection,
-		ConversationHistory: make([]llm.Message, 0),
-		MaxHistorySize:      50,
-		SystemPrompt:        defaultSystemPrompt,
-	}
+	// Create forge agent on an engine using native tool calls
+	engine := NewEngine(baseAgent, llmClient, NativeToolStrategy{})
+	engine.SystemPrompt = defaultSystemPrompt
+	engine.MaxIterations = 6 // The first request and up to five rounds of follow-up tool calls
+	engine.FailureGuidance = forgeFailureGuidance
+	engine.SummaryPrompt = forgeSummaryPrompt
+
+	agent := &ForgeAgent{Engine: engine}
+	agent.AddHook(Hook{AfterToolCall: agent.webSearchFallback})
 
 	return agent
 }
 
+// forgeFailureGuidance is sent after a round of tool calls in which a tool failed
+const forgeFailureGuidance = "Some tools failed to execute. Please use the successful results to provide the best possible response, and consider alternative approaches for the failed tools."
+
+// forgeSummaryPrompt asks for a final answer when the tool calls do not end in one
+const forgeSummaryPrompt = "Please provide a comprehensive summary of the information you've gathered. Make sure to include all relevant details and answer the original query thoroughly."
+
 // defaultSystemPrompt is the default system prompt for the agent
 const defaultSystemPrompt = `You are CommandForge, a highly autonomous AI agent designed to help users execute commands and perform tasks.
 
@@ -98,335 +89,62 @@ func (a *ForgeAgent) WithSystemPrompt(prompt string) *ForgeAgent {
 	return a
 }
 
-// WithMaxHistorySize sets the maximum conversation history size
+// WithMaxHistorySize caps the number of messages kept in the conversation
 func (a *ForgeAgent) WithMaxHistorySize(size int) *ForgeAgent {
-	a.MaxHistorySize = size
+	a.ContextManager.WithMaxMessages(size)
 	return a
 }
 
-// AddTool adds a tool to the agent
-func (a *ForgeAgent) AddTool(tool tools.Tool) error {
-	return a.ToolCollection.AddTool(tool)
+// WithContextManager sets the context manager that keeps the conversation
+// within the model's context window
+func (a *ForgeAgent) WithContextManager(manager *ContextManager) *ForgeAgent {
+	a.ContextManager = manager
+	return a
 }
 
-// Initialize sets up the agent
-func (a *ForgeAgent) Initialize(ctx context.Context) error {
-	// Initialize the base agent
-	if err := a.BaseAgent.Initialize(ctx); err != nil {
-		return err
-	}
-
-	// Add the system message to the conversation history
-	a.ConversationHistory = append(a.ConversationHistory, llm.Message{
-		Role:    "system",
-		Content: a.SystemPrompt,
-	})
-
-	return nil
+// WithMaxIterations sets the maximum number of reasoning iterations
+func (a *ForgeAgent) WithMaxIterations(iterations int) *ForgeAgent {
+	a.MaxIterations = iterations
+	return a
 }
 
-// Run processes a user request
-func (a *ForgeAgent) Run(ctx context.Context, request *Request) (*Response, error) {
-	// Update agent state
-	a.setState(StateRunning)
-
-	// Add user message to conversation history
-	a.ConversationHistory = append(a.ConversationHistory, llm.Message{
-		Role:    "user",
-		Content: request.Input,
-	})
-
-	// Prepare the response
-	response := &Response{
-		Success:  true,
-		Metadata: make(map[string]interface{}),
-	}
-
-	// Process the request
-	output, err := a.processRequest(ctx, request)
-	if err != nil {
-		a.setState(StateError)
-		response.Success = false
-		response.Error = err.Error()
-		return response, nil
-	}
-
-	// Update the response
-	response.Output = output
-
-	// Update agent state
-	a.setState(StateIdle)
-
-	return response, nil
+// WithStrategy sets the reasoning strategy
+func (a *ForgeAgent) WithStrategy(strategy Strategy) *ForgeAgent {
+	a.SetStrategy(strategy)
+	return a
 }
 
-// processRequest processes a user request
-func (a *ForgeAgent) processRequest(ctx context.Context, request *Request) (string, error) {
-	// Create a chat completion request
-	chatRequest := &llm.ChatCompletionRequest{
-		Messages:    a.ConversationHistory,
-		Tools:       a.ToolHandler.GenerateToolDefinitions(),
-		Temperature: 0.7,
-	}
-
-	// Send the request to the LLM
-	completion, err := a.LLMClient.ChatCompletion(ctx, chatRequest)
-	if err != nil {
-		return "", fmt.Errorf("failed to get chat completion: %w", err)
-	}
-
-	// Check if there are any choices
-	if len(completion.Choices) == 0 {
-		return "", fmt.Errorf("no completion choices returned")
-	}
-
-	// Get the assistant's message
-	message := completion.Choices[0].Message
-
-	// Add the message to the conversation history
-	a.ConversationHistory = append(a.ConversationHistory, message)
-
-	// Check for tool calls
-	toolCalls, err := llm.ParseToolCalls(message)
-	if err != nil || len(toolCalls) == 0 {
-		// No tool calls, just return the message content
-		return message.Content, nil
-	}
-
-	// Track tool execution failures for better fallback handling
-	type toolExecutionResult struct {
-		toolCall llm.ToolCall
-		success  bool
-		error    error
-		results  []llm.Message
-	}
-
-	// Process tool calls in a loop to handle sequential tool calling
-	maxIterations := 5 // Prevent infinite loops
-	for i := 0; i < maxIterations; i++ {
-		// Process each tool call individually and collect all results
-		var allToolResults []llm.Message
-		var executionResults []toolExecutionResult
-		var hasFailures bool
-
-		for _, tc := range toolCalls {
-			// Process a single tool call
-			toolResults, err := a.ToolHandler.ProcessToolCalls(ctx, []llm.ToolCall{tc})
-
-			// Record the execution result regardless of success/failure
-			execResult := toolExecutionResult{
-				toolCall: tc,
-				success:  err == nil,
-				error:    err,
-				results:  toolResults,
-			}
-			executionResults = append(executionResults, execResult)
-
-			if err != nil {
-				// Handle tool execution failure
-				hasFailures = true
-
-				// Create an error message for the failed tool call
-				errorMessage := llm.Message{
-					Role:       "tool",
-					Content:    fmt.Sprintf("Error executing %s tool: %v", tc.Function.Name, err),
-					ToolCallID: tc.ID,
-				}
-
-				// Special handling for web_browser tool failures
-				if tc.Function.Name == "web_browser" {
-					// Try to fallback to web_search if browser navigation fails
-					var args map[string]interface{}
-					json.Unmarshal([]byte(tc.Function.Arguments), &args)
-
-					if url, ok := args["url"].(string); ok {
-						// Extract domain for search context
-						parsedURL, parseErr := uri.Parse(url)
-						var domain string
-						if parseErr == nil {
-							domain = parsedURL.Hostname()
-						}
-
-						// Create a search query based on the URL
-						searchParams := map[string]interface{}{
-							"query": fmt.Sprintf("information about %s", url),
-						}
-						if domain != "" {
-							searchParams["domain"] = domain
-						}
-
-						// Execute web search as fallback
-						searchResults, searchErr := a.ToolHandler.ExecuteToolByName(ctx, "web_search", searchParams)
-						if searchErr == nil {
-							// Add fallback message
-							errorMessage.Content += fmt.Sprintf("\n\nFalling back to web search for information about %s", url)
-
-							// Add search results
-							searchResultMsg := llm.Message{
-								Role:       "tool",
-								Content:    fmt.Sprintf("Web search results: %v", searchResults),
-								ToolCallID: tc.ID,
-							}
-							allToolResults = append(allToolResults, searchResultMsg)
-						}
-					}
-				}
-
-				// Add the error message to results
-				allToolResults = append(allToolResults, errorMessage)
-			} else {
-				// Add successful tool results
-				allToolResults = append(allToolResults, toolResults...)
-			}
-		}
-
-		// Add all tool results to conversation history at once
-		a.ConversationHistory = append(a.ConversationHistory, allToolResults...)
-
-		// If there were failures, add a special message to guide the LLM
-		if hasFailures {
-			guidanceMsg := llm.Message{
-				Role:    "user",
-				Content: "Some tools failed to execute. Please use the successful results to provide the best possible response, and consider alternative approaches for the failed tools.",
-			}
-			a.ConversationHistory = append(a.ConversationHistory, guidanceMsg)
-		}
-
-		// Create a follow-up request to process the tool results
-		followUpRequest := &llm.ChatCompletionRequest{
-			Messages:    a.ConversationHistory,
-			Tools:       a.ToolHandler.GenerateToolDefinitions(),
-			Temperature: 0.7,
-		}
-
-		// Send the follow-up request to the LLM
-		followUpCompletion, err := a.LLMClient.ChatCompletion(ctx, followUpRequest)
-		if err != nil {
-			return "", fmt.Errorf("failed to get follow-up completion: %w", err)
-		}
-
-		// Check if there are any choices
-		if len(followUpCompletion.Choices) == 0 {
-			return "", fmt.Errorf("no follow-up completion choices returned")
-		}
-
-		// Get the follow-up message
-		followUpMessage := followUpCompletion.Choices[0].Message
-
-		// Add the follow-up message to the conversation history
-		a.ConversationHistory = append(a.ConversationHistory, followUpMessage)
-
-		// Trim conversation history if needed
-		a.trimConversationHistory()
-
-		// Check for more tool calls in the follow-up message
-		moreCalls, _ := llm.ParseToolCalls(followUpMessage)
-		if len(moreCalls) == 0 {
-			// No more tool calls, return the final message content if available
-			if followUpMessage.Content != "" {
-				return followUpMessage.Content, nil
-			}
-			break // Break the loop to generate a summary if content is empty
-		}
-
-		// Continue with the next iteration of tool calls
-		toolCalls = moreCalls
-	}
-
-	// If we've reached the maximum number of iterations or exited the loop without a response,
-	// generate a final summary based on the conversation history
-	finalRequest := &llm.ChatCompletionRequest{
-		Messages: append(a.ConversationHistory, llm.Message{
-			Role:    "user",
-			Content: "Please provide a comprehensive summary of the information you've gathered. Make sure to include all relevant details and answer the original query thoroughly.",
-		}),
-		Temperature: 0.7,
-	}
-
-	// Send the final request to the LLM
-	finalCompletion, err := a.LLMClient.ChatCompletion(ctx, finalRequest)
-	if err != nil {
-		return "I encountered an error while summarizing the information. Please try again with a more specific query.", nil
-	}
-
-	// Check if there are any choices
-	if len(finalCompletion.Choices) == 0 {
-		return "I couldn't generate a summary of the information. Please try again with a more specific query.", nil
-	}
-
-	// Get the final message and add it to the conversation history
-	finalMessage := finalCompletion.Choices[0].Message
-	a.ConversationHistory = append(a.ConversationHistory, finalMessage)
-
-	// Return the final message content
-	return finalMessage.Content, nil
+// WithHook adds a hook to the agent's run loop
+func (a *ForgeAgent) WithHook(hook Hook) *ForgeAgent {
+	a.AddHook(hook)
+	return a
 }
 
-// trimConversationHistory trims the conversation history to the maximum size
-func (a *ForgeAgent) trimConversationHistory() {
-	// Check if we need to trim
-	if len(a.ConversationHistory) <= a.MaxHistorySize {
+// webSearchFallback searches the web for information about a page the
+// web_browser tool failed to load, and adds the results to the tool's result
+func (a *ForgeAgent) webSearchFallback(ctx context.Context, toolCall llm.ToolCall, result *ToolResult) {
+	if toolCall.Function.Name != "web_browser" || result.Err == nil {
 		return
 	}
 
-	// Keep the system message and the most recent messages
-	newHistory := make([]llm.Message, 0, a.MaxHistorySize)
-
-	// Always keep the system message (assumed to be the first message)
-	newHistory = append(newHistory, a.ConversationHistory[0])
-
-	// Keep the most recent messages
-	start := len(a.ConversationHistory) - a.MaxHistorySize + 1
-	newHistory = append(newHistory, a.ConversationHistory[start:]...)
-
-	// Update the conversation history
-	a.ConversationHistory = newHistory
-}
-
-// ExecuteTool executes a tool by name
-func (a *ForgeAgent) ExecuteTool(ctx context.Context, name string, params map[string]interface{}) (interface{}, error) {
-	return a.ToolHandler.ExecuteToolByName(ctx, name, params)
-}
-
-// GetConversationHistory returns the conversation history
-func (a *ForgeAgent) GetConversationHistory() []llm.Message {
-	return a.ConversationHistory
-}
-
-// SaveConversation saves the conversation history to memory
-func (a *ForgeAgent) SaveConversation(ctx context.Context, key string) error {
-	// Convert conversation history to JSON
-	data, err := json.Marshal(a.ConversationHistory)
-	if err != nil {
-		return fmt.Errorf("failed to marshal conversation history: %w", err)
+	url, ok := toolCall.Args["url"].(string)
+	if !ok {
+		return
 	}
 
-	// Save to memory
-	return a.SaveMemory(ctx, key, string(data))
-}
-
-// LoadConversation loads conversation history from memory
-func (a *ForgeAgent) LoadConversation(ctx context.Context, key string) error {
-	// Load from memory
-	data, err := a.LoadMemory(ctx, key)
-	if err != nil {
-		return fmt.Errorf("failed to load conversation history: %w", err)
+	// Create a search query based on the URL
+	searchParams := map[string]interface{}{
+		"query": fmt.Sprintf("information about %s", url),
 	}
-
-	// Convert to string
-	dataStr, ok := data.(string)
-	if !ok {
-		return fmt.Errorf("invalid conversation history format")
+	if parsedURL, err := uri.Parse(url); err == nil && parsedURL.Hostname() != "" {
+		searchParams["domain"] = parsedURL.Hostname()
 	}
 
-	// Parse JSON
-	var history []llm.Message
-	if err := json.Unmarshal([]byte(dataStr), &history); err != nil {
-		return fmt.Errorf("failed to unmarshal conversation history: %w", err)
+	// Execute web search as fallback
+	searchResults, err := a.ToolHandler.ExecuteToolByName(ctx, "web_search", searchParams)
+	if err != nil {
+		return
 	}
 
-	// Update conversation history
-	a.ConversationHistory = history
-
-	return nil
+	result.Content += fmt.Sprintf("\n\nFalling back to web search for information about %s\nWeb search results: %v", url, searchResults)
 }
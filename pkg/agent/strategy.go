@@ -0,0 +1,245 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// Names of the built-in reasoning strategies
+const (
+	// StrategyNative uses the provider's structured tool calling
+	StrategyNative = "native"
+	// StrategyReAct describes the tools in the prompt and parses
+	// Thought/Action/Action Input text from the reply
+	StrategyReAct = "react"
+	// StrategyHybrid offers structured tool calls and also accepts ReAct text
+	StrategyHybrid = "hybrid"
+)
+
+// Decision is a strategy's reading of an assistant reply
+type Decision struct {
+	// ToolCalls are the tools to run before asking the LLM again. The run
+	// ends with Answer when there are none.
+	ToolCalls []llm.ToolCall
+	// TextAction reports that the tool calls were parsed from ReAct text, so
+	// their results are sent back as Observation messages instead of tool messages
+	TextAction bool
+	// Answer is the final answer to the user
+	Answer string
+}
+
+// Strategy decides how the engine asks the LLM for its next step and how it
+// reads the reply
+type Strategy interface {
+	// Name returns the name of the strategy
+	Name() string
+
+	// PrepareRequest adds the tools to a chat completion request, either as
+	// definitions or as part of the prompt
+	PrepareRequest(request *llm.ChatCompletionRequest, tools []llm.ToolDefinition)
+
+	// Decide reads an assistant reply
+	Decide(message llm.Message) Decision
+}
+
+// NewStrategy returns the built-in strategy with the given name
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case StrategyNative:
+		return NativeToolStrategy{}, nil
+	case StrategyReAct:
+		return ReActTextStrategy{}, nil
+	case StrategyHybrid:
+		return HybridStrategy{}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy: %s", name)
+	}
+}
+
+// NativeToolStrategy relies on the provider's structured tool calling
+type NativeToolStrategy struct{}
+
+// Name returns the name of the strategy
+func (NativeToolStrategy) Name() string {
+	return StrategyNative
+}
+
+// PrepareRequest attaches the tool definitions to the request
+func (NativeToolStrategy) PrepareRequest(request *llm.ChatCompletionRequest, tools []llm.ToolDefinition) {
+	request.Tools = tools
+}
+
+// Decide runs the reply's tool calls, or answers with its content
+func (NativeToolStrategy) Decide(message llm.Message) Decision {
+	toolCalls, err := llm.ParseToolCalls(message)
+	if err == nil && len(toolCalls) > 0 {
+		return Decision{ToolCalls: toolCalls}
+	}
+
+	return Decision{Answer: message.Content}
+}
+
+// ReActTextStrategy drives tools through Thought/Action/Action Input text,
+// for models without structured tool calling
+type ReActTextStrategy struct{}
+
+// Name returns the name of the strategy
+func (ReActTextStrategy) Name() string {
+	return StrategyReAct
+}
+
+// PrepareRequest describes the tools in a system message after the system prompt
+func (ReActTextStrategy) PrepareRequest(request *llm.ChatCompletionRequest, tools []llm.ToolDefinition) {
+	if len(tools) == 0 {
+		return
+	}
+
+	leading := 0
+	for leading < len(request.Messages) && request.Messages[leading].Role == "system" {
+		leading++
+	}
+
+	messages := make([]llm.Message, 0, len(request.Messages)+1)
+	messages = append(messages, request.Messages[:leading]...)
+	messages = append(messages, llm.Message{Role: "system", Content: describeTools(tools)})
+	messages = append(messages, request.Messages[leading:]...)
+	request.Messages = messages
+}
+
+// Decide answers with a Final Answer, or runs the Action in the reply
+func (ReActTextStrategy) Decide(message llm.Message) Decision {
+	if strings.Contains(message.Content, "Final Answer:") {
+		return Decision{Answer: extractFinalAnswer(message.Content)}
+	}
+
+	if toolCall, ok := parseReActToolCall(message.Content); ok {
+		return Decision{ToolCalls: []llm.ToolCall{toolCall}, TextAction: true}
+	}
+
+	return Decision{Answer: message.Content}
+}
+
+// HybridStrategy offers structured tool calls and also follows ReAct text,
+// whichever the model replies with
+type HybridStrategy struct{}
+
+// Name returns the name of the strategy
+func (HybridStrategy) Name() string {
+	return StrategyHybrid
+}
+
+// PrepareRequest attaches the tool definitions to the request
+func (HybridStrategy) PrepareRequest(request *llm.ChatCompletionRequest, tools []llm.ToolDefinition) {
+	request.Tools = tools
+}
+
+// Decide prefers a Final Answer, then structured tool calls, then a ReAct Action
+func (HybridStrategy) Decide(message llm.Message) Decision {
+	if strings.Contains(message.Content, "Final Answer:") {
+		return Decision{Answer: extractFinalAnswer(message.Content)}
+	}
+
+	toolCalls, err := llm.ParseToolCalls(message)
+	if err == nil && len(toolCalls) > 0 {
+		return Decision{ToolCalls: toolCalls}
+	}
+
+	if toolCall, ok := parseReActToolCall(message.Content); ok {
+		return Decision{ToolCalls: []llm.ToolCall{toolCall}, TextAction: true}
+	}
+
+	return Decision{Answer: message.Content}
+}
+
+// describeTools lists the tools and the ReAct format for calling them
+func describeTools(tools []llm.ToolDefinition) string {
+	var sb strings.Builder
+	sb.WriteString("To use a tool, reply with:\nThought: <your reasoning>\nAction: <the tool name>\nAction Input: <the tool arguments as a JSON object>\n\n")
+	sb.WriteString("You will receive the result as an Observation. When you are done, reply with:\nFinal Answer: <your response to the user>\n\nAvailable tools:\n")
+
+	for _, tool := range tools {
+		parameters, err := json.Marshal(tool.Function.Parameters)
+		if err != nil {
+			parameters = []byte("{}")
+		}
+		sb.WriteString(fmt.Sprintf("- %s: %s\n  Parameters: %s\n", tool.Function.Name, tool.Function.Description, parameters))
+	}
+
+	return sb.String()
+}
+
+// parseReActToolCall turns the Action in a ReAct reply into a tool call
+func parseReActToolCall(content string) (llm.ToolCall, bool) {
+	action, actionInput, err := parseReActPattern(content)
+	if err != nil || action == "" {
+		return llm.ToolCall{}, false
+	}
+
+	// If the action input isn't valid JSON, use it as a string parameter
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(actionInput), &params); err != nil {
+		params = map[string]interface{}{
+			"input": actionInput,
+		}
+	}
+
+	return llm.ToolCall{
+		Type: "function",
+		Function: llm.ToolCallFunction{
+			Name:      action,
+			Arguments: actionInput,
+		},
+		Args: params,
+	}, true
+}
+
+// parseReActPattern parses the ReAct pattern from a message
+func parseReActPattern(content string) (string, string, error) {
+	// Allow the Action to start the message
+	content = "\n" + content
+
+	// Check for the Action: pattern
+	actionIndex := strings.Index(content, "\nAction:")
+	if actionIndex == -1 {
+		return "", "", fmt.Errorf("no Action found in message")
+	}
+
+	// Check for the Action Input: pattern
+	actionInputIndex := strings.Index(content, "\nAction Input:")
+	if actionInputIndex == -1 || actionInputIndex < actionIndex {
+		return "", "", fmt.Errorf("no Action Input found in message")
+	}
+
+	// Extract the action
+	action := content[actionIndex+9 : actionInputIndex]
+	action = strings.TrimSpace(action)
+
+	// Extract the action input
+	actionInput := content[actionInputIndex+14:]
+
+	// Check if there's an Observation after the Action Input
+	observationIndex := strings.Index(actionInput, "\nObservation:")
+	if observationIndex != -1 {
+		actionInput = actionInput[:observationIndex]
+	}
+
+	actionInput = strings.TrimSpace(actionInput)
+
+	return action, actionInput, nil
+}
+
+// extractFinalAnswer extracts the final answer from a message
+func extractFinalAnswer(content string) string {
+	// Check for the Final Answer: pattern
+	finalAnswerIndex := strings.Index(content, "Final Answer:")
+	if finalAnswerIndex == -1 {
+		return content
+	}
+
+	// Extract the final answer
+	finalAnswer := content[finalAnswerIndex+13:]
+	return strings.TrimSpace(finalAnswer)
+}
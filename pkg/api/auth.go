@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Role decides what an authenticated user may do
+type Role string
+
+const (
+	// RoleViewer may read its own flows, their commands and their events
+	RoleViewer Role = "viewer"
+	// RoleOperator may also create flows, run commands in them and decide
+	// their approvals
+	RoleOperator Role = "operator"
+	// RoleAdmin may do anything, with the flows of all users
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole parses the name of a role
+func ParseRole(name string) (Role, error) {
+	switch role := Role(strings.ToLower(name)); role {
+	case RoleViewer, RoleOperator, RoleAdmin:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role: %s", name)
+	}
+}
+
+// Permission is what a route needs from the role of the user
+type Permission int
+
+const (
+	// PermissionRead allows reading flows, commands and events
+	PermissionRead Permission = iota
+	// PermissionExecute allows changing flows and running commands
+	PermissionExecute
+)
+
+// allows reports whether the role has a permission
+func (r Role) allows(permission Permission) bool {
+	switch r {
+	case RoleAdmin, RoleOperator:
+		return true
+	case RoleViewer:
+		return permission == PermissionRead
+	default:
+		return false
+	}
+}
+
+// Credential is an API key and the user it authenticates. The key can be
+// given as is, or as the hex SHA-256 hash of it so that the configuration
+// does not hold the key itself.
+type Credential struct {
+	Key     string
+	KeyHash string
+	User    string
+	Role    Role
+}
+
+// Principal is the user a request is made by
+type Principal struct {
+	User string `json:"user"`
+	Role Role   `json:"role"`
+}
+
+// anonymous is the principal of requests to a server without authentication
+var anonymous = Principal{Role: RoleAdmin}
+
+// Authenticator checks the API keys of requests. A key is sent in the
+// X-API-Key header, or as a bearer token in the Authorization header.
+type Authenticator struct {
+	// principals are keyed by the SHA-256 hash of the API key
+	principals map[[sha256.Size]byte]Principal
+}
+
+// NewAuthenticator creates an authenticator accepting the given credentials
+func NewAuthenticator(credentials []Credential) (*Authenticator, error) {
+	a := &Authenticator{principals: make(map[[sha256.Size]byte]Principal)}
+
+	for i, credential := range credentials {
+		if credential.User == "" {
+			return nil, fmt.Errorf("credential %d has no user", i+1)
+		}
+		if !credential.Role.allows(PermissionRead) {
+			return nil, fmt.Errorf("credential of %s has unknown role %q", credential.User, credential.Role)
+		}
+
+		var hash [sha256.Size]byte
+		switch {
+		case credential.Key != "":
+			hash = sha256.Sum256([]byte(credential.Key))
+		case credential.KeyHash != "":
+			decoded, err := hex.DecodeString(credential.KeyHash)
+			if err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("credential of %s has an invalid key hash", credential.User)
+			}
+			copy(hash[:], decoded)
+		default:
+			return nil, fmt.Errorf("credential of %s has no key", credential.User)
+		}
+
+		if _, exists := a.principals[hash]; exists {
+			return nil, fmt.Errorf("credential of %s reuses the key of another", credential.User)
+		}
+		a.principals[hash] = Principal{User: credential.User, Role: credential.Role}
+	}
+
+	return a, nil
+}
+
+// Authenticate returns the principal of a request's API key. Websocket
+// upgrades and event streams may pass the key in the access_token query
+// parameter instead, since browsers cannot set headers on them.
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+			key = strings.TrimSpace(token)
+		}
+	}
+	if key == "" && (websocket.IsWebSocketUpgrade(r) || acceptsEventStream(r)) {
+		key = r.URL.Query().Get("access_token")
+	}
+	if key == "" {
+		return Principal{}, false
+	}
+
+	principal, ok := a.principals[sha256.Sum256([]byte(key))]
+	return principal, ok
+}
+
+// principalKey is the context key of the principal of a request
+type principalKey struct{}
+
+// PrincipalFromContext returns the principal of the request a context
+// belongs to
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// authorize wraps a handler so that it only serves authenticated users whose
+// role has the permission. Routes of a flow also need the user to own it;
+// flows of other users are reported as not found.
+func (s *Server) authorize(permission Permission, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal := anonymous
+		if s.Auth != nil {
+			var ok bool
+			if principal, ok = s.Auth.Authenticate(r); !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="commandforge"`)
+				http.Error(w, "Missing or invalid API key", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if !principal.Role.allows(permission) {
+			http.Error(w, fmt.Sprintf("Role %s may not change flows or run commands", principal.Role), http.StatusForbidden)
+			return
+		}
+
+		if flowID := mux.Vars(r)["id"]; flowID != "" && !s.owns(principal, flowID) {
+			http.Error(w, fmt.Sprintf("Flow not found: flow with ID %s not found", flowID), http.StatusNotFound)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// owns reports whether a user may use a flow. Unknown flows are left to the
+// handler to report.
+func (s *Server) owns(principal Principal, flowID string) bool {
+	if principal.Role == RoleAdmin {
+		return true
+	}
+
+	record, err := s.FlowManager.GetFlowRecord(flowID)
+	if err != nil {
+		return true
+	}
+
+	return record.Owner == principal.User
+}
@@ -3,20 +3,57 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
 	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+	"github.com/prathyushnallamothu/commandforge/pkg/flow"
 )
 
+// StreamTransport is how a client streams updates from the server
+type StreamTransport string
+
+const (
+	// StreamWebSocket streams over a websocket
+	StreamWebSocket StreamTransport = "websocket"
+	// StreamSSE streams server-sent events over plain HTTP, which passes
+	// through proxies that do not support websockets. A stream that drops is
+	// resumed where it left off.
+	StreamSSE StreamTransport = "sse"
+)
+
+// maxStreamReconnects is how many times in a row a client reconnects to an
+// event stream that drops before it receives another event
+const maxStreamReconnects = 5
+
+// ParseStreamTransport parses the name of a stream transport
+func ParseStreamTransport(name string) (StreamTransport, error) {
+	switch transport := StreamTransport(name); transport {
+	case StreamWebSocket, StreamSSE:
+		return transport, nil
+	case "":
+		return StreamWebSocket, nil
+	default:
+		return "", fmt.Errorf("unknown stream transport %q: expected websocket or sse", name)
+	}
+}
+
 // Client represents an API client
 type Client struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	// Credentials are the headers that authenticate the client's requests
+	Credentials http.Header
+	// Transport is how the client streams updates; websockets by default
+	Transport StreamTransport
 }
 
 // NewClient creates a new API client
@@ -29,19 +66,145 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
+// WithAPIKey authenticates the client's requests with an API key, sent in
+// the X-API-Key header
+func (c *Client) WithAPIKey(key string) *Client {
+	return c.withCredential("X-API-Key", key)
+}
+
+// WithBearerToken authenticates the client's requests with a bearer token,
+// sent in the Authorization header
+func (c *Client) WithBearerToken(token string) *Client {
+	return c.withCredential("Authorization", "Bearer "+token)
+}
+
+// WithStreamTransport sets how the client streams updates
+func (c *Client) WithStreamTransport(transport StreamTransport) *Client {
+	c.Transport = transport
+	return c
+}
+
+// withCredential adds a header to every request of the client
+func (c *Client) withCredential(header, value string) *Client {
+	if c.Credentials == nil {
+		c.Credentials = make(http.Header)
+		base := c.HTTPClient.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		c.HTTPClient.Transport = &credentialTransport{credentials: c.Credentials, base: base}
+	}
+	c.Credentials.Set(header, value)
+	return c
+}
+
+// credentialTransport adds credentials to the requests it sends
+type credentialTransport struct {
+	credentials http.Header
+	base        http.RoundTripper
+}
+
+// RoundTrip sends a copy of the request with the credentials added
+func (t *credentialTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for header, values := range t.credentials {
+		req.Header[header] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// endpoint returns the URL of a route, with its path variables replaced by
+// the values in order
+func (c *Client) endpoint(name string, values ...string) (string, error) {
+	r, err := findRoute(name)
+	if err != nil {
+		return "", err
+	}
+
+	variables := pathVariables(r.path)
+	if len(values) != len(variables) {
+		return "", fmt.Errorf("route %s takes %d path values, got %d", name, len(variables), len(values))
+	}
+
+	path := r.path
+	for i, variable := range variables {
+		path = strings.Replace(path, "{"+variable+"}", url.PathEscape(values[i]), 1)
+	}
+
+	return c.BaseURL + apiPrefix + path, nil
+}
+
+// newRequest creates a request to a route, with the method the route is
+// served with
+func (c *Client) newRequest(name string, body io.Reader, values ...string) (*http.Request, error) {
+	r, err := findRoute(name)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := c.endpoint(name, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.NewRequest(r.method, endpoint, body)
+}
+
+// CheckAPI fetches the OpenAPI document of the server and checks that it
+// serves every route the client uses, with the same method
+func (c *Client) CheckAPI() error {
+	// Create request
+	req, err := c.newRequest("getOpenAPI", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var doc openAPIDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var missing []string
+	for _, r := range apiRoutes() {
+		op := doc.Paths[r.path][strings.ToLower(r.method)]
+		if op == nil || op.OperationID != r.name {
+			missing = append(missing, fmt.Sprintf("%s %s", r.method, r.path))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("server does not serve %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
 // CreateFlow creates a new flow
 func (c *Client) CreateFlow(flowType, goal string) (string, error) {
 	// Create request body
-	reqBody, err := json.Marshal(map[string]string{
-		"type": flowType,
-		"goal": goal,
+	reqBody, err := json.Marshal(CreateFlowRequest{
+		Type: flowType,
+		Goal: goal,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/flows", c.BaseURL), bytes.NewBuffer(reqBody))
+	req, err := c.newRequest("createFlow", bytes.NewBuffer(reqBody))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -61,9 +224,7 @@ func (c *Client) CreateFlow(flowType, goal string) (string, error) {
 	}
 
 	// Parse response
-	var response struct {
-		ID string `json:"id"`
-	}
+	var response CreateFlowResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
@@ -71,18 +232,412 @@ func (c *Client) CreateFlow(flowType, goal string) (string, error) {
 	return response.ID, nil
 }
 
+// GetFlowUsage gets the LLM usage of a flow
+func (c *Client) GetFlowUsage(flowID string) (*FlowUsageResponse, error) {
+	// Create request
+	req, err := c.newRequest("getFlowUsage", nil, flowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var response FlowUsageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// ListPlanRevisions lists the revisions of a flow's plan, oldest first
+func (c *Client) ListPlanRevisions(flowID string) ([]flow.PlanRevision, error) {
+	// Create request
+	req, err := c.newRequest("listPlanRevisions", nil, flowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var revisions []flow.PlanRevision
+	if err := json.NewDecoder(resp.Body).Decode(&revisions); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return revisions, nil
+}
+
+// ResumeFlow continues a flow that stopped before its run finished. The flow
+// runs in the background on the server.
+func (c *Client) ResumeFlow(flowID string) error {
+	// Create request
+	req, err := c.newRequest("resumeFlow", nil, flowID)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s", body)
+	}
+
+	return nil
+}
+
+// ListFlows lists a page of the flows matching a query, oldest first. The
+// server only lists the flows of the client's user, unless it is an admin.
+func (c *Client) ListFlows(query flow.FlowQuery) (*flow.FlowPage, error) {
+	values := url.Values{}
+	if query.Owner != "" {
+		values.Set("owner", query.Owner)
+	}
+	if query.State != "" {
+		values.Set("state", string(query.State))
+	}
+	if query.Type != "" {
+		values.Set("type", string(query.Type))
+	}
+	if query.Offset > 0 {
+		values.Set("offset", strconv.Itoa(query.Offset))
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+
+	// Create request
+	req, err := c.newRequest("listFlows", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.URL.RawQuery = values.Encode()
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var page flow.FlowPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &page, nil
+}
+
+// DeleteFlow cancels the run of a flow, terminates its commands and deletes
+// it
+func (c *Client) DeleteFlow(flowID string) error {
+	// Create request
+	req, err := c.newRequest("deleteFlow", nil, flowID)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s", body)
+	}
+
+	return nil
+}
+
+// CancelFlow cancels the run of a flow in progress. The run stops in the
+// background, and the flow can be resumed later.
+func (c *Client) CancelFlow(flowID string) error {
+	// Create request
+	req, err := c.newRequest("cancelFlow", nil, flowID)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s", body)
+	}
+
+	return nil
+}
+
+// GetFlowHistory gets the conversations of a flow's agents, its plan and the
+// commands it ran
+func (c *Client) GetFlowHistory(flowID string) (*flow.FlowHistory, error) {
+	// Create request
+	req, err := c.newRequest("getFlowHistory", nil, flowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var history flow.FlowHistory
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &history, nil
+}
+
+// SubmitRun queues a run of a flow on the input and returns the run without
+// waiting for it. Its progress can be polled with GetRun.
+func (c *Client) SubmitRun(flowID, input string, priority int) (*flow.RunRecord, error) {
+	// Create request body
+	reqBody, err := json.Marshal(RunRequest{
+		Input:    input,
+		Priority: priority,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create request
+	req, err := c.newRequest("submitRun", bytes.NewBuffer(reqBody), flowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return c.sendRunRequest(req, http.StatusAccepted)
+}
+
+// GetRun gets the state and outcome of a submitted run
+func (c *Client) GetRun(runID string) (*flow.RunRecord, error) {
+	// Create request
+	req, err := c.newRequest("getRun", nil, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	return c.sendRunRequest(req, http.StatusOK)
+}
+
+// CancelRun takes a queued run off the queue, or cancels the flow of a run
+// in progress
+func (c *Client) CancelRun(runID string) (*flow.RunRecord, error) {
+	// Create request
+	req, err := c.newRequest("cancelRun", nil, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	return c.sendRunRequest(req, http.StatusAccepted)
+}
+
+// sendRunRequest sends a request that responds with the record of a run
+func (c *Client) sendRunRequest(req *http.Request, status int) (*flow.RunRecord, error) {
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != status {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var run flow.RunRecord
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &run, nil
+}
+
+// ListRuns lists the runs submitted for a flow, oldest first
+func (c *Client) ListRuns(flowID string) ([]flow.RunRecord, error) {
+	// Create request
+	req, err := c.newRequest("listRuns", nil, flowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var runs []flow.RunRecord
+	if err := json.NewDecoder(resp.Body).Decode(&runs); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return runs, nil
+}
+
+// ListApprovals lists the tool calls of a flow waiting for approval
+func (c *Client) ListApprovals(flowID string) ([]agent.ApprovalRequest, error) {
+	// Create request
+	req, err := c.newRequest("listApprovals", nil, flowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var approvals []agent.ApprovalRequest
+	if err := json.NewDecoder(resp.Body).Decode(&approvals); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return approvals, nil
+}
+
+// ResolveApproval approves or rejects a tool call waiting for approval
+func (c *Client) ResolveApproval(flowID, approvalID string, approved bool, reason string) error {
+	// Create request body
+	reqBody, err := json.Marshal(ApprovalRequest{
+		Approved: approved,
+		Reason:   reason,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create request
+	req, err := c.newRequest("resolveApproval", bytes.NewBuffer(reqBody), flowID, approvalID)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s", body)
+	}
+
+	return nil
+}
+
 // ExecuteCommand executes a command in a flow
 func (c *Client) ExecuteCommand(flowID, command string) (string, error) {
-	// Create request body
-	reqBody, err := json.Marshal(CommandRequest{
+	return c.executeCommand(flowID, CommandRequest{
 		Command: command,
 	})
+}
+
+// ExecuteCommandInTerminal executes a command in a flow in a pseudo-terminal
+// of the given size, or 24 by 80 if it is zero
+func (c *Client) ExecuteCommandInTerminal(flowID, command string, rows, cols uint16) (string, error) {
+	return c.executeCommand(flowID, CommandRequest{
+		Command: command,
+		PTY:     true,
+		Rows:    rows,
+		Cols:    cols,
+	})
+}
+
+// executeCommand sends a command execution request
+func (c *Client) executeCommand(flowID string, request CommandRequest) (string, error) {
+	// Create request body
+	reqBody, err := json.Marshal(request)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
 	// Create request
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/v1/flows/%s/execute", c.BaseURL, flowID), bytes.NewBuffer(reqBody))
+	req, err := c.newRequest("executeCommand", bytes.NewBuffer(reqBody), flowID)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
@@ -114,10 +669,84 @@ func (c *Client) ExecuteCommand(flowID, command string) (string, error) {
 	return response.CommandID, nil
 }
 
+// WriteInput writes to the stdin of a command, and closes it afterwards if
+// eof is set. Nothing is added to the input, so it should end with a newline
+// to answer a prompt.
+func (c *Client) WriteInput(flowID, commandID, input string, eof bool) error {
+	return c.postCommand(flowID, commandID, "writeInput", InputRequest{
+		Input: input,
+		EOF:   eof,
+	})
+}
+
+// SignalCommand sends SIGINT, SIGTERM or SIGKILL to the process group of a
+// command. With a positive grace period, the group is killed if the command
+// is still running after it.
+func (c *Client) SignalCommand(flowID, commandID, signal string, gracePeriod time.Duration) error {
+	return c.postCommand(flowID, commandID, "signalCommand", SignalRequest{
+		Signal:      signal,
+		GracePeriod: gracePeriod.Seconds(),
+	})
+}
+
+// CancelCommand asks a command to terminate with SIGTERM and kills its
+// process group if it is still running after the grace period
+func (c *Client) CancelCommand(flowID, commandID string, gracePeriod time.Duration) error {
+	return c.SignalCommand(flowID, commandID, "SIGTERM", gracePeriod)
+}
+
+// SendKeys sends keystrokes to a command running in a pseudo-terminal.
+// Special keys go in angle brackets, for example "yes<Enter>" or "<C-c>".
+func (c *Client) SendKeys(flowID, commandID, keys string) error {
+	return c.postCommand(flowID, commandID, "sendKeys", KeysRequest{
+		Keys: keys,
+	})
+}
+
+// ResizeTerminal changes the terminal size of a command running in a
+// pseudo-terminal
+func (c *Client) ResizeTerminal(flowID, commandID string, rows, cols uint16) error {
+	return c.postCommand(flowID, commandID, "resizeTerminal", ResizeRequest{
+		Rows: rows,
+		Cols: cols,
+	})
+}
+
+// postCommand sends a request to a route of a command
+func (c *Client) postCommand(flowID, commandID, routeName string, request interface{}) error {
+	// Create request body
+	reqBody, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create request
+	req, err := c.newRequest(routeName, bytes.NewBuffer(reqBody), flowID, commandID)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server returned error: %s", body)
+	}
+
+	return nil
+}
+
 // GetCommandStatus gets the status of a command
 func (c *Client) GetCommandStatus(flowID, commandID string) (*executor.BackgroundCommandStatus, error) {
 	// Create request
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/v1/flows/%s/commands/%s", c.BaseURL, flowID, commandID), nil)
+	req, err := c.newRequest("getCommandStatus", nil, flowID, commandID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -150,13 +779,72 @@ func (c *Client) GetCommandStatus(flowID, commandID string) (*executor.Backgroun
 		OutputList: response.OutputList,
 		ErrorList:  response.ErrorList,
 		Duration:   response.Duration,
+		State:      executor.CommandState(response.State),
+		Usage:      response.Usage,
 	}, nil
 }
 
-// StreamCommandStatus streams the status of a command
+// ReadCommandOutput reads lines of a command's output
+func (c *Client) ReadCommandOutput(flowID, commandID string, query executor.OutputQuery) (*executor.OutputPage, error) {
+	values := url.Values{}
+	if query.Offset > 0 {
+		values.Set("offset", strconv.Itoa(query.Offset))
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	if query.Tail > 0 {
+		values.Set("tail", strconv.Itoa(query.Tail))
+	}
+	if query.Stream != "" {
+		values.Set("stream", string(query.Stream))
+	}
+	if query.Pattern != "" {
+		values.Set("pattern", query.Pattern)
+	}
+
+	// Create request
+	req, err := c.newRequest("readCommandOutput", nil, flowID, commandID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.URL.RawQuery = values.Encode()
+
+	// Send request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned error: %s", body)
+	}
+
+	// Parse response
+	var page executor.OutputPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &page, nil
+}
+
+// StreamCommandStatus streams the status of a command, over the client's
+// transport, until the command has finished
 func (c *Client) StreamCommandStatus(flowID, commandID string, callback func(*executor.BackgroundCommandStatus)) error {
+	if c.Transport == StreamSSE {
+		return c.streamCommandEvents(flowID, commandID, callback)
+	}
+
 	// Create WebSocket URL
-	u, err := url.Parse(fmt.Sprintf("%s/api/v1/flows/%s/commands/%s/stream", c.BaseURL, flowID, commandID))
+	endpoint, err := c.endpoint("streamCommand", flowID, commandID)
+	if err != nil {
+		return fmt.Errorf("failed to create URL: %w", err)
+	}
+	u, err := url.Parse(endpoint)
 	if err != nil {
 		return fmt.Errorf("failed to parse URL: %w", err)
 	}
@@ -169,17 +857,14 @@ func (c *Client) StreamCommandStatus(flowID, commandID string, callback func(*ex
 	}
 
 	// Connect to WebSocket
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), c.Credentials)
 	if err != nil {
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
 	defer conn.Close()
 
-	// Track accumulated output for incremental updates
-	accumulatedOutputList := []string{}
-	accumulatedErrorList := []string{}
-
 	// Read messages
+	var updates commandUpdates
 	for {
 		// Read message
 		var response CommandStatusResponse
@@ -190,41 +875,165 @@ func (c *Client) StreamCommandStatus(flowID, commandID string, callback func(*ex
 			return fmt.Errorf("failed to read message: %w", err)
 		}
 
-		// Handle incremental updates by appending to accumulated lists
-		if response.Incremental {
-			// Append new output and error lines to accumulated lists
-			accumulatedOutputList = append(accumulatedOutputList, response.OutputList...)
-			accumulatedErrorList = append(accumulatedErrorList, response.ErrorList...)
-
-			// Use accumulated lists for the status
-			response.OutputList = accumulatedOutputList
-			response.ErrorList = accumulatedErrorList
+		if updates.handle(response, callback) {
+			break
 		}
+	}
 
-		// Convert to BackgroundCommandStatus
-		status := &executor.BackgroundCommandStatus{
-			Running:    response.Running,
-			ExitCode:   response.ExitCode,
-			Output:     response.Output,
-			Error:      response.Error,
-			OutputList: response.OutputList,
-			ErrorList:  response.ErrorList,
-			Duration:   response.Duration,
+	return nil
+}
+
+// streamCommandEvents streams the status of a command as server-sent
+// events. When the stream drops, the client reconnects with the ID of the
+// last event it received, and the server sends the output lines it missed.
+func (c *Client) streamCommandEvents(flowID, commandID string, callback func(*executor.BackgroundCommandStatus)) error {
+	endpoint, err := c.endpoint("streamCommandEvents", flowID, commandID)
+	if err != nil {
+		return fmt.Errorf("failed to create URL: %w", err)
+	}
+
+	// Streams stay open for as long as the command runs, so they are not
+	// subject to the client's timeout
+	httpClient := *c.HTTPClient
+	httpClient.Timeout = 0
+
+	var updates commandUpdates
+	lastID := ""
+	retry := sseRetry
+	reconnects := 0
+
+	for {
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
 		}
 
-		// Call callback
-		callback(status)
+		done := false
+		err = c.readEventStream(&httpClient, req, func(event serverEvent) (bool, error) {
+			if event.Retry > 0 {
+				retry = event.Retry
+			}
 
-		// If this is the final update, break the loop
-		if response.Complete {
-			break
+			switch event.Event {
+			case "status", "complete":
+				var response CommandStatusResponse
+				if err := json.Unmarshal([]byte(event.Data), &response); err != nil {
+					return true, fmt.Errorf("failed to parse event: %w", err)
+				}
+				lastID = event.ID
+				reconnects = 0
+				done = updates.handle(response, callback)
+				return done, nil
+			case "error":
+				var message struct {
+					Error string `json:"error"`
+				}
+				json.Unmarshal([]byte(event.Data), &message)
+				return true, fmt.Errorf("server returned error: %s", message.Error)
+			}
+			return false, nil
+		})
+		if done {
+			return nil
+		}
+		var failed *streamError
+		if errors.As(err, &failed) {
+			return failed.err
 		}
 
-		// If command is no longer running, break
-		if !response.Running {
-			break
+		// The stream dropped; resume it after the delay the server asked for
+		reconnects++
+		if reconnects > maxStreamReconnects {
+			return fmt.Errorf("failed to read events: %w", err)
 		}
+		time.Sleep(retry)
 	}
+}
 
-	return nil
+// streamError is an error that ends an event stream for good, rather than
+// one the client reconnects after
+type streamError struct {
+	err error
+}
+
+func (e *streamError) Error() string {
+	return e.err.Error()
+}
+
+// readEventStream connects to an event stream and passes its events to
+// the handler. Errors of the handler and error responses are returned as
+// stream errors.
+func (c *Client) readEventStream(httpClient *http.Client, req *http.Request, handle func(serverEvent) (bool, error)) error {
+	// Send request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &streamError{fmt.Errorf("server returned error: %s", body)}
+	}
+
+	var failed error
+	err = readEvents(resp.Body, func(event serverEvent) (bool, error) {
+		stop, err := handle(event)
+		if err != nil {
+			failed = err
+		}
+		return stop, err
+	})
+	if failed != nil {
+		return &streamError{failed}
+	}
+
+	return err
+}
+
+// commandUpdates turns the updates of a command stream into statuses with
+// all the output received so far
+type commandUpdates struct {
+	outputList []string
+	errorList  []string
+}
+
+// handle passes the status of an update to the callback, and reports
+// whether it is the last update
+func (u *commandUpdates) handle(response CommandStatusResponse, callback func(*executor.BackgroundCommandStatus)) bool {
+	// Handle incremental updates by appending to accumulated lists
+	if response.Incremental {
+		// Append new output and error lines to accumulated lists
+		u.outputList = append(u.outputList, response.OutputList...)
+		u.errorList = append(u.errorList, response.ErrorList...)
+
+		// Use accumulated lists for the status
+		response.OutputList = u.outputList
+		response.ErrorList = u.errorList
+	}
+
+	// Convert to BackgroundCommandStatus
+	status := &executor.BackgroundCommandStatus{
+		Running:    response.Running,
+		ExitCode:   response.ExitCode,
+		Output:     response.Output,
+		Error:      response.Error,
+		OutputList: response.OutputList,
+		ErrorList:  response.ErrorList,
+		Duration:   response.Duration,
+		State:      executor.CommandState(response.State),
+		Usage:      response.Usage,
+	}
+
+	// Call callback
+	callback(status)
+
+	// The final update, or one of a command that is no longer running, ends
+	// the stream
+	return response.Complete || !response.Running
 }
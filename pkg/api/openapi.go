@@ -0,0 +1,320 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIDocument is an OpenAPI 3 document. Only the parts the API uses are
+// modelled.
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Servers    []openAPIServer                         `json:"servers"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+	Security   []map[string][]string                   `json:"security"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type openAPIServer struct {
+	URL string `json:"url"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*openAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Summary     string                      `json:"summary,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+	// Security is empty for public routes, which need no API key
+	Security []map[string][]string `json:"security,omitempty"`
+	// WebSocketMessage is the schema of the messages of websocket routes
+	WebSocketMessage *openAPISchema `json:"x-websocket-message,omitempty"`
+	// EventStreamMessage is the schema of the event data of event stream
+	// routes
+	EventStreamMessage *openAPISchema `json:"x-event-stream-message,omitempty"`
+}
+
+type openAPIParameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *openAPISchema `json:"schema"`
+}
+
+type openAPIBody struct {
+	Required bool                        `json:"required,omitempty"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `json:"schema"`
+}
+
+// openAPISchema is a JSON schema, as OpenAPI 3.0 restricts it
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// newOpenAPIDocument describes routes in an OpenAPI document. The schemas of
+// request and response bodies are generated from the Go types the handlers
+// encode and decode, following their JSON tags.
+func newOpenAPIDocument(routes []route) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: "CommandForge API", Version: "1"},
+		Servers: []openAPIServer{{URL: apiPrefix}},
+		Paths:   make(map[string]map[string]*openAPIOperation),
+		Components: openAPIComponents{
+			Schemas: make(map[string]*openAPISchema),
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				"apiKey": {Type: "apiKey", In: "header", Name: "X-API-Key"},
+				"bearer": {Type: "http", Scheme: "bearer"},
+			},
+		},
+		Security: []map[string][]string{{"apiKey": {}}, {"bearer": {}}},
+	}
+
+	for _, r := range routes {
+		if doc.Paths[r.path] == nil {
+			doc.Paths[r.path] = make(map[string]*openAPIOperation)
+		}
+		doc.Paths[r.path][strings.ToLower(r.method)] = doc.operation(r)
+	}
+
+	return doc
+}
+
+// operation describes a route
+func (doc *openAPIDocument) operation(r route) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: r.name,
+		Summary:     r.summary,
+		Responses:   make(map[string]*openAPIResponse),
+	}
+	if r.public {
+		op.Security = []map[string][]string{{}}
+	}
+
+	for _, name := range pathVariables(r.path) {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &openAPISchema{Type: "string"},
+		})
+	}
+	for _, q := range r.query {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:        q.name,
+			In:          "query",
+			Description: q.description,
+			Schema:      &openAPISchema{Type: q.kind},
+		})
+	}
+	if r.eventStream {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:        "Last-Event-ID",
+			In:          "header",
+			Description: "ID of the last event received, to resume the stream after it",
+			Schema:      &openAPISchema{Type: "string"},
+		})
+	}
+
+	if r.request != nil {
+		op.RequestBody = &openAPIBody{
+			Required: true,
+			Content: map[string]openAPIMediaType{
+				"application/json": {Schema: doc.schema(reflect.TypeOf(r.request))},
+			},
+		}
+	}
+
+	switch {
+	case r.eventStream:
+		op.Responses[strconv.Itoa(http.StatusOK)] = &openAPIResponse{
+			Description: "Server-sent events",
+			Content: map[string]openAPIMediaType{
+				"text/event-stream": {Schema: &openAPISchema{Type: "string"}},
+			},
+		}
+		op.EventStreamMessage = doc.schema(reflect.TypeOf(r.response))
+	case r.webSocket:
+		op.Responses[strconv.Itoa(http.StatusSwitchingProtocols)] = &openAPIResponse{
+			Description: "Switched to the websocket protocol",
+		}
+		if r.response != nil {
+			op.WebSocketMessage = doc.schema(reflect.TypeOf(r.response))
+		}
+	default:
+		status := r.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		response := &openAPIResponse{Description: http.StatusText(status)}
+		if r.response != nil {
+			response.Content = map[string]openAPIMediaType{
+				"application/json": {Schema: doc.schema(reflect.TypeOf(r.response))},
+			}
+		}
+		op.Responses[strconv.Itoa(status)] = response
+	}
+
+	// Errors are reported as plain text
+	errorContent := map[string]openAPIMediaType{
+		"text/plain": {Schema: &openAPISchema{Type: "string"}},
+	}
+	if !r.public {
+		op.Responses["401"] = &openAPIResponse{Description: "Missing or invalid API key", Content: errorContent}
+		if r.permission == PermissionExecute {
+			op.Responses["403"] = &openAPIResponse{Description: "The role of the user may not change flows or run commands", Content: errorContent}
+		}
+	}
+	op.Responses["default"] = &openAPIResponse{Description: "Error", Content: errorContent}
+
+	return op
+}
+
+// schema returns the schema of values of a type as encoding/json encodes
+// them. Named structs are added to the components of the document and
+// referred to.
+func (doc *openAPIDocument) schema(t reflect.Type) *openAPISchema {
+	switch {
+	case t == timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return doc.schema(t.Elem())
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &openAPISchema{Type: "integer"}
+	case reflect.Float32:
+		return &openAPISchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &openAPISchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: doc.schema(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: doc.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return doc.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, exists := doc.Components.Schemas[name]; !exists {
+			// Reserve the name first, so that recursive types terminate
+			doc.Components.Schemas[name] = &openAPISchema{}
+			*doc.Components.Schemas[name] = *doc.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	default:
+		// Interfaces can hold any value
+		return &openAPISchema{}
+	}
+}
+
+// structSchema returns the schema of a struct's fields
+func (doc *openAPIDocument) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: make(map[string]*openAPISchema)}
+	doc.addFields(s, t)
+	return s
+}
+
+// addFields adds the fields of a struct to a schema. Embedded structs without
+// a JSON name have their fields promoted, as encoding/json does.
+func (doc *openAPIDocument) addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				doc.addFields(s, embedded)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		property := doc.schema(field.Type)
+		if field.Type.Kind() == reflect.Pointer && property.Ref == "" {
+			property.Nullable = true
+		}
+		s.Properties[name] = property
+
+		omitEmpty := false
+		for _, option := range strings.Split(options, ",") {
+			omitEmpty = omitEmpty || option == "omitempty"
+		}
+		if !omitEmpty && field.Type.Kind() != reflect.Pointer {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// schemaName returns the component name of a named type. Types of other
+// packages are qualified with the name of their package.
+func schemaName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if pkg == reflect.TypeOf(route{}).PkgPath() {
+		return t.Name()
+	}
+	return path.Base(pkg) + "." + t.Name()
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// servedOpenAPIDocument fetches the OpenAPI document a server serves
+func servedOpenAPIDocument(t *testing.T, s *Server) *openAPIDocument {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	s.Router.ServeHTTP(recorder, httptest.NewRequest("GET", apiPrefix+"/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var doc openAPIDocument
+	if err := json.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("failed to parse OpenAPI document: %v", err)
+	}
+	return &doc
+}
+
+// TestOpenAPIDocumentMatchesRouter checks that every route the router serves
+// is in the OpenAPI document and that the document describes no others
+func TestOpenAPIDocumentMatchesRouter(t *testing.T) {
+	s := NewServer(":0", nil)
+	doc := servedOpenAPIDocument(t, s)
+
+	registered := make(map[string]string)
+	err := s.Router.Walk(func(r *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		// The subrouter of the API prefix has no handler of its own
+		if r.GetHandler() == nil {
+			return nil
+		}
+
+		template, err := r.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		path, ok := strings.CutPrefix(template, apiPrefix)
+		if !ok {
+			t.Errorf("route %s is outside %s", template, apiPrefix)
+			return nil
+		}
+
+		methods, err := r.GetMethods()
+		if err != nil {
+			// Websocket routes match any method and are documented with
+			// the method of their handshake
+			methods = nil
+			for method, op := range doc.Paths[path] {
+				if op.WebSocketMessage != nil || op.Responses["101"] != nil {
+					methods = append(methods, strings.ToUpper(method))
+				}
+			}
+			if len(methods) != 1 {
+				t.Errorf("websocket route %s is documented with methods %v, want one", path, methods)
+				return nil
+			}
+		}
+
+		for _, method := range methods {
+			registered[method+" "+path] = r.GetName()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	documented := make(map[string]string)
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			documented[strings.ToUpper(method)+" "+path] = op.OperationID
+		}
+	}
+
+	for key, name := range registered {
+		operationID, ok := documented[key]
+		if !ok {
+			t.Errorf("%s is served but not documented", key)
+		} else if operationID != name {
+			t.Errorf("%s is served as %q but documented as %q", key, name, operationID)
+		}
+	}
+	for key := range documented {
+		if _, ok := registered[key]; !ok {
+			t.Errorf("%s is documented but not served", key)
+		}
+	}
+}
+
+// TestOpenAPISchemasMatchTypes checks that the documented request and
+// response bodies of every route describe the JSON its Go types encode to
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := servedOpenAPIDocument(t, NewServer(":0", nil))
+
+	for _, r := range apiRoutes() {
+		op := doc.Paths[r.path][strings.ToLower(r.method)]
+		if op == nil {
+			t.Errorf("%s %s is not documented", r.method, r.path)
+			continue
+		}
+
+		if r.request != nil {
+			if op.RequestBody == nil {
+				t.Errorf("%s: request body is not documented", r.name)
+			} else {
+				checkSchema(t, doc, r.name+" request", op.RequestBody.Content["application/json"].Schema, r.request)
+			}
+		}
+
+		if r.response == nil {
+			continue
+		}
+		var schema *openAPISchema
+		switch {
+		case r.eventStream:
+			schema = op.EventStreamMessage
+		case r.webSocket:
+			schema = op.WebSocketMessage
+		default:
+			status := r.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if response := op.Responses[strconv.Itoa(status)]; response != nil {
+				schema = response.Content["application/json"].Schema
+			}
+		}
+		if schema == nil {
+			t.Errorf("%s: response body is not documented", r.name)
+			continue
+		}
+		checkSchema(t, doc, r.name+" response", schema, r.response)
+	}
+}
+
+// checkSchema encodes a sample of the type of a value, with every field,
+// slice, map and pointer filled in, and checks it against a schema
+func checkSchema(t *testing.T, doc *openAPIDocument, name string, schema *openAPISchema, value interface{}) {
+	t.Helper()
+
+	encoded, err := json.Marshal(sampleValue(reflect.TypeOf(value), 0).Interface())
+	if err != nil {
+		t.Errorf("%s: failed to encode sample: %v", name, err)
+		return
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Errorf("%s: failed to decode sample: %v", name, err)
+		return
+	}
+
+	for _, problem := range matchSchema(doc, schema, decoded, "$") {
+		t.Errorf("%s: %s", name, problem)
+	}
+}
+
+// sampleValue returns a value of a type with every field, slice, map and
+// pointer filled in, down to a few levels for recursive types
+func sampleValue(t reflect.Type, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	if depth > 4 {
+		return v
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		v.Set(sampleValue(t.Elem(), depth+1).Addr())
+	case reflect.Slice:
+		v.Set(reflect.Append(reflect.MakeSlice(t, 0, 1), sampleValue(t.Elem(), depth+1)))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		key := reflect.New(t.Key()).Elem()
+		if key.Kind() == reflect.String {
+			key.SetString("key")
+		}
+		v.SetMapIndex(key, sampleValue(t.Elem(), depth+1))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if field := v.Field(i); field.CanSet() {
+				field.Set(sampleValue(t.Field(i).Type, depth+1))
+			}
+		}
+	}
+	return v
+}
+
+// matchSchema returns how a decoded JSON value does not match a schema
+func matchSchema(doc *openAPIDocument, schema *openAPISchema, value interface{}, at string) []string {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		component, ok := doc.Components.Schemas[name]
+		if !ok {
+			return []string{at + ": refers to unknown schema " + name}
+		}
+		// Pointers to named structs may be null too
+		if value == nil {
+			return nil
+		}
+		return matchSchema(doc, component, value, at)
+	}
+
+	// An empty schema, for interfaces, allows any value
+	if schema.Type == "" {
+		return nil
+	}
+	if value == nil {
+		// Nil slices and maps encode as null
+		if schema.Nullable || schema.Type == "array" || schema.Type == "object" {
+			return nil
+		}
+		return []string{at + ": is null, but the schema is not nullable"}
+	}
+
+	var problems []string
+	switch schema.Type {
+	case "string":
+		if _, ok := value.(string); !ok {
+			problems = append(problems, at+": is not a string")
+		}
+	case "integer", "number":
+		if _, ok := value.(float64); !ok {
+			problems = append(problems, at+": is not a number")
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			problems = append(problems, at+": is not a boolean")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(problems, at+": is not an array")
+		}
+		for _, item := range items {
+			problems = append(problems, matchSchema(doc, schema.Items, item, at+"[]")...)
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return append(problems, at+": is not an object")
+		}
+
+		if schema.AdditionalProperties != nil {
+			for key, item := range object {
+				problems = append(problems, matchSchema(doc, schema.AdditionalProperties, item, at+"."+key)...)
+			}
+			return problems
+		}
+
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			property, ok := schema.Properties[key]
+			if !ok {
+				problems = append(problems, at+"."+key+": is not in the schema")
+				continue
+			}
+			problems = append(problems, matchSchema(doc, property, object[key], at+"."+key)...)
+		}
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				problems = append(problems, at+"."+key+": is required by the schema but not encoded")
+			}
+		}
+	default:
+		problems = append(problems, at+": has unknown schema type "+schema.Type)
+	}
+	return problems
+}
@@ -0,0 +1,306 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+	"github.com/prathyushnallamothu/commandforge/pkg/flow"
+)
+
+// apiPrefix is the path all routes of the API are under
+const apiPrefix = "/api/v1"
+
+// route describes an endpoint of the API. The server registers its routes
+// and describes them in its OpenAPI document from the same table, and the
+// client builds its URLs from it, so that they cannot drift apart.
+type route struct {
+	// name is the operation ID of the route in the OpenAPI document
+	name    string
+	method  string
+	path    string
+	summary string
+	// public routes need no API key; the others need the permission
+	public     bool
+	permission Permission
+	query      []queryParameter
+	// request and response are values of the types of the JSON bodies
+	request  interface{}
+	response interface{}
+	// status is the status of a successful response, http.StatusOK if zero
+	status int
+	// webSocket routes upgrade the connection, and send response values as
+	// messages
+	webSocket bool
+	// eventStream routes respond with server-sent events, whose data are
+	// response values
+	eventStream bool
+	handler     func(*Server, http.ResponseWriter, *http.Request)
+}
+
+// queryParameter describes a query parameter of a route
+type queryParameter struct {
+	name        string
+	kind        string
+	description string
+}
+
+// apiRoutes returns the routes of the API
+func apiRoutes() []route {
+	return []route{
+		{
+			name: "healthCheck", method: "GET", path: "/health",
+			summary:  "Check that the server is up",
+			public:   true,
+			response: HealthResponse{},
+			handler:  (*Server).healthCheckHandler,
+		},
+		{
+			name: "getOpenAPI", method: "GET", path: "/openapi.json",
+			summary: "Get the OpenAPI document of the API",
+			public:  true,
+			handler: (*Server).openAPIHandler,
+		},
+		{
+			name: "listFlows", method: "GET", path: "/flows",
+			summary:    "List the flows of the user, oldest first; admins see all flows, or those of the given owner",
+			permission: PermissionRead,
+			query: []queryParameter{
+				{"owner", "string", "Owner whose flows admins list"},
+				{"state", "string", "Only list flows in this state"},
+				{"type", "string", "Only list flows of this type"},
+				{"offset", "integer", "Number of matching flows to skip"},
+				{"limit", "integer", "Maximum number of flows to list"},
+			},
+			response: flow.FlowPage{},
+			handler:  (*Server).listFlowsHandler,
+		},
+		{
+			name: "createFlow", method: "POST", path: "/flows",
+			summary:    "Create a new flow",
+			permission: PermissionExecute,
+			request:    CreateFlowRequest{},
+			response:   CreateFlowResponse{},
+			handler:    (*Server).createFlowHandler,
+		},
+		{
+			name: "getFlow", method: "GET", path: "/flows/{id}",
+			summary:    "Get a flow and its current plan",
+			permission: PermissionRead,
+			response:   FlowInfoResponse{},
+			handler:    (*Server).getFlowHandler,
+		},
+		{
+			name: "deleteFlow", method: "DELETE", path: "/flows/{id}",
+			summary:    "Cancel the run of a flow, terminate its commands and delete it with its runs",
+			permission: PermissionExecute,
+			status:     http.StatusNoContent,
+			handler:    (*Server).deleteFlowHandler,
+		},
+		{
+			name: "cancelFlow", method: "POST", path: "/flows/{id}/cancel",
+			summary:    "Cancel the run of a flow in progress; the flow can be resumed later",
+			permission: PermissionExecute,
+			response:   FlowStateResponse{},
+			status:     http.StatusAccepted,
+			handler:    (*Server).cancelFlowHandler,
+		},
+		{
+			name: "getFlowHistory", method: "GET", path: "/flows/{id}/history",
+			summary:    "Get the conversations of a flow's agents, its plan and the commands it ran",
+			permission: PermissionRead,
+			response:   flow.FlowHistory{},
+			handler:    (*Server).getFlowHistoryHandler,
+		},
+		{
+			name: "getFlowUsage", method: "GET", path: "/flows/{id}/usage",
+			summary:    "Get the token usage and cost of a flow, by step, agent run and model",
+			permission: PermissionRead,
+			response:   FlowUsageResponse{},
+			handler:    (*Server).getFlowUsageHandler,
+		},
+		{
+			name: "listPlanRevisions", method: "GET", path: "/flows/{id}/revisions",
+			summary:    "List the revisions of a flow's plan",
+			permission: PermissionRead,
+			response:   []flow.PlanRevision{},
+			handler:    (*Server).listRevisionsHandler,
+		},
+		{
+			name: "resumeFlow", method: "POST", path: "/flows/{id}/resume",
+			summary:    "Resume a flow that stopped before its plan finished",
+			permission: PermissionExecute,
+			response:   FlowStateResponse{},
+			status:     http.StatusAccepted,
+			handler:    (*Server).resumeFlowHandler,
+		},
+		{
+			name: "submitRun", method: "POST", path: "/flows/{id}/runs",
+			summary:    "Queue a run of a flow on the input; returns the run straight away",
+			permission: PermissionExecute,
+			request:    RunRequest{},
+			response:   flow.RunRecord{},
+			status:     http.StatusAccepted,
+			handler:    (*Server).submitRunHandler,
+		},
+		{
+			name: "listRuns", method: "GET", path: "/flows/{id}/runs",
+			summary:    "List the runs submitted for a flow, oldest first",
+			permission: PermissionRead,
+			response:   []flow.RunRecord{},
+			handler:    (*Server).listRunsHandler,
+		},
+		{
+			name: "getRun", method: "GET", path: "/runs/{run_id}",
+			summary:    "Get the state and outcome of a submitted run",
+			permission: PermissionRead,
+			response:   flow.RunRecord{},
+			handler:    (*Server).getRunHandler,
+		},
+		{
+			name: "cancelRun", method: "POST", path: "/runs/{run_id}/cancel",
+			summary:    "Take a queued run off the queue, or cancel the flow of a run in progress",
+			permission: PermissionExecute,
+			response:   flow.RunRecord{},
+			status:     http.StatusAccepted,
+			handler:    (*Server).cancelRunHandler,
+		},
+		{
+			name: "executeCommand", method: "POST", path: "/flows/{id}/execute",
+			summary:    "Run a command in the background, or in a pseudo-terminal",
+			permission: PermissionExecute,
+			request:    CommandRequest{},
+			response:   CommandResponse{},
+			handler:    (*Server).executeCommandHandler,
+		},
+		{
+			name: "getCommandStatus", method: "GET", path: "/flows/{id}/commands/{command_id}",
+			summary:    "Get the status of a command",
+			permission: PermissionRead,
+			response:   CommandStatusResponse{},
+			handler:    (*Server).getCommandStatusHandler,
+		},
+		{
+			name: "readCommandOutput", method: "GET", path: "/flows/{id}/commands/{command_id}/output",
+			summary:    "Read lines of the output of a command",
+			permission: PermissionRead,
+			query: []queryParameter{
+				{"offset", "integer", "Number of the first line to read"},
+				{"limit", "integer", "Maximum number of lines to read"},
+				{"tail", "integer", "Read the last lines instead"},
+				{"stream", "string", "Only read lines of stdout or stderr"},
+				{"pattern", "string", "Only read lines matching this regular expression"},
+			},
+			response: executor.OutputPage{},
+			handler:  (*Server).readCommandOutputHandler,
+		},
+		{
+			name: "writeInput", method: "POST", path: "/flows/{id}/commands/{command_id}/input",
+			summary:    "Write to the stdin of a command",
+			permission: PermissionExecute,
+			request:    InputRequest{},
+			response:   CommandResponse{},
+			handler:    (*Server).writeInputHandler,
+		},
+		{
+			name: "signalCommand", method: "POST", path: "/flows/{id}/commands/{command_id}/signal",
+			summary:    "Send a signal to the process group of a command",
+			permission: PermissionExecute,
+			request:    SignalRequest{},
+			response:   CommandResponse{},
+			handler:    (*Server).signalCommandHandler,
+		},
+		{
+			name: "sendKeys", method: "POST", path: "/flows/{id}/commands/{command_id}/keys",
+			summary:    "Send keystrokes to a command running in a pseudo-terminal",
+			permission: PermissionExecute,
+			request:    KeysRequest{},
+			response:   CommandResponse{},
+			handler:    (*Server).sendKeysHandler,
+		},
+		{
+			name: "resizeTerminal", method: "POST", path: "/flows/{id}/commands/{command_id}/resize",
+			summary:    "Resize the terminal of a command",
+			permission: PermissionExecute,
+			request:    ResizeRequest{},
+			response:   CommandResponse{},
+			handler:    (*Server).resizeTerminalHandler,
+		},
+		{
+			name: "listApprovals", method: "GET", path: "/flows/{id}/approvals",
+			summary:    "List the tool calls of a flow waiting for approval",
+			permission: PermissionRead,
+			response:   []agent.ApprovalRequest{},
+			handler:    (*Server).listApprovalsHandler,
+		},
+		{
+			name: "resolveApproval", method: "POST", path: "/flows/{id}/approvals/{approval_id}",
+			summary:    "Approve or reject a tool call",
+			permission: PermissionExecute,
+			request:    ApprovalRequest{},
+			response:   ApprovalResponse{},
+			handler:    (*Server).resolveApprovalHandler,
+		},
+		{
+			name: "streamFlow", method: "GET", path: "/flows/{id}/stream",
+			summary:    "Stream the events of a flow and its agents",
+			permission: PermissionRead,
+			response:   agent.EventInfo{},
+			webSocket:  true,
+			handler:    (*Server).streamFlowHandler,
+		},
+		{
+			name: "streamCommand", method: "GET", path: "/flows/{id}/commands/{command_id}/stream",
+			summary:    "Stream the status and new output of a command",
+			permission: PermissionRead,
+			response:   CommandStatusResponse{},
+			webSocket:  true,
+			handler:    (*Server).streamCommandHandler,
+		},
+		{
+			name: "streamFlowEvents", method: "GET", path: "/flows/{id}/events",
+			summary:    "Stream the events of a flow and its agents as server-sent events, named by event type and numbered for resuming",
+			permission: PermissionRead,
+			query: []queryParameter{
+				{"last_event_id", "integer", "ID of the last event received, for clients that cannot send the Last-Event-ID header"},
+			},
+			response:    agent.EventInfo{},
+			eventStream: true,
+			handler:     (*Server).streamFlowEventsHandler,
+		},
+		{
+			name: "streamCommandEvents", method: "GET", path: "/flows/{id}/commands/{command_id}/events",
+			summary:    "Stream the status and new output of a command as server-sent events; each event ID is the number of the next output line",
+			permission: PermissionRead,
+			query: []queryParameter{
+				{"last_event_id", "integer", "ID of the last event received, for clients that cannot send the Last-Event-ID header"},
+			},
+			response:    CommandStatusResponse{},
+			eventStream: true,
+			handler:     (*Server).streamCommandEventsHandler,
+		},
+	}
+}
+
+// findRoute returns the route with the given name
+func findRoute(name string) (route, error) {
+	for _, r := range apiRoutes() {
+		if r.name == name {
+			return r, nil
+		}
+	}
+	return route{}, fmt.Errorf("unknown route: %s", name)
+}
+
+// pathVariables returns the names of the variables in a route path
+func pathVariables(path string) []string {
+	var names []string
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, segment[1:len(segment)-1])
+		}
+	}
+	return names
+}
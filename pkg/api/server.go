@@ -3,31 +3,121 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
 	"github.com/prathyushnallamothu/commandforge/pkg/executor"
 	"github.com/prathyushnallamothu/commandforge/pkg/flow"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
 )
 
 // Server represents the API server
 type Server struct {
 	Router       *mux.Router
 	FlowManager  *flow.FlowManager
+	UsageLedger  *llm.Ledger
 	Addr         string
 	Clients      map[string][]*websocket.Conn
 	ClientsMutex sync.Mutex
 	upgrader     websocket.Upgrader
+	// openAPI is the OpenAPI document describing the routes
+	openAPI []byte
+	// Auth checks the API keys of requests; without it the server is open
+	// to anyone who can reach it
+	Auth *Authenticator
+	// Runs processes the runs submitted to the server; without it runs
+	// cannot be submitted
+	Runs *flow.RunQueue
+	// writeMutex serialises broadcasts, since a websocket connection
+	// supports only one concurrent writer
+	writeMutex sync.Mutex
+	// flowEvents keeps the latest events of each flow for event stream
+	// clients; it is guarded by ClientsMutex
+	flowEvents map[string]*flowEventLog
+}
+
+// HealthResponse reports that the server is up
+type HealthResponse struct {
+	OK bool `json:"ok"`
+}
+
+// CreateFlowRequest represents a request to create a flow
+type CreateFlowRequest struct {
+	Type string `json:"type"`
+	Goal string `json:"goal,omitempty"`
+}
+
+// CreateFlowResponse returns the ID of a created flow
+type CreateFlowResponse struct {
+	ID string `json:"id"`
+}
+
+// FlowStateResponse reports the state a flow was put in
+type FlowStateResponse struct {
+	ID    string     `json:"id"`
+	State flow.State `json:"state"`
+}
+
+// RunRequest submits a run of a flow
+type RunRequest struct {
+	Input string `json:"input"`
+	// Priority orders the queued runs; higher runs first
+	Priority int `json:"priority,omitempty"`
+}
+
+// FlowInfoResponse describes a flow, with its current plan if it has one
+type FlowInfoResponse struct {
+	flow.FlowRecord
+	Plan *flow.Plan `json:"plan,omitempty"`
 }
 
 // CommandRequest represents a request to execute a command
 type CommandRequest struct {
 	Command string `json:"command"`
+	// PTY runs the command in a pseudo-terminal of Rows by Cols, or 24 by 80
+	// if they are zero
+	PTY  bool   `json:"pty,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+}
+
+// KeysRequest sends keystrokes to a command running in a pseudo-terminal.
+// Special keys go in angle brackets, for example "yes<Enter>" or "<C-c>".
+type KeysRequest struct {
+	Keys string `json:"keys"`
+}
+
+// InputRequest writes to the stdin of a command. Nothing is added to the
+// input, so it should end with a newline to answer a prompt.
+type InputRequest struct {
+	Input string `json:"input"`
+	// EOF closes the stdin of the command after writing
+	EOF bool `json:"eof,omitempty"`
+}
+
+// SignalRequest sends SIGINT, SIGTERM or SIGKILL to the process group of a
+// command
+type SignalRequest struct {
+	Signal string `json:"signal"`
+	// GracePeriod kills the process group if the command is still running
+	// this many seconds after the signal
+	GracePeriod float64 `json:"grace_period,omitempty"`
+}
+
+// ResizeRequest changes the terminal size of a command running in a
+// pseudo-terminal
+type ResizeRequest struct {
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
 }
 
 // CommandResponse represents a response from executing a command
@@ -40,15 +130,41 @@ type CommandResponse struct {
 
 // CommandStatusResponse represents the status of a command
 type CommandStatusResponse struct {
-	Running     bool     `json:"running"`
-	ExitCode    int      `json:"exit_code"`
-	Output      string   `json:"output,omitempty"`
-	Error       string   `json:"error,omitempty"`
-	OutputList  []string `json:"output_list,omitempty"`
-	ErrorList   []string `json:"error_list,omitempty"`
-	Duration    float64  `json:"duration"`
-	Incremental bool     `json:"incremental,omitempty"` // Whether this is an incremental update
-	Complete    bool     `json:"complete,omitempty"`    // Whether this is the final update
+	Running     bool                   `json:"running"`
+	ExitCode    int                    `json:"exit_code"`
+	Output      string                 `json:"output,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	OutputList  []string               `json:"output_list,omitempty"`
+	ErrorList   []string               `json:"error_list,omitempty"`
+	Duration    float64                `json:"duration"`
+	State       string                 `json:"state,omitempty"`
+	Usage       executor.ResourceUsage `json:"usage"`
+	Screen      []string               `json:"screen,omitempty"`
+	Incremental bool                   `json:"incremental,omitempty"` // Whether this is an incremental update
+	Complete    bool                   `json:"complete,omitempty"`    // Whether this is the final update
+}
+
+// ApprovalRequest approves or rejects a tool call waiting for approval
+type ApprovalRequest struct {
+	Approved bool   `json:"approved"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// ApprovalResponse reports how a tool call waiting for approval was decided
+type ApprovalResponse struct {
+	ID       string `json:"id"`
+	Approved bool   `json:"approved"`
+}
+
+// FlowUsageResponse reports the LLM usage of a flow. The totals cover all
+// calls of the flow, the records only the latest calls the ledger keeps.
+type FlowUsageResponse struct {
+	FlowID  string                     `json:"flow_id"`
+	Total   llm.UsageTotals            `json:"total"`
+	Steps   map[string]llm.UsageTotals `json:"steps"`
+	Runs    map[string]llm.UsageTotals `json:"runs"`
+	Models  map[string]llm.UsageTotals `json:"models"`
+	Records []llm.UsageRecord          `json:"records"`
 }
 
 // NewServer creates a new API server
@@ -60,6 +176,7 @@ func NewServer(addr string, flowManager *flow.FlowManager) *Server {
 		FlowManager: flowManager,
 		Addr:        addr,
 		Clients:     make(map[string][]*websocket.Conn),
+		flowEvents:  make(map[string]*flowEventLog),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for now
@@ -70,32 +187,62 @@ func NewServer(addr string, flowManager *flow.FlowManager) *Server {
 	// Register routes
 	server.registerRoutes()
 
+	// Describe them
+	document, err := json.MarshalIndent(newOpenAPIDocument(apiRoutes()), "", "  ")
+	if err != nil {
+		log.Printf("Failed to generate OpenAPI document: %v", err)
+	}
+	server.openAPI = document
+
 	return server
 }
 
+// WithUsageLedger sets the ledger that flow usage is reported from
+func (s *Server) WithUsageLedger(ledger *llm.Ledger) *Server {
+	s.UsageLedger = ledger
+	return s
+}
+
+// WithAuthenticator requires requests to authenticate with an API key
+func (s *Server) WithAuthenticator(auth *Authenticator) *Server {
+	s.Auth = auth
+	return s
+}
+
+// WithRunQueue sets the queue that submitted runs are processed by
+func (s *Server) WithRunQueue(runs *flow.RunQueue) *Server {
+	s.Runs = runs
+	return s
+}
+
 // registerRoutes registers all API routes
 func (s *Server) registerRoutes() {
 	// API version prefix
-	api := s.Router.PathPrefix("/api/v1").Subrouter()
-
-	// Health check endpoint
-	api.HandleFunc("/health", s.healthCheckHandler).Methods("GET")
+	api := s.Router.PathPrefix(apiPrefix).Subrouter()
 
-	// Flow management endpoints
-	api.HandleFunc("/flows", s.createFlowHandler).Methods("POST")
-	api.HandleFunc("/flows/{id}", s.getFlowHandler).Methods("GET")
-
-	// Command execution endpoints
-	api.HandleFunc("/flows/{id}/execute", s.executeCommandHandler).Methods("POST")
-	api.HandleFunc("/flows/{id}/commands/{command_id}", s.getCommandStatusHandler).Methods("GET")
+	for _, r := range apiRoutes() {
+		handler := func(w http.ResponseWriter, req *http.Request) {
+			r.handler(s, w, req)
+		}
+		if !r.public {
+			handler = s.authorize(r.permission, handler)
+		}
 
-	// Streaming endpoints
-	api.HandleFunc("/flows/{id}/stream", s.streamFlowHandler)
-	api.HandleFunc("/flows/{id}/commands/{command_id}/stream", s.streamCommandHandler)
+		route := api.HandleFunc(r.path, handler).Name(r.name)
+		// Websocket upgrades are left to the handler
+		if !r.webSocket {
+			route.Methods(r.method)
+		}
+	}
 }
 
 // Start starts the API server
 func (s *Server) Start() error {
+	// Relay the events of flows recovered from an earlier run too
+	for _, flowID := range s.FlowManager.ListFlows() {
+		s.relayFlowEvents(flowID)
+	}
+
 	log.Printf("Starting API server on %s", s.Addr)
 	return http.ListenAndServe(s.Addr, s.Router)
 }
@@ -103,7 +250,18 @@ func (s *Server) Start() error {
 // healthCheckHandler handles health check requests
 func (s *Server) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	json.NewEncoder(w).Encode(HealthResponse{OK: true})
+}
+
+// openAPIHandler serves the OpenAPI document of the API
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if s.openAPI == nil {
+		http.Error(w, "OpenAPI document is not available", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s.openAPI)
 }
 
 // createFlowHandler creates a new flow
@@ -111,10 +269,7 @@ func (s *Server) createFlowHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	// Parse request body
-	var request struct {
-		Type string `json:"type"`
-		Goal string `json:"goal"`
-	}
+	var request CreateFlowRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
@@ -140,19 +295,297 @@ func (s *Server) createFlowHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create the flow
-	_, err = s.FlowManager.CreateFlow(ctx, flowType, flowID)
+	// Create the flow, owned by the user who asked for it
+	principal, _ := PrincipalFromContext(ctx)
+	_, err = s.FlowManager.CreateOwnedFlow(ctx, flowType, flowID, principal.User)
 
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create flow: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	// Relay the events of the flow and its agents to websocket clients
+	s.relayFlowEvents(flowID)
+
 	// Return the flow ID
-	json.NewEncoder(w).Encode(map[string]string{"id": flowID})
+	json.NewEncoder(w).Encode(CreateFlowResponse{ID: flowID})
+}
+
+// relayFlowEvents relays the events of a flow and its agents to websocket
+// and event stream clients
+func (s *Server) relayFlowEvents(flowID string) {
+	events := s.flowEventLog(flowID)
+	if _, err := s.FlowManager.Subscribe(flowID, func(event agent.Event) {
+		events.append(event)
+		s.BroadcastFlowUpdate(flowID, event)
+	}); err != nil {
+		log.Printf("Flow %s events will not be streamed: %v", flowID, err)
+	}
+}
+
+// resumeFlowHandler continues a flow that stopped before its run finished.
+// The flow runs in the background; its progress is streamed as events.
+func (s *Server) resumeFlowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	resumed, err := s.FlowManager.GetFlow(flowID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+	if state := resumed.GetState(); state == flow.StateRunning || state == flow.StateAwaitingApproval {
+		http.Error(w, fmt.Sprintf("Flow %s is already running", flowID), http.StatusConflict)
+		return
+	}
+
+	go func() {
+		response, err := s.FlowManager.ResumeFlow(context.Background(), flowID)
+		if err != nil {
+			log.Printf("Failed to resume flow %s: %v", flowID, err)
+		} else if !response.Success {
+			log.Printf("Resumed flow %s failed: %s", flowID, response.Error)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(FlowStateResponse{ID: flowID, State: flow.StateRunning})
+}
+
+// submitRunHandler queues a run of a flow and returns its record straight
+// away. Its progress can be polled with getRunHandler or streamed as events.
+func (s *Server) submitRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.Runs == nil {
+		http.Error(w, "Runs are not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	var req RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	run, err := s.Runs.Submit(flowID, req.Input, req.Priority)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// listRunsHandler lists the runs submitted for a flow, oldest first
+func (s *Server) listRunsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if s.Runs == nil {
+		http.Error(w, "Runs are not enabled on this server", http.StatusNotImplemented)
+		return
+	}
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	if _, err := s.FlowManager.GetFlow(flowID); err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(s.Runs.ListRuns(flowID))
+}
+
+// getRunHandler gets the record of a submitted run
+func (s *Server) getRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	run, ok := s.run(w, r)
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(run)
+}
+
+// cancelRunHandler cancels a submitted run. A queued run is taken off the
+// queue; the flow of a run in progress is cancelled in the background.
+func (s *Server) cancelRunHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	run, ok := s.run(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.Runs.CancelRun(run.ID); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, flow.ErrRunFinished) || errors.Is(err, flow.ErrFlowNotRunning) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Failed to cancel run: %v", err), status)
+		return
+	}
+
+	run, err := s.Runs.GetRun(run.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Run not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(run)
+}
+
+// run looks up the run named in the URL, reporting runs of flows the user
+// does not own as not found
+func (s *Server) run(w http.ResponseWriter, r *http.Request) (flow.RunRecord, bool) {
+	if s.Runs == nil {
+		http.Error(w, "Runs are not enabled on this server", http.StatusNotImplemented)
+		return flow.RunRecord{}, false
+	}
+
+	// Get run ID from URL
+	vars := mux.Vars(r)
+	runID := vars["run_id"]
+
+	run, err := s.Runs.GetRun(runID)
+	principal, _ := PrincipalFromContext(r.Context())
+	if err != nil || !s.owns(principal, run.FlowID) {
+		http.Error(w, fmt.Sprintf("Run not found: run with ID %s not found", runID), http.StatusNotFound)
+		return flow.RunRecord{}, false
+	}
+
+	return run, true
+}
+
+// listFlowsHandler lists a page of the flows of the user, or those of all
+// users, or of the one given with the owner query parameter, for admins. The
+// state, type, offset and limit query parameters select the flows.
+func (s *Server) listFlowsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Parse the query
+	values := r.URL.Query()
+	query := flow.FlowQuery{
+		State: flow.State(values.Get("state")),
+		Type:  flow.FlowType(values.Get("type")),
+	}
+	for name, field := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		if value := values.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid %s: %s", name, value), http.StatusBadRequest)
+				return
+			}
+			*field = n
+		}
+	}
+
+	principal, _ := PrincipalFromContext(r.Context())
+	query.Owner = principal.User
+	if principal.Role == RoleAdmin {
+		query.Owner = values.Get("owner")
+	}
+
+	json.NewEncoder(w).Encode(s.FlowManager.QueryFlowRecords(query))
+}
+
+// deleteFlowHandler drops the runs of a flow, cancels the one in progress,
+// terminates its commands and deletes it
+func (s *Server) deleteFlowHandler(w http.ResponseWriter, r *http.Request) {
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	if _, err := s.FlowManager.GetFlow(flowID); err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	// Drop the flow's runs first, so that no queued run starts while the
+	// flow is deleted
+	if s.Runs != nil {
+		s.Runs.ForgetFlow(flowID)
+	}
+
+	if err := s.FlowManager.DeleteFlow(r.Context(), flowID); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete flow: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Disconnect the clients streaming the flow
+	s.ClientsMutex.Lock()
+	clients := s.Clients[flowID]
+	delete(s.Clients, flowID)
+	events := s.flowEvents[flowID]
+	delete(s.flowEvents, flowID)
+	s.ClientsMutex.Unlock()
+	for _, conn := range clients {
+		conn.Close()
+	}
+	if events != nil {
+		events.close()
+	}
+
+	if s.UsageLedger != nil {
+		s.UsageLedger.ForgetFlow(flowID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// getFlowHandler gets a flow by ID
+// cancelFlowHandler cancels the run of a flow in progress. The run stops in
+// the background; the flow is left cancelled and can be resumed.
+func (s *Server) cancelFlowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	if err := s.FlowManager.CancelFlow(flowID); err != nil {
+		status := http.StatusNotFound
+		if errors.Is(err, flow.ErrFlowNotRunning) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("Failed to cancel flow: %v", err), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(FlowStateResponse{ID: flowID, State: flow.StateCancelled})
+}
+
+// getFlowHistoryHandler gets the conversations of a flow's agents, its plan
+// and the commands it ran
+func (s *Server) getFlowHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	history, err := s.FlowManager.History(flowID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get flow history: %v", err), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
+
+// getFlowHandler gets a flow by ID, with its current plan
 func (s *Server) getFlowHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -161,14 +594,80 @@ func (s *Server) getFlowHandler(w http.ResponseWriter, r *http.Request) {
 	flowID := vars["id"]
 
 	// Get flow
-	flow, err := s.FlowManager.GetFlow(flowID)
+	f, err := s.FlowManager.GetFlow(flowID)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
 		return
 	}
+	record, err := s.FlowManager.GetFlowRecord(flowID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	response := FlowInfoResponse{FlowRecord: record}
+	if planning, ok := f.(*flow.PlanningFlow); ok {
+		response.Plan = planning.PlanSnapshot()
+	}
 
 	// Return the flow
-	json.NewEncoder(w).Encode(flow)
+	json.NewEncoder(w).Encode(response)
+}
+
+// getFlowUsageHandler reports the LLM usage of a flow
+func (s *Server) getFlowUsageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	// Make sure the flow exists
+	if _, err := s.FlowManager.GetFlow(flowID); err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	if s.UsageLedger == nil {
+		http.Error(w, "Usage accounting is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	// Aggregate the flow's usage by step, agent run and model
+	filter := llm.UsageScope{FlowID: flowID}
+	response := FlowUsageResponse{
+		FlowID: flowID,
+		Total:  s.UsageLedger.Totals(filter),
+		Steps: s.UsageLedger.TotalsBy(filter, func(record llm.UsageRecord) string {
+			return record.StepID
+		}),
+		Runs: s.UsageLedger.TotalsBy(filter, func(record llm.UsageRecord) string {
+			return record.RunID
+		}),
+		Models: s.UsageLedger.TotalsBy(filter, func(record llm.UsageRecord) string {
+			return record.Model
+		}),
+		Records: s.UsageLedger.Records(filter),
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// listRevisionsHandler lists the revisions of a flow's plan
+func (s *Server) listRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	revisions, err := s.FlowManager.PlanRevisions(flowID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list plan revisions: %v", err), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(revisions)
 }
 
 // executeCommandHandler executes a command in a flow
@@ -200,8 +699,20 @@ func (s *Server) executeCommandHandler(w http.ResponseWriter, r *http.Request) {
 	// We need to check if the flow supports command execution
 	switch f := flow.(type) {
 	case interface{ ExecuteCommandInBackground(string) (string, error) }:
-		// Execute command in background using the planning flow
-		commandID, execErr = f.ExecuteCommandInBackground(request.Command)
+		if !request.PTY {
+			// Execute command in background using the planning flow
+			commandID, execErr = f.ExecuteCommandInBackground(request.Command)
+			break
+		}
+
+		terminal, ok := flow.(interface {
+			ExecuteCommandInTerminal(string, uint16, uint16) (string, error)
+		})
+		if !ok {
+			http.Error(w, "Flow does not support terminal commands", http.StatusBadRequest)
+			return
+		}
+		commandID, execErr = terminal.ExecuteCommandInTerminal(request.Command, request.Rows, request.Cols)
 	default:
 		http.Error(w, "Flow does not support command execution", http.StatusBadRequest)
 		return
@@ -248,12 +759,223 @@ func (s *Server) getCommandStatusHandler(w http.ResponseWriter, r *http.Request)
 		OutputList: status.OutputList,
 		ErrorList:  status.ErrorList,
 		Duration:   status.Duration,
+		State:      string(status.State),
+		Usage:      status.Usage,
+		Screen:     status.Screen,
 	}
 
 	// Return success with status details even if command failed
 	json.NewEncoder(w).Encode(response)
 }
 
+// readCommandOutputHandler reads lines of a command's output. The offset,
+// limit, tail, stream and pattern query parameters select the lines.
+func (s *Server) readCommandOutputHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID and command ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	commandID := vars["command_id"]
+
+	// Parse the query
+	values := r.URL.Query()
+	query := executor.OutputQuery{
+		Stream:  executor.OutputStream(values.Get("stream")),
+		Pattern: values.Get("pattern"),
+	}
+	for name, field := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit, "tail": &query.Tail} {
+		if value := values.Get(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("Invalid %s: %s", name, value), http.StatusBadRequest)
+				return
+			}
+			*field = n
+		}
+	}
+
+	page, err := s.FlowManager.ReadCommandOutput(flowID, commandID, query)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read command output: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(page)
+}
+
+// writeInputHandler writes to the stdin of a command
+func (s *Server) writeInputHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID and command ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	commandID := vars["command_id"]
+
+	// Parse request body
+	var request InputRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if request.Input == "" && !request.EOF {
+		http.Error(w, "Invalid request: input or eof is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.FlowManager.WriteInput(flowID, commandID, request.Input, request.EOF); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to write input: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CommandResponse{
+		Success:   true,
+		CommandID: commandID,
+	})
+}
+
+// signalCommandHandler sends a signal to the process group of a command
+func (s *Server) signalCommandHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID and command ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	commandID := vars["command_id"]
+
+	// Parse request body
+	var request SignalRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sig, err := executor.ParseSignal(request.Signal)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	grace := time.Duration(request.GracePeriod * float64(time.Second))
+
+	if err := s.FlowManager.SignalCommand(flowID, commandID, sig, grace); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to signal command: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CommandResponse{
+		Success:   true,
+		CommandID: commandID,
+	})
+}
+
+// sendKeysHandler sends keystrokes to a command running in a pseudo-terminal
+func (s *Server) sendKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID and command ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	commandID := vars["command_id"]
+
+	// Parse request body
+	var request KeysRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if request.Keys == "" {
+		http.Error(w, "Invalid request: keys are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.FlowManager.SendKeys(flowID, commandID, request.Keys); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to send keys: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CommandResponse{
+		Success:   true,
+		CommandID: commandID,
+	})
+}
+
+// resizeTerminalHandler changes the terminal size of a command running in a
+// pseudo-terminal
+func (s *Server) resizeTerminalHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID and command ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	commandID := vars["command_id"]
+
+	// Parse request body
+	var request ResizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.FlowManager.ResizeTerminal(flowID, commandID, request.Rows, request.Cols); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to resize terminal: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(CommandResponse{
+		Success:   true,
+		CommandID: commandID,
+	})
+}
+
+// listApprovalsHandler lists the tool calls of a flow waiting for approval
+func (s *Server) listApprovalsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	approvals, err := s.FlowManager.PendingApprovals(flowID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list approvals: %v", err), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(approvals)
+}
+
+// resolveApprovalHandler approves or rejects a tool call, resuming the flow
+func (s *Server) resolveApprovalHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Get flow ID and approval ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	approvalID := vars["approval_id"]
+
+	// Parse request body
+	var request ApprovalRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	decision := agent.ApprovalDecision{
+		Approved: request.Approved,
+		Reason:   request.Reason,
+	}
+	if err := s.FlowManager.ResolveApproval(flowID, approvalID, decision); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to resolve approval: %v", err), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(ApprovalResponse{
+		ID:       approvalID,
+		Approved: decision.Approved,
+	})
+}
+
 // streamFlowHandler streams flow updates
 func (s *Server) streamFlowHandler(w http.ResponseWriter, r *http.Request) {
 	// Get flow ID from URL
@@ -304,10 +1026,33 @@ func (s *Server) streamCommandHandler(w http.ResponseWriter, r *http.Request) {
 	// Clean up when the connection is closed
 	defer conn.Close()
 
-	// Stream command status updates
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
+	writeFailed := false
+	err = s.followCommand(r.Context(), flowID, commandID, 1, func(response CommandStatusResponse, next int) error {
+		if err := conn.WriteJSON(response); err != nil {
+			log.Printf("Failed to write to WebSocket: %v", err)
+			writeFailed = true
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		if !writeFailed && r.Context().Err() == nil {
+			// Send error message and close connection
+			conn.WriteJSON(map[string]string{"error": err.Error()})
+		}
+		return
+	}
+
+	// Close the connection gracefully
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Command completed"))
+}
 
+// followCommand sends updates of the status of a command, with the output
+// lines written since the last update, starting at the line at offset. Each
+// update is sent with the offset of the line after it. Once the command has
+// finished and all its output is sent, a complete update with the output
+// kept in memory is sent and followCommand returns.
+func (s *Server) followCommand(ctx context.Context, flowID, commandID string, offset int, send func(response CommandStatusResponse, next int) error) error {
 	// Create a ticker to poll for updates - use 1 second as mentioned in the memories
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -315,46 +1060,44 @@ func (s *Server) streamCommandHandler(w http.ResponseWriter, r *http.Request) {
 	// Keep track of the last status to detect changes
 	var lastStatus *executor.BackgroundCommandStatus
 
-	// Keep track of processed output and error lines to avoid duplicates
-	processedOutputLines := 0
-	processedErrorLines := 0
+	// Keep track of the next output line to send, so that lines are sent
+	// once even after they have left the command's status
+	nextOffset := offset
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return ctx.Err()
 		case <-ticker.C:
 			// Get command status
 			status, err := s.FlowManager.GetCommandStatus(flowID, commandID)
 			if err != nil {
-				// Send error message and close connection
-				conn.WriteJSON(map[string]string{"error": fmt.Sprintf("Failed to get command status: %v", err)})
-				return
+				return fmt.Errorf("failed to get command status: %w", err)
 			}
 
-			// Always send updates for streaming output
-			// Check if there are new output or error lines
-			hasNewOutput := lastStatus == nil ||
-				len(status.OutputList) > processedOutputLines ||
-				len(status.ErrorList) > processedErrorLines
-
-			// Add running state and exit code changes if we have a previous status
-			if lastStatus != nil {
-				hasNewOutput = hasNewOutput || status.Running != lastStatus.Running || status.ExitCode != lastStatus.ExitCode
+			// Read the output written since the last update
+			page, err := s.FlowManager.ReadCommandOutput(flowID, commandID, executor.OutputQuery{Offset: nextOffset})
+			if err != nil {
+				return fmt.Errorf("failed to read command output: %w", err)
 			}
+			nextOffset = page.NextOffset
 
-			// Extract only new output lines
 			newOutputList := []string{}
 			newErrorList := []string{}
-
-			if len(status.OutputList) > processedOutputLines {
-				newOutputList = status.OutputList[processedOutputLines:]
-				processedOutputLines = len(status.OutputList)
+			for _, line := range page.Lines {
+				if line.Stream == executor.StreamStderr {
+					newErrorList = append(newErrorList, line.Text)
+				} else {
+					newOutputList = append(newOutputList, line.Text)
+				}
 			}
 
-			if len(status.ErrorList) > processedErrorLines {
-				newErrorList = status.ErrorList[processedErrorLines:]
-				processedErrorLines = len(status.ErrorList)
+			// Always send updates for streaming output
+			hasNewOutput := lastStatus == nil || len(page.Lines) > 0
+
+			// Add running state and exit code changes if we have a previous status
+			if lastStatus != nil {
+				hasNewOutput = hasNewOutput || status.Running != lastStatus.Running || status.ExitCode != lastStatus.ExitCode
 			}
 
 			// Send update if there's new output or status change
@@ -368,41 +1111,40 @@ func (s *Server) streamCommandHandler(w http.ResponseWriter, r *http.Request) {
 					OutputList: newOutputList, // Only send new lines
 					ErrorList:  newErrorList,  // Only send new lines
 					Duration:   status.Duration,
+					State:      string(status.State),
+					Usage:      status.Usage,
+					Screen:     status.Screen,
 					// Add flags to indicate if this is incremental or complete output
 					Incremental: true,
 				}
 
-				if err := conn.WriteJSON(response); err != nil {
-					log.Printf("Failed to write to WebSocket: %v", err)
-					return
+				if err := send(response, nextOffset); err != nil {
+					return err
 				}
 
 				// Update last status
 				lastStatus = status
+			}
 
-				// If command is no longer running and we've sent all output, we can close the connection
-				if !status.Running && len(newOutputList) == 0 && len(newErrorList) == 0 {
-					// Send one final complete update with all output
-					finalResponse := CommandStatusResponse{
-						Running:     status.Running,
-						ExitCode:    status.ExitCode,
-						Output:      status.Output,
-						Error:       status.Error,
-						OutputList:  status.OutputList, // Send complete output
-						ErrorList:   status.ErrorList,  // Send complete error
-						Duration:    status.Duration,
-						Incremental: false,
-						Complete:    true,
-					}
-
-					if err := conn.WriteJSON(finalResponse); err != nil {
-						log.Printf("Failed to write final status to WebSocket: %v", err)
-					}
-
-					// Close the connection gracefully
-					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Command completed"))
-					return
+			// If command is no longer running and we've sent all output, we're
+			// done, even if its last lines came with the update that said so
+			if !status.Running && len(page.Lines) == 0 {
+				// Send one final complete update with all output
+				finalResponse := CommandStatusResponse{
+					Running:     status.Running,
+					ExitCode:    status.ExitCode,
+					Output:      status.Output,
+					Error:       status.Error,
+					OutputList:  status.OutputList, // Send the output kept in memory
+					ErrorList:   status.ErrorList,
+					Duration:    status.Duration,
+					State:       string(status.State),
+					Usage:       status.Usage,
+					Incremental: false,
+					Complete:    true,
 				}
+
+				return send(finalResponse, nextOffset)
 			}
 		}
 	}
@@ -425,9 +1167,12 @@ func (s *Server) removeClient(flowID string, conn *websocket.Conn) {
 // BroadcastFlowUpdate broadcasts a flow update to all connected clients
 func (s *Server) BroadcastFlowUpdate(flowID string, message interface{}) {
 	s.ClientsMutex.Lock()
-	clients := s.Clients[flowID]
+	clients := append([]*websocket.Conn(nil), s.Clients[flowID]...)
 	s.ClientsMutex.Unlock()
 
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
 	for _, client := range clients {
 		if err := client.WriteJSON(message); err != nil {
 			log.Printf("Failed to write to WebSocket: %v", err)
@@ -453,6 +1198,11 @@ func statusChanged(old, new *executor.BackgroundCommandStatus) bool {
 		return true
 	}
 
+	// Check if the terminal screen changed, which redraws in place
+	if !slices.Equal(old.Screen, new.Screen) {
+		return true
+	}
+
 	// Check if output content changed (for partial updates)
 	if old.Output != new.Output || old.Error != new.Error {
 		return true
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+)
+
+const (
+	// sseRetry is how long event stream clients wait before reconnecting
+	sseRetry = 2 * time.Second
+	// sseKeepAlive is how often an idle event stream sends a comment, so
+	// that proxies do not close it
+	sseKeepAlive = 15 * time.Second
+	// flowEventBacklog is how many of the latest events of a flow are kept
+	// for event stream clients that reconnect
+	flowEventBacklog = 1000
+	// flowEventBuffer is how many events an event stream client may fall
+	// behind before it is disconnected, to catch up when it reconnects
+	flowEventBuffer = 256
+)
+
+// eventStream writes server-sent events to a response
+type eventStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	mu      sync.Mutex
+}
+
+// newEventStream starts an event stream on a response
+func newEventStream(w http.ResponseWriter) (*eventStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming is not supported by the connection")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &eventStream{w: w, flusher: flusher}
+	if err := stream.write(fmt.Sprintf("retry: %d\n\n", sseRetry.Milliseconds())); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// send sends an event with its data encoded as JSON. The ID is left out if
+// it is empty.
+func (e *eventStream) send(id, event string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	var message strings.Builder
+	if id != "" {
+		fmt.Fprintf(&message, "id: %s\n", id)
+	}
+	fmt.Fprintf(&message, "event: %s\ndata: %s\n\n", event, encoded)
+
+	return e.write(message.String())
+}
+
+// keepAlive sends a comment whenever the stream has been idle for a while,
+// until the returned function is called
+func (e *eventStream) keepAlive() func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(sseKeepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if e.write(": keep-alive\n\n") != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// write writes to the stream and flushes it
+func (e *eventStream) write(s string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := io.WriteString(e.w, s); err != nil {
+		return err
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+// lastEventID returns the ID of the last event a reconnecting client
+// received, from the Last-Event-ID header or, for clients that cannot set
+// it, the last_event_id query parameter
+func lastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("last_event_id")
+}
+
+// acceptsEventStream reports whether a request asks for an event stream
+func acceptsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// flowEvent is an event of a flow, numbered in the order it was published
+type flowEvent struct {
+	id    int64
+	event agent.Event
+}
+
+// flowEventLog keeps the latest events of a flow and passes new ones to the
+// event stream clients of the flow
+type flowEventLog struct {
+	mu          sync.Mutex
+	last        int64
+	events      []flowEvent
+	subscribers map[chan flowEvent]struct{}
+}
+
+// newFlowEventLog creates an empty event log
+func newFlowEventLog() *flowEventLog {
+	return &flowEventLog{subscribers: make(map[chan flowEvent]struct{})}
+}
+
+// append numbers an event, keeps it and passes it to the subscribers.
+// Subscribers that have fallen too far behind are dropped.
+func (l *flowEventLog) append(event agent.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.last++
+	numbered := flowEvent{id: l.last, event: event}
+	l.events = append(l.events, numbered)
+	if len(l.events) > flowEventBacklog {
+		l.events = append([]flowEvent(nil), l.events[len(l.events)-flowEventBacklog:]...)
+	}
+
+	for events := range l.subscribers {
+		select {
+		case events <- numbered:
+		default:
+			delete(l.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// subscribe returns the kept events after the given ID and a channel of the
+// events appended from now on. The channel is closed when the subscriber is
+// dropped or the log is closed. An ID from before a restart of the server,
+// which the log has not reached, is treated as no ID.
+func (l *flowEventLog) subscribe(after int64) ([]flowEvent, chan flowEvent, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if after > l.last {
+		after = 0
+	}
+
+	var missed []flowEvent
+	for _, event := range l.events {
+		if event.id > after {
+			missed = append(missed, event)
+		}
+	}
+
+	events := make(chan flowEvent, flowEventBuffer)
+	l.subscribers[events] = struct{}{}
+
+	unsubscribe := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if _, subscribed := l.subscribers[events]; subscribed {
+			delete(l.subscribers, events)
+			close(events)
+		}
+	}
+
+	return missed, events, unsubscribe
+}
+
+// close drops all subscribers
+func (l *flowEventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for events := range l.subscribers {
+		delete(l.subscribers, events)
+		close(events)
+	}
+}
+
+// flowEventLog returns the event log of a flow, creating it if needed
+func (s *Server) flowEventLog(flowID string) *flowEventLog {
+	s.ClientsMutex.Lock()
+	defer s.ClientsMutex.Unlock()
+
+	events, exists := s.flowEvents[flowID]
+	if !exists {
+		events = newFlowEventLog()
+		s.flowEvents[flowID] = events
+	}
+	return events
+}
+
+// streamFlowEventsHandler streams the events of a flow and its agents as
+// server-sent events. A client that reconnects with the ID of the last event
+// it received gets the events it missed first, as far as they are kept.
+func (s *Server) streamFlowEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get flow ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+
+	if _, err := s.FlowManager.GetFlow(flowID); err != nil {
+		http.Error(w, fmt.Sprintf("Flow not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	var after int64
+	if id := lastEventID(r); id != "" {
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Invalid last event ID: %s", id), http.StatusBadRequest)
+			return
+		}
+		after = n
+	}
+
+	missed, events, unsubscribe := s.flowEventLog(flowID).subscribe(after)
+	defer unsubscribe()
+
+	stream, err := newEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stream.keepAlive()()
+
+	send := func(event flowEvent) error {
+		return stream.send(strconv.FormatInt(event.id, 10), string(event.event.Info().Type), event.event)
+	}
+
+	for _, event := range missed {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// streamCommandEventsHandler streams the status and new output of a command
+// as server-sent events. The ID of each event is the number of the next
+// output line, so that a client reconnecting with the ID of the last event
+// it received gets the lines it missed.
+func (s *Server) streamCommandEventsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get flow ID and command ID from URL
+	vars := mux.Vars(r)
+	flowID := vars["id"]
+	commandID := vars["command_id"]
+
+	offset := 1
+	if id := lastEventID(r); id != "" {
+		n, err := strconv.Atoi(id)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("Invalid last event ID: %s", id), http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	if _, err := s.FlowManager.GetCommandStatus(flowID, commandID); err != nil {
+		http.Error(w, fmt.Sprintf("Command not found: %v", err), http.StatusNotFound)
+		return
+	}
+
+	stream, err := newEventStream(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stream.keepAlive()()
+
+	err = s.followCommand(r.Context(), flowID, commandID, offset, func(response CommandStatusResponse, next int) error {
+		event := "status"
+		if response.Complete {
+			event = "complete"
+		}
+		return stream.send(strconv.Itoa(next), event, response)
+	})
+	if err != nil && r.Context().Err() == nil {
+		stream.send("", "error", map[string]string{"error": err.Error()})
+	}
+}
+
+// serverEvent is an event read from an event stream
+type serverEvent struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry is the reconnection delay the server last asked for, if any
+	Retry time.Duration
+}
+
+// readEvents reads the events of an event stream and passes them to the
+// handler until the stream ends, the handler returns an error, or it
+// returns true to stop
+func readEvents(body io.Reader, handle func(serverEvent) (bool, error)) error {
+	reader := bufio.NewReader(body)
+	var event serverEvent
+	var data []string
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// A blank line dispatches the event
+		if line == "" {
+			if len(data) > 0 {
+				event.Data = strings.Join(data, "\n")
+				if event.Event == "" {
+					event.Event = "message"
+				}
+				if stop, err := handle(event); stop || err != nil {
+					return err
+				}
+			}
+			// The ID and reconnection delay carry over to later events
+			event = serverEvent{ID: event.ID, Retry: event.Retry}
+			data = nil
+			continue
+		}
+
+		// Lines starting with a colon are comments
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "":
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
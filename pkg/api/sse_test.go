@@ -0,0 +1,361 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/flow"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+	"github.com/prathyushnallamothu/commandforge/pkg/memory"
+)
+
+// idleClient is an LLM client for flows the tests never run
+type idleClient struct{}
+
+func (idleClient) ChatCompletion(ctx context.Context, request *llm.ChatCompletionRequest) (*llm.ChatCompletionResponse, error) {
+	return nil, errors.New("not expected to be called")
+}
+
+func (idleClient) GetModelName() string { return "idle" }
+
+func (idleClient) GetProvider() string { return "test" }
+
+// newEventTestServer serves the API for a manager with one planning flow,
+// whose events are relayed to event stream clients
+func newEventTestServer(t *testing.T) (*httptest.Server, *flow.PlanningFlow) {
+	t.Helper()
+
+	mem, err := memory.NewFileMemory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := flow.NewFlowManager(flow.NewFlowFactory(idleClient{}, mem, agent.NewFactory(idleClient{}, mem)))
+	created, err := manager.CreateFlow(context.Background(), flow.FlowTypePlanning, "flow1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(":0", manager)
+	s.relayFlowEvents("flow1")
+	server := httptest.NewServer(s.Router)
+	t.Cleanup(server.Close)
+
+	return server, created.(*flow.PlanningFlow)
+}
+
+// publishStep publishes the start of a step on the bus of a flow
+func publishStep(f *flow.PlanningFlow, stepID string) {
+	f.EventBus().Publish(flow.StepEvent{
+		EventInfo: agent.NewEventInfo(context.Background(), flow.EventStepStarted),
+		Step:      flow.PlanStep{ID: stepID},
+	})
+}
+
+// openEvents connects to an event stream, resuming after lastEventID if it
+// is set, and returns the response
+func openEvents(t *testing.T, url, lastEventID string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// nextEvents reads events from a stream until n have been read
+func nextEvents(t *testing.T, body io.Reader, n int) []serverEvent {
+	t.Helper()
+
+	var events []serverEvent
+	err := readEvents(body, func(event serverEvent) (bool, error) {
+		events = append(events, event)
+		return len(events) == n, nil
+	})
+	if err != nil {
+		t.Fatalf("read %d of %d events: %v", len(events), n, err)
+	}
+	return events
+}
+
+// eventIDs returns the IDs of events
+func eventIDs(events []serverEvent) string {
+	ids := make([]string, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := "retry: 1500\r\n\r\n" +
+		": keep-alive\n\n" +
+		"id: 1\nevent: status\ndata: {\"a\":1}\n\n" +
+		"data: first\ndata: second\n\n" +
+		"id: 3\nevent: complete\ndata:no space\n\n"
+
+	var events []serverEvent
+	err := readEvents(strings.NewReader(stream), func(event serverEvent) (bool, error) {
+		events = append(events, event)
+		return false, nil
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("a stream that ends returned %v, want an unexpected EOF", err)
+	}
+
+	want := []serverEvent{
+		{ID: "1", Event: "status", Data: `{"a":1}`, Retry: 1500 * time.Millisecond},
+		// The ID carries over, and events are named message by default
+		{ID: "1", Event: "message", Data: "first\nsecond", Retry: 1500 * time.Millisecond},
+		{ID: "3", Event: "complete", Data: "no space", Retry: 1500 * time.Millisecond},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, events[i], want[i])
+		}
+	}
+
+	// The handler stops the stream, or fails it
+	calls := 0
+	err = readEvents(strings.NewReader(stream), func(event serverEvent) (bool, error) {
+		calls++
+		return true, nil
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("stopping after the first event returned %v after %d calls", err, calls)
+	}
+	failure := errors.New("failed")
+	err = readEvents(strings.NewReader(stream), func(event serverEvent) (bool, error) {
+		return false, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("a failing handler returned %v", err)
+	}
+}
+
+func TestFlowEventLogSubscribe(t *testing.T) {
+	log := newFlowEventLog()
+	for i := 0; i < 3; i++ {
+		log.append(flow.StepEvent{})
+	}
+
+	// Events after the last one received are passed first, then new ones
+	missed, events, unsubscribe := log.subscribe(1)
+	if len(missed) != 2 || missed[0].id != 2 || missed[1].id != 3 {
+		t.Errorf("missed events after 1: %+v", missed)
+	}
+	log.append(flow.StepEvent{})
+	if event := <-events; event.id != 4 {
+		t.Errorf("new event has ID %d, want 4", event.id)
+	}
+	unsubscribe()
+	if _, open := <-events; open {
+		t.Error("the channel is open after unsubscribing")
+	}
+	unsubscribe()
+
+	// An ID the log has not reached, as after a restart, means no ID
+	missed, _, unsubscribe = log.subscribe(99)
+	if len(missed) != 4 || missed[0].id != 1 {
+		t.Errorf("missed events after an unknown ID: %+v", missed)
+	}
+	unsubscribe()
+
+	// Closing the log drops its subscribers
+	_, events, _ = log.subscribe(4)
+	log.close()
+	if _, open := <-events; open {
+		t.Error("the channel is open after the log was closed")
+	}
+}
+
+func TestFlowEventLogKeepsLatestEvents(t *testing.T) {
+	log := newFlowEventLog()
+	for i := 0; i < flowEventBacklog+10; i++ {
+		log.append(flow.StepEvent{})
+	}
+
+	missed, _, unsubscribe := log.subscribe(0)
+	defer unsubscribe()
+	if len(missed) != flowEventBacklog {
+		t.Fatalf("kept %d events, want %d", len(missed), flowEventBacklog)
+	}
+	if first, last := missed[0].id, missed[len(missed)-1].id; first != 11 || last != flowEventBacklog+10 {
+		t.Errorf("kept events %d to %d, want 11 to %d", first, last, flowEventBacklog+10)
+	}
+}
+
+func TestFlowEventLogDropsSlowSubscribers(t *testing.T) {
+	log := newFlowEventLog()
+	_, slow, _ := log.subscribe(0)
+	_, fast, unsubscribe := log.subscribe(0)
+	defer unsubscribe()
+
+	for i := 0; i < flowEventBuffer+1; i++ {
+		log.append(flow.StepEvent{})
+		<-fast
+	}
+
+	// The slow subscriber gets what fit in its buffer, then its channel is
+	// closed so that it reconnects
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != flowEventBuffer {
+		t.Errorf("slow subscriber received %d events, want %d", received, flowEventBuffer)
+	}
+
+	log.append(flow.StepEvent{})
+	if event, open := <-fast; !open || event.id != flowEventBuffer+2 {
+		t.Errorf("subscriber that kept up got %+v, open %v", event, open)
+	}
+}
+
+func TestStreamFlowEvents(t *testing.T) {
+	server, f := newEventTestServer(t)
+	url := server.URL + apiPrefix + "/flows/flow1/events"
+
+	publishStep(f, "s1")
+	publishStep(f, "s2")
+
+	resp := openEvents(t, url, "")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream returned %d with %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events := nextEvents(t, resp.Body, 2)
+	if ids := eventIDs(events); ids != "1,2" {
+		t.Errorf("kept events have IDs %s, want 1,2", ids)
+	}
+	if events[0].Event != string(flow.EventStepStarted) || events[0].Retry != sseRetry {
+		t.Errorf("unexpected first event: %+v", events[0])
+	}
+	var step flow.StepEvent
+	if err := json.Unmarshal([]byte(events[1].Data), &step); err != nil || step.Step.ID != "s2" {
+		t.Errorf("second event is not the start of s2: %s (%v)", events[1].Data, err)
+	}
+
+	// Events published while connected are streamed as they come
+	publishStep(f, "s3")
+	if ids := eventIDs(nextEvents(t, resp.Body, 1)); ids != "3" {
+		t.Errorf("live event has ID %s, want 3", ids)
+	}
+	resp.Body.Close()
+
+	// A client that reconnects gets the events it missed
+	publishStep(f, "s4")
+	resp = openEvents(t, url, "2")
+	if ids := eventIDs(nextEvents(t, resp.Body, 2)); ids != "3,4" {
+		t.Errorf("events after 2 have IDs %s, want 3,4", ids)
+	}
+	resp.Body.Close()
+
+	// An ID from before a restart starts the stream over
+	resp = openEvents(t, url, "99")
+	if ids := eventIDs(nextEvents(t, resp.Body, 4)); ids != "1,2,3,4" {
+		t.Errorf("events after an unknown ID have IDs %s, want 1,2,3,4", ids)
+	}
+	resp.Body.Close()
+
+	resp = openEvents(t, url, "x")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("an invalid last event ID returned %d", resp.StatusCode)
+	}
+	resp = openEvents(t, server.URL+apiPrefix+"/flows/unknown/events", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the events of an unknown flow returned %d", resp.StatusCode)
+	}
+}
+
+func TestStreamCommandEvents(t *testing.T) {
+	server, f := newEventTestServer(t)
+
+	commandID, err := f.ExecuteCommandInBackground("echo one; echo two >&2; echo three")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := f.ExecutionPipeline.GetCommand(commandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cmd.Done
+	url := fmt.Sprintf("%s%s/flows/flow1/commands/%s/events", server.URL, apiPrefix, commandID)
+
+	// readCommand reads the updates of the stream until the command is
+	// complete, and returns the last update with its event ID and the new
+	// output lines of the updates before it
+	readCommand := func(lastEventID string) (CommandStatusResponse, string, []string, []string) {
+		resp := openEvents(t, url, lastEventID)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("stream returned %d", resp.StatusCode)
+		}
+
+		var last CommandStatusResponse
+		var lastID string
+		var output, errors []string
+		err := readEvents(resp.Body, func(event serverEvent) (bool, error) {
+			var update CommandStatusResponse
+			if err := json.Unmarshal([]byte(event.Data), &update); err != nil {
+				return false, err
+			}
+			last, lastID = update, event.ID
+			if update.Incremental {
+				output = append(output, update.OutputList...)
+				errors = append(errors, update.ErrorList...)
+			}
+			return event.Event == "complete", nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return last, lastID, output, errors
+	}
+
+	last, lastID, output, errors := readCommand("")
+	if strings.Join(output, ",") != "one,three" || strings.Join(errors, ",") != "two" {
+		t.Errorf("streamed output %q and errors %q", output, errors)
+	}
+	// The complete update carries all the output, and its ID is the number
+	// of the next output line
+	if !last.Complete || strings.Join(last.OutputList, ",") != "one,three" || lastID != "4" {
+		t.Errorf("last update %+v has ID %s, want all output and ID 4", last, lastID)
+	}
+
+	// A client that reconnects gets the lines from the one after its last
+	// event on
+	_, _, output, errors = readCommand("3")
+	if strings.Join(output, ",") != "three" || len(errors) != 0 {
+		t.Errorf("output after line 2 is %q and errors %q", output, errors)
+	}
+
+	resp := openEvents(t, url, "0")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("an invalid last event ID returned %d", resp.StatusCode)
+	}
+	resp = openEvents(t, fmt.Sprintf("%s%s/flows/flow1/commands/unknown/events", server.URL, apiPrefix), "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the events of an unknown command returned %d", resp.StatusCode)
+	}
+}
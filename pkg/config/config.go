@@ -9,26 +9,197 @@ import (
 
 // Config holds the application configuration
 type Config struct {
-	LLMProvider   string            `json:"llm_provider"`
-	APIKeys       map[string]string `json:"api_keys"`
-	LogLevel      string            `json:"log_level"`
-	WorkingDir    string            `json:"working_dir"`
-	MaxMemorySize int               `json:"max_memory_size"`
-	Timeout       int               `json:"timeout_seconds"`
+	LLMProvider   string                    `json:"llm_provider"`
+	APIKeys       map[string]string         `json:"api_keys"`
+	Providers     map[string]ProviderConfig `json:"providers,omitempty"`
+	Failover      []string                  `json:"failover,omitempty"`
+	Retry         RetryConfig               `json:"retry"`
+	Pricing       map[string]ModelPricing   `json:"pricing,omitempty"`
+	Budget        BudgetConfig              `json:"budget"`
+	Approval      ApprovalConfig            `json:"approval"`
+	Execution     ExecutionConfig           `json:"execution"`
+	Planning      PlanningConfig            `json:"planning"`
+	Server        ServerConfig              `json:"server"`
+	LogLevel      string                    `json:"log_level"`
+	WorkingDir    string                    `json:"working_dir"`
+	StateDir      string                    `json:"state_dir"`
+	MaxMemorySize int                       `json:"max_memory_size"`
+	Timeout       int                       `json:"timeout_seconds"`
+}
+
+// ProviderConfig holds per-provider LLM settings. Empty fields fall back to
+// the provider's defaults.
+type ProviderConfig struct {
+	BaseURL       string `json:"base_url,omitempty"`
+	Model         string `json:"model,omitempty"`
+	SupportsTools *bool  `json:"supports_tools,omitempty"`
+}
+
+// RetryConfig controls retries and circuit breaking for LLM requests
+type RetryConfig struct {
+	MaxRetries             int `json:"max_retries"`
+	InitialBackoffMillis   int `json:"initial_backoff_ms"`
+	MaxBackoffMillis       int `json:"max_backoff_ms"`
+	CircuitBreakerFailures int `json:"circuit_breaker_failures"`
+	CircuitBreakerCooldown int `json:"circuit_breaker_cooldown_seconds"`
+}
+
+// ModelPricing is the price of a model in dollars per million tokens
+type ModelPricing struct {
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+}
+
+// BudgetConfig limits the LLM usage of agent runs and flows. Zero means no limit.
+type BudgetConfig struct {
+	MaxRunTokens  int     `json:"max_run_tokens"`
+	MaxRunCost    float64 `json:"max_run_cost"`
+	MaxFlowTokens int     `json:"max_flow_tokens"`
+	MaxFlowCost   float64 `json:"max_flow_cost"`
+}
+
+// ApprovalConfig controls which tool calls need approval. Without rules, the
+// built-in rules ask before recursive deletes, sudo and paths outside the
+// working directory.
+type ApprovalConfig struct {
+	// Default is the action for calls that match no rule: allow, deny or ask
+	Default string               `json:"default,omitempty"`
+	Rules   []ApprovalRuleConfig `json:"rules,omitempty"`
+}
+
+// ApprovalRuleConfig is a rule of the approval policy. The first rule whose
+// conditions all match a tool call decides it.
+type ApprovalRuleConfig struct {
+	Action            string `json:"action"`
+	Tool              string `json:"tool,omitempty"`
+	Agent             string `json:"agent,omitempty"`
+	Pattern           string `json:"pattern,omitempty"`
+	OutsideWorkingDir bool   `json:"outside_working_dir,omitempty"`
+	Reason            string `json:"reason,omitempty"`
+}
+
+// ExecutionConfig selects where commands run
+type ExecutionConfig struct {
+	// Backend is "host" to run commands directly, or "sandbox" to run them
+	// in a Linux namespace sandbox
+	Backend string        `json:"backend"`
+	Sandbox SandboxConfig `json:"sandbox"`
+	// Commands controls how long background commands are kept
+	Commands CommandRetentionConfig `json:"commands"`
+}
+
+// CommandRetentionConfig limits the finished background commands kept, and
+// whether they are stored under the state directory to survive restarts.
+// Zero limits are not enforced.
+type CommandRetentionConfig struct {
+	MaxCommands   int  `json:"max_commands"`
+	MaxAgeMinutes int  `json:"max_age_minutes"`
+	Persist       bool `json:"persist"`
+}
+
+// SandboxConfig sets the isolation and limits of the sandbox backend. Zero
+// limits are not enforced.
+type SandboxConfig struct {
+	AllowNetwork     bool     `json:"allow_network"`
+	WritablePaths    []string `json:"writable_paths,omitempty"`
+	MemoryMB         int      `json:"memory_mb"`
+	CPUSeconds       int      `json:"cpu_seconds"`
+	MaxProcesses     int      `json:"max_processes"`
+	WallClockSeconds int      `json:"wall_clock_seconds"`
+}
+
+// PlanningConfig controls how planning flows run their plans
+type PlanningConfig struct {
+	// MaxParallelSteps is the number of independent steps run at once
+	MaxParallelSteps int `json:"max_parallel_steps"`
+	// FailurePolicy is "skip" to skip the steps that depend on a failed
+	// step, or "abort" to stop the whole plan
+	FailurePolicy string `json:"failure_policy"`
+	// ReplanBudget is how many times the planner can patch a plan after
+	// steps fail; zero disables replanning
+	ReplanBudget int `json:"replan_budget"`
+}
+
+// ServerConfig configures the API server
+type ServerConfig struct {
+	// Credentials are the API keys the server accepts. Without any, the
+	// server serves everyone who can reach it.
+	Credentials []CredentialConfig `json:"credentials,omitempty"`
+	// RunWorkers is how many submitted runs are processed at once
+	RunWorkers int `json:"run_workers"`
+	// RunsPerUser limits the runs of each user processed at once; a
+	// negative value removes the limit
+	RunsPerUser int `json:"runs_per_user"`
+	// Runs controls how long finished runs are kept
+	Runs RunRetentionConfig `json:"runs"`
+}
+
+// RunRetentionConfig limits the finished runs kept. Zero limits are not
+// enforced.
+type RunRetentionConfig struct {
+	MaxRuns       int `json:"max_runs"`
+	MaxAgeMinutes int `json:"max_age_minutes"`
+}
+
+// CredentialConfig is the API key of a user of the API server, given as is
+// or as its hex SHA-256 hash. Role is viewer, operator or admin.
+type CredentialConfig struct {
+	Key       string `json:"key,omitempty"`
+	KeySHA256 string `json:"key_sha256,omitempty"`
+	User      string `json:"user"`
+	Role      string `json:"role"`
 }
 
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
 	homeDir, _ := os.UserHomeDir()
 	workingDir := filepath.Join(homeDir, ".commandforge")
+	// The state of flows, runs and commands is kept apart from the working
+	// directory, which sandboxed commands may write to
+	stateDir := filepath.Join(homeDir, ".local", "state", "commandforge")
 
 	return &Config{
 		LLMProvider:   "openai",
 		APIKeys:       make(map[string]string),
 		LogLevel:      "info",
 		WorkingDir:    workingDir,
+		StateDir:      stateDir,
 		MaxMemorySize: 100,
 		Timeout:       60,
+		Retry: RetryConfig{
+			MaxRetries:             3,
+			InitialBackoffMillis:   1000,
+			MaxBackoffMillis:       30000,
+			CircuitBreakerFailures: 5,
+			CircuitBreakerCooldown: 60,
+		},
+		Server: ServerConfig{
+			RunWorkers:  4,
+			RunsPerUser: 2,
+			Runs: RunRetentionConfig{
+				MaxRuns:       1000,
+				MaxAgeMinutes: 10080,
+			},
+		},
+		Planning: PlanningConfig{
+			MaxParallelSteps: 4,
+			FailurePolicy:    "skip",
+			ReplanBudget:     3,
+		},
+		Execution: ExecutionConfig{
+			Backend: "host",
+			Sandbox: SandboxConfig{
+				MemoryMB:         2048,
+				CPUSeconds:       600,
+				MaxProcesses:     256,
+				WallClockSeconds: 1800,
+			},
+			Commands: CommandRetentionConfig{
+				MaxCommands:   100,
+				MaxAgeMinutes: 1440,
+				Persist:       true,
+			},
+		},
 	}
 }
 
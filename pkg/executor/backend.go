@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Names of the built-in execution backends
+const (
+	// BackendHost runs commands directly on the host as the current user
+	BackendHost = "host"
+	// BackendSandbox runs commands in a Linux namespace sandbox
+	BackendSandbox = "sandbox"
+)
+
+// ProcessSpec describes a process to run
+type ProcessSpec struct {
+	// Path is the program to run, looked up in PATH if it has no slash
+	Path string
+	Args []string
+	Dir  string
+	// Env is the environment of the process; empty means the current one
+	Env []string
+}
+
+// Backend creates the processes that commands run in
+type Backend interface {
+	// Name returns the name of the backend
+	Name() string
+
+	// Command returns an unstarted process for the spec. The process is
+	// killed when the context is done.
+	Command(ctx context.Context, spec ProcessSpec) (*exec.Cmd, error)
+}
+
+// HostBackend runs processes directly on the host
+type HostBackend struct{}
+
+// Name returns the name of the backend
+func (HostBackend) Name() string {
+	return BackendHost
+}
+
+// Command returns a process running on the host
+func (HostBackend) Command(ctx context.Context, spec ProcessSpec) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, spec.Path, spec.Args...)
+	cmd.Dir = spec.Dir
+	if len(spec.Env) > 0 {
+		cmd.Env = spec.Env
+	}
+	return cmd, nil
+}
+
+// SandboxConfig sets the isolation and limits of the sandbox backend. Zero
+// limits are not enforced.
+type SandboxConfig struct {
+	// AllowNetwork keeps the host network; by default the sandbox has none
+	AllowNetwork bool `json:"allow_network"`
+	// WritablePaths are writable in addition to the command's directory
+	WritablePaths []string `json:"writable_paths,omitempty"`
+	// MemoryLimit is the address space limit of each process in bytes
+	MemoryLimit uint64 `json:"memory_limit"`
+	// CPULimit is the CPU time limit of each process
+	CPULimit time.Duration `json:"cpu_limit"`
+	// MaxProcesses limits the number of processes of the sandbox user
+	MaxProcesses uint64 `json:"max_processes"`
+	// WallClockLimit kills everything in the sandbox after this long
+	WallClockLimit time.Duration `json:"wall_clock_limit"`
+}
+
+// DefaultSandboxConfig returns the default sandbox limits
+func DefaultSandboxConfig() SandboxConfig {
+	return SandboxConfig{
+		MemoryLimit:    2 << 30,
+		CPULimit:       10 * time.Minute,
+		MaxProcesses:   256,
+		WallClockLimit: 30 * time.Minute,
+	}
+}
+
+// NewBackend returns the built-in backend with the given name. The sandbox
+// config is only used by the sandbox backend.
+func NewBackend(name string, sandbox SandboxConfig) (Backend, error) {
+	switch name {
+	case "", BackendHost:
+		return HostBackend{}, nil
+	case BackendSandbox:
+		return NewSandboxBackend(sandbox), nil
+	default:
+		return nil, fmt.Errorf("unknown execution backend: %s", name)
+	}
+}
+
+// defaultBackend is used by commands that have no backend of their own
+var defaultBackend = struct {
+	mu      sync.RWMutex
+	backend Backend
+}{
+	backend: HostBackend{},
+}
+
+// SetDefaultBackend sets the backend used by commands that have none
+func SetDefaultBackend(backend Backend) {
+	defaultBackend.mu.Lock()
+	defer defaultBackend.mu.Unlock()
+	defaultBackend.backend = backend
+}
+
+// DefaultBackend returns the backend used by commands that have none
+func DefaultBackend() Backend {
+	defaultBackend.mu.RLock()
+	defer defaultBackend.mu.RUnlock()
+	return defaultBackend.backend
+}
+
+// backendOrDefault returns the backend, or the default one if it is nil
+func backendOrDefault(backend Backend) Backend {
+	if backend == nil {
+		return DefaultBackend()
+	}
+	return backend
+}
@@ -5,9 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -16,6 +18,8 @@ type BackgroundCommand struct {
 	ID          string
 	Command     string
 	WorkingDir  string
+	// Owner is what the command was started for, such as the ID of a flow
+	Owner       string
 	Cmd         *exec.Cmd
 	StartTime   time.Time
 	EndTime     time.Time
@@ -23,11 +27,25 @@ type BackgroundCommand struct {
 	ExitCode    int
 	Output      string
 	Error       string
-	OutputLines []string
-	ErrorLines  []string
+	// Store keeps the interleaved output of both streams within its limits
+	Store       *OutputStore
 	Done        chan struct{}
 	Cancel      func()
+	Backend     Backend
+	// PTY runs the command in a pseudo-terminal of Rows by Cols. Its output
+	// is rendered into plain text, and lines are stored as they scroll off
+	// the screen.
+	PTY         bool
+	Rows        uint16
+	Cols        uint16
 	mu          sync.RWMutex
+	terminal    *Terminal
+	pty         *os.File
+	ptyDone     chan struct{}
+	stdin       io.WriteCloser
+	// interrupted marks a command restored after it was cut off by a restart
+	interrupted bool
+	usage       *usageMonitor
 }
 
 // NewBackgroundCommand creates a new background command
@@ -40,49 +58,112 @@ func NewBackgroundCommand(command string, workingDir string) (*BackgroundCommand
 		ID:          id,
 		Command:     command,
 		WorkingDir:  workingDir,
-		OutputLines: make([]string, 0),
-		ErrorLines:  make([]string, 0),
+		Store:       NewOutputStore(DefaultOutputLimits()),
 		Done:        make(chan struct{}),
 	}, nil
 }
 
+// WithOutputLimits sets the limits of the command's output store
+func (c *BackgroundCommand) WithOutputLimits(limits OutputLimits) *BackgroundCommand {
+	c.Store = NewOutputStore(limits)
+	return c
+}
+
+// WithTerminal runs the command in a pseudo-terminal of the given size, or
+// the default size if it is zero
+func (c *BackgroundCommand) WithTerminal(rows, cols uint16) *BackgroundCommand {
+	c.PTY = true
+	c.Rows = rows
+	c.Cols = cols
+	return c
+}
+
 // Start begins execution of the background command
 func (c *BackgroundCommand) Start() error {
 	// Create a cancellable context
 	ctx, cancel := context.WithCancel(context.Background())
 	c.Cancel = cancel
 
-	// Create the command
-	c.Cmd = exec.CommandContext(ctx, "sh", "-c", c.Command)
-	if c.WorkingDir != "" {
-		c.Cmd.Dir = c.WorkingDir
-	}
-
-	// Set up pipes for stdout and stderr
-	stdoutPipe, err := c.Cmd.StdoutPipe()
+	// Create the command on its backend
+	cmd, err := backendOrDefault(c.Backend).Command(ctx, ProcessSpec{
+		Path: "sh",
+		Args: []string{"-c", c.Command},
+		Dir:  c.WorkingDir,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+		cancel()
+		return fmt.Errorf("failed to create command: %w", err)
 	}
+	c.Cmd = cmd
 
-	stderrPipe, err := c.Cmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stderr pipe: %w", err)
+	// Cancelling kills the whole process group rather than only the shell
+	if c.Cmd.Cancel != nil {
+		c.Cmd.Cancel = func() error {
+			return signalProcessGroup(c.Cmd, syscall.SIGKILL)
+		}
 	}
 
-	// Start the command
-	c.StartTime = time.Now()
-	if err := c.Cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start command: %w", err)
+	var readers sync.WaitGroup
+	if c.PTY {
+		if err := c.startTerminal(); err != nil {
+			cancel()
+			return err
+		}
+	} else {
+		// Set up pipes for stdin, stdout and stderr
+		stdinPipe, err := c.Cmd.StdinPipe()
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+
+		stdoutPipe, err := c.Cmd.StdoutPipe()
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to create stdout pipe: %w", err)
+		}
+
+		stderrPipe, err := c.Cmd.StderrPipe()
+		if err != nil {
+			cancel()
+			return fmt.Errorf("failed to create stderr pipe: %w", err)
+		}
+
+		// Start the command
+		setProcessGroup(c.Cmd)
+		c.StartTime = time.Now()
+		if err := c.Cmd.Start(); err != nil {
+			cancel()
+			return fmt.Errorf("failed to start command: %w", err)
+		}
+
+		c.mu.Lock()
+		c.stdin = stdinPipe
+		c.mu.Unlock()
+
+		// Start goroutines to read stdout and stderr
+		readers.Add(2)
+		go func() {
+			defer readers.Done()
+			c.readOutput(stdoutPipe, true)
+		}()
+		go func() {
+			defer readers.Done()
+			c.readOutput(stderrPipe, false)
+		}()
 	}
 
-	// Start goroutines to read stdout and stderr
-	go c.readOutput(stdoutPipe, true)
-	go c.readOutput(stderrPipe, false)
+	c.mu.Lock()
+	c.usage = monitorUsage(c.Cmd.Process.Pid)
+	c.mu.Unlock()
 
 	// Start goroutine to wait for command completion
 	go func() {
-		// Wait for the command to finish
+		// Wait for output processing to complete, then for the command
+		readers.Wait()
 		err := c.Cmd.Wait()
+		c.usage.finish(c.Cmd.ProcessState)
+		c.closeTerminal()
 		c.EndTime = time.Now()
 		c.Duration = c.EndTime.Sub(c.StartTime)
 
@@ -97,10 +178,11 @@ func (c *BackgroundCommand) Start() error {
 			c.ExitCode = 0
 		}
 
-		// Finalize the output and error strings
+		// Finalize the output and error strings from the lines in memory
+		outputLines, errorLines := splitStreams(c.Store.Recent())
 		c.mu.Lock()
-		c.Output = strings.Join(c.OutputLines, "\n")
-		c.Error = strings.Join(c.ErrorLines, "\n")
+		c.Output = strings.Join(outputLines, "\n")
+		c.Error = strings.Join(errorLines, "\n")
 		c.mu.Unlock()
 
 		// Signal that the command is done
@@ -110,109 +192,315 @@ func (c *BackgroundCommand) Start() error {
 	return nil
 }
 
-// ExecuteCommandWithStreaming runs a command in the background with streaming output
-func ExecuteCommandWithStreaming(command string, workingDir string) (*BackgroundCommand, error) {
-	// Create a unique ID for the command
-	id := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
+// startTerminal starts the command with a pseudo-terminal as its stdin,
+// stdout and stderr, and renders what it writes
+func (c *BackgroundCommand) startTerminal() error {
+	if c.Rows == 0 {
+		c.Rows = DefaultTerminalRows
+	}
+	if c.Cols == 0 {
+		c.Cols = DefaultTerminalCols
+	}
 
-	// Create a cancellable context
-	ctx, cancel := context.WithCancel(context.Background())
+	master, slave, err := openPTY()
+	if err != nil {
+		return err
+	}
+	defer slave.Close()
 
-	// Create the command
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	if workingDir != "" {
-		cmd.Dir = workingDir
+	if err := setTerminalSize(master, c.Rows, c.Cols); err != nil {
+		master.Close()
+		return fmt.Errorf("failed to set terminal size: %w", err)
 	}
 
-	// Create pipes for stdout and stderr
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	c.Cmd.Stdin = slave
+	c.Cmd.Stdout = slave
+	c.Cmd.Stderr = slave
+	c.Cmd.Env = terminalEnv(c.Cmd.Env)
+	setControllingTerminal(c.Cmd)
+	setProcessGroup(c.Cmd)
+
+	c.StartTime = time.Now()
+	if err := c.Cmd.Start(); err != nil {
+		master.Close()
+		return fmt.Errorf("failed to start command: %w", err)
 	}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
+	terminal := NewTerminal(c.Rows, c.Cols)
+	terminal.OnScroll = func(line string) {
+		c.Store.Append(StreamStdout, line)
 	}
 
-	// Create the background command
-	bgCmd := &BackgroundCommand{
-		ID:          id,
-		Command:     command,
-		WorkingDir:  workingDir,
-		Cmd:         cmd,
-		StartTime:   time.Now(),
-		OutputLines: make([]string, 0),
-		ErrorLines:  make([]string, 0),
-		Done:        make(chan struct{}),
-		Cancel:      cancel,
+	c.mu.Lock()
+	c.terminal = terminal
+	c.pty = master
+	c.ptyDone = make(chan struct{})
+	c.mu.Unlock()
+
+	go c.readTerminal()
+
+	return nil
+}
+
+// readTerminal renders the output of a command running in a pseudo-terminal
+// until every process holding the terminal has exited
+func (c *BackgroundCommand) readTerminal() {
+	defer close(c.ptyDone)
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.pty.Read(buf)
+		if n > 0 {
+			c.terminal.Write(buf[:n])
+		}
+		if err != nil {
+			// Reads fail with EIO once the terminal has no processes left
+			return
+		}
 	}
+}
 
-	// Start the command
-	if err := cmd.Start(); err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to start command: %w", err)
+// closeTerminal closes the pseudo-terminal once its output has been read.
+// Processes that the command left running in the background may keep the
+// terminal open, so it is only waited on briefly.
+func (c *BackgroundCommand) closeTerminal() {
+	if c.pty == nil {
+		return
 	}
-	
-	// Register the command in the registry
-	RegisterCommand(bgCmd)
 
-	// Start goroutines to read from stdout and stderr
-	var wg sync.WaitGroup
-	wg.Add(2)
+	select {
+	case <-c.ptyDone:
+	case <-time.After(2 * time.Second):
+	}
+	c.pty.Close()
 
-	// Process stdout
-	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			bgCmd.AppendOutput(line)
+	// Store what is left on the screen
+	for _, line := range trimBlankLines(c.terminal.Screen()) {
+		c.Store.Append(StreamStdout, line)
+	}
+}
+
+// SendKeys writes keystrokes to a command running in a pseudo-terminal. See
+// ParseKeys for how special keys are written.
+func (c *BackgroundCommand) SendKeys(keys string) error {
+	c.mu.RLock()
+	pty := c.pty
+	c.mu.RUnlock()
+
+	if pty == nil {
+		return fmt.Errorf("command %s is not running in a terminal", c.ID)
+	}
+	if err := c.checkRunning(); err != nil {
+		return err
+	}
+
+	if _, err := pty.Write(ParseKeys(keys)); err != nil {
+		return fmt.Errorf("failed to send keys: %w", err)
+	}
+
+	return nil
+}
+
+// Resize changes the terminal size of a command running in a pseudo-terminal
+func (c *BackgroundCommand) Resize(rows, cols uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pty == nil {
+		return fmt.Errorf("command %s is not running in a terminal", c.ID)
+	}
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("terminal size must be positive")
+	}
+
+	if err := setTerminalSize(c.pty, rows, cols); err != nil {
+		return fmt.Errorf("failed to set terminal size: %w", err)
+	}
+	c.terminal.Resize(rows, cols)
+	c.Rows, c.Cols = rows, cols
+
+	return nil
+}
+
+// WriteInput writes to the stdin of the command, or types into its terminal
+func (c *BackgroundCommand) WriteInput(input string) error {
+	if err := c.checkRunning(); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	stdin, pty := c.stdin, c.pty
+	c.mu.RUnlock()
+
+	var w io.Writer
+	switch {
+	case pty != nil:
+		w = pty
+	case stdin != nil:
+		w = stdin
+	default:
+		return fmt.Errorf("command %s does not accept input", c.ID)
+	}
+
+	if _, err := io.WriteString(w, input); err != nil {
+		return fmt.Errorf("failed to write input: %w", err)
+	}
+
+	return nil
+}
+
+// CloseInput closes the stdin of the command, so that programs reading it
+// to the end can finish. In a terminal it types Ctrl-D instead.
+func (c *BackgroundCommand) CloseInput() error {
+	if err := c.checkRunning(); err != nil {
+		return err
+	}
+
+	c.mu.RLock()
+	stdin, pty := c.stdin, c.pty
+	c.mu.RUnlock()
+
+	switch {
+	case pty != nil:
+		if _, err := pty.Write([]byte{0x04}); err != nil {
+			return fmt.Errorf("failed to close input: %w", err)
 		}
-	}()
+	case stdin != nil:
+		if err := stdin.Close(); err != nil {
+			return fmt.Errorf("failed to close input: %w", err)
+		}
+	default:
+		return fmt.Errorf("command %s does not accept input", c.ID)
+	}
+
+	return nil
+}
+
+// Signal sends a signal to the process group of the command, which includes
+// the processes it started
+func (c *BackgroundCommand) Signal(sig syscall.Signal) error {
+	if err := c.checkRunning(); err != nil {
+		return err
+	}
+
+	if err := signalProcessGroup(c.Cmd, sig); err != nil {
+		return fmt.Errorf("failed to send %s to command %s: %w", SignalName(sig), c.ID, err)
+	}
+
+	return nil
+}
+
+// Terminate sends a signal to the process group of the command and kills
+// the group if the command is still running after the grace period. It
+// returns without waiting for the command to exit.
+func (c *BackgroundCommand) Terminate(sig syscall.Signal, grace time.Duration) error {
+	if err := c.Signal(sig); err != nil {
+		return err
+	}
+	if sig == syscall.SIGKILL {
+		return nil
+	}
 
-	// Process stderr
 	go func() {
-		defer wg.Done()
-		scanner := bufio.NewScanner(stderr)
-		for scanner.Scan() {
-			line := scanner.Text()
-			bgCmd.AppendError(line)
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
+
+		select {
+		case <-c.Done:
+		case <-timer.C:
+			c.Cancel()
 		}
 	}()
 
-	// Wait for the command to complete
-	go func() {
-		// Wait for output processing to complete
-		wg.Wait()
+	return nil
+}
 
-		// Wait for the command to finish
-		err := cmd.Wait()
-		bgCmd.EndTime = time.Now()
-		bgCmd.Duration = bgCmd.EndTime.Sub(bgCmd.StartTime)
+// checkRunning returns an error if the command has not started or has
+// finished
+func (c *BackgroundCommand) checkRunning() error {
+	select {
+	case <-c.Done:
+		return fmt.Errorf("command %s has finished", c.ID)
+	default:
+	}
 
-		// Get the exit code
-		if err != nil {
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				bgCmd.ExitCode = exitErr.ExitCode()
-			} else {
-				bgCmd.ExitCode = 1
-			}
-		} else {
-			bgCmd.ExitCode = 0
+	if c.Cmd == nil || c.Cmd.Process == nil {
+		return fmt.Errorf("command %s has not started", c.ID)
+	}
+
+	return nil
+}
+
+// Screen returns the lines currently on the terminal screen of a command
+// running in a pseudo-terminal, or nil otherwise
+func (c *BackgroundCommand) Screen() []string {
+	c.mu.RLock()
+	terminal := c.terminal
+	c.mu.RUnlock()
+
+	if terminal == nil {
+		return nil
+	}
+
+	return terminal.Screen()
+}
+
+// terminalEnv adds TERM to the environment of a command running in a
+// pseudo-terminal
+func terminalEnv(env []string) []string {
+	if env == nil {
+		env = os.Environ()
+	}
+	for _, value := range env {
+		if strings.HasPrefix(value, "TERM=") {
+			return env
 		}
+	}
 
-		// Finalize the output and error strings
-		bgCmd.mu.Lock()
-		bgCmd.Output = strings.Join(bgCmd.OutputLines, "\n")
-		bgCmd.Error = strings.Join(bgCmd.ErrorLines, "\n")
-		bgCmd.mu.Unlock()
+	return append(env, "TERM=xterm-256color")
+}
 
-		// Signal that the command is done
-		close(bgCmd.Done)
-	}()
+// ExecuteCommandWithStreaming runs a command in the background with streaming output
+func ExecuteCommandWithStreaming(command string, workingDir string) (*BackgroundCommand, error) {
+	return ExecuteCommandOnBackend(nil, command, workingDir)
+}
+
+// ExecuteCommandOnBackend runs a command in the background on the given
+// backend, or the default one if it is nil, with streaming output
+func ExecuteCommandOnBackend(backend Backend, command string, workingDir string) (*BackgroundCommand, error) {
+	bgCmd, err := NewBackgroundCommand(command, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	bgCmd.Backend = backendOrDefault(backend)
+
+	if err := bgCmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Register the command in the registry
+	RegisterCommand(bgCmd)
+
+	return bgCmd, nil
+}
+
+// ExecuteCommandInTerminal runs a command in the background in a
+// pseudo-terminal of the given size, on the given backend or the default one
+// if it is nil
+func ExecuteCommandInTerminal(backend Backend, command string, workingDir string, rows, cols uint16) (*BackgroundCommand, error) {
+	bgCmd, err := NewBackgroundCommand(command, workingDir)
+	if err != nil {
+		return nil, err
+	}
+	bgCmd.Backend = backendOrDefault(backend)
+	bgCmd.WithTerminal(rows, cols)
+
+	if err := bgCmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Register the command in the registry
+	RegisterCommand(bgCmd)
 
 	return bgCmd, nil
 }
@@ -235,38 +523,81 @@ func (c *BackgroundCommand) readOutput(pipe io.Reader, isStdout bool) {
 	}
 }
 
-// AppendOutput adds a line to the output list
+// AppendOutput adds a line to the output
 func (c *BackgroundCommand) AppendOutput(line string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.OutputLines = append(c.OutputLines, line)
+	c.Store.Append(StreamStdout, line)
 }
 
-// AppendError adds a line to the error list
+// AppendError adds a line to the error output
 func (c *BackgroundCommand) AppendError(line string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.ErrorLines = append(c.ErrorLines, line)
+	c.Store.Append(StreamStderr, line)
 }
 
-// GetStatus returns the current status of the command
-func (c *BackgroundCommand) GetStatus() (bool, int, string, string, []string, []string) {
+// ReadOutput reads lines of the command's output. See OutputQuery.
+func (c *BackgroundCommand) ReadOutput(query OutputQuery) (*OutputPage, error) {
+	return c.Store.Read(query)
+}
+
+// Usage returns the resources the command has used so far
+func (c *BackgroundCommand) Usage() ResourceUsage {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
+	monitor := c.usage
+	c.mu.RUnlock()
+
+	var usage ResourceUsage
+	if monitor != nil {
+		usage = monitor.Usage()
+	}
+	usage.OutputBytes = c.Store.Bytes()
 
-	// Make copies of the output and error lines to avoid race conditions
-	outputCopy := make([]string, len(c.OutputLines))
-	errorCopy := make([]string, len(c.ErrorLines))
-	copy(outputCopy, c.OutputLines)
-	copy(errorCopy, c.ErrorLines)
+	return usage
+}
+
+// Close removes the output the command spilled to disk
+func (c *BackgroundCommand) Close() error {
+	return c.Store.Close()
+}
+
+// GetStatus returns the current status of the command. The output is limited
+// to the lines the output store keeps in memory; use ReadOutput for the rest.
+func (c *BackgroundCommand) GetStatus() (bool, int, string, string, []string, []string) {
+	outputLines, errorLines := splitStreams(c.Store.Recent())
 
 	// Check if the command is done
 	select {
 	case <-c.Done:
 		// Command is done
-		return true, c.ExitCode, c.Output, c.Error, outputCopy, errorCopy
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+		return true, c.ExitCode, c.Output, c.Error, outputLines, errorLines
 	default:
-		// Command is still running
-		return false, 0, strings.Join(outputCopy, "\n"), strings.Join(errorCopy, "\n"), outputCopy, errorCopy
+		// Command is still running, and a terminal still shows its latest output
+		outputLines = append(outputLines, trimBlankLines(c.Screen())...)
+		return false, 0, strings.Join(outputLines, "\n"), strings.Join(errorLines, "\n"), outputLines, errorLines
+	}
+}
+
+// splitStreams splits output lines into the text of each stream
+func splitStreams(lines []OutputLine) ([]string, []string) {
+	outputLines := make([]string, 0, len(lines))
+	errorLines := make([]string, 0)
+	for _, line := range lines {
+		if line.Stream == StreamStderr {
+			errorLines = append(errorLines, line.Text)
+		} else {
+			outputLines = append(outputLines, line.Text)
+		}
+	}
+
+	return outputLines, errorLines
+}
+
+// trimBlankLines removes the blank lines at the end of a screen
+func trimBlankLines(lines []string) []string {
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
 	}
+
+	return lines[:end]
 }
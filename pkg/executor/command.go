@@ -23,6 +23,7 @@ type CommandResult struct {
 	Duration   float64  `json:"duration"`
 	OutputList []string `json:"output_list,omitempty"`
 	ErrorList  []string `json:"error_list,omitempty"`
+	Usage      ResourceUsage `json:"usage"`
 }
 
 // Command represents a command to be executed
@@ -33,6 +34,7 @@ type Command struct {
 	Env       []string
 	Timeout   time.Duration
 	Streaming bool
+	Backend   Backend
 	
 	// For streaming output
 	outputMu   sync.Mutex
@@ -78,6 +80,12 @@ func (c *Command) WithEnv(env []string) *Command {
 	return c
 }
 
+// WithBackend sets the backend the command runs on
+func (c *Command) WithBackend(backend Backend) *Command {
+	c.Backend = backend
+	return c
+}
+
 // WithStreaming enables streaming output for the command
 func (c *Command) WithStreaming() *Command {
 	c.Streaming = true
@@ -121,19 +129,20 @@ func (c *Command) Execute() (*CommandResult, error) {
 	startTime := time.Now()
 	startTimeStr := startTime.Format(time.RFC3339)
 	
-	// Create the command
-	cmd := exec.CommandContext(ctx, c.Cmd, c.Args...)
-	if c.Dir != "" {
-		cmd.Dir = c.Dir
-	}
-	if len(c.Env) > 0 {
-		cmd.Env = c.Env
+	// Create the command on its backend
+	cmd, err := backendOrDefault(c.Backend).Command(ctx, ProcessSpec{
+		Path: c.Cmd,
+		Args: c.Args,
+		Dir:  c.Dir,
+		Env:  c.Env,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create command: %w", err)
 	}
 	
 	// Set up pipes for output and error
 	var stdoutBuf, stderrBuf bytes.Buffer
 	var stdout, stderr io.ReadCloser
-	var err error
 	
 	if c.Streaming {
 		stdout, err = cmd.StdoutPipe()
@@ -155,6 +164,8 @@ func (c *Command) Execute() (*CommandResult, error) {
 		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 	
+	usage := monitorUsage(cmd.Process.Pid)
+	
 	// If streaming, process the output in real-time
 	if c.Streaming {
 		var wg sync.WaitGroup
@@ -188,6 +199,7 @@ func (c *Command) Execute() (*CommandResult, error) {
 	
 	// Wait for the command to finish
 	err = cmd.Wait()
+	usage.finish(cmd.ProcessState)
 	endTime := time.Now()
 	endTimeStr := endTime.Format(time.RFC3339)
 	duration := endTime.Sub(startTime).Seconds()
@@ -201,7 +213,9 @@ func (c *Command) Execute() (*CommandResult, error) {
 		StartTime:  startTimeStr,
 		EndTime:    endTimeStr,
 		Duration:   duration,
+		Usage:      usage.Usage(),
 	}
+	result.Usage.OutputBytes = int64(stdoutBuf.Len() + stderrBuf.Len())
 	
 	// Get exit code if available
 	if exitError, ok := err.(*exec.ExitError); ok {
@@ -0,0 +1,393 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// OutputStream identifies the stream an output line was written to
+type OutputStream string
+
+const (
+	StreamStdout OutputStream = "stdout"
+	StreamStderr OutputStream = "stderr"
+)
+
+// spillIndexInterval is the number of spilled lines between indexed offsets
+const spillIndexInterval = 256
+
+// OutputLine is a line of command output. Lines of both streams are numbered
+// together, from 1, in the order they were read.
+type OutputLine struct {
+	Number int          `json:"number"`
+	Stream OutputStream `json:"stream"`
+	Time   time.Time    `json:"time"`
+	Text   string       `json:"text"`
+}
+
+// OutputLimits bounds the output an OutputStore keeps
+type OutputLimits struct {
+	// MaxLines and MaxBytes limit the lines kept in memory. Older lines are
+	// moved to a spill file.
+	MaxLines int `json:"max_lines"`
+	MaxBytes int `json:"max_bytes"`
+	// MaxSpillBytes limits the spill file. Lines that do not fit are dropped.
+	// Zero disables spilling.
+	MaxSpillBytes int64 `json:"max_spill_bytes"`
+	// SpillDir is where spill files are created; empty means the system's
+	// temporary directory
+	SpillDir string `json:"spill_dir,omitempty"`
+}
+
+// DefaultOutputLimits returns the default output limits: 10000 lines or
+// 4 MiB in memory, and up to 256 MiB on disk
+func DefaultOutputLimits() OutputLimits {
+	return OutputLimits{
+		MaxLines:      10000,
+		MaxBytes:      4 << 20,
+		MaxSpillBytes: 256 << 20,
+	}
+}
+
+// OutputQuery selects lines to read from an OutputStore
+type OutputQuery struct {
+	// Offset is the number of the first line to read, from 1
+	Offset int
+	// Limit caps the number of lines read; zero means no limit
+	Limit int
+	// Tail reads the last Tail matching lines instead of reading from Offset
+	Tail int
+	// Stream only reads lines of one stream if it is set
+	Stream OutputStream
+	// Pattern only reads lines matching the regular expression if it is set
+	Pattern string
+}
+
+// OutputPage is the result of reading an OutputStore
+type OutputPage struct {
+	Lines []OutputLine `json:"lines"`
+	// TotalLines is the number of lines written so far
+	TotalLines int `json:"total_lines"`
+	// NextOffset is the offset to continue reading from
+	NextOffset int `json:"next_offset"`
+	// HasMore reports that the read stopped at its limit
+	HasMore bool `json:"has_more"`
+	// DroppedLines is the number of lines lost because the spill file was
+	// full
+	DroppedLines int `json:"dropped_lines,omitempty"`
+}
+
+// OutputStore keeps the output of a command within limits. The latest lines
+// are kept in a ring buffer in memory, and lines evicted from it are appended
+// to a spill file, so that the whole output can still be read by offset.
+type OutputStore struct {
+	limits OutputLimits
+
+	mu sync.Mutex
+	// ring holds count lines from head, numbered from first
+	ring      []OutputLine
+	head      int
+	count     int
+	ringBytes int
+	first     int
+	total     int
+	bytes     int64
+
+	// spill holds lines 1 to spilled, and index the offsets of every
+	// spillIndexInterval-th of them
+	spill      *os.File
+	spillBytes int64
+	spilled    int
+	index      []int64
+	dropped    int
+	closed     bool
+}
+
+// NewOutputStore creates an output store with the given limits
+func NewOutputStore(limits OutputLimits) *OutputStore {
+	if limits.MaxLines <= 0 {
+		limits.MaxLines = DefaultOutputLimits().MaxLines
+	}
+
+	return &OutputStore{
+		limits: limits,
+		first:  1,
+	}
+}
+
+// Append adds a line of output
+func (s *OutputStore) Append(stream OutputStream, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.appendLine(OutputLine{
+		Number: s.total + 1,
+		Stream: stream,
+		Time:   time.Now(),
+		Text:   text,
+	})
+}
+
+// Restore adds lines read back from persisted output, keeping their numbers
+// and times. Missing numbers are counted as dropped lines.
+func (s *OutputStore) Restore(lines []OutputLine) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, line := range lines {
+		if line.Number <= s.total {
+			continue
+		}
+		s.dropped += line.Number - s.total - 1
+		s.appendLine(line)
+	}
+}
+
+// appendLine adds a line numbered after the last one
+func (s *OutputStore) appendLine(line OutputLine) {
+	if s.closed {
+		return
+	}
+
+	s.total = line.Number
+	s.bytes += int64(len(line.Text)) + 1
+
+	if s.count == len(s.ring) {
+		s.grow()
+	}
+	s.ring[(s.head+s.count)%len(s.ring)] = line
+	s.count++
+	s.ringBytes += len(line.Text)
+
+	for s.count > 1 && (s.count > s.limits.MaxLines || (s.limits.MaxBytes > 0 && s.ringBytes > s.limits.MaxBytes)) {
+		s.evict()
+	}
+}
+
+// grow enlarges the ring buffer up to the line limit
+func (s *OutputStore) grow() {
+	size := len(s.ring) * 2
+	if size == 0 {
+		size = 64
+	}
+	if size > s.limits.MaxLines+1 {
+		size = s.limits.MaxLines + 1
+	}
+
+	ring := make([]OutputLine, size)
+	for i := 0; i < s.count; i++ {
+		ring[i] = s.ring[(s.head+i)%len(s.ring)]
+	}
+	s.ring = ring
+	s.head = 0
+}
+
+// evict removes the oldest line from memory and spills it
+func (s *OutputStore) evict() {
+	line := s.ring[s.head]
+	s.ring[s.head] = OutputLine{}
+	s.head = (s.head + 1) % len(s.ring)
+	s.count--
+	s.ringBytes -= len(line.Text)
+	s.first++
+
+	// Lines can only be spilled while every earlier line has been
+	if s.dropped == 0 && s.spillLine(line) == nil {
+		return
+	}
+	s.dropped++
+}
+
+// spillLine appends a line to the spill file
+func (s *OutputStore) spillLine(line OutputLine) error {
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if s.spillBytes+int64(len(data)) > s.limits.MaxSpillBytes {
+		return fmt.Errorf("spill file is full")
+	}
+
+	if s.spill == nil {
+		file, err := os.CreateTemp(s.limits.SpillDir, "commandforge-output-*.jsonl")
+		if err != nil {
+			return err
+		}
+		s.spill = file
+	}
+
+	if (line.Number-1)%spillIndexInterval == 0 {
+		s.index = append(s.index, s.spillBytes)
+	}
+	if _, err := s.spill.WriteAt(data, s.spillBytes); err != nil {
+		return err
+	}
+	s.spillBytes += int64(len(data))
+	s.spilled = line.Number
+
+	return nil
+}
+
+// Read returns the lines selected by the query
+func (s *OutputStore) Read(query OutputQuery) (*OutputPage, error) {
+	var pattern *regexp.Regexp
+	if query.Pattern != "" {
+		var err error
+		pattern, err = regexp.Compile(query.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+	}
+	matches := func(line OutputLine) bool {
+		if query.Stream != "" && line.Stream != query.Stream {
+			return false
+		}
+		return pattern == nil || pattern.MatchString(line.Text)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := &OutputPage{
+		Lines:        []OutputLine{},
+		TotalLines:   s.total,
+		NextOffset:   s.total + 1,
+		DroppedLines: s.dropped,
+	}
+
+	if query.Tail > 0 {
+		// Without a filter the last lines are the matching ones, so only
+		// they need to be read
+		from := 1
+		if query.Stream == "" && pattern == nil {
+			from = s.total - query.Tail + 1
+		}
+
+		err := s.each(from, func(line OutputLine) bool {
+			if matches(line) {
+				page.Lines = append(page.Lines, line)
+				if len(page.Lines) > query.Tail {
+					page.Lines = page.Lines[1:]
+				}
+			}
+			return true
+		})
+		return page, err
+	}
+
+	err := s.each(query.Offset, func(line OutputLine) bool {
+		if query.Limit > 0 && len(page.Lines) == query.Limit {
+			page.NextOffset = line.Number
+			page.HasMore = true
+			return false
+		}
+		if matches(line) {
+			page.Lines = append(page.Lines, line)
+		}
+		return true
+	})
+
+	return page, err
+}
+
+// each calls fn on every line from the given number on, spilled lines
+// first, until fn returns false
+func (s *OutputStore) each(from int, fn func(OutputLine) bool) error {
+	if from < 1 {
+		from = 1
+	}
+
+	if from <= s.spilled {
+		offset := s.index[(from-1)/spillIndexInterval]
+		reader := bufio.NewReader(io.NewSectionReader(s.spill, offset, s.spillBytes-offset))
+		for {
+			data, err := reader.ReadBytes('\n')
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read spilled output: %w", err)
+			}
+
+			var line OutputLine
+			if err := json.Unmarshal(data, &line); err != nil {
+				return fmt.Errorf("failed to read spilled output: %w", err)
+			}
+			if line.Number < from {
+				continue
+			}
+			if !fn(line) {
+				return nil
+			}
+		}
+	}
+
+	for i := 0; i < s.count; i++ {
+		line := s.ring[(s.head+i)%len(s.ring)]
+		if line.Number < from {
+			continue
+		}
+		if !fn(line) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Recent returns the lines kept in memory
+func (s *OutputStore) Recent() []OutputLine {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make([]OutputLine, s.count)
+	for i := range lines {
+		lines[i] = s.ring[(s.head+i)%len(s.ring)]
+	}
+
+	return lines
+}
+
+// Len returns the number of lines written
+func (s *OutputStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// Bytes returns the number of bytes written, counting line endings
+func (s *OutputStore) Bytes() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Close removes the spill file. The store keeps the lines in memory, but
+// drops any further output.
+func (s *OutputStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	if s.spill == nil {
+		return nil
+	}
+
+	name := s.spill.Name()
+	s.spill.Close()
+	s.spill = nil
+	s.dropped += s.spilled
+	s.spilled = 0
+	s.index = nil
+	s.spillBytes = 0
+
+	return os.Remove(name)
+}
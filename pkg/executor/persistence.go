@@ -0,0 +1,306 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandState is the state of a background command
+type CommandState string
+
+const (
+	CommandRunning   CommandState = "running"
+	CommandSucceeded CommandState = "succeeded"
+	CommandFailed    CommandState = "failed"
+	// CommandInterrupted marks a command that was still running when the
+	// process that started it stopped
+	CommandInterrupted CommandState = "interrupted"
+)
+
+// ParseCommandState parses the name of a command state
+func ParseCommandState(name string) (CommandState, error) {
+	switch state := CommandState(strings.ToLower(name)); state {
+	case CommandRunning, CommandSucceeded, CommandFailed, CommandInterrupted:
+		return state, nil
+	default:
+		return "", fmt.Errorf("unknown command state: %s", name)
+	}
+}
+
+// CommandRecord describes a background command. It is what a CommandStore
+// persists besides the output.
+type CommandRecord struct {
+	ID         string        `json:"id"`
+	Command    string        `json:"command"`
+	WorkingDir string        `json:"working_dir,omitempty"`
+	Owner      string        `json:"owner,omitempty"`
+	Backend    string        `json:"backend,omitempty"`
+	PTY        bool          `json:"pty,omitempty"`
+	State      CommandState  `json:"state"`
+	ExitCode   int           `json:"exit_code"`
+	StartTime  time.Time     `json:"start_time"`
+	EndTime    time.Time     `json:"end_time,omitempty"`
+	TotalLines int           `json:"total_lines"`
+	Usage      ResourceUsage `json:"usage"`
+}
+
+// CommandStore persists background commands and their output, so that they
+// can be read after a restart
+type CommandStore interface {
+	// SaveCommand creates or replaces the record of a command
+	SaveCommand(record CommandRecord) error
+
+	// AppendOutput adds lines to the output of a command
+	AppendOutput(id string, lines []OutputLine) error
+
+	// LoadCommands returns the records of all stored commands
+	LoadCommands() ([]CommandRecord, error)
+
+	// LoadOutput returns the stored output of a command
+	LoadOutput(id string) ([]OutputLine, error)
+
+	// DeleteCommand removes a command and its output
+	DeleteCommand(id string) error
+}
+
+// FileCommandStore stores commands in a directory, with the record of each
+// command in <id>.json and its output in <id>.output.jsonl
+type FileCommandStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewFileCommandStore creates a command store in the given directory
+func NewFileCommandStore(dir string) (*FileCommandStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create command directory: %w", err)
+	}
+
+	return &FileCommandStore{Dir: dir}, nil
+}
+
+// path returns the path of a file of a command
+func (s *FileCommandStore) path(id string, ext string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+ext)
+}
+
+// SaveCommand writes the record of a command, replacing it atomically
+func (s *FileCommandStore) SaveCommand(record CommandRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal command record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(record.ID, ".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write command record: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write command record: %w", err)
+	}
+
+	return nil
+}
+
+// AppendOutput appends lines to the output file of a command
+func (s *FileCommandStore) AppendOutput(id string, lines []OutputLine) error {
+	var data []byte
+	for _, line := range lines {
+		encoded, err := json.Marshal(line)
+		if err != nil {
+			return fmt.Errorf("failed to marshal output line: %w", err)
+		}
+		data = append(data, encoded...)
+		data = append(data, '\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path(id, ".output.jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+
+	return nil
+}
+
+// LoadCommands reads the records of all stored commands, oldest first
+func (s *FileCommandStore) LoadCommands() ([]CommandRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commands: %w", err)
+	}
+
+	records := make([]CommandRecord, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read command record %s: %w", path, err)
+		}
+
+		var record CommandRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse command record %s: %w", path, err)
+		}
+		// A record is only trusted under the name it was saved with
+		if s.path(record.ID, ".json") != path {
+			return nil, fmt.Errorf("command record %s has the ID of another command: %s", path, record.ID)
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+
+	return records, nil
+}
+
+// LoadOutput reads the output file of a command. A line cut short by a
+// crash is skipped.
+func (s *FileCommandStore) LoadOutput(id string) ([]OutputLine, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path(id, ".output.jsonl"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	var lines []OutputLine
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line OutputLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return lines, fmt.Errorf("failed to read output file: %w", err)
+	}
+
+	return lines, nil
+}
+
+// DeleteCommand removes the files of a command
+func (s *FileCommandStore) DeleteCommand(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ext := range []string{".json", ".output.jsonl"} {
+		if err := os.Remove(s.path(id, ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete command %s: %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// State returns the state of the command
+func (c *BackgroundCommand) State() CommandState {
+	select {
+	case <-c.Done:
+	default:
+		return CommandRunning
+	}
+
+	switch {
+	case c.interrupted:
+		return CommandInterrupted
+	case c.ExitCode == 0:
+		return CommandSucceeded
+	default:
+		return CommandFailed
+	}
+}
+
+// Record returns the record of the command
+func (c *BackgroundCommand) Record() CommandRecord {
+	record := CommandRecord{
+		ID:         c.ID,
+		Command:    c.Command,
+		WorkingDir: c.WorkingDir,
+		Owner:      c.Owner,
+		PTY:        c.PTY,
+		State:      c.State(),
+		StartTime:  c.StartTime,
+		TotalLines: c.Store.Len(),
+		Usage:      c.Usage(),
+	}
+	if c.Backend != nil {
+		record.Backend = c.Backend.Name()
+	}
+	if record.State != CommandRunning {
+		record.ExitCode = c.ExitCode
+		record.EndTime = c.EndTime
+	}
+
+	return record
+}
+
+// restoreCommand recreates a finished command from its record and output. A
+// command that was still running is marked as interrupted, as its process
+// can no longer be reached.
+func restoreCommand(record CommandRecord, lines []OutputLine) *BackgroundCommand {
+	cmd := &BackgroundCommand{
+		ID:         record.ID,
+		Command:    record.Command,
+		WorkingDir: record.WorkingDir,
+		Owner:      record.Owner,
+		PTY:        record.PTY,
+		StartTime:  record.StartTime,
+		EndTime:    record.EndTime,
+		ExitCode:   record.ExitCode,
+		Store:      NewOutputStore(DefaultOutputLimits()),
+		Done:       make(chan struct{}),
+		usage:      &usageMonitor{usage: record.Usage},
+	}
+	cmd.Store.Restore(lines)
+
+	if record.State == CommandRunning || record.State == CommandInterrupted {
+		cmd.interrupted = true
+		if cmd.ExitCode == 0 {
+			cmd.ExitCode = -1
+		}
+		// The command stopped at some point after its last output
+		if cmd.EndTime.IsZero() {
+			cmd.EndTime = cmd.StartTime
+			if len(lines) > 0 && lines[len(lines)-1].Time.After(cmd.EndTime) {
+				cmd.EndTime = lines[len(lines)-1].Time
+			}
+		}
+	}
+	cmd.Duration = cmd.EndTime.Sub(cmd.StartTime)
+
+	outputLines, errorLines := splitStreams(cmd.Store.Recent())
+	cmd.Output = strings.Join(outputLines, "\n")
+	cmd.Error = strings.Join(errorLines, "\n")
+	close(cmd.Done)
+
+	return cmd
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing, since process groups need a Unix system
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcessGroup sends a signal to the process only, since process groups
+// need a Unix system
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// openPTY fails, since pseudo-terminals are only supported on Linux
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are not supported on %s", runtime.GOOS)
+}
+
+// setTerminalSize fails, since pseudo-terminals are only supported on Linux
+func setTerminalSize(file *os.File, rows, cols uint16) error {
+	return fmt.Errorf("pseudo-terminals are not supported on %s", runtime.GOOS)
+}
+
+// disableEcho fails, since pseudo-terminals are only supported on Linux
+func disableEcho(file *os.File) error {
+	return fmt.Errorf("pseudo-terminals are not supported on %s", runtime.GOOS)
+}
+
+// setControllingTerminal does nothing, since pseudo-terminals are only
+// supported on Linux
+func setControllingTerminal(cmd *exec.Cmd) {}
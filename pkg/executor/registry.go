@@ -2,22 +2,209 @@ package executor
 
 import (
 	"fmt"
+	"log"
+	"sort"
 	"sync"
+	"syscall"
+	"time"
 )
 
+// persistInterval is how often the new output of running commands is
+// persisted
+const persistInterval = time.Second
+
+// RetentionPolicy limits the finished commands kept in the registry. Running
+// commands are never removed. Zero values are not enforced.
+type RetentionPolicy struct {
+	// MaxCommands is the number of finished commands kept; the ones that
+	// finished first are removed
+	MaxCommands int
+	// MaxAge is how long finished commands are kept
+	MaxAge time.Duration
+}
+
+// DefaultRetentionPolicy returns the default retention policy: the last 100
+// finished commands, for up to a day
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		MaxCommands: 100,
+		MaxAge:      24 * time.Hour,
+	}
+}
+
 // CommandRegistry is a registry for background commands
 var commandRegistry = struct {
-	mu       sync.RWMutex
-	commands map[string]*BackgroundCommand
+	mu        sync.RWMutex
+	commands  map[string]*BackgroundCommand
+	retention RetentionPolicy
+	store     CommandStore
 }{
-	commands: make(map[string]*BackgroundCommand),
+	commands:  make(map[string]*BackgroundCommand),
+	retention: DefaultRetentionPolicy(),
+}
+
+// SetRetentionPolicy sets how many finished commands the registry keeps and
+// for how long
+func SetRetentionPolicy(policy RetentionPolicy) {
+	commandRegistry.mu.Lock()
+	commandRegistry.retention = policy
+	commandRegistry.mu.Unlock()
+
+	collectCommands()
 }
 
-// RegisterCommand adds a command to the registry
+// PersistCommands saves the commands registered from now on, and their
+// output, to the store. The commands stored by an earlier run are registered
+// again; the ones that were still running are marked as interrupted.
+func PersistCommands(store CommandStore) error {
+	records, err := store.LoadCommands()
+	if err != nil {
+		return fmt.Errorf("failed to load commands: %w", err)
+	}
+
+	restored := make([]*BackgroundCommand, 0, len(records))
+	for _, record := range records {
+		lines, err := store.LoadOutput(record.ID)
+		if err != nil {
+			log.Printf("Failed to load the output of command %s: %v", record.ID, err)
+		}
+
+		cmd := restoreCommand(record, lines)
+		if record.State == CommandRunning {
+			if err := store.SaveCommand(cmd.Record()); err != nil {
+				return fmt.Errorf("failed to mark command %s as interrupted: %w", record.ID, err)
+			}
+		}
+		restored = append(restored, cmd)
+	}
+
+	commandRegistry.mu.Lock()
+	commandRegistry.store = store
+	for _, cmd := range restored {
+		if _, ok := commandRegistry.commands[cmd.ID]; !ok {
+			commandRegistry.commands[cmd.ID] = cmd
+		}
+	}
+	commandRegistry.mu.Unlock()
+
+	collectCommands()
+
+	return nil
+}
+
+// RegisterCommand adds a command to the registry. Once it has finished, the
+// retention policy applies to it.
 func RegisterCommand(cmd *BackgroundCommand) {
 	commandRegistry.mu.Lock()
-	defer commandRegistry.mu.Unlock()
 	commandRegistry.commands[cmd.ID] = cmd
+	store := commandRegistry.store
+	commandRegistry.mu.Unlock()
+
+	go watchCommand(cmd, store)
+	collectCommands()
+}
+
+// watchCommand persists a command while it runs, if there is a store, and
+// applies the retention policy once it has finished
+func watchCommand(cmd *BackgroundCommand, store CommandStore) {
+	if store == nil {
+		<-cmd.Done
+		collectCommands()
+		return
+	}
+
+	if err := store.SaveCommand(cmd.Record()); err != nil {
+		log.Printf("Failed to persist command %s: %v", cmd.ID, err)
+	}
+
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+
+	next := 1
+	for running := true; running; {
+		select {
+		case <-cmd.Done:
+			running = false
+		case <-ticker.C:
+		}
+
+		// Stop once the command has been removed from the registry
+		if _, err := GetCommand(cmd.ID); err != nil {
+			return
+		}
+		next = persistOutput(store, cmd, next)
+	}
+
+	if err := store.SaveCommand(cmd.Record()); err != nil {
+		log.Printf("Failed to persist command %s: %v", cmd.ID, err)
+	}
+	collectCommands()
+}
+
+// persistOutput appends the output of a command from the given line on to
+// the store, and returns the line to continue from
+func persistOutput(store CommandStore, cmd *BackgroundCommand, next int) int {
+	for {
+		page, err := cmd.ReadOutput(OutputQuery{Offset: next, Limit: 1000})
+		if err != nil {
+			log.Printf("Failed to read the output of command %s: %v", cmd.ID, err)
+			return next
+		}
+		if len(page.Lines) > 0 {
+			if err := store.AppendOutput(cmd.ID, page.Lines); err != nil {
+				log.Printf("Failed to persist the output of command %s: %v", cmd.ID, err)
+				return next
+			}
+		}
+		next = page.NextOffset
+
+		if !page.HasMore {
+			return next
+		}
+	}
+}
+
+// collectCommands removes the finished commands the retention policy no
+// longer keeps
+func collectCommands() {
+	commandRegistry.mu.Lock()
+	policy := commandRegistry.retention
+	store := commandRegistry.store
+
+	finished := make([]*BackgroundCommand, 0, len(commandRegistry.commands))
+	for _, cmd := range commandRegistry.commands {
+		if cmd.State() != CommandRunning {
+			finished = append(finished, cmd)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].EndTime.Before(finished[j].EndTime)
+	})
+
+	var removed []*BackgroundCommand
+	for i, cmd := range finished {
+		expired := policy.MaxAge > 0 && time.Since(cmd.EndTime) > policy.MaxAge
+		excess := policy.MaxCommands > 0 && len(finished)-i > policy.MaxCommands
+		if expired || excess {
+			delete(commandRegistry.commands, cmd.ID)
+			removed = append(removed, cmd)
+		}
+	}
+	commandRegistry.mu.Unlock()
+
+	for _, cmd := range removed {
+		forgetCommand(cmd, store)
+	}
+}
+
+// forgetCommand deletes what is kept of a command removed from the registry
+func forgetCommand(cmd *BackgroundCommand, store CommandStore) {
+	cmd.Close()
+	if store != nil {
+		if err := store.DeleteCommand(cmd.ID); err != nil {
+			log.Printf("Failed to delete command %s: %v", cmd.ID, err)
+		}
+	}
 }
 
 // GetCommand retrieves a command from the registry
@@ -42,9 +229,82 @@ func ListCommands() []string {
 	return ids
 }
 
-// RemoveCommand removes a command from the registry
+// OwnedCommands returns the registered commands of an owner, oldest first
+func OwnedCommands(owner string) []*BackgroundCommand {
+	commandRegistry.mu.RLock()
+	commands := make([]*BackgroundCommand, 0)
+	for _, cmd := range commandRegistry.commands {
+		if cmd.Owner == owner {
+			commands = append(commands, cmd)
+		}
+	}
+	commandRegistry.mu.RUnlock()
+
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].StartTime.Before(commands[j].StartTime)
+	})
+
+	return commands
+}
+
+// ListCommandRecords returns the records of the registered commands in the
+// given state, or all of them if it is empty, oldest first
+func ListCommandRecords(state CommandState) []CommandRecord {
+	commandRegistry.mu.RLock()
+	records := make([]CommandRecord, 0, len(commandRegistry.commands))
+	for _, cmd := range commandRegistry.commands {
+		record := cmd.Record()
+		if state == "" || record.State == state {
+			records = append(records, record)
+		}
+	}
+	commandRegistry.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime.Before(records[j].StartTime)
+	})
+
+	return records
+}
+
+// RemoveCommand removes a command from the registry and deletes its output,
+// both spilled and persisted
 func RemoveCommand(id string) {
 	commandRegistry.mu.Lock()
-	defer commandRegistry.mu.Unlock()
+	cmd, ok := commandRegistry.commands[id]
 	delete(commandRegistry.commands, id)
+	store := commandRegistry.store
+	commandRegistry.mu.Unlock()
+
+	if ok {
+		forgetCommand(cmd, store)
+	}
+}
+
+// WriteCommandInput writes to the stdin of a registered command
+func WriteCommandInput(id string, input string) error {
+	cmd, err := GetCommand(id)
+	if err != nil {
+		return err
+	}
+	return cmd.WriteInput(input)
+}
+
+// SignalCommand sends a signal to the process group of a registered command
+func SignalCommand(id string, sig syscall.Signal) error {
+	cmd, err := GetCommand(id)
+	if err != nil {
+		return err
+	}
+	return cmd.Signal(sig)
+}
+
+// CancelCommand asks a registered command to terminate with SIGTERM and kills
+// its process group if it is still running after the grace period
+func CancelCommand(id string, grace time.Duration) error {
+	cmd, err := GetCommand(id)
+	if err != nil {
+		return err
+	}
+	return cmd.Terminate(syscall.SIGTERM, grace)
 }
@@ -0,0 +1,420 @@
+//go:build linux
+
+package executor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxEnv carries the sandbox spec to the re-executed binary
+const sandboxEnv = "COMMANDFORGE_SANDBOX"
+
+// Sandbox stages. The init stage runs as PID 1 of the sandbox: it sets up
+// the mounts, starts the exec stage and enforces the wall-clock limit. The
+// exec stage sets the resource limits, drops its capabilities and replaces
+// itself with the command.
+const (
+	sandboxStageInit = "init"
+	sandboxStageExec = "exec"
+)
+
+// sandboxSpec is passed from each stage to the next
+type sandboxSpec struct {
+	Stage  string        `json:"stage"`
+	Dir    string        `json:"dir"`
+	Config SandboxConfig `json:"config"`
+}
+
+// The binary is re-executed to run the sandbox stages, so that the mounts
+// and limits are set up before the command starts. This runs before main.
+func init() {
+	encoded, ok := os.LookupEnv(sandboxEnv)
+	if !ok {
+		return
+	}
+
+	os.Exit(runSandboxStage(encoded))
+}
+
+// SandboxBackend runs processes in Linux user, mount, PID, IPC, UTS and
+// network namespaces. The root file system is read-only except for the
+// process's directory, the configured writable paths and a private /tmp.
+type SandboxBackend struct {
+	Config SandboxConfig
+}
+
+// NewSandboxBackend creates a sandbox backend
+func NewSandboxBackend(config SandboxConfig) *SandboxBackend {
+	return &SandboxBackend{
+		Config: config,
+	}
+}
+
+// Name returns the name of the backend
+func (b *SandboxBackend) Name() string {
+	return BackendSandbox
+}
+
+// Command returns a process that runs the spec in a new sandbox
+func (b *SandboxBackend) Command(ctx context.Context, spec ProcessSpec) (*exec.Cmd, error) {
+	dir := spec.Dir
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get working directory: %w", err)
+		}
+		dir = wd
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve working directory: %w", err)
+	}
+
+	encoded, err := json.Marshal(sandboxSpec{Stage: sandboxStageInit, Dir: dir, Config: b.Config})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sandbox spec: %w", err)
+	}
+
+	env := spec.Env
+	if len(env) == 0 {
+		env = os.Environ()
+	}
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Args = append([]string{"commandforge-sandbox", spec.Path}, spec.Args...)
+	cmd.Dir = dir
+	cmd.Env = append(withoutSandboxEnv(env), sandboxEnv+"="+string(encoded))
+
+	cloneFlags := uintptr(unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS)
+	if !b.Config.AllowNetwork {
+		cloneFlags |= unix.CLONE_NEWNET
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: cloneFlags,
+		UidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getuid(), Size: 1},
+		},
+		GidMappings: []syscall.SysProcIDMap{
+			{ContainerID: 0, HostID: os.Getgid(), Size: 1},
+		},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+
+	return cmd, nil
+}
+
+// runSandboxStage runs a sandbox stage and returns its exit code
+func runSandboxStage(encoded string) int {
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: invalid spec: %v\n", err)
+		return 126
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "sandbox: no command given")
+		return 126
+	}
+
+	switch spec.Stage {
+	case sandboxStageInit:
+		return sandboxInit(spec)
+	case sandboxStageExec:
+		return sandboxExec(spec)
+	default:
+		fmt.Fprintf(os.Stderr, "sandbox: unknown stage: %s\n", spec.Stage)
+		return 126
+	}
+}
+
+// sandboxInit sets up the mounts, then runs the exec stage until it exits
+// or the wall-clock limit is reached
+func sandboxInit(spec sandboxSpec) int {
+	if err := setupSandboxMounts(spec); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 126
+	}
+
+	next := spec
+	next.Stage = sandboxStageExec
+	encoded, err := json.Marshal(next)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: failed to encode spec: %v\n", err)
+		return 126
+	}
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = os.Args
+	cmd.Dir = spec.Dir
+	cmd.Env = append(withoutSandboxEnv(os.Environ()), sandboxEnv+"="+string(encoded))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Forward signals, since PID 1 of a namespace ignores them by default
+	signals := make(chan os.Signal, 4)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: failed to start command: %v\n", err)
+		return 126
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if spec.Config.WallClockLimit > 0 {
+		timer := time.NewTimer(spec.Config.WallClockLimit)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		select {
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+		case <-timeout:
+			fmt.Fprintf(os.Stderr, "sandbox: killed after the wall-clock limit of %s\n", spec.Config.WallClockLimit)
+			unix.Kill(-1, unix.SIGKILL)
+			<-done
+			return 137
+		case <-done:
+			// Kill anything the command left running in the sandbox
+			unix.Kill(-1, unix.SIGKILL)
+			return exitCode(cmd.ProcessState)
+		}
+	}
+}
+
+// sandboxExec sets the resource limits, drops all capabilities and replaces
+// the process with the command
+func sandboxExec(spec sandboxSpec) int {
+	if err := setSandboxLimits(spec.Config); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 126
+	}
+
+	if err := dropCapabilities(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 126
+	}
+
+	path, err := exec.LookPath(os.Args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+		return 127
+	}
+
+	err = unix.Exec(path, os.Args[1:], withoutSandboxEnv(os.Environ()))
+	fmt.Fprintf(os.Stderr, "sandbox: failed to run %s: %v\n", os.Args[1], err)
+	return 126
+}
+
+// setupSandboxMounts makes the root file system read-only and the writable
+// paths writable, with a private /tmp and /proc
+func setupSandboxMounts(spec sandboxSpec) error {
+	// Keep the mount changes out of the host's namespace
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	mountPoints, err := readMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if mountPoint == "/proc" || strings.HasPrefix(mountPoint, "/proc/") {
+			continue
+		}
+		if err := remount(mountPoint, unix.MS_RDONLY); err != nil {
+			// Mount points that cannot be reached cannot be written either
+			if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.EACCES) {
+				continue
+			}
+			return fmt.Errorf("failed to make %s read-only: %w", mountPoint, err)
+		}
+	}
+
+	writable := []string{spec.Dir}
+	for _, path := range spec.Config.WritablePaths {
+		if abs, err := filepath.Abs(path); err == nil {
+			writable = append(writable, abs)
+		}
+	}
+
+	// Give the sandbox its own /tmp unless it would hide a writable path
+	if !anyWithin(writable, "/tmp") {
+		if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+			return fmt.Errorf("failed to mount /tmp: %w", err)
+		}
+	}
+	unix.Mount("tmpfs", "/dev/shm", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+
+	for _, path := range writable {
+		if _, err := os.Stat(path); err != nil {
+			if path == spec.Dir {
+				return fmt.Errorf("working directory is not available: %w", err)
+			}
+			continue
+		}
+		if err := unix.Mount(path, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("failed to bind %s: %w", path, err)
+		}
+		if err := remount(path, 0); err != nil {
+			return fmt.Errorf("failed to make %s writable: %w", path, err)
+		}
+	}
+
+	// Show only the sandbox's processes; some hosts do not allow a new /proc
+	unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	return nil
+}
+
+// lockedMountFlags are the flags a remount inside a user namespace must keep
+const lockedMountFlags = unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME
+
+// remount changes the read-only flag of a mount, keeping its locked flags
+func remount(target string, flags uintptr) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(target, &stat); err != nil {
+		return err
+	}
+
+	locked := uintptr(stat.Flags) & lockedMountFlags
+	return unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|locked|flags, "")
+}
+
+// readMountPoints returns the mount points of the current namespace
+func readMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer file.Close()
+
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoints = append(mountPoints, unescapeMountPoint(fields[4]))
+	}
+
+	return mountPoints, scanner.Err()
+}
+
+// unescapeMountPoint decodes the octal escapes of a mountinfo path
+func unescapeMountPoint(path string) string {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if value, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(path[i])
+	}
+	return sb.String()
+}
+
+// anyWithin reports whether any of the paths is dir or inside it
+func anyWithin(paths []string, dir string) bool {
+	for _, path := range paths {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// setSandboxLimits sets the resource limits inherited by the command
+func setSandboxLimits(config SandboxConfig) error {
+	limits := []struct {
+		resource int
+		value    uint64
+		name     string
+	}{
+		{unix.RLIMIT_AS, config.MemoryLimit, "memory"},
+		{unix.RLIMIT_CPU, uint64((config.CPULimit + time.Second - 1) / time.Second), "CPU"},
+		{unix.RLIMIT_NPROC, config.MaxProcesses, "process"},
+	}
+
+	for _, limit := range limits {
+		if limit.value == 0 {
+			continue
+		}
+		rlimit := unix.Rlimit{Cur: limit.value, Max: limit.value}
+		if err := unix.Setrlimit(limit.resource, &rlimit); err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", limit.name, err)
+		}
+	}
+
+	return nil
+}
+
+// dropCapabilities makes sure that the command cannot regain the
+// capabilities the sandbox was set up with, such as to undo its mounts
+func dropCapabilities() error {
+	for capability := 0; capability <= unix.CAP_LAST_CAP; capability++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0); err != nil && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("failed to drop capabilities: %w", err)
+		}
+	}
+
+	// SECBIT_NOROOT, SECBIT_NO_SETUID_FIXUP and their locks, so that running
+	// as root in the namespace grants nothing
+	securebits := 0x0f
+	if err := unix.Prctl(unix.PR_SET_SECUREBITS, uintptr(securebits), 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set securebits: %w", err)
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+
+	return nil
+}
+
+// exitCode returns the exit code of a process, or 128 plus the signal that
+// killed it
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// withoutSandboxEnv returns the environment without the sandbox spec
+func withoutSandboxEnv(env []string) []string {
+	filtered := make([]string, 0, len(env))
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, sandboxEnv+"=") {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,33 @@
+//go:build !linux
+
+package executor
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// SandboxBackend runs processes in a Linux namespace sandbox. It is not
+// available on this platform.
+type SandboxBackend struct {
+	Config SandboxConfig
+}
+
+// NewSandboxBackend creates a sandbox backend
+func NewSandboxBackend(config SandboxConfig) *SandboxBackend {
+	return &SandboxBackend{
+		Config: config,
+	}
+}
+
+// Name returns the name of the backend
+func (b *SandboxBackend) Name() string {
+	return BackendSandbox
+}
+
+// Command fails, since the sandbox needs Linux namespaces
+func (b *SandboxBackend) Command(ctx context.Context, spec ProcessSpec) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("the sandbox backend is not supported on %s", runtime.GOOS)
+}
@@ -5,7 +5,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,15 +27,58 @@ type Session struct {
 	Mutex      sync.Mutex
 	IsRunning  bool
 	StartTime  time.Time
+	// PTY is the pseudo-terminal of a terminal session, which is both its
+	// Stdin and Stdout
+	PTY *os.File
+
+	// readMutex serialises Send and Read, which track the running command
+	readMutex  sync.Mutex
+	marker     string
+	outputDone bool
+	errorDone  bool
+	exitCode   int
+	// lastUsed and running, which marks a command in progress, are guarded
+	// by Mutex, so that the pool can tell idle sessions without waiting for
+	// a read
+	lastUsed   time.Time
+	running    bool
+	ptySlave   *os.File
+	// prompt starts the prompt of a terminal session, which reports the ID
+	// and exit code of each command
+	prompt    string
+	commandID int
+}
+
+// SessionResult is the output of a command run in a session
+type SessionResult struct {
+	Output   []string `json:"output"`
+	Errors   []string `json:"errors"`
+	ExitCode int      `json:"exit_code"`
+	// Complete reports whether the command has finished. If not, Read
+	// returns the rest of its output.
+	Complete bool `json:"complete"`
+	// Closed reports that the shell has exited
+	Closed bool `json:"closed"`
 }
 
 // NewSession creates a new interactive session
 func NewSession(command string, args []string, dir string) (*Session, error) {
+	return NewSessionOnBackend(nil, command, args, dir)
+}
+
+// NewSessionOnBackend creates a new interactive session on the given backend,
+// or the default one if it is nil
+func NewSessionOnBackend(backend Backend, command string, args []string, dir string) (*Session, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	
-	cmd := exec.CommandContext(ctx, command, args...)
-	if dir != "" {
-		cmd.Dir = dir
+	cmd, err := backendOrDefault(backend).Command(ctx, ProcessSpec{
+		Path: command,
+		Args: args,
+		Dir:  dir,
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create command: %w", err)
 	}
 	
 	stdin, err := cmd.StdinPipe()
@@ -70,6 +115,77 @@ func NewSession(command string, args []string, dir string) (*Session, error) {
 	return session, nil
 }
 
+// NewTerminalSessionOnBackend creates a new interactive session that runs in
+// a pseudo-terminal of the given size, on the given backend or the default one
+// if it is nil. Output is stripped of escape sequences and errors are mixed
+// into it. The terminal does not echo input.
+func NewTerminalSessionOnBackend(backend Backend, command string, args []string, dir string, rows, cols uint16) (*Session, error) {
+	if rows == 0 {
+		rows = DefaultTerminalRows
+	}
+	if cols == 0 {
+		cols = DefaultTerminalCols
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The shell is interactive, so rather than a marker after each command,
+	// which an interrupted command would skip, its prompt reports the ID of
+	// the command it finished and its exit code
+	prompt := fmt.Sprintf("__COMMANDFORGE_PROMPT_%d", time.Now().UnixNano())
+	env := append(os.Environ(), "PS1="+prompt+"_${__commandforge_id}:$?\n", "PS2=", "PROMPT_COMMAND=")
+	cmd, err := backendOrDefault(backend).Command(ctx, ProcessSpec{
+		Path: command,
+		Args: args,
+		Dir:  dir,
+		Env:  terminalEnv(env),
+	})
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create command: %w", err)
+	}
+
+	master, slave, err := openPTY()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := setTerminalSize(master, rows, cols); err != nil {
+		cancel()
+		master.Close()
+		slave.Close()
+		return nil, fmt.Errorf("failed to set terminal size: %w", err)
+	}
+	if err := disableEcho(slave); err != nil {
+		cancel()
+		master.Close()
+		slave.Close()
+		return nil, fmt.Errorf("failed to disable terminal echo: %w", err)
+	}
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	setControllingTerminal(cmd)
+
+	session := &Session{
+		Cmd:        cmd,
+		Stdin:      master,
+		Stdout:     master,
+		Ctx:        ctx,
+		Cancel:     cancel,
+		OutputChan: make(chan string, 100),
+		ErrorChan:  make(chan string, 100),
+		DoneChan:   make(chan struct{}),
+		IsRunning:  false,
+		PTY:        master,
+		ptySlave:   slave,
+		prompt:     prompt,
+	}
+
+	return session, nil
+}
+
 // NewShellSession creates a new interactive shell session
 func NewShellSession(dir string) (*Session, error) {
 	return NewSession("sh", nil, dir)
@@ -85,20 +201,34 @@ func (s *Session) Start() error {
 	}
 	
 	// Start the command
-	if err := s.Cmd.Start(); err != nil {
+	err := s.Cmd.Start()
+	if s.ptySlave != nil {
+		// The shell holds its own copy of the terminal
+		s.ptySlave.Close()
+		s.ptySlave = nil
+	}
+	if err != nil {
 		return fmt.Errorf("failed to start command: %w", err)
 	}
 	
 	s.IsRunning = true
 	s.StartTime = time.Now()
+	s.lastUsed = s.StartTime
 	
 	// Start goroutines to read from stdout and stderr
 	go s.readOutput()
-	go s.readError()
+	if s.Stderr != nil {
+		go s.readError()
+	}
 	
 	// Start goroutine to wait for command completion
 	go func() {
 		s.Cmd.Wait()
+		if s.PTY != nil {
+			// Give the reader a moment to take the last output
+			time.Sleep(100 * time.Millisecond)
+			s.PTY.Close()
+		}
 		s.Mutex.Lock()
 		s.IsRunning = false
 		s.Mutex.Unlock()
@@ -112,10 +242,14 @@ func (s *Session) Start() error {
 func (s *Session) readOutput() {
 	scanner := bufio.NewScanner(s.Stdout)
 	for scanner.Scan() {
+		line := scanner.Text()
+		if s.PTY != nil {
+			line = StripANSI(line)
+		}
 		select {
 		case <-s.Ctx.Done():
 			return
-		case s.OutputChan <- scanner.Text():
+		case s.OutputChan <- line:
 			// Line sent to channel
 		}
 	}
@@ -186,6 +320,222 @@ func (s *Session) GetOutput(timeout time.Duration) ([]string, []string, error) {
 	}
 }
 
+// Run sends a shell command and waits up to the timeout for it to finish
+func (s *Session) Run(command string, timeout time.Duration) (*SessionResult, error) {
+	if err := s.Send(command); err != nil {
+		return nil, err
+	}
+
+	return s.Read(timeout)
+}
+
+// Send sends a shell command followed by markers that report its exit code
+// on stdout and the end of its output on stderr. In a terminal session the
+// prompt reports the exit code instead. Only one command can run at a time.
+func (s *Session) Send(command string) error {
+	s.readMutex.Lock()
+	defer s.readMutex.Unlock()
+
+	if s.marker != "" {
+		return fmt.Errorf("the previous command is still running; read its output first")
+	}
+
+	var marker, script string
+	if s.PTY != nil {
+		// The group makes the shell read the whole command before running it,
+		// so that the rest of it is not taken as input by the first line
+		s.commandID++
+		marker = fmt.Sprintf("%s_%d", s.prompt, s.commandID)
+		script = fmt.Sprintf("__commandforge_id=%d; {\n%s\n}\n", s.commandID, strings.TrimRight(command, "\n"))
+	} else {
+		marker = fmt.Sprintf("__COMMANDFORGE_DONE_%d__", time.Now().UnixNano())
+		script = fmt.Sprintf("%s\n__commandforge_status=$?; printf '%%s:%%s\\n' '%s' \"$__commandforge_status\"; printf '%%s\\n' '%s' >&2\n",
+			strings.TrimRight(command, "\n"), marker, marker)
+	}
+	if err := s.SendCommand(script); err != nil {
+		return err
+	}
+
+	s.marker = marker
+	s.outputDone = false
+	s.errorDone = s.PTY != nil
+	s.setRunning(true)
+
+	return nil
+}
+
+// Read collects the output of the running command until it finishes or the
+// timeout passes. With no command running, it returns any pending output.
+func (s *Session) Read(timeout time.Duration) (*SessionResult, error) {
+	s.readMutex.Lock()
+	defer s.readMutex.Unlock()
+	defer s.touch()
+
+	result := &SessionResult{
+		Output: []string{},
+		Errors: []string{},
+	}
+
+	if s.marker == "" {
+		result.Output, result.Errors = s.drain(result.Output, result.Errors)
+		result.ExitCode = s.exitCode
+		result.Complete = true
+		result.Closed = !s.IsActive()
+		return result, nil
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for !s.outputDone || !s.errorDone {
+		select {
+		case line := <-s.OutputChan:
+			if i := strings.Index(line, s.marker+":"); i >= 0 {
+				if i > 0 {
+					result.Output = append(result.Output, line[:i])
+				}
+				s.exitCode, _ = strconv.Atoi(strings.TrimSpace(line[i+len(s.marker)+1:]))
+				s.outputDone = true
+				continue
+			}
+			if stripped, isPrompt := s.stripPrompt(line); isPrompt {
+				if stripped != "" {
+					result.Output = append(result.Output, stripped)
+				}
+				continue
+			}
+			result.Output = append(result.Output, line)
+		case line := <-s.ErrorChan:
+			if i := strings.Index(line, s.marker); i >= 0 {
+				if i > 0 {
+					result.Errors = append(result.Errors, line[:i])
+				}
+				s.errorDone = true
+				continue
+			}
+			result.Errors = append(result.Errors, line)
+		case <-s.DoneChan:
+			// The shell exited, for example after `exit`
+			result.Output, result.Errors = s.drain(result.Output, result.Errors)
+			s.marker = ""
+			s.setRunning(false)
+			if s.Cmd.ProcessState != nil {
+				s.exitCode = s.Cmd.ProcessState.ExitCode()
+			}
+			result.ExitCode = s.exitCode
+			result.Complete = true
+			result.Closed = true
+			return result, nil
+		case <-deadline.C:
+			return result, nil
+		}
+	}
+
+	s.marker = ""
+	s.setRunning(false)
+	result.ExitCode = s.exitCode
+	result.Complete = true
+
+	return result, nil
+}
+
+// drain appends the output that is already waiting
+func (s *Session) drain(output, errors []string) ([]string, []string) {
+	for {
+		select {
+		case line := <-s.OutputChan:
+			if stripped, isPrompt := s.stripPrompt(line); isPrompt {
+				if stripped != "" {
+					output = append(output, stripped)
+				}
+				continue
+			}
+			output = append(output, line)
+		case line := <-s.ErrorChan:
+			errors = append(errors, line)
+		default:
+			return output, errors
+		}
+	}
+}
+
+// SendKeys writes keystrokes to the program running in a terminal session,
+// for example to answer a prompt. See ParseKeys for how special keys are
+// written.
+func (s *Session) SendKeys(keys string) error {
+	if s.PTY == nil {
+		return fmt.Errorf("session is not running in a terminal")
+	}
+	if !s.IsActive() {
+		return fmt.Errorf("session is not running")
+	}
+
+	if _, err := s.PTY.Write(ParseKeys(keys)); err != nil {
+		return fmt.Errorf("failed to send keys: %w", err)
+	}
+	s.touch()
+
+	return nil
+}
+
+// Resize changes the terminal size of a terminal session
+func (s *Session) Resize(rows, cols uint16) error {
+	if s.PTY == nil {
+		return fmt.Errorf("session is not running in a terminal")
+	}
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("terminal size must be positive")
+	}
+
+	if err := setTerminalSize(s.PTY, rows, cols); err != nil {
+		return fmt.Errorf("failed to set terminal size: %w", err)
+	}
+
+	return nil
+}
+
+// stripPrompt removes the prompt of a terminal session from an output line,
+// for example after keystrokes sent to the shell itself
+func (s *Session) stripPrompt(line string) (string, bool) {
+	if s.prompt == "" {
+		return line, false
+	}
+
+	i := strings.Index(line, s.prompt)
+	if i < 0 {
+		return line, false
+	}
+
+	return line[:i], true
+}
+
+// touch records that the session has been used
+func (s *Session) touch() {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.lastUsed = time.Now()
+}
+
+// setRunning records whether a command is in progress, and that the session
+// has been used
+func (s *Session) setRunning(running bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.running = running
+	s.lastUsed = time.Now()
+}
+
+// IdleFor returns how long the session has not been used. A session with a
+// command in progress is not idle, however long the command runs.
+func (s *Session) IdleFor() time.Duration {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if s.running {
+		return 0
+	}
+	return time.Since(s.lastUsed)
+}
+
 // Stop terminates the session
 func (s *Session) Stop() error {
 	s.Mutex.Lock()
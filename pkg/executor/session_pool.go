@@ -0,0 +1,205 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SessionPool holds shell sessions by ID and closes the ones that have been
+// idle for too long
+type SessionPool struct {
+	Backend     Backend
+	WorkingDir  string
+	Shell       string
+	IdleTimeout time.Duration
+
+	mutex    sync.Mutex
+	sessions map[string]*Session
+	reaping  bool
+}
+
+// NewSessionPool creates a new session pool. Sessions start in the working
+// directory and use bash if it is installed, or sh.
+func NewSessionPool(workingDir string) *SessionPool {
+	return &SessionPool{
+		WorkingDir:  workingDir,
+		IdleTimeout: 10 * time.Minute,
+		sessions:    make(map[string]*Session),
+	}
+}
+
+// WithBackend sets the backend that sessions run on
+func (p *SessionPool) WithBackend(backend Backend) *SessionPool {
+	p.Backend = backend
+	return p
+}
+
+// WithShell sets the shell that sessions run
+func (p *SessionPool) WithShell(shell string) *SessionPool {
+	p.Shell = shell
+	return p
+}
+
+// WithIdleTimeout sets how long a session may be unused before it is closed
+func (p *SessionPool) WithIdleTimeout(timeout time.Duration) *SessionPool {
+	p.IdleTimeout = timeout
+	return p
+}
+
+// Open starts a new session in the given directory, or the pool's working
+// directory if it is empty, and returns its ID
+func (p *SessionPool) Open(dir string) (string, error) {
+	return p.open(dir, false, 0, 0)
+}
+
+// OpenTerminal starts a new session in a pseudo-terminal of the given size,
+// for programs that need a terminal to prompt for input or draw their output
+func (p *SessionPool) OpenTerminal(dir string, rows, cols uint16) (string, error) {
+	return p.open(dir, true, rows, cols)
+}
+
+// open starts a new session and adds it to the pool
+func (p *SessionPool) open(dir string, terminal bool, rows, cols uint16) (string, error) {
+	if dir == "" {
+		dir = p.WorkingDir
+	}
+
+	shell := p.Shell
+	if shell == "" {
+		shell = "sh"
+		if _, err := exec.LookPath("bash"); err == nil {
+			shell = "bash"
+		}
+	}
+
+	var session *Session
+	var err error
+	if terminal {
+		// A shell on a terminal is interactive, so keep it from reading
+		// startup files and line editing the commands it is sent
+		var args []string
+		if filepath.Base(shell) == "bash" {
+			args = []string{"--norc", "--noprofile", "--noediting"}
+		}
+		session, err = NewTerminalSessionOnBackend(p.Backend, shell, args, dir, rows, cols)
+	} else {
+		session, err = NewSessionOnBackend(p.Backend, shell, nil, dir)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	if err := session.Start(); err != nil {
+		return "", fmt.Errorf("failed to start session: %w", err)
+	}
+
+	id := fmt.Sprintf("session-%d", time.Now().UnixNano())
+
+	p.mutex.Lock()
+	p.sessions[id] = session
+	if !p.reaping && p.IdleTimeout > 0 {
+		p.reaping = true
+		go p.reap()
+	}
+	p.mutex.Unlock()
+
+	return id, nil
+}
+
+// Get returns the session with the given ID
+func (p *SessionPool) Get(id string) (*Session, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	session, exists := p.sessions[id]
+	if !exists {
+		return nil, fmt.Errorf("session not found: %s", id)
+	}
+
+	return session, nil
+}
+
+// List returns the IDs of the open sessions
+func (p *SessionPool) List() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ids := make([]string, 0, len(p.sessions))
+	for id := range p.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// Close stops a session and removes it from the pool
+func (p *SessionPool) Close(id string) error {
+	p.mutex.Lock()
+	session, exists := p.sessions[id]
+	delete(p.sessions, id)
+	p.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("session not found: %s", id)
+	}
+
+	return session.Stop()
+}
+
+// CloseAll stops every session in the pool
+func (p *SessionPool) CloseAll() error {
+	p.mutex.Lock()
+	sessions := p.sessions
+	p.sessions = make(map[string]*Session)
+	p.mutex.Unlock()
+
+	for _, session := range sessions {
+		session.Stop()
+	}
+
+	return nil
+}
+
+// reap closes idle and exited sessions until the pool is empty
+func (p *SessionPool) reap() {
+	interval := p.IdleTimeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Stopping a session waits for its lock, which a command in
+		// progress may hold, so expired sessions are stopped after the
+		// pool is unlocked
+		var expired []*Session
+		p.mutex.Lock()
+		for id, session := range p.sessions {
+			if !session.IsActive() || session.IdleFor() > p.IdleTimeout {
+				expired = append(expired, session)
+				delete(p.sessions, id)
+			}
+		}
+		empty := len(p.sessions) == 0
+		if empty {
+			p.reaping = false
+		}
+		p.mutex.Unlock()
+
+		for _, session := range expired {
+			session.Stop()
+		}
+		if empty {
+			return
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// signals are the signals that can be sent to background commands by name
+var signals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGKILL": syscall.SIGKILL,
+}
+
+// ParseSignal parses the name of a signal that can be sent to a background
+// command: SIGINT, SIGTERM or SIGKILL, with or without the SIG prefix and in
+// any case
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal: %s (use SIGINT, SIGTERM or SIGKILL)", name)
+	}
+
+	return sig, nil
+}
+
+// SignalName returns the name of a signal, such as SIGTERM
+func SignalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("signal %d", int(sig))
+}
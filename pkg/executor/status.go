@@ -12,6 +12,12 @@ type BackgroundCommandStatus struct {
 	OutputList []string `json:"output_list"`
 	ErrorList  []string `json:"error_list"`
 	Duration   float64  `json:"duration"`
+	// State is running, succeeded, failed or interrupted
+	State CommandState `json:"state,omitempty"`
+	// Usage is the memory, CPU time and output the command has used so far
+	Usage ResourceUsage `json:"usage"`
+	// Screen is the terminal screen of a command running in a pseudo-terminal
+	Screen []string `json:"screen,omitempty"`
 }
 
 // NewBackgroundCommandStatus creates a new background command status
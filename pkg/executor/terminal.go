@@ -0,0 +1,573 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Default size of pseudo-terminals
+const (
+	DefaultTerminalRows uint16 = 24
+	DefaultTerminalCols uint16 = 80
+)
+
+// DefaultMaxScrollback is the number of lines a terminal keeps after they
+// scroll off the screen
+const DefaultMaxScrollback = 5000
+
+// parser states of the terminal
+const (
+	stateGround = iota
+	stateEscape
+	stateCSI
+	stateOSC
+	stateOSCEscape
+	stateCharset
+)
+
+// Terminal renders the output of a program running in a pseudo-terminal into
+// plain text. It understands the cursor movement, erase and scrolling
+// sequences that progress bars and full-screen programs use, and drops
+// colours and other attributes.
+type Terminal struct {
+	// MaxScrollback limits the lines kept after scrolling off the screen
+	MaxScrollback int
+	// OnScroll, if set, is called with each line that scrolls off the main
+	// screen instead of keeping it in the scrollback
+	OnScroll func(line string)
+
+	mu         sync.Mutex
+	rows, cols int
+	screen     [][]rune
+	row, col   int
+	savedRow   int
+	savedCol   int
+	scrollback []string
+	// main holds the main screen while the alternate screen is shown
+	main    [][]rune
+	mainRow int
+	mainCol int
+
+	state   int
+	params  []byte
+	pending []byte
+}
+
+// NewTerminal creates a terminal with the given size
+func NewTerminal(rows, cols uint16) *Terminal {
+	if rows == 0 {
+		rows = DefaultTerminalRows
+	}
+	if cols == 0 {
+		cols = DefaultTerminalCols
+	}
+
+	t := &Terminal{
+		MaxScrollback: DefaultMaxScrollback,
+		rows:          int(rows),
+		cols:          int(cols),
+	}
+	t.screen = t.blankScreen()
+	return t
+}
+
+// Write feeds program output to the terminal
+func (t *Terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	data := p
+	if len(t.pending) > 0 {
+		data = append(t.pending, p...)
+		t.pending = nil
+	}
+
+	for len(data) > 0 {
+		if !utf8.FullRune(data) {
+			t.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		t.handle(r)
+	}
+
+	return len(p), nil
+}
+
+// Resize changes the size of the terminal, keeping the top left of the screen
+func (t *Terminal) Resize(rows, cols uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rows == 0 || cols == 0 {
+		return
+	}
+
+	old := t.screen
+	t.rows, t.cols = int(rows), int(cols)
+	t.screen = t.blankScreen()
+	for i := 0; i < len(old) && i < t.rows; i++ {
+		copy(t.screen[i], old[i])
+	}
+	t.row = min(t.row, t.rows-1)
+	t.col = min(t.col, t.cols-1)
+}
+
+// Lines returns the scrollback followed by the screen, without trailing
+// blank lines
+func (t *Terminal) Lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := make([]string, 0, len(t.scrollback)+t.rows)
+	lines = append(lines, t.scrollback...)
+	for _, line := range t.screen {
+		lines = append(lines, renderLine(line))
+	}
+
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+
+	return lines[:end]
+}
+
+// Screen returns the lines currently on the screen
+func (t *Terminal) Screen() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := make([]string, len(t.screen))
+	for i, line := range t.screen {
+		lines[i] = renderLine(line)
+	}
+
+	return lines
+}
+
+// String returns the rendered text of the terminal
+func (t *Terminal) String() string {
+	return strings.Join(t.Lines(), "\n")
+}
+
+// handle processes one character of output
+func (t *Terminal) handle(r rune) {
+	switch t.state {
+	case stateEscape:
+		t.state = stateGround
+		switch r {
+		case '[':
+			t.state = stateCSI
+			t.params = t.params[:0]
+		case ']':
+			t.state = stateOSC
+		case '(', ')', '*', '+':
+			t.state = stateCharset
+		case '7':
+			t.savedRow, t.savedCol = t.row, t.col
+		case '8':
+			t.row, t.col = t.savedRow, t.savedCol
+		case 'D':
+			t.lineFeed()
+		case 'E':
+			t.col = 0
+			t.lineFeed()
+		case 'M':
+			if t.row == 0 {
+				t.scrollDown(1)
+			} else {
+				t.row--
+			}
+		case 'c':
+			t.screen = t.blankScreen()
+			t.row, t.col = 0, 0
+		}
+		return
+	case stateCSI:
+		if r >= 0x40 && r <= 0x7e {
+			t.state = stateGround
+			t.csi(r)
+			return
+		}
+		t.params = append(t.params, byte(r))
+		return
+	case stateOSC:
+		// Operating system commands, such as window titles, end with BEL or ST
+		switch r {
+		case 0x07:
+			t.state = stateGround
+		case 0x1b:
+			t.state = stateOSCEscape
+		}
+		return
+	case stateOSCEscape:
+		t.state = stateGround
+		if r != '\\' {
+			t.state = stateOSC
+		}
+		return
+	case stateCharset:
+		t.state = stateGround
+		return
+	}
+
+	switch r {
+	case 0x1b:
+		t.state = stateEscape
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\r':
+		t.col = 0
+	case '\b':
+		if t.col > 0 {
+			t.col--
+		}
+	case '\t':
+		t.col = min((t.col/8+1)*8, t.cols-1)
+	default:
+		if r < 0x20 || r == 0x7f {
+			return
+		}
+		t.put(r)
+	}
+}
+
+// csi processes a control sequence with the given final character
+func (t *Terminal) csi(final rune) {
+	private := len(t.params) > 0 && t.params[0] == '?'
+	params := t.params
+	if private {
+		params = params[1:]
+	}
+
+	var args []int
+	for _, field := range strings.Split(string(params), ";") {
+		n, _ := strconv.Atoi(field)
+		args = append(args, n)
+	}
+	// arg returns the i-th argument, or def if it is missing or zero
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'A':
+		t.row = max(t.row-arg(0, 1), 0)
+	case 'B', 'e':
+		t.row = min(t.row+arg(0, 1), t.rows-1)
+	case 'C', 'a':
+		t.col = min(t.col+arg(0, 1), t.cols-1)
+	case 'D':
+		t.col = max(t.col-arg(0, 1), 0)
+	case 'E':
+		t.row = min(t.row+arg(0, 1), t.rows-1)
+		t.col = 0
+	case 'F':
+		t.row = max(t.row-arg(0, 1), 0)
+		t.col = 0
+	case 'G', '`':
+		t.col = min(arg(0, 1)-1, t.cols-1)
+	case 'd':
+		t.row = min(arg(0, 1)-1, t.rows-1)
+	case 'H', 'f':
+		t.row = min(arg(0, 1)-1, t.rows-1)
+		t.col = min(arg(1, 1)-1, t.cols-1)
+	case 'J':
+		switch arg(0, 0) {
+		case 0:
+			t.clear(t.row, t.col, t.rows-1, t.cols)
+		case 1:
+			t.clear(0, 0, t.row, t.col+1)
+		default:
+			t.clear(0, 0, t.rows-1, t.cols)
+		}
+	case 'K':
+		switch arg(0, 0) {
+		case 0:
+			t.clear(t.row, t.col, t.row, t.cols)
+		case 1:
+			t.clear(t.row, 0, t.row, t.col+1)
+		default:
+			t.clear(t.row, 0, t.row, t.cols)
+		}
+	case 'X':
+		t.clear(t.row, t.col, t.row, min(t.col+arg(0, 1), t.cols))
+	case 'P':
+		line := t.screen[t.row]
+		n := min(arg(0, 1), t.cols-t.col)
+		copy(line[t.col:], line[t.col+n:])
+		for i := t.cols - n; i < t.cols; i++ {
+			line[i] = 0
+		}
+	case '@':
+		line := t.screen[t.row]
+		n := min(arg(0, 1), t.cols-t.col)
+		copy(line[t.col+n:], line[t.col:])
+		for i := t.col; i < t.col+n; i++ {
+			line[i] = 0
+		}
+	case 'L':
+		n := min(arg(0, 1), t.rows-t.row)
+		copy(t.screen[t.row+n:], t.screen[t.row:t.rows-n])
+		for i := t.row; i < t.row+n; i++ {
+			t.screen[i] = make([]rune, t.cols)
+		}
+	case 'M':
+		n := min(arg(0, 1), t.rows-t.row)
+		copy(t.screen[t.row:], t.screen[t.row+n:])
+		for i := t.rows - n; i < t.rows; i++ {
+			t.screen[i] = make([]rune, t.cols)
+		}
+	case 'S':
+		t.scrollUp(arg(0, 1))
+	case 'T':
+		t.scrollDown(arg(0, 1))
+	case 's':
+		t.savedRow, t.savedCol = t.row, t.col
+	case 'u':
+		t.row, t.col = t.savedRow, t.savedCol
+	case 'h', 'l':
+		if private {
+			for _, mode := range args {
+				if mode == 47 || mode == 1047 || mode == 1049 {
+					t.alternateScreen(final == 'h')
+				}
+			}
+		}
+	}
+}
+
+// put writes a character at the cursor, wrapping at the end of the line
+func (t *Terminal) put(r rune) {
+	if t.col >= t.cols {
+		t.col = 0
+		t.lineFeed()
+	}
+	t.screen[t.row][t.col] = r
+	t.col++
+}
+
+// lineFeed moves the cursor down, scrolling at the bottom of the screen
+func (t *Terminal) lineFeed() {
+	if t.row == t.rows-1 {
+		t.scrollUp(1)
+		return
+	}
+	t.row++
+}
+
+// scrollUp scrolls the screen up, moving the top lines into the scrollback
+// unless the alternate screen is shown
+func (t *Terminal) scrollUp(n int) {
+	n = min(n, t.rows)
+	if t.main == nil {
+		for _, line := range t.screen[:n] {
+			if t.OnScroll != nil {
+				t.OnScroll(renderLine(line))
+				continue
+			}
+			t.scrollback = append(t.scrollback, renderLine(line))
+		}
+		if t.MaxScrollback > 0 && len(t.scrollback) > t.MaxScrollback {
+			t.scrollback = append([]string(nil), t.scrollback[len(t.scrollback)-t.MaxScrollback:]...)
+		}
+	}
+	copy(t.screen, t.screen[n:])
+	for i := t.rows - n; i < t.rows; i++ {
+		t.screen[i] = make([]rune, t.cols)
+	}
+}
+
+// scrollDown scrolls the screen down, inserting blank lines at the top
+func (t *Terminal) scrollDown(n int) {
+	n = min(n, t.rows)
+	copy(t.screen[n:], t.screen[:t.rows-n])
+	for i := 0; i < n; i++ {
+		t.screen[i] = make([]rune, t.cols)
+	}
+}
+
+// clear blanks the screen from (row1, col1) up to (row2, col2) exclusive
+func (t *Terminal) clear(row1, col1, row2, col2 int) {
+	for row := row1; row <= row2 && row < t.rows; row++ {
+		start, end := 0, t.cols
+		if row == row1 {
+			start = col1
+		}
+		if row == row2 {
+			end = col2
+		}
+		for col := max(start, 0); col < end && col < t.cols; col++ {
+			t.screen[row][col] = 0
+		}
+	}
+}
+
+// alternateScreen switches to or from the alternate screen that full-screen
+// programs draw on
+func (t *Terminal) alternateScreen(enter bool) {
+	if enter && t.main == nil {
+		t.main, t.mainRow, t.mainCol = t.screen, t.row, t.col
+		t.screen = t.blankScreen()
+		t.row, t.col = 0, 0
+	} else if !enter && t.main != nil {
+		t.screen, t.row, t.col = t.main, t.mainRow, t.mainCol
+		t.main = nil
+	}
+}
+
+// blankScreen returns an empty screen of the terminal's size
+func (t *Terminal) blankScreen() [][]rune {
+	screen := make([][]rune, t.rows)
+	for i := range screen {
+		screen[i] = make([]rune, t.cols)
+	}
+	return screen
+}
+
+// renderLine converts a screen line to text without trailing spaces
+func renderLine(line []rune) string {
+	var b strings.Builder
+	for _, r := range line {
+		if r == 0 {
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// StripANSI removes escape sequences from a line of terminal output and
+// applies carriage returns and backspaces, so that a progress bar redrawn in
+// place shows only its final state
+func StripANSI(line string) string {
+	var text []rune
+	col := 0
+	state := stateGround
+
+	for _, r := range line {
+		switch state {
+		case stateEscape:
+			switch r {
+			case '[':
+				state = stateCSI
+			case ']':
+				state = stateOSC
+			case '(', ')', '*', '+':
+				state = stateCharset
+			default:
+				state = stateGround
+			}
+			continue
+		case stateCSI:
+			if r >= 0x40 && r <= 0x7e {
+				state = stateGround
+			}
+			continue
+		case stateOSC:
+			switch r {
+			case 0x07:
+				state = stateGround
+			case 0x1b:
+				state = stateOSCEscape
+			}
+			continue
+		case stateOSCEscape:
+			state = stateGround
+			if r != '\\' {
+				state = stateOSC
+			}
+			continue
+		case stateCharset:
+			state = stateGround
+			continue
+		}
+
+		switch {
+		case r == 0x1b:
+			state = stateEscape
+		case r == '\r':
+			col = 0
+		case r == '\b':
+			if col > 0 {
+				col--
+			}
+		case r < 0x20 && r != '\t', r == 0x7f:
+			// Drop other control characters
+		case col < len(text):
+			text[col] = r
+			col++
+		default:
+			text = append(text, r)
+			col++
+		}
+	}
+
+	return string(text)
+}
+
+// keyNames maps the names accepted by ParseKeys to the bytes a terminal
+// sends for them
+var keyNames = map[string]string{
+	"enter":     "\r",
+	"return":    "\r",
+	"tab":       "\t",
+	"esc":       "\x1b",
+	"escape":    "\x1b",
+	"space":     " ",
+	"backspace": "\x7f",
+	"delete":    "\x1b[3~",
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"pageup":    "\x1b[5~",
+	"pagedown":  "\x1b[6~",
+	"lt":        "<",
+}
+
+// ParseKeys converts a keystroke description into the bytes to write to a
+// terminal. Text is sent as is, and special keys are written in angle
+// brackets, for example "q", "yes<Enter>", "<Up><Up><Enter>" or "<C-c>".
+// Unknown names are sent literally.
+func ParseKeys(keys string) []byte {
+	var out []byte
+
+	for len(keys) > 0 {
+		start := strings.IndexByte(keys, '<')
+		if start < 0 {
+			out = append(out, keys...)
+			break
+		}
+		out = append(out, keys[:start]...)
+		keys = keys[start:]
+
+		end := strings.IndexByte(keys, '>')
+		if end < 0 {
+			out = append(out, keys...)
+			break
+		}
+
+		name := strings.ToLower(keys[1:end])
+		if sequence, ok := keyNames[name]; ok {
+			out = append(out, sequence...)
+		} else if len(name) == 3 && strings.HasPrefix(name, "c-") && name[2] >= '@' && name[2] <= 'z' {
+			// Control keys clear the upper bits of the character
+			out = append(out, name[2]&0x1f)
+		} else {
+			out = append(out, keys[:end+1]...)
+		}
+		keys = keys[end+1:]
+	}
+
+	return out
+}
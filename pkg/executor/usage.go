@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// usageSampleInterval is how often the resource usage of a running command
+// is sampled
+const usageSampleInterval = 500 * time.Millisecond
+
+// ResourceUsage is the resources a command used, together with the
+// processes it started
+type ResourceUsage struct {
+	// PeakRSSBytes is the highest resident memory seen
+	PeakRSSBytes     int64   `json:"peak_rss_bytes"`
+	UserCPUSeconds   float64 `json:"user_cpu_seconds"`
+	SystemCPUSeconds float64 `json:"system_cpu_seconds"`
+	// OutputBytes is the size of the output, counting line endings
+	OutputBytes int64 `json:"output_bytes"`
+}
+
+// usageMonitor samples the resource usage of a process tree while it runs.
+// Where sampling is not supported, the usage is only known once the process
+// has exited.
+type usageMonitor struct {
+	pid   int
+	mu    sync.Mutex
+	usage ResourceUsage
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// monitorUsage starts sampling the resource usage of a process and the
+// processes it started
+func monitorUsage(pid int) *usageMonitor {
+	m := &usageMonitor{
+		pid:  pid,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go m.run()
+
+	return m
+}
+
+// run samples the usage until the monitor is finished
+func (m *usageMonitor) run() {
+	defer close(m.done)
+
+	ticker := time.NewTicker(usageSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+// sample adds the current usage of the process tree
+func (m *usageMonitor) sample() {
+	sample, ok := sampleProcessTree(m.pid)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.PeakRSSBytes = max(m.usage.PeakRSSBytes, sample.PeakRSSBytes)
+	m.usage.UserCPUSeconds = max(m.usage.UserCPUSeconds, sample.UserCPUSeconds)
+	m.usage.SystemCPUSeconds = max(m.usage.SystemCPUSeconds, sample.SystemCPUSeconds)
+}
+
+// finish stops sampling and adds the usage the kernel reported for the
+// exited process, which includes the children it waited for
+func (m *usageMonitor) finish(state *os.ProcessState) {
+	close(m.stop)
+	<-m.done
+
+	if state == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.usage.PeakRSSBytes = max(m.usage.PeakRSSBytes, maxRSS(state))
+	m.usage.UserCPUSeconds = max(m.usage.UserCPUSeconds, state.UserTime().Seconds())
+	m.usage.SystemCPUSeconds = max(m.usage.SystemCPUSeconds, state.SystemTime().Seconds())
+}
+
+// Usage returns the usage seen so far
+func (m *usageMonitor) Usage() ResourceUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usage
+}
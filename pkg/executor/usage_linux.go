@@ -0,0 +1,114 @@
+//go:build linux
+
+package executor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// clockTicks is the unit of the CPU times in /proc, which is always 100 per
+// second on Linux
+const clockTicks = 100
+
+// procStat holds the fields of /proc/<pid>/stat used for resource usage
+type procStat struct {
+	ppid   int
+	utime  int64
+	stime  int64
+	cutime int64
+	cstime int64
+	rss    int64
+}
+
+// sampleProcessTree sums the current resident memory and CPU time of a
+// process and its descendants from /proc. The CPU time includes the children
+// that have already exited and been waited for.
+func sampleProcessTree(pid int) (ResourceUsage, bool) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return ResourceUsage{}, false
+	}
+
+	stats := make(map[int]procStat)
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcStat(child)
+		if err != nil {
+			continue
+		}
+		stats[child] = stat
+		children[stat.ppid] = append(children[stat.ppid], child)
+	}
+
+	root, ok := stats[pid]
+	if !ok {
+		return ResourceUsage{}, false
+	}
+
+	userTicks, systemTicks, rssPages := root.cutime, root.cstime, int64(0)
+	for pending := []int{pid}; len(pending) > 0; {
+		current := pending[len(pending)-1]
+		pending = append(pending[:len(pending)-1], children[current]...)
+
+		stat := stats[current]
+		userTicks += stat.utime
+		systemTicks += stat.stime
+		rssPages += stat.rss
+	}
+
+	return ResourceUsage{
+		PeakRSSBytes:     rssPages * int64(os.Getpagesize()),
+		UserCPUSeconds:   float64(userTicks) / clockTicks,
+		SystemCPUSeconds: float64(systemTicks) / clockTicks,
+	}, true
+}
+
+// readProcStat reads /proc/<pid>/stat
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return procStat{}, err
+	}
+
+	// The command name is in parentheses and may contain spaces, so fields
+	// are counted from the last parenthesis, starting with the third
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := bytes.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	field := func(n int) int64 {
+		value, _ := strconv.ParseInt(string(fields[n-3]), 10, 64)
+		return value
+	}
+
+	return procStat{
+		ppid:   int(field(4)),
+		utime:  field(14),
+		stime:  field(15),
+		cutime: field(16),
+		cstime: field(17),
+		rss:    field(24),
+	}, nil
+}
+
+// maxRSS returns the peak resident memory of an exited process and the
+// children it waited for
+func maxRSS(state *os.ProcessState) int64 {
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		// Linux reports it in kilobytes
+		return rusage.Maxrss * 1024
+	}
+	return 0
+}
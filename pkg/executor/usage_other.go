@@ -0,0 +1,15 @@
+//go:build !linux
+
+package executor
+
+import "os"
+
+// sampleProcessTree does nothing, since sampling needs /proc
+func sampleProcessTree(pid int) (ResourceUsage, bool) {
+	return ResourceUsage{}, false
+}
+
+// maxRSS returns zero, since the peak resident memory is only read on Linux
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -4,25 +4,72 @@ import (
 	"context"
 	"fmt"
 	"sync"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
 )
 
 // BaseFlow provides common functionality for all flows
 type BaseFlow struct {
+	ID          string
 	Name        string
 	Description string
 	State       State
 	StateMutex  sync.Mutex
 	Memory      Memory
+	Events      *agent.EventBus
+	Approvals   *agent.ApprovalQueue
 }
 
 // NewBaseFlow creates a new base flow
 func NewBaseFlow(name, description string, memory Memory) *BaseFlow {
+	events := agent.NewEventBus()
+
 	return &BaseFlow{
 		Name:        name,
 		Description: description,
 		State:       StateIdle,
 		Memory:      memory,
+		Events:      events,
+		Approvals:   agent.NewApprovalQueue(events),
+	}
+}
+
+// SetID sets the ID the flow is managed under
+func (f *BaseFlow) SetID(id string) {
+	f.ID = id
+}
+
+// EventBus returns the bus the flow publishes its events on
+func (f *BaseFlow) EventBus() *agent.EventBus {
+	return f.Events
+}
+
+// ApprovalQueue returns the queue of tool calls waiting for approval
+func (f *BaseFlow) ApprovalQueue() *agent.ApprovalQueue {
+	return f.Approvals
+}
+
+// RequestApproval pauses the flow until the request is resolved through its
+// approval queue
+func (f *BaseFlow) RequestApproval(ctx context.Context, request agent.ApprovalRequest) (agent.ApprovalDecision, error) {
+	if request.FlowID == "" {
+		request.FlowID = f.ID
 	}
+
+	previous := f.GetState()
+	f.setState(StateAwaitingApproval)
+	defer f.setState(previous)
+
+	return f.Approvals.RequestApproval(ctx, request)
+}
+
+// eventInfo returns the info for an event published by the flow
+func (f *BaseFlow) eventInfo(ctx context.Context, eventType agent.EventType) agent.EventInfo {
+	info := agent.NewEventInfo(ctx, eventType)
+	if info.FlowID == "" {
+		info.FlowID = f.ID
+	}
+	return info
 }
 
 // GetState returns the current state of the flow
@@ -39,6 +86,15 @@ func (f *BaseFlow) setState(state State) {
 	f.State = state
 }
 
+// failureState returns the state of a flow whose run stopped with an error:
+// cancelled if the run's context was, and error otherwise
+func failureState(ctx context.Context) State {
+	if ctx.Err() != nil {
+		return StateCancelled
+	}
+	return StateError
+}
+
 // GetName returns the name of the flow
 func (f *BaseFlow) GetName() string {
 	return f.Name
@@ -69,6 +125,15 @@ func (f *BaseFlow) SaveState(ctx context.Context, key string, value interface{})
 	return f.Memory.Save(ctx, key, value)
 }
 
+// stateKey returns the memory key of a part of the flow's state. Flows with
+// an ID keep their state apart from other flows.
+func (f *BaseFlow) stateKey(name string) string {
+	if f.ID == "" {
+		return name
+	}
+	return f.ID + "_" + name
+}
+
 // LoadState loads the flow state from memory
 func (f *BaseFlow) LoadState(ctx context.Context, key string) (interface{}, error) {
 	if f.Memory == nil {
@@ -0,0 +1,461 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// Step statuses
+const (
+	StepPending   = "pending"
+	StepRunning   = "running"
+	StepCompleted = "completed"
+	StepFailed    = "failed"
+	StepSkipped   = "skipped"
+)
+
+// DefaultMaxParallelSteps is the number of plan steps run at once by default
+const DefaultMaxParallelSteps = 4
+
+// stepGracePeriod is how long a step's command is given to exit after it is
+// asked to terminate, before it is killed
+const stepGracePeriod = 5 * time.Second
+
+// stepOutputLines is the number of last output lines kept in a step
+const stepOutputLines = 20
+
+// FailurePolicy decides what happens to the rest of a plan when a step fails
+type FailurePolicy string
+
+const (
+	// FailureSkipDependents skips the steps that depend on a failed step,
+	// directly or not, and runs the others
+	FailureSkipDependents FailurePolicy = "skip"
+	// FailureAbort cancels the running steps and skips all the others
+	FailureAbort FailurePolicy = "abort"
+)
+
+// ParseFailurePolicy parses the name of a failure policy; empty means
+// FailureSkipDependents
+func ParseFailurePolicy(name string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(strings.ToLower(name)); policy {
+	case "":
+		return FailureSkipDependents, nil
+	case FailureSkipDependents, FailureAbort:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown failure policy: %s", name)
+	}
+}
+
+// stepUpdate reports the progress of a step to the scheduler. An update
+// without a command ID that is not done starts an attempt.
+type stepUpdate struct {
+	stepID    string
+	attempt   int
+	commandID string
+	output    string
+	err       error
+	done      bool
+	// cancelled marks a step cut off by the cancellation of the run, which
+	// runs again when the flow is resumed
+	cancelled bool
+}
+
+// validatePlan names unnamed steps and checks that dependencies exist and
+// form no cycle. A plan in which no step declares dependencies runs its
+// steps in order, as plans did before steps could run in parallel.
+func validatePlan(plan *Plan) error {
+	ids := make(map[string]int, len(plan.Steps))
+	sequential := true
+	for i := range plan.Steps {
+		step := &plan.Steps[i]
+		if step.ID == "" {
+			step.ID = fmt.Sprintf("step%d", i+1)
+		}
+		if _, exists := ids[step.ID]; exists {
+			return fmt.Errorf("duplicate step ID: %s", step.ID)
+		}
+		ids[step.ID] = i
+		if len(step.DependsOn) > 0 {
+			sequential = false
+		}
+	}
+
+	if sequential {
+		for i := 1; i < len(plan.Steps); i++ {
+			plan.Steps[i].DependsOn = []string{plan.Steps[i-1].ID}
+		}
+		return nil
+	}
+
+	return checkDependencies(plan)
+}
+
+// checkDependencies checks that the dependencies of the steps of a plan exist
+// and form no cycle
+func checkDependencies(plan *Plan) error {
+	ids := stepIndex(plan)
+
+	// Count the unfinished dependencies of each step, then take the steps
+	// without any until none are left; steps that remain are in a cycle
+	remaining := make([]int, len(plan.Steps))
+	dependents := make([][]int, len(plan.Steps))
+	for i, step := range plan.Steps {
+		for _, dependency := range step.DependsOn {
+			j, exists := ids[dependency]
+			if !exists {
+				return fmt.Errorf("step %s depends on unknown step %s", step.ID, dependency)
+			}
+			if j == i {
+				return fmt.Errorf("step %s depends on itself", step.ID)
+			}
+			remaining[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	ready := make([]int, 0, len(plan.Steps))
+	for i, count := range remaining {
+		if count == 0 {
+			ready = append(ready, i)
+		}
+	}
+	for visited := 0; visited < len(plan.Steps); visited++ {
+		if len(ready) == 0 {
+			return fmt.Errorf("steps depend on each other in a cycle")
+		}
+		i := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		for _, dependent := range dependents[i] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runSteps runs the steps of the current plan as their dependencies
+// complete, up to MaxParallelSteps at a time. The plan is saved after every
+// change of a step. A cancelled run stops starting steps, and returns once
+// the running ones have stopped. Steps are changed under the plan lock, and
+// saved and published after it is released.
+func (f *PlanningFlow) runSteps(ctx context.Context) error {
+	run := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	f.planMu.Lock()
+	for i := range f.CurrentPlan.Steps {
+		// Steps cut off while running are run again
+		if f.CurrentPlan.Steps[i].Status == StepRunning || f.CurrentPlan.Steps[i].Status == "" {
+			f.CurrentPlan.Steps[i].Status = StepPending
+		}
+	}
+	f.planMu.Unlock()
+
+	workers := f.MaxParallelSteps
+	if workers <= 0 {
+		workers = 1
+	}
+
+	updates := make(chan stepUpdate)
+	running := 0
+	aborted := false
+	for {
+		// Replanning can change the steps, so they are looked up by ID
+		f.planMu.Lock()
+		plan := f.CurrentPlan
+		ids := stepIndex(plan)
+		skipped := skipBlockedSteps(plan, ids, aborted)
+		f.planMu.Unlock()
+
+		for _, step := range skipped {
+			if err := f.stepFinished(ctx, step); err != nil {
+				cancel()
+				f.drainSteps(updates, running)
+				return err
+			}
+		}
+
+		// Start the steps whose dependencies have completed
+		f.planMu.Lock()
+		for i := range plan.Steps {
+			if aborted || run.Err() != nil || running >= workers {
+				break
+			}
+			if plan.Steps[i].Status != StepPending || !dependenciesCompleted(plan, ids, i) {
+				continue
+			}
+			plan.Steps[i].Status = StepRunning
+			running++
+			go f.runStep(ctx, plan.Steps[i], updates)
+		}
+		f.planMu.Unlock()
+
+		if running == 0 {
+			return run.Err()
+		}
+
+		update := <-updates
+		if update.done {
+			running--
+			update.cancelled = update.err != nil && run.Err() != nil
+		}
+		failed, err := f.applyStepUpdate(ctx, ids[update.stepID], update)
+		if err != nil {
+			cancel()
+			f.drainSteps(updates, running)
+			return err
+		}
+		if !failed || aborted {
+			continue
+		}
+
+		// Let the planner fix the plan, and only apply the failure policy
+		// if it does not
+		revised, err := f.replan(ctx, update.stepID)
+		if err != nil {
+			fmt.Printf("Error replanning after step %s failed: %v\n", update.stepID, err)
+		}
+		if !revised && f.FailurePolicy == FailureAbort {
+			aborted = true
+			cancel()
+		}
+	}
+}
+
+// drainSteps waits for the running steps to finish after the run was
+// cancelled
+func (f *PlanningFlow) drainSteps(updates <-chan stepUpdate, running int) {
+	for running > 0 {
+		if update := <-updates; update.done {
+			running--
+		}
+	}
+}
+
+// stepIndex maps the IDs of the steps of a plan to their index
+func stepIndex(plan *Plan) map[string]int {
+	ids := make(map[string]int, len(plan.Steps))
+	for i := range plan.Steps {
+		ids[plan.Steps[i].ID] = i
+	}
+	return ids
+}
+
+// dependenciesCompleted reports whether all dependencies of a step have
+// completed
+func dependenciesCompleted(plan *Plan, ids map[string]int, index int) bool {
+	for _, dependency := range plan.Steps[index].DependsOn {
+		if plan.Steps[ids[dependency]].Status != StepCompleted {
+			return false
+		}
+	}
+	return true
+}
+
+// skipBlockedSteps skips the pending steps that can no longer run: all of
+// them once the plan is aborted, and otherwise those with a failed or
+// skipped dependency. It returns copies of the skipped steps; the caller
+// holds the plan lock.
+func skipBlockedSteps(plan *Plan, ids map[string]int, aborted bool) []PlanStep {
+	var skipped []PlanStep
+	for changed := true; changed; {
+		changed = false
+		for i := range plan.Steps {
+			step := &plan.Steps[i]
+			if step.Status != StepPending {
+				continue
+			}
+
+			reason := ""
+			if aborted {
+				reason = "Skipped because the plan was aborted after a step failed"
+			}
+			for _, dependency := range step.DependsOn {
+				status := plan.Steps[ids[dependency]].Status
+				if reason == "" && (status == StepFailed || status == StepSkipped) {
+					reason = fmt.Sprintf("Skipped because step %s %s", dependency, status)
+				}
+			}
+			if reason == "" {
+				continue
+			}
+
+			step.Status = StepSkipped
+			step.Error = reason
+			changed = true
+			skipped = append(skipped, *step)
+		}
+	}
+
+	return skipped
+}
+
+// applyStepUpdate records the progress of a step, and reports whether the
+// step has failed
+func (f *PlanningFlow) applyStepUpdate(ctx context.Context, index int, update stepUpdate) (bool, error) {
+	f.planMu.Lock()
+	step := &f.CurrentPlan.Steps[index]
+	switch {
+	case update.cancelled:
+		// The cut off attempt does not count against the step's retries
+		step.Output = update.output
+		step.Status = StepPending
+		step.Attempts = update.attempt - 1
+		step.Error = update.err.Error()
+	case update.done:
+		step.Output = update.output
+		if update.err != nil {
+			step.Status = StepFailed
+			step.Error = update.err.Error()
+		} else {
+			step.Status = StepCompleted
+			step.Error = ""
+		}
+	case update.commandID != "":
+		step.CommandID = update.commandID
+	default:
+		// A new attempt clears what the previous one left
+		step.Status = StepRunning
+		step.Attempts = update.attempt
+		step.CommandID = ""
+		step.Error = ""
+	}
+	changed := *step
+	f.planMu.Unlock()
+
+	ctx = llm.WithUsageScope(ctx, llm.UsageScope{StepID: changed.ID})
+	if update.cancelled || update.done {
+		return changed.Status == StepFailed, f.stepFinished(ctx, changed)
+	}
+
+	if err := f.savePlan(ctx); err != nil {
+		return false, fmt.Errorf("failed to save plan: %w", err)
+	}
+	if update.commandID == "" {
+		f.Events.Publish(StepEvent{
+			EventInfo: f.eventInfo(ctx, EventStepStarted),
+			Step:      changed,
+		})
+	}
+
+	return false, nil
+}
+
+// stepFinished saves the plan and publishes a finished step, given as a copy
+// taken under the plan lock
+func (f *PlanningFlow) stepFinished(ctx context.Context, step PlanStep) error {
+	if err := f.savePlan(ctx); err != nil {
+		return fmt.Errorf("failed to save plan: %w", err)
+	}
+
+	f.Events.Publish(StepEvent{
+		EventInfo: f.eventInfo(ctx, EventStepFinished),
+		Step:      step,
+	})
+
+	return nil
+}
+
+// runStep runs a step, trying it again after a failure as many times as it
+// allows, and reports its progress
+func (f *PlanningFlow) runStep(ctx context.Context, step PlanStep, updates chan<- stepUpdate) {
+	// Attribute LLM usage during the step to its ID
+	ctx = llm.WithUsageScope(ctx, llm.UsageScope{StepID: step.ID})
+
+	// A command left running by an earlier run of the flow is waited for
+	// rather than started again
+	adopted := ""
+	if cmd, err := f.ExecutionPipeline.GetCommand(step.CommandID); err == nil && cmd.State() == executor.CommandRunning {
+		adopted = step.CommandID
+	}
+
+	for attempt := step.Attempts + 1; ; attempt++ {
+		updates <- stepUpdate{stepID: step.ID, attempt: attempt}
+
+		output, err := f.runStepAttempt(ctx, attempt, step, adopted, updates)
+		adopted = ""
+		if err == nil || attempt > step.Retries || ctx.Err() != nil {
+			updates <- stepUpdate{stepID: step.ID, attempt: attempt, output: output, err: err, done: true}
+			return
+		}
+	}
+}
+
+// runStepAttempt runs the command of a step once, or waits for an adopted
+// command, until it exits. A step without a command completes at once.
+func (f *PlanningFlow) runStepAttempt(ctx context.Context, attempt int, step PlanStep, adopted string, updates chan<- stepUpdate) (string, error) {
+	if step.Command == "" {
+		return "", nil
+	}
+
+	commandID := adopted
+	if commandID == "" {
+		var err error
+		commandID, err = f.ExecuteCommandInBackground(step.Command)
+		if err != nil {
+			return "", fmt.Errorf("execution error: %w", err)
+		}
+	}
+	updates <- stepUpdate{stepID: step.ID, attempt: attempt, commandID: commandID}
+
+	cmd, err := f.ExecutionPipeline.GetCommand(commandID)
+	if err != nil {
+		return "", err
+	}
+
+	var timeout <-chan time.Time
+	if step.TimeoutSeconds > 0 {
+		timer := time.NewTimer(time.Duration(step.TimeoutSeconds) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var stopped error
+	select {
+	case <-cmd.Done:
+	case <-timeout:
+		stopped = fmt.Errorf("command timed out after %d seconds", step.TimeoutSeconds)
+	case <-ctx.Done():
+		stopped = fmt.Errorf("command was canceled")
+	}
+	if stopped != nil {
+		cmd.Terminate(syscall.SIGTERM, stepGracePeriod)
+		<-cmd.Done
+	}
+
+	output := commandOutput(cmd)
+	if stopped != nil {
+		return output, stopped
+	}
+	if cmd.ExitCode != 0 {
+		return output, fmt.Errorf("command exited with code %d", cmd.ExitCode)
+	}
+
+	return output, nil
+}
+
+// commandOutput returns the last output lines of a step's command
+func commandOutput(cmd *executor.BackgroundCommand) string {
+	page, err := cmd.ReadOutput(executor.OutputQuery{Tail: stepOutputLines})
+	if err != nil {
+		return ""
+	}
+
+	lines := make([]string, len(page.Lines))
+	for i, line := range page.Lines {
+		lines[i] = line.Text
+	}
+	return strings.Join(lines, "\n")
+}
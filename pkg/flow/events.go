@@ -0,0 +1,89 @@
+package flow
+
+import (
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+)
+
+// Flow event types
+const (
+	EventFlowStarted    agent.EventType = "flow_started"
+	EventFlowFinished   agent.EventType = "flow_finished"
+	EventPlanGenerated  agent.EventType = "plan_generated"
+	EventPlanRevised    agent.EventType = "plan_revised"
+	EventStepStarted    agent.EventType = "step_started"
+	EventStepFinished   agent.EventType = "step_finished"
+	EventCommandStarted agent.EventType = "command_started"
+	EventCommandStatus  agent.EventType = "command_status"
+	EventFlowRunUpdated agent.EventType = "flow_run_updated"
+)
+
+// FlowStartedEvent is published when a flow starts processing a request
+type FlowStartedEvent struct {
+	agent.EventInfo
+	Input string `json:"input"`
+}
+
+// FlowFinishedEvent is published when a flow has finished a request
+type FlowFinishedEvent struct {
+	agent.EventInfo
+	Success bool   `json:"success"`
+	Output  string `json:"output,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PlanGeneratedEvent is published when a planning flow has made its plan
+type PlanGeneratedEvent struct {
+	agent.EventInfo
+	Plan Plan `json:"plan"`
+}
+
+// PlanRevisedEvent is published when the planner has patched a plan after a
+// step failed
+type PlanRevisedEvent struct {
+	agent.EventInfo
+	Revision PlanRevision `json:"revision"`
+}
+
+// StepEvent is published when a plan step starts or finishes
+type StepEvent struct {
+	agent.EventInfo
+	Step PlanStep `json:"step"`
+}
+
+// CommandStartedEvent is published when a flow starts a background command
+type CommandStartedEvent struct {
+	agent.EventInfo
+	CommandID string `json:"command_id"`
+	Command   string `json:"command"`
+}
+
+// CommandStatusEvent reports the progress of a background command with its
+// latest output and error lines
+type CommandStatusEvent struct {
+	agent.EventInfo
+	CommandID string   `json:"command_id"`
+	Running   bool     `json:"running"`
+	ExitCode  int      `json:"exit_code"`
+	Duration  float64  `json:"duration"`
+	Output    []string `json:"output,omitempty"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// FlowRunEvent is published when a run submitted to a run queue is queued,
+// starts or finishes
+type FlowRunEvent struct {
+	agent.EventInfo
+	Run RunRecord `json:"run"`
+}
+
+// ApprovalSource is implemented by flows whose tool calls can wait for approval
+type ApprovalSource interface {
+	// ApprovalQueue returns the queue of tool calls waiting for approval
+	ApprovalQueue() *agent.ApprovalQueue
+}
+
+// EventSource is implemented by flows that publish events
+type EventSource interface {
+	// EventBus returns the bus the flow publishes its events on
+	EventBus() *agent.EventBus
+}
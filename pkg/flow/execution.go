@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prathyushnallamothu/commandforge/pkg/agent"
@@ -25,6 +26,7 @@ type ExecutionResult struct {
 type StreamingExecutor struct {
 	CommandMutex   sync.Mutex
 	ActiveCommands map[string]*executor.BackgroundCommand
+	Backend        executor.Backend
 }
 
 // NewStreamingExecutor creates a new streaming executor
@@ -40,7 +42,7 @@ func (e *StreamingExecutor) ExecuteCommand(ctx context.Context, command string,
 	commandID := fmt.Sprintf("cmd-%d", time.Now().UnixNano())
 
 	// Create and start the background command
-	cmd, err := executor.ExecuteCommandWithStreaming(command, workingDir)
+	cmd, err := executor.ExecuteCommandOnBackend(e.Backend, command, workingDir)
 	if err != nil {
 		// Handle system-level execution errors
 		return &ExecutionResult{
@@ -71,14 +73,15 @@ func (e *StreamingExecutor) ExecuteCommand(ctx context.Context, command string,
 	e.CommandMutex.Unlock()
 
 	// Create the execution result
+	_, exitCode, output, errOutput, outputList, errorList := cmd.GetStatus()
 	result := &ExecutionResult{
-		Success:    cmd.ExitCode == 0,
-		ExitCode:   cmd.ExitCode,
-		Output:     cmd.Output,
-		Error:      cmd.Error,
+		Success:    exitCode == 0,
+		ExitCode:   exitCode,
+		Output:     output,
+		Error:      errOutput,
 		Duration:   cmd.Duration.Seconds(),
-		OutputList: cmd.OutputLines,
-		ErrorList:  cmd.ErrorLines,
+		OutputList: outputList,
+		ErrorList:  errorList,
 	}
 
 	return result, nil
@@ -96,38 +99,50 @@ func (e *StreamingExecutor) GetCommandStatus(commandID string) (*ExecutionResult
 	}
 
 	// Create the execution result
+	done, exitCode, output, errOutput, outputList, errorList := cmd.GetStatus()
 	result := &ExecutionResult{
-		Success:    cmd.ExitCode == 0,
-		ExitCode:   cmd.ExitCode,
-		Output:     cmd.Output,
-		Error:      cmd.Error,
+		Success:    !done || exitCode == 0,
+		ExitCode:   exitCode,
+		Output:     output,
+		Error:      errOutput,
 		Duration:   cmd.Duration.Seconds(),
-		OutputList: cmd.OutputLines,
-		ErrorList:  cmd.ErrorLines,
+		OutputList: outputList,
+		ErrorList:  errorList,
 	}
 
 	return result, nil
 }
 
-// ExecutionPipeline coordinates the execution of commands
+// ExecutionPipeline coordinates the execution of commands. Its background
+// commands are kept in the command registry under its owner, so they are
+// gone from the pipeline once the registry removes them.
 type ExecutionPipeline struct {
-	Executor           *StreamingExecutor
-	WorkingDir         string
-	StatusListeners    []func(string, *ExecutionResult)
-	BackgroundCommands map[string]*executor.BackgroundCommand
-	mu                 sync.RWMutex
+	Executor        *StreamingExecutor
+	WorkingDir      string
+	StatusListeners []func(string, *ExecutionResult)
+	// Owner marks the background commands of the pipeline in the registry;
+	// a flow sets it to its ID
+	Owner   string
+	Backend executor.Backend
 }
 
 // NewExecutionPipeline creates a new execution pipeline
 func NewExecutionPipeline(workingDir string) *ExecutionPipeline {
 	return &ExecutionPipeline{
-		Executor:           NewStreamingExecutor(),
-		WorkingDir:         workingDir,
-		StatusListeners:    make([]func(string, *ExecutionResult), 0),
-		BackgroundCommands: make(map[string]*executor.BackgroundCommand),
+		Executor:        NewStreamingExecutor(),
+		WorkingDir:      workingDir,
+		StatusListeners: make([]func(string, *ExecutionResult), 0),
+		Owner:           fmt.Sprintf("pipeline-%d", time.Now().UnixNano()),
 	}
 }
 
+// WithBackend sets the backend that commands run on
+func (p *ExecutionPipeline) WithBackend(backend executor.Backend) *ExecutionPipeline {
+	p.Backend = backend
+	p.Executor.Backend = backend
+	return p
+}
+
 // AddStatusListener registers a function to be called when command status changes
 func (p *ExecutionPipeline) AddStatusListener(listener func(string, *ExecutionResult)) {
 	p.StatusListeners = append(p.StatusListeners, listener)
@@ -180,39 +195,86 @@ func (p *ExecutionPipeline) ExecuteCommandWithAgent(ctx context.Context, agentIn
 
 // ExecuteCommandInBackground runs a command in the background and returns its ID
 func (p *ExecutionPipeline) ExecuteCommandInBackground(command string) (string, error) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	return p.startBackgroundCommand(command, nil)
+}
 
+// ExecuteCommandInTerminal runs a command in the background in a
+// pseudo-terminal of the given size and returns its ID
+func (p *ExecutionPipeline) ExecuteCommandInTerminal(command string, rows, cols uint16) (string, error) {
+	return p.startBackgroundCommand(command, func(cmd *executor.BackgroundCommand) {
+		cmd.WithTerminal(rows, cols)
+	})
+}
+
+// startBackgroundCommand creates, configures and starts a background command
+func (p *ExecutionPipeline) startBackgroundCommand(command string, configure func(*executor.BackgroundCommand)) (string, error) {
 	// Create a background command
 	cmd, err := executor.NewBackgroundCommand(command, p.WorkingDir)
 	if err != nil {
 		return "", fmt.Errorf("failed to create background command: %w", err)
 	}
+	cmd.Owner = p.Owner
+	cmd.Backend = p.Backend
+	if configure != nil {
+		configure(cmd)
+	}
 
 	// Start the command
 	if err := cmd.Start(); err != nil {
 		return "", fmt.Errorf("failed to start background command: %w", err)
 	}
 
-	// Store the command
-	p.BackgroundCommands[cmd.ID] = cmd
+	// Register the command so that it is persisted and collected with the
+	// others
+	executor.RegisterCommand(cmd)
 
 	return cmd.ID, nil
 }
 
+// GetCommand returns a background command of the pipeline, including one
+// started by an earlier run of its flow and recovered after a restart
+func (p *ExecutionPipeline) GetCommand(commandID string) (*executor.BackgroundCommand, error) {
+	cmd, err := executor.GetCommand(commandID)
+	if err != nil || cmd.Owner != p.Owner {
+		return nil, fmt.Errorf("command with ID %s not found", commandID)
+	}
+
+	return cmd, nil
+}
+
+// ListCommands returns the records of the background commands of the
+// pipeline, oldest first
+func (p *ExecutionPipeline) ListCommands() []executor.CommandRecord {
+	commands := executor.OwnedCommands(p.Owner)
+	records := make([]executor.CommandRecord, len(commands))
+	for i, cmd := range commands {
+		records[i] = cmd.Record()
+	}
+
+	return records
+}
+
+// TerminateCommands asks the running background commands of the pipeline to
+// terminate with SIGTERM, and kills those still running after the grace
+// period. It returns without waiting for them to exit.
+func (p *ExecutionPipeline) TerminateCommands(grace time.Duration) {
+	for _, cmd := range executor.OwnedCommands(p.Owner) {
+		if cmd.State() == executor.CommandRunning {
+			cmd.Terminate(syscall.SIGTERM, grace)
+		}
+	}
+}
+
 // GetCommandStatus retrieves the status of a background command
 func (p *ExecutionPipeline) GetCommandStatus(commandID string) (*executor.BackgroundCommandStatus, error) {
-	p.mu.RLock()
-	defer p.mu.RUnlock()
-
 	// Find the command
-	cmd, exists := p.BackgroundCommands[commandID]
-	if !exists {
-		return nil, fmt.Errorf("command with ID %s not found", commandID)
+	cmd, err := p.GetCommand(commandID)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get the status
-	running, exitCode, output, errOutput, outputList, errorList := cmd.GetStatus()
+	done, exitCode, output, errOutput, outputList, errorList := cmd.GetStatus()
 
 	// Calculate duration
 	duration := 0.0
@@ -225,16 +287,21 @@ func (p *ExecutionPipeline) GetCommandStatus(commandID string) (*executor.Backgr
 	}
 
 	// Create and return the status object
-	return executor.NewBackgroundCommandStatus(
+	status := executor.NewBackgroundCommandStatus(
 		cmd.ID,
 		cmd.Command,
 		cmd.WorkingDir,
-		running,
+		!done,
 		exitCode,
 		output,
 		errOutput,
 		outputList,
 		errorList,
 		duration,
-	), nil
+	)
+	status.State = cmd.State()
+	status.Usage = cmd.Usage()
+	status.Screen = cmd.Screen()
+
+	return status, nil
 }
@@ -0,0 +1,43 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+)
+
+func TestPipelineResolvesCommandsThroughRegistry(t *testing.T) {
+	pipeline := NewExecutionPipeline(t.TempDir())
+	pipeline.Owner = "flow1"
+	other := NewExecutionPipeline(t.TempDir())
+	other.Owner = "flow2"
+
+	commandID, err := pipeline.ExecuteCommandInBackground("true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := pipeline.GetCommand(commandID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-cmd.Done
+
+	if _, err := other.GetCommand(commandID); err == nil {
+		t.Error("a command of another flow was found")
+	}
+	if records := pipeline.ListCommands(); len(records) != 1 || records[0].Owner != "flow1" {
+		t.Errorf("unexpected commands: %+v", records)
+	}
+
+	// Commands the registry removes are gone from the pipeline
+	executor.RemoveCommand(commandID)
+	if _, err := pipeline.GetCommand(commandID); err == nil {
+		t.Error("a removed command was found")
+	}
+	if _, err := pipeline.GetCommandStatus(commandID); err == nil {
+		t.Error("a removed command has a status")
+	}
+	if records := pipeline.ListCommands(); len(records) != 0 {
+		t.Errorf("removed commands are listed: %+v", records)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
 	"github.com/prathyushnallamothu/commandforge/pkg/llm"
 )
 
@@ -21,6 +22,18 @@ type FlowFactory struct {
 	LLMClient    llm.Client
 	Memory       Memory
 	AgentFactory *agent.Factory
+	// ApprovalPolicy is applied to the tool calls of new flows, if set
+	ApprovalPolicy *agent.ApprovalPolicy
+	// Backend runs the commands of new flows, if set
+	Backend executor.Backend
+	// MaxParallelSteps and FailurePolicy configure how planning flows run
+	// their plans, if set
+	MaxParallelSteps int
+	FailurePolicy    FailurePolicy
+	// ReplanBudget limits how many times new planning flows patch their
+	// plan after steps fail; negative disables replanning, zero keeps the
+	// default
+	ReplanBudget int
 }
 
 // NewFlowFactory creates a new flow factory
@@ -32,11 +45,56 @@ func NewFlowFactory(llmClient llm.Client, memory Memory, agentFactory *agent.Fac
 	}
 }
 
+// WithApprovalPolicy sets the approval policy applied to new flows
+func (f *FlowFactory) WithApprovalPolicy(policy *agent.ApprovalPolicy) *FlowFactory {
+	f.ApprovalPolicy = policy
+	return f
+}
+
+// WithBackend sets the backend that new flows run commands on
+func (f *FlowFactory) WithBackend(backend executor.Backend) *FlowFactory {
+	f.Backend = backend
+	return f
+}
+
+// WithPlanning sets how many steps of a plan new planning flows run at once,
+// and what happens to the rest of the plan when a step fails
+func (f *FlowFactory) WithPlanning(maxParallelSteps int, policy FailurePolicy) *FlowFactory {
+	f.MaxParallelSteps = maxParallelSteps
+	f.FailurePolicy = policy
+	return f
+}
+
+// WithReplanBudget sets how many times new planning flows patch their plan
+// after steps fail; negative disables replanning
+func (f *FlowFactory) WithReplanBudget(n int) *FlowFactory {
+	f.ReplanBudget = n
+	return f
+}
+
 // CreateFlow creates a flow of the specified type
 func (f *FlowFactory) CreateFlow(flowType FlowType) (Flow, error) {
 	switch flowType {
 	case FlowTypePlanning:
-		return NewPlanningFlow(f.LLMClient, f.Memory, f.AgentFactory), nil
+		flow := NewPlanningFlow(f.LLMClient, f.Memory, f.AgentFactory)
+		if f.ApprovalPolicy != nil {
+			flow.WithApprovalPolicy(f.ApprovalPolicy)
+		}
+		if f.Backend != nil {
+			flow.ExecutionPipeline.WithBackend(f.Backend)
+		}
+		if f.MaxParallelSteps > 0 {
+			flow.WithMaxParallelSteps(f.MaxParallelSteps)
+		}
+		if f.FailurePolicy != "" {
+			flow.WithFailurePolicy(f.FailurePolicy)
+		}
+		if f.ReplanBudget < 0 {
+			flow.WithReplanBudget(0)
+		} else if f.ReplanBudget > 0 {
+			flow.WithReplanBudget(f.ReplanBudget)
+		}
+		return flow, nil
 	case FlowTypeSimple:
 		return NewSimpleFlow(f.LLMClient, f.Memory), nil
 	default:
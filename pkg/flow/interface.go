@@ -13,6 +13,15 @@ const (
 	StateRunning  State = "running"
 	StateError    State = "error"
 	StateComplete State = "complete"
+	// StateAwaitingApproval means the flow is paused until a tool call is
+	// approved or rejected
+	StateAwaitingApproval State = "awaiting_approval"
+	// StateInterrupted means the flow was running when the process that ran
+	// it stopped, and can be resumed
+	StateInterrupted State = "interrupted"
+	// StateCancelled means the flow's run was cancelled before it finished,
+	// and can be resumed
+	StateCancelled State = "cancelled"
 )
 
 // FlowRequest represents a request to a flow
@@ -0,0 +1,78 @@
+package flow
+
+import (
+	"context"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// FlowHistory is what a flow has done: the conversations of its agents, its
+// current plan and the commands it ran
+type FlowHistory struct {
+	// Conversations are the messages exchanged with the LLM, by agent
+	Conversations map[string][]llm.Message `json:"conversations"`
+	Plan          *Plan                    `json:"plan,omitempty"`
+	Commands      []executor.CommandRecord `json:"commands"`
+}
+
+// HistorySource is implemented by flows that report their history
+type HistorySource interface {
+	History() FlowHistory
+}
+
+// conversational is implemented by agents that keep their conversation
+type conversational interface {
+	GetConversationHistory() []llm.Message
+}
+
+// History returns the conversations of the planner and executor agents, the
+// current plan and the background commands of the flow
+func (f *PlanningFlow) History() FlowHistory {
+	history := FlowHistory{
+		Conversations: make(map[string][]llm.Message),
+		Commands:      f.ExecutionPipeline.ListCommands(),
+	}
+
+	for name, a := range map[string]agent.Agent{"planner": f.PlannerAgent, "executor": f.ExecutorAgent} {
+		if c, ok := a.(conversational); ok {
+			history.Conversations[name] = append([]llm.Message(nil), c.GetConversationHistory()...)
+		}
+	}
+
+	history.Plan = f.PlanSnapshot()
+
+	return history
+}
+
+// Dispose terminates the running commands of the flow, stops its agents and
+// deletes the state it saved to memory
+func (f *PlanningFlow) Dispose(ctx context.Context) error {
+	f.ExecutionPipeline.TerminateCommands(stepGracePeriod)
+
+	for _, a := range []agent.Agent{f.PlannerAgent, f.ExecutorAgent} {
+		if err := a.Stop(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Memories that cannot delete keep the state
+	deleter, ok := f.Memory.(interface {
+		Delete(ctx context.Context, key string) error
+	})
+	if !ok {
+		return nil
+	}
+	for _, name := range []string{"current_plan", "plan_revisions"} {
+		key := f.stateKey(name)
+		if _, err := f.LoadState(ctx, key); err != nil {
+			continue
+		}
+		if err := deleter.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
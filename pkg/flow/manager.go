@@ -2,31 +2,166 @@ package flow
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
 	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
 )
 
 // FlowManager coordinates multiple flows and provides a centralized way to manage them
 type FlowManager struct {
-	FlowFactory    *FlowFactory
-	ActiveFlows    map[string]Flow
-	OutputHandlers map[string][]func(string)
-	mu             sync.RWMutex
+	FlowFactory *FlowFactory
+	ActiveFlows map[string]Flow
+	mu          sync.RWMutex
+
+	// runs are the runs in progress, by flow ID
+	runs map[string]*flowRun
+
+	// records describe the flows, and are saved to the store if one is set
+	records   map[string]*FlowRecord
+	store     FlowStore
+	recordsMu sync.Mutex
+}
+
+// ErrFlowNotRunning is returned when cancelling a flow that has no run in
+// progress
+var ErrFlowNotRunning = errors.New("flow is not running")
+
+// DefaultFlowPageLimit is the number of flows listed at once by default
+const DefaultFlowPageLimit = 50
+
+// FlowQuery selects flow records. Empty fields match any flow.
+type FlowQuery struct {
+	Owner string
+	State State
+	Type  FlowType
+	// Offset is the number of matching flows to skip, oldest first
+	Offset int
+	// Limit is the maximum number of flows to return, or
+	// DefaultFlowPageLimit if zero
+	Limit int
+}
+
+// FlowPage is a page of flow records
+type FlowPage struct {
+	Flows []FlowRecord `json:"flows"`
+	// Total is the number of flows matching the query
+	Total int `json:"total"`
+	// NextOffset is the offset to continue listing from
+	NextOffset int  `json:"next_offset"`
+	HasMore    bool `json:"has_more"`
+}
+
+// flowRun is a run of a flow in progress
+type flowRun struct {
+	cancel context.CancelFunc
+	// done is closed once the run has finished and its record is updated
+	done chan struct{}
+}
+
+// Disposable is implemented by flows that hold resources, such as running
+// commands, to release when they are deleted
+type Disposable interface {
+	Dispose(ctx context.Context) error
+}
+
+// Resumable is implemented by flows that can continue a run that stopped
+// before it finished
+type Resumable interface {
+	// Restore loads the state the flow saved to memory
+	Restore(ctx context.Context) error
+
+	// Resume continues the flow from its saved state
+	Resume(ctx context.Context) (*FlowResponse, error)
 }
 
 // NewFlowManager creates a new flow manager
 func NewFlowManager(flowFactory *FlowFactory) *FlowManager {
 	return &FlowManager{
-		FlowFactory:    flowFactory,
-		ActiveFlows:    make(map[string]Flow),
-		OutputHandlers: make(map[string][]func(string)),
+		FlowFactory: flowFactory,
+		ActiveFlows: make(map[string]Flow),
+		runs:        make(map[string]*flowRun),
+		records:     make(map[string]*FlowRecord),
+	}
+}
+
+// PersistFlows saves the records of flows to a store from now on, and
+// recreates the flows stored in it. Flows that were running when the
+// previous process stopped are marked as interrupted, and can be resumed.
+func (m *FlowManager) PersistFlows(ctx context.Context, store FlowStore) error {
+	records, err := store.LoadFlows()
+	if err != nil {
+		return err
+	}
+
+	m.recordsMu.Lock()
+	m.store = store
+	m.recordsMu.Unlock()
+
+	var failed []string
+	for _, record := range records {
+		if err := m.rehydrateFlow(ctx, record); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", record.ID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to recover flows: %s", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// rehydrateFlow recreates a flow from its record and the state it saved
+func (m *FlowManager) rehydrateFlow(ctx context.Context, record FlowRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.ActiveFlows[record.ID]; exists {
+		return fmt.Errorf("flow with ID %s already exists", record.ID)
+	}
+
+	flow, err := m.newFlow(ctx, record.Type, record.ID)
+	if err != nil {
+		return err
+	}
+
+	if resumable, ok := flow.(Resumable); ok {
+		if err := resumable.Restore(ctx); err != nil {
+			return err
+		}
+	}
+
+	if record.State == StateRunning || record.State == StateAwaitingApproval {
+		record.State = StateInterrupted
+	}
+	if settable, ok := flow.(interface{ setState(State) }); ok {
+		settable.setState(record.State)
 	}
+
+	m.ActiveFlows[record.ID] = flow
+	m.trackFlow(record.ID, flow)
+	m.updateRecord(record.ID, func(r *FlowRecord) {
+		*r = record
+	})
+
+	return nil
 }
 
 // CreateFlow creates a new flow and registers it with the manager
 func (m *FlowManager) CreateFlow(ctx context.Context, flowType FlowType, flowID string) (Flow, error) {
+	return m.CreateOwnedFlow(ctx, flowType, flowID, "")
+}
+
+// CreateOwnedFlow creates a new flow owned by a user and registers it with
+// the manager
+func (m *FlowManager) CreateOwnedFlow(ctx context.Context, flowType FlowType, flowID string, owner string) (Flow, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -36,28 +171,148 @@ func (m *FlowManager) CreateFlow(ctx context.Context, flowType FlowType, flowID
 	}
 
 	// Create the flow
+	flow, err := m.newFlow(ctx, flowType, flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the flow, and keep its record up to date
+	m.ActiveFlows[flowID] = flow
+	m.trackFlow(flowID, flow)
+	now := time.Now()
+	m.updateRecord(flowID, func(record *FlowRecord) {
+		*record = FlowRecord{
+			ID:        flowID,
+			Type:      flowType,
+			Owner:     owner,
+			State:     flow.GetState(),
+			CreatedAt: now,
+		}
+	})
+
+	return flow, nil
+}
+
+// newFlow creates and initializes a flow with the given ID
+func (m *FlowManager) newFlow(ctx context.Context, flowType FlowType, flowID string) (Flow, error) {
 	flow, err := m.FlowFactory.CreateFlow(flowType)
 	if err != nil {
 		return nil, err
 	}
 
+	// Let the flow know its ID so that its events carry it and its state
+	// is kept apart in memory
+	if identifiable, ok := flow.(interface{ SetID(string) }); ok {
+		identifiable.SetID(flowID)
+	}
+
 	// Initialize the flow
 	if err := flow.Initialize(ctx); err != nil {
 		return nil, fmt.Errorf("failed to initialize flow: %w", err)
 	}
 
-	// Register output handlers for planning flows
-	if planningFlow, ok := flow.(*PlanningFlow); ok {
-		// Add our output handler to the planning flow
-		planningFlow.OutputListeners = append(planningFlow.OutputListeners, func(output string) {
-			m.handleFlowOutput(flowID, output)
+	return flow, nil
+}
+
+// trackFlow keeps the record of a flow up to date with the steps it runs
+func (m *FlowManager) trackFlow(flowID string, flow Flow) {
+	source, ok := flow.(EventSource)
+	if !ok {
+		return
+	}
+
+	source.EventBus().Subscribe(func(event agent.Event) {
+		step, ok := event.(StepEvent)
+		if !ok {
+			return
+		}
+		m.updateRecord(flowID, func(record *FlowRecord) {
+			if step.Type == EventStepStarted {
+				record.CurrentStep = step.Step.ID
+			}
+			record.State = flow.GetState()
 		})
+	})
+}
+
+// updateRecord changes the record of a flow and saves it to the store
+func (m *FlowManager) updateRecord(flowID string, update func(*FlowRecord)) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	record, exists := m.records[flowID]
+	if !exists {
+		record = &FlowRecord{ID: flowID}
+		m.records[flowID] = record
+	}
+	update(record)
+	record.UpdatedAt = time.Now()
+
+	if m.store != nil {
+		if err := m.store.SaveFlow(*record); err != nil {
+			// Just log the error, don't interrupt the flow
+			fmt.Printf("Error saving flow %s: %v\n", flowID, err)
+		}
 	}
+}
 
-	// Store the flow
-	m.ActiveFlows[flowID] = flow
+// GetFlowRecord returns the record of a flow
+func (m *FlowManager) GetFlowRecord(flowID string) (FlowRecord, error) {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
 
-	return flow, nil
+	record, exists := m.records[flowID]
+	if !exists {
+		return FlowRecord{}, fmt.Errorf("flow with ID %s not found", flowID)
+	}
+
+	return *record, nil
+}
+
+// ListFlowRecords returns the records of the flows of an owner, or of all
+// flows if the owner is empty, oldest first
+func (m *FlowManager) ListFlowRecords(owner string) []FlowRecord {
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	records := make([]FlowRecord, 0, len(m.records))
+	for _, record := range m.records {
+		if owner == "" || record.Owner == owner {
+			records = append(records, *record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records
+}
+
+// QueryFlowRecords returns a page of the records of the flows matching a
+// query, oldest first
+func (m *FlowManager) QueryFlowRecords(query FlowQuery) FlowPage {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = DefaultFlowPageLimit
+	}
+
+	matching := make([]FlowRecord, 0)
+	for _, record := range m.ListFlowRecords(query.Owner) {
+		if (query.State == "" || record.State == query.State) && (query.Type == "" || record.Type == query.Type) {
+			matching = append(matching, record)
+		}
+	}
+
+	start := min(query.Offset, len(matching))
+	end := min(start+limit, len(matching))
+
+	return FlowPage{
+		Flows:      matching[start:end],
+		Total:      len(matching),
+		NextOffset: end,
+		HasMore:    end < len(matching),
+	}
 }
 
 // GetFlow retrieves a flow by ID
@@ -83,36 +338,152 @@ func (m *FlowManager) RemoveFlow(flowID string) error {
 	}
 
 	delete(m.ActiveFlows, flowID)
-	delete(m.OutputHandlers, flowID)
+
+	m.recordsMu.Lock()
+	defer m.recordsMu.Unlock()
+
+	delete(m.records, flowID)
+	if m.store != nil {
+		return m.store.DeleteFlow(flowID)
+	}
 
 	return nil
 }
 
-// RegisterOutputHandler registers a handler for flow output
-func (m *FlowManager) RegisterOutputHandler(flowID string, handler func(string)) {
+// running reports whether a flow has a run in progress
+func (m *FlowManager) running(flowID string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if _, exists := m.OutputHandlers[flowID]; !exists {
-		m.OutputHandlers[flowID] = make([]func(string), 0)
+	_, running := m.runs[flowID]
+	return running
+}
+
+// CancelFlow cancels the run of a flow in progress. The run stops its steps
+// and the flow is left cancelled, so that it can be resumed; CancelFlow
+// returns without waiting for it.
+func (m *FlowManager) CancelFlow(flowID string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, exists := m.ActiveFlows[flowID]; !exists {
+		return fmt.Errorf("flow with ID %s not found", flowID)
 	}
 
-	m.OutputHandlers[flowID] = append(m.OutputHandlers[flowID], handler)
+	run, running := m.runs[flowID]
+	if !running {
+		return fmt.Errorf("flow with ID %s: %w", flowID, ErrFlowNotRunning)
+	}
+	run.cancel()
+
+	return nil
 }
 
-// handleFlowOutput processes output from a flow and sends it to registered handlers
-func (m *FlowManager) handleFlowOutput(flowID string, output string) {
+// DeleteFlow cancels the run of a flow in progress and waits for it to stop,
+// terminates the flow's commands and releases its resources, and removes it
+// from the manager and the store
+func (m *FlowManager) DeleteFlow(ctx context.Context, flowID string) error {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return err
+	}
+
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	run, running := m.runs[flowID]
+	m.mu.RUnlock()
+	if running {
+		run.cancel()
+		<-run.done
+	}
 
-	handlers, exists := m.OutputHandlers[flowID]
-	if !exists || len(handlers) == 0 {
-		return
+	if disposable, ok := flow.(Disposable); ok {
+		if err := disposable.Dispose(ctx); err != nil {
+			return fmt.Errorf("failed to dispose of flow %s: %w", flowID, err)
+		}
+	}
+
+	return m.RemoveFlow(flowID)
+}
+
+// History returns the conversations, plan and commands of a flow
+func (m *FlowManager) History(flowID string) (FlowHistory, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return FlowHistory{}, err
+	}
+
+	source, ok := flow.(HistorySource)
+	if !ok {
+		return FlowHistory{}, fmt.Errorf("flow with ID %s has no history", flowID)
+	}
+
+	return source.History(), nil
+}
+
+// Subscribe registers a subscriber for the events of a flow and its agents.
+// The returned function removes the subscriber.
+func (m *FlowManager) Subscribe(flowID string, subscriber agent.Subscriber) (func(), error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	source, ok := flow.(EventSource)
+	if !ok {
+		return nil, fmt.Errorf("flow with ID %s does not publish events", flowID)
+	}
+
+	return source.EventBus().Subscribe(subscriber), nil
+}
+
+// PendingApprovals returns the tool calls of a flow waiting for approval
+func (m *FlowManager) PendingApprovals(flowID string) ([]agent.ApprovalRequest, error) {
+	queue, err := m.approvalQueue(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	return queue.Pending(), nil
+}
+
+// ResolveApproval approves or rejects a tool call waiting for approval
+func (m *FlowManager) ResolveApproval(flowID, approvalID string, decision agent.ApprovalDecision) error {
+	queue, err := m.approvalQueue(flowID)
+	if err != nil {
+		return err
+	}
+
+	return queue.Resolve(approvalID, decision)
+}
+
+// PlanRevisions returns the revisions of a flow's plan, oldest first
+func (m *FlowManager) PlanRevisions(flowID string) ([]PlanRevision, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	source, ok := flow.(RevisionSource)
+	if !ok {
+		return nil, fmt.Errorf("flow with ID %s has no plan", flowID)
+	}
+
+	return source.PlanRevisions(), nil
+}
+
+// approvalQueue returns the approval queue of a flow
+func (m *FlowManager) approvalQueue(flowID string) (*agent.ApprovalQueue, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, handler := range handlers {
-		handler(output)
+	source, ok := flow.(ApprovalSource)
+	if !ok {
+		return nil, fmt.Errorf("flow with ID %s does not support approvals", flowID)
 	}
+
+	return source.ApprovalQueue(), nil
 }
 
 // RunFlow runs a flow with the given request
@@ -123,8 +494,103 @@ func (m *FlowManager) RunFlow(ctx context.Context, flowID string, request *FlowR
 		return nil, err
 	}
 
-	// Run the flow
-	return flow.Run(ctx, request)
+	// A request made for a user may only run the user's flows
+	if request.User != "" {
+		record, err := m.GetFlowRecord(flowID)
+		if err != nil {
+			return nil, err
+		}
+		if record.Owner != "" && record.Owner != request.User {
+			return nil, fmt.Errorf("flow with ID %s is not owned by %s", flowID, request.User)
+		}
+	}
+
+	return m.runFlow(ctx, flowID, flow, request.Input, func(ctx context.Context) (*FlowResponse, error) {
+		return flow.Run(ctx, request)
+	})
+}
+
+// ResumeFlow continues a flow that stopped before its run finished, such as
+// an interrupted flow, from the state it saved
+func (m *FlowManager) ResumeFlow(ctx context.Context, flowID string) (*FlowResponse, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	resumable, ok := flow.(Resumable)
+	if !ok {
+		return nil, fmt.Errorf("flow with ID %s cannot be resumed", flowID)
+	}
+	if state := flow.GetState(); state == StateRunning || state == StateAwaitingApproval {
+		return nil, fmt.Errorf("flow with ID %s is already running", flowID)
+	}
+
+	record, err := m.GetFlowRecord(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.runFlow(ctx, flowID, flow, record.Goal, resumable.Resume)
+}
+
+// runFlow runs a flow, attributing its LLM usage and events to the flow ID,
+// and records its goal and state. A flow runs once at a time, and its run
+// can be cancelled with CancelFlow.
+func (m *FlowManager) runFlow(ctx context.Context, flowID string, flow Flow, input string, run func(context.Context) (*FlowResponse, error)) (*FlowResponse, error) {
+	ctx, cancel := context.WithCancel(llm.WithUsageScope(ctx, llm.UsageScope{FlowID: flowID}))
+	current := &flowRun{cancel: cancel, done: make(chan struct{})}
+
+	m.mu.Lock()
+	if _, running := m.runs[flowID]; running {
+		m.mu.Unlock()
+		cancel()
+		return nil, fmt.Errorf("flow with ID %s is already running", flowID)
+	}
+	m.runs[flowID] = current
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		delete(m.runs, flowID)
+		m.mu.Unlock()
+		cancel()
+		close(current.done)
+	}()
+
+	m.updateRecord(flowID, func(record *FlowRecord) {
+		record.Goal = input
+		record.State = StateRunning
+	})
+	defer m.updateRecord(flowID, func(record *FlowRecord) {
+		record.State = flow.GetState()
+	})
+
+	source, publishes := flow.(EventSource)
+	if publishes {
+		source.EventBus().Publish(FlowStartedEvent{
+			EventInfo: agent.NewEventInfo(ctx, EventFlowStarted),
+			Input:     input,
+		})
+	}
+
+	response, err := run(ctx)
+
+	if publishes {
+		event := FlowFinishedEvent{
+			EventInfo: agent.NewEventInfo(ctx, EventFlowFinished),
+		}
+		if err != nil {
+			event.Error = err.Error()
+		} else if response != nil {
+			event.Success = response.Success
+			event.Output = response.Output
+			event.Error = response.Error
+		}
+		source.EventBus().Publish(event)
+	}
+
+	return response, err
 }
 
 // GetCommandStatus retrieves the status of a background command
@@ -145,6 +611,89 @@ func (m *FlowManager) GetCommandStatus(flowID string, commandID string) (*execut
 	return planningFlow.ExecutionPipeline.GetCommandStatus(commandID)
 }
 
+// SendKeys writes keystrokes to a background command running in a
+// pseudo-terminal
+func (m *FlowManager) SendKeys(flowID string, commandID string, keys string) error {
+	cmd, err := m.command(flowID, commandID)
+	if err != nil {
+		return err
+	}
+
+	return cmd.SendKeys(keys)
+}
+
+// ResizeTerminal changes the terminal size of a background command running
+// in a pseudo-terminal
+func (m *FlowManager) ResizeTerminal(flowID string, commandID string, rows, cols uint16) error {
+	cmd, err := m.command(flowID, commandID)
+	if err != nil {
+		return err
+	}
+
+	return cmd.Resize(rows, cols)
+}
+
+// WriteInput writes to the stdin of a background command, and closes it
+// afterwards if eof is set
+func (m *FlowManager) WriteInput(flowID string, commandID string, input string, eof bool) error {
+	cmd, err := m.command(flowID, commandID)
+	if err != nil {
+		return err
+	}
+
+	if input != "" {
+		if err := cmd.WriteInput(input); err != nil {
+			return err
+		}
+	}
+	if eof {
+		return cmd.CloseInput()
+	}
+
+	return nil
+}
+
+// SignalCommand sends a signal to the process group of a background command.
+// With a positive grace period, the group is killed if the command is still
+// running after it.
+func (m *FlowManager) SignalCommand(flowID string, commandID string, sig syscall.Signal, grace time.Duration) error {
+	cmd, err := m.command(flowID, commandID)
+	if err != nil {
+		return err
+	}
+
+	if grace > 0 {
+		return cmd.Terminate(sig, grace)
+	}
+
+	return cmd.Signal(sig)
+}
+
+// ReadCommandOutput reads lines of a command's output
+func (m *FlowManager) ReadCommandOutput(flowID string, commandID string, query executor.OutputQuery) (*executor.OutputPage, error) {
+	cmd, err := m.command(flowID, commandID)
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd.ReadOutput(query)
+}
+
+// command returns a background command of a planning flow
+func (m *FlowManager) command(flowID string, commandID string) (*executor.BackgroundCommand, error) {
+	flow, err := m.GetFlow(flowID)
+	if err != nil {
+		return nil, err
+	}
+
+	planningFlow, ok := flow.(*PlanningFlow)
+	if !ok {
+		return nil, fmt.Errorf("flow with ID %s is not a planning flow", flowID)
+	}
+
+	return planningFlow.ExecutionPipeline.GetCommand(commandID)
+}
+
 // ListFlows returns a list of all active flows
 func (m *FlowManager) ListFlows() []string {
 	m.mu.RLock()
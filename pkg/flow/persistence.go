@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FlowRecord describes a managed flow. It is what a FlowStore persists, so
+// that the flow can be resumed after a restart; the plan itself is kept in
+// the flow's memory.
+type FlowRecord struct {
+	ID   string   `json:"id"`
+	Type FlowType `json:"type"`
+	// Owner is the user who created the flow, if it was created for one
+	Owner string `json:"owner,omitempty"`
+	// Goal is the input of the flow's latest run
+	Goal  string `json:"goal,omitempty"`
+	State State  `json:"state"`
+	// CurrentStep is the plan step that started last
+	CurrentStep string    `json:"current_step,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// FlowStore persists the records of managed flows
+type FlowStore interface {
+	// SaveFlow creates or replaces the record of a flow
+	SaveFlow(record FlowRecord) error
+
+	// LoadFlows returns the records of all stored flows
+	LoadFlows() ([]FlowRecord, error)
+
+	// DeleteFlow removes the record of a flow
+	DeleteFlow(id string) error
+}
+
+// FileFlowStore stores the record of each flow in <id>.json in a directory
+type FileFlowStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewFileFlowStore creates a flow store in the given directory
+func NewFileFlowStore(dir string) (*FileFlowStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create flow directory: %w", err)
+	}
+
+	return &FileFlowStore{Dir: dir}, nil
+}
+
+// path returns the path of the record of a flow
+func (s *FileFlowStore) path(id string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+".json")
+}
+
+// SaveFlow writes the record of a flow, replacing it atomically
+func (s *FileFlowStore) SaveFlow(record FlowRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal flow record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(record.ID)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write flow record: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write flow record: %w", err)
+	}
+
+	return nil
+}
+
+// LoadFlows reads the records of all stored flows, oldest first
+func (s *FileFlowStore) LoadFlows() ([]FlowRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flows: %w", err)
+	}
+
+	records := make([]FlowRecord, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read flow record %s: %w", path, err)
+		}
+
+		var record FlowRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse flow record %s: %w", path, err)
+		}
+		// A record is only trusted under the name it was saved with
+		if s.path(record.ID) != path {
+			return nil, fmt.Errorf("flow record %s has the ID of another flow: %s", path, record.ID)
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
+
+// DeleteFlow removes the record of a flow
+func (s *FileFlowStore) DeleteFlow(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete flow %s: %w", id, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package flow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoresRejectRecordsUnderAnotherName(t *testing.T) {
+	flows, err := NewFileFlowStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := flows.SaveFlow(FlowRecord{ID: "flow1", Owner: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if records, err := flows.LoadFlows(); err != nil || len(records) != 1 {
+		t.Fatalf("LoadFlows() = %v, %v", records, err)
+	}
+
+	// A copy of the record under another name must not pass for that flow
+	data, err := os.ReadFile(filepath.Join(flows.Dir, "flow1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(flows.Dir, "flow2.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := flows.LoadFlows(); err == nil {
+		t.Error("LoadFlows accepted a flow record saved under another ID")
+	}
+
+	runs, err := NewFileRunStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(runs.Dir, "run1.json"), []byte(`{"id": "run2", "status": "queued"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runs.LoadRuns(); err == nil {
+		t.Error("LoadRuns accepted a run record saved under another ID")
+	}
+}
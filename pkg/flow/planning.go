@@ -5,7 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/prathyushnallamothu/commandforge/pkg/agent"
 	"github.com/prathyushnallamothu/commandforge/pkg/executor"
@@ -17,15 +17,26 @@ type PlanStep struct {
 	ID          string `json:"id"`
 	Description string `json:"description"`
 	Command     string `json:"command,omitempty"`
-	Status      string `json:"status"` // pending, running, completed, failed
-	Output      string `json:"output,omitempty"`
-	Error       string `json:"error,omitempty"`
+	// DependsOn lists the IDs of the steps that must complete before this one
+	DependsOn []string `json:"depends_on,omitempty"`
+	// Retries is how many more times the step is tried after failing
+	Retries int `json:"retries,omitempty"`
+	// TimeoutSeconds limits each attempt of the step's command
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+	Status         string `json:"status"` // pending, running, completed, failed, skipped
+	Attempts       int    `json:"attempts,omitempty"`
+	// CommandID is the ID of the background command of the latest attempt
+	CommandID string `json:"command_id,omitempty"`
+	Output    string `json:"output,omitempty"`
+	Error     string `json:"error,omitempty"`
 }
 
-// Plan represents a sequence of steps to accomplish a task
+// Plan represents a graph of steps to accomplish a task
 type Plan struct {
 	Goal  string     `json:"goal"`
 	Steps []PlanStep `json:"steps"`
+	// Revision counts the times the plan was patched after a step failed
+	Revision int `json:"revision"`
 }
 
 // PlanningFlow coordinates multiple agents to handle complex tasks
@@ -37,7 +48,20 @@ type PlanningFlow struct {
 	ExecutorAgent     agent.Agent
 	CurrentPlan       *Plan
 	ExecutionPipeline *ExecutionPipeline
-	OutputListeners   []func(string)
+	// MaxParallelSteps limits how many steps run at once
+	MaxParallelSteps int
+	// FailurePolicy decides what happens to the rest of the plan when a step
+	// fails
+	FailurePolicy FailurePolicy
+	// ReplanBudget limits how many times the planner can patch the plan
+	// after steps fail
+	ReplanBudget int
+
+	// planMu guards the current plan. It is only changed by the run of the
+	// flow, under the lock; other goroutines read it with PlanSnapshot.
+	planMu      sync.RWMutex
+	revisions   []PlanRevision
+	revisionsMu sync.Mutex
 }
 
 // NewPlanningFlow creates a new planning flow
@@ -48,7 +72,9 @@ func NewPlanningFlow(llmClient llm.Client, memory Memory, agentFactory *agent.Fa
 		AgentFactory:      agentFactory,
 		CurrentPlan:       nil,
 		ExecutionPipeline: NewExecutionPipeline("/"),
-		OutputListeners:   make([]func(string), 0),
+		MaxParallelSteps:  DefaultMaxParallelSteps,
+		FailurePolicy:     FailureSkipDependents,
+		ReplanBudget:      DefaultReplanBudget,
 	}
 
 	// Create the planner agent (ReAct agent for reasoning)
@@ -57,49 +83,89 @@ func NewPlanningFlow(llmClient llm.Client, memory Memory, agentFactory *agent.Fa
 	// Create the executor agent (ToolCall agent for execution)
 	flow.ExecutorAgent = agentFactory.CreateAgent(agent.AgentTypeToolCall)
 
+	// Publish the events of both agents on the flow's event bus
+	for _, a := range []agent.Agent{flow.PlannerAgent, flow.ExecutorAgent} {
+		if hookable, ok := a.(interface{ AddHook(agent.Hook) }); ok {
+			hookable.AddHook(flow.Events.Hook())
+		}
+	}
+
 	// Add a status listener to the execution pipeline
 	flow.ExecutionPipeline.AddStatusListener(func(commandID string, result *ExecutionResult) {
-		// Find the step with this command ID
+		// Find the step with this command ID and update its status based
+		// on the result
+		var finished *PlanStep
+		flow.planMu.Lock()
 		if flow.CurrentPlan != nil {
 			for i := range flow.CurrentPlan.Steps {
 				step := &flow.CurrentPlan.Steps[i]
 				if step.ID == commandID {
-					// Update the step status based on the result
 					if result.Success {
-						step.Status = "completed"
+						step.Status = StepCompleted
 					} else {
-						step.Status = "failed"
+						step.Status = StepFailed
 					}
 					step.Output = result.Output
 					step.Error = result.Error
-
-					// Notify output listeners
-					for _, listener := range flow.OutputListeners {
-						outputUpdate := fmt.Sprintf("Step %s: %s\nStatus: %s", step.ID, step.Description, step.Status)
-						if result.Output != "" {
-							outputUpdate += fmt.Sprintf("\nOutput: %s", result.Output)
-						}
-						if result.Error != "" {
-							outputUpdate += fmt.Sprintf("\nError: %s", result.Error)
-						}
-						listener(outputUpdate)
-					}
-
-					// Save the updated plan
-					ctx := context.Background()
-					if err := flow.savePlan(ctx); err != nil {
-						// Just log the error, don't interrupt execution
-						fmt.Printf("Error saving plan: %v\n", err)
-					}
+					copied := *step
+					finished = &copied
 					break
 				}
 			}
 		}
+		flow.planMu.Unlock()
+		if finished == nil {
+			return
+		}
+
+		// Publish the finished step
+		ctx := context.Background()
+		flow.Events.Publish(StepEvent{
+			EventInfo: flow.eventInfo(ctx, EventStepFinished),
+			Step:      *finished,
+		})
+
+		// Save the updated plan
+		if err := flow.savePlan(ctx); err != nil {
+			// Just log the error, don't interrupt execution
+			fmt.Printf("Error saving plan: %v\n", err)
+		}
 	})
 
 	return flow
 }
 
+// WithApprovalPolicy applies an approval policy to the tool calls of the
+// flow's agents. Calls that need approval pause the flow until they are
+// resolved through its approval queue.
+func (f *PlanningFlow) WithApprovalPolicy(policy *agent.ApprovalPolicy) *PlanningFlow {
+	for _, a := range []agent.Agent{f.PlannerAgent, f.ExecutorAgent} {
+		if hookable, ok := a.(interface{ AddHook(agent.Hook) }); ok {
+			hookable.AddHook(policy.Hook(f.BaseFlow))
+		}
+	}
+	return f
+}
+
+// WithMaxParallelSteps sets how many steps of a plan run at once
+func (f *PlanningFlow) WithMaxParallelSteps(n int) *PlanningFlow {
+	f.MaxParallelSteps = n
+	return f
+}
+
+// WithFailurePolicy sets what happens to the rest of a plan when a step fails
+func (f *PlanningFlow) WithFailurePolicy(policy FailurePolicy) *PlanningFlow {
+	f.FailurePolicy = policy
+	return f
+}
+
+// WithReplanBudget sets how many times the planner can patch a plan after
+// steps fail; zero disables replanning
+func (f *PlanningFlow) WithReplanBudget(n int) *PlanningFlow {
+	f.ReplanBudget = n
+	return f
+}
+
 // Initialize initializes the flow
 func (f *PlanningFlow) Initialize(ctx context.Context) error {
 	// Initialize the base flow
@@ -128,7 +194,7 @@ func (f *PlanningFlow) Run(ctx context.Context, request *FlowRequest) (*FlowResp
 	// Generate a plan
 	plan, err := f.generatePlan(ctx, request.Input)
 	if err != nil {
-		f.setState(StateError)
+		f.setState(failureState(ctx))
 		return &FlowResponse{
 			Output:  "",
 			Success: false,
@@ -137,11 +203,13 @@ func (f *PlanningFlow) Run(ctx context.Context, request *FlowRequest) (*FlowResp
 	}
 
 	// Store the current plan
+	f.planMu.Lock()
 	f.CurrentPlan = plan
+	f.planMu.Unlock()
 
 	// Save the plan to memory
 	if err := f.savePlan(ctx); err != nil {
-		f.setState(StateError)
+		f.setState(failureState(ctx))
 		return &FlowResponse{
 			Output:  "",
 			Success: false,
@@ -149,22 +217,26 @@ func (f *PlanningFlow) Run(ctx context.Context, request *FlowRequest) (*FlowResp
 		}, nil
 	}
 
-	// Notify listeners about the plan
-	planDescription := fmt.Sprintf("Generated plan for: %s\n", f.CurrentPlan.Goal)
-	for i, step := range f.CurrentPlan.Steps {
-		planDescription += fmt.Sprintf("Step %d: %s\n", i+1, step.Description)
-		if step.Command != "" {
-			planDescription += fmt.Sprintf("  Command: %s\n", step.Command)
-		}
-	}
-	for _, listener := range f.OutputListeners {
-		listener(planDescription)
+	// Keep the plan as its first revision
+	if err := f.resetRevisions(ctx); err != nil {
+		f.setState(failureState(ctx))
+		return &FlowResponse{
+			Output:  "",
+			Success: false,
+			Error:   fmt.Sprintf("Failed to save plan: %v", err),
+		}, nil
 	}
 
+	// Publish the plan
+	f.Events.Publish(PlanGeneratedEvent{
+		EventInfo: f.eventInfo(ctx, EventPlanGenerated),
+		Plan:      *f.PlanSnapshot(),
+	})
+
 	// Execute the plan
 	result, err := f.executePlan(ctx)
 	if err != nil {
-		f.setState(StateError)
+		f.setState(failureState(ctx))
 		return &FlowResponse{
 			Output:  "",
 			Success: false,
@@ -184,12 +256,17 @@ func (f *PlanningFlow) Run(ctx context.Context, request *FlowRequest) (*FlowResp
 
 // generatePlan uses the planner agent to create a plan
 func (f *PlanningFlow) generatePlan(ctx context.Context, input string) (*Plan, error) {
-	// Create a system prompt for the planner
-	systemPrompt := `You are a planning agent. Your task is to break down complex requests into a series of steps.
+	// Tell the planner how to plan. The instructions go in the input, since
+	// the planner sends its own system prompt.
+	instructions := `You are a planning agent. Your task is to break down complex requests into a series of steps.
 
 For each step, provide:
-1. A clear description of what needs to be done
+1. A unique ID and a clear description of what needs to be done
 2. If applicable, a command to execute
+3. The IDs of the steps that must complete before it can start, in depends_on. Steps that do not depend on each other run in parallel, so only list real dependencies, such as a build before its tests
+4. Optionally, retries for steps that may fail transiently, like downloads, and timeout_seconds for commands that could hang
+
+When a step fails, you will be asked how to patch the plan. Steps that depend on a failed step are skipped.
 
 Respond with a JSON object in the following format:
 {
@@ -200,19 +277,25 @@ Respond with a JSON object in the following format:
       "description": "Description of the step",
       "command": "Command to execute (if applicable)"
     },
+    {
+      "id": "step2",
+      "description": "Description of a step that needs step1",
+      "command": "Command to execute (if applicable)",
+      "depends_on": ["step1"],
+      "retries": 2,
+      "timeout_seconds": 600
+    },
     ...
   ]
 }`
 
 	// Create the request for the planner agent
 	plannerRequest := &agent.Request{
-		Input: input,
-		Context: map[string]interface{}{
-			"system_prompt": systemPrompt,
-		},
+		Input: fmt.Sprintf("%s\n\nRequest:\n%s", instructions, input),
 	}
 
-	// Run the planner agent
+	// Run the planner agent, attributing its usage to the planning step
+	ctx = llm.WithUsageScope(ctx, llm.UsageScope{StepID: "plan"})
 	plannerResponse, err := f.PlannerAgent.Run(ctx, plannerRequest)
 	if err != nil {
 		return nil, fmt.Errorf("planner agent error: %w", err)
@@ -230,7 +313,7 @@ Respond with a JSON object in the following format:
 
 	// Initialize step statuses
 	for i := range plan.Steps {
-		plan.Steps[i].Status = "pending"
+		plan.Steps[i].Status = StepPending
 	}
 
 	return plan, nil
@@ -258,90 +341,48 @@ func parsePlan(output string) (*Plan, error) {
 	if len(plan.Steps) == 0 {
 		return nil, fmt.Errorf("plan contains no steps")
 	}
+	if err := validatePlan(&plan); err != nil {
+		return nil, fmt.Errorf("invalid plan: %w", err)
+	}
 
 	return &plan, nil
 }
 
-// executePlan runs each step in the plan
+// executePlan runs the steps of the plan, each once its dependencies have
+// completed
 func (f *PlanningFlow) executePlan(ctx context.Context) (string, error) {
-	if f.CurrentPlan == nil || len(f.CurrentPlan.Steps) == 0 {
+	if plan := f.PlanSnapshot(); plan == nil || len(plan.Steps) == 0 {
 		return "", fmt.Errorf("no plan to execute")
 	}
 
-	results := []string{fmt.Sprintf("Executing plan for: %s\n", f.CurrentPlan.Goal)}
-
-	// Execute each step in sequence
-	for i := range f.CurrentPlan.Steps {
-		step := &f.CurrentPlan.Steps[i]
-		step.Status = "running"
-
-		// Save the updated plan
-		if err := f.savePlan(ctx); err != nil {
-			return "", fmt.Errorf("failed to save plan: %w", err)
-		}
+	err := f.runSteps(ctx)
 
+	// Report the outcome of each step
+	plan := f.PlanSnapshot()
+	results := []string{fmt.Sprintf("Executing plan for: %s\n", plan.Goal)}
+	for _, step := range plan.Steps {
 		results = append(results, fmt.Sprintf("\nStep %s: %s", step.ID, step.Description))
-
-		// If the step has a command, execute it
 		if step.Command != "" {
 			results = append(results, fmt.Sprintf("Executing: %s", step.Command))
-
-			// Notify output listeners that we're starting this step
-			for _, listener := range f.OutputListeners {
-				listener(fmt.Sprintf("Starting step %s: %s\nExecuting: %s", step.ID, step.Description, step.Command))
-			}
-
-			// Execute the command in the background with streaming output
-			commandID, err := f.ExecuteCommandInBackground(step.Command)
-			if err != nil {
-				step.Status = "failed"
-				step.Error = fmt.Sprintf("Execution error: %v", err)
-				results = append(results, fmt.Sprintf("Error: %s", step.Error))
-
-				// Save the updated plan
-				if saveErr := f.savePlan(ctx); saveErr != nil {
-					return strings.Join(results, "\n"), fmt.Errorf("failed to save plan: %w", saveErr)
-				}
-
-				// Continue with the next step despite the error
-				continue
-			}
-
-			// Store the command ID in the step for later status checks
-			step.ID = commandID
-
-			// Mark the step as running - the actual status will be checked later
-			step.Status = "running"
-			results = append(results, fmt.Sprintf("Command started with ID: %s", commandID))
-
-			// Wait for a short time to get initial output (optional)
-			select {
-			case <-ctx.Done():
-				// Context canceled
-				step.Status = "failed"
-				step.Error = "Command execution was canceled"
-			case <-time.After(500 * time.Millisecond):
-				// Get initial status
-				status, err := f.ExecutionPipeline.GetCommandStatus(commandID)
-				if err == nil && len(status.OutputList) > 0 {
-					outputLen := len(status.OutputList)
-					linesToShow := 5
-					if outputLen < linesToShow {
-						linesToShow = outputLen
-					}
-					results = append(results, fmt.Sprintf("Initial output: %s", strings.Join(status.OutputList[:linesToShow], "\n")))
-				}
-			}
-		} else {
-			// If there's no command, mark the step as completed
-			step.Status = "completed"
 		}
-
-		// Save the updated plan
-		if err := f.savePlan(ctx); err != nil {
-			return strings.Join(results, "\n"), fmt.Errorf("failed to save plan: %w", err)
+		if step.CommandID != "" {
+			results = append(results, fmt.Sprintf("Command ID: %s", step.CommandID))
+		}
+		status := fmt.Sprintf("Status: %s", step.Status)
+		if step.Attempts > 1 {
+			status += fmt.Sprintf(" after %d attempts", step.Attempts)
+		}
+		results = append(results, status)
+		if step.Output != "" {
+			results = append(results, fmt.Sprintf("Output: %s", step.Output))
+		}
+		if step.Error != "" {
+			results = append(results, fmt.Sprintf("Error: %s", step.Error))
 		}
 	}
+	if err != nil {
+		return strings.Join(results, "\n"), err
+	}
 
 	// Generate a summary of the execution
 	summary := f.generateSummary(ctx)
@@ -350,26 +391,48 @@ func (f *PlanningFlow) executePlan(ctx context.Context) (string, error) {
 	return strings.Join(results, "\n"), nil
 }
 
-// savePlan stores the current plan in memory
-func (f *PlanningFlow) savePlan(ctx context.Context) error {
+// SetID sets the ID the flow is managed under, which also owns the
+// background commands it starts
+func (f *PlanningFlow) SetID(id string) {
+	f.BaseFlow.SetID(id)
+	f.ExecutionPipeline.Owner = id
+}
+
+// PlanSnapshot returns a copy of the current plan, or nil if the flow has
+// none. It is safe to call while the flow runs.
+func (f *PlanningFlow) PlanSnapshot() *Plan {
+	f.planMu.RLock()
+	defer f.planMu.RUnlock()
+
 	if f.CurrentPlan == nil {
 		return nil
 	}
+	plan := copyPlan(f.CurrentPlan)
+	return &plan
+}
 
+// savePlan stores the current plan in memory
+func (f *PlanningFlow) savePlan(ctx context.Context) error {
 	// Convert the plan to JSON
+	f.planMu.RLock()
+	if f.CurrentPlan == nil {
+		f.planMu.RUnlock()
+		return nil
+	}
 	planJSON, err := json.Marshal(f.CurrentPlan)
+	f.planMu.RUnlock()
 	if err != nil {
 		return fmt.Errorf("failed to marshal plan: %w", err)
 	}
 
 	// Save the plan to memory
-	return f.SaveState(ctx, "current_plan", string(planJSON))
+	return f.SaveState(ctx, f.stateKey("current_plan"), string(planJSON))
 }
 
 // loadPlan retrieves a stored plan from memory
 func (f *PlanningFlow) loadPlan(ctx context.Context) error {
 	// Load the plan from memory
-	value, err := f.LoadState(ctx, "current_plan")
+	value, err := f.LoadState(ctx, f.stateKey("current_plan"))
 	if err != nil {
 		return fmt.Errorf("failed to load plan: %w", err)
 	}
@@ -387,7 +450,9 @@ func (f *PlanningFlow) loadPlan(ctx context.Context) error {
 	}
 
 	// Set the current plan
+	f.planMu.Lock()
 	f.CurrentPlan = &plan
+	f.planMu.Unlock()
 
 	return nil
 }
@@ -400,14 +465,33 @@ func (f *PlanningFlow) ExecuteCommandInBackground(command string) (string, error
 		return "", fmt.Errorf("failed to execute background command: %w", err)
 	}
 
-	// Notify listeners about the command execution
-	for _, listener := range f.OutputListeners {
-		listener(fmt.Sprintf("Started background command: %s (ID: %s)", command, commandID))
+	f.publishCommandStarted(commandID, command)
+
+	return commandID, nil
+}
+
+// ExecuteCommandInTerminal runs a command in the background in a
+// pseudo-terminal of the given size and returns its ID
+func (f *PlanningFlow) ExecuteCommandInTerminal(command string, rows, cols uint16) (string, error) {
+	commandID, err := f.ExecutionPipeline.ExecuteCommandInTerminal(command, rows, cols)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute background command: %w", err)
 	}
 
+	f.publishCommandStarted(commandID, command)
+
 	return commandID, nil
 }
 
+// publishCommandStarted publishes the start of a background command
+func (f *PlanningFlow) publishCommandStarted(commandID, command string) {
+	f.Events.Publish(CommandStartedEvent{
+		EventInfo: f.eventInfo(context.Background(), EventCommandStarted),
+		CommandID: commandID,
+		Command:   command,
+	})
+}
+
 // GetCommandStatusUpdates checks the status of a background command and notifies listeners
 func (f *PlanningFlow) GetCommandStatusUpdates(commandID string) (*executor.BackgroundCommandStatus, error) {
 	// Get the command status
@@ -416,41 +500,17 @@ func (f *PlanningFlow) GetCommandStatusUpdates(commandID string) (*executor.Back
 		return nil, fmt.Errorf("failed to get command status: %w", err)
 	}
 
-	// Notify listeners about the command status
-	for _, listener := range f.OutputListeners {
-		// Only notify if there's output or error to report
-		if len(status.OutputList) > 0 || len(status.ErrorList) > 0 {
-			var statusMsg string
-			if status.Running {
-				statusMsg = "Running"
-			} else if status.ExitCode == 0 {
-				statusMsg = "Completed successfully"
-			} else {
-				statusMsg = fmt.Sprintf("Failed with exit code %d", status.ExitCode)
-			}
-
-			listener(fmt.Sprintf("Command status update (ID: %s): %s\nDuration: %.2f seconds", commandID, statusMsg, status.Duration))
-
-			// Include the latest output lines (up to 10)
-			if len(status.OutputList) > 0 {
-				outputLen := len(status.OutputList)
-				linesToShow := 10
-				if outputLen < linesToShow {
-					linesToShow = outputLen
-				}
-				listener(fmt.Sprintf("Output: %s", strings.Join(status.OutputList[outputLen-linesToShow:], "\n")))
-			}
-
-			// Include the latest error lines (up to 5)
-			if len(status.ErrorList) > 0 {
-				errorLen := len(status.ErrorList)
-				linesToShow := 5
-				if errorLen < linesToShow {
-					linesToShow = errorLen
-				}
-				listener(fmt.Sprintf("Error: %s", strings.Join(status.ErrorList[errorLen-linesToShow:], "\n")))
-			}
-		}
+	// Publish the latest output lines (up to 10) and error lines (up to 5)
+	if len(status.OutputList) > 0 || len(status.ErrorList) > 0 {
+		f.Events.Publish(CommandStatusEvent{
+			EventInfo: f.eventInfo(context.Background(), EventCommandStatus),
+			CommandID: commandID,
+			Running:   status.Running,
+			ExitCode:  status.ExitCode,
+			Duration:  status.Duration,
+			Output:    lastLines(status.OutputList, 10),
+			Errors:    lastLines(status.ErrorList, 5),
+		})
 	}
 
 	return status, nil
@@ -458,29 +518,35 @@ func (f *PlanningFlow) GetCommandStatusUpdates(commandID string) (*executor.Back
 
 // generateSummary creates a summary of the plan execution
 func (f *PlanningFlow) generateSummary(ctx context.Context) string {
-	if f.CurrentPlan == nil {
+	plan := f.PlanSnapshot()
+	if plan == nil {
 		return "No plan available"
 	}
 
 	// Count steps by status
-	total := len(f.CurrentPlan.Steps)
+	total := len(plan.Steps)
 	completed := 0
 	failed := 0
+	skipped := 0
 
-	for _, step := range f.CurrentPlan.Steps {
-		if step.Status == "completed" {
+	for _, step := range plan.Steps {
+		switch step.Status {
+		case StepCompleted:
 			completed++
-		} else if step.Status == "failed" {
+		case StepFailed:
 			failed++
+		case StepSkipped:
+			skipped++
 		}
 	}
 
 	// Generate the summary
 	summary := fmt.Sprintf("Plan Execution Summary:\n")
-	summary += fmt.Sprintf("Goal: %s\n", f.CurrentPlan.Goal)
+	summary += fmt.Sprintf("Goal: %s\n", plan.Goal)
 	summary += fmt.Sprintf("Total Steps: %d\n", total)
 	summary += fmt.Sprintf("Completed: %d\n", completed)
 	summary += fmt.Sprintf("Failed: %d\n", failed)
+	summary += fmt.Sprintf("Skipped: %d\n", skipped)
 
 	// Add overall status
 	if failed > 0 {
@@ -491,10 +557,13 @@ func (f *PlanningFlow) generateSummary(ctx context.Context) string {
 		summary += "Status: Partially completed"
 	}
 
-	// Notify output listeners of the summary
-	for _, listener := range f.OutputListeners {
-		listener(summary)
-	}
-
 	return summary
 }
+
+// lastLines returns up to n lines from the end of lines
+func lastLines(lines []string, n int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	return lines[len(lines)-n:]
+}
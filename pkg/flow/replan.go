@@ -0,0 +1,514 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+)
+
+// DefaultReplanBudget is how many times a plan is revised after failed steps
+// by default
+const DefaultReplanBudget = 3
+
+// Plan patch operations
+const (
+	// PatchInsert adds a new step
+	PatchInsert = "insert"
+	// PatchRewrite changes a step that has not completed, and runs it again
+	PatchRewrite = "rewrite"
+	// PatchDrop removes a step that has not completed
+	PatchDrop = "drop"
+)
+
+// PatchOperation is a change the planner makes to a plan
+type PatchOperation struct {
+	Op string `json:"op"`
+	// StepID is the step to rewrite or drop
+	StepID string `json:"step_id,omitempty"`
+	// After is the step an inserted step is placed after in the plan; by
+	// default the failed step. It does not make the step depend on it.
+	After string `json:"after,omitempty"`
+	// Step is the inserted step, or the fields to change in a rewritten one
+	Step *PlanStep `json:"step,omitempty"`
+}
+
+// PlanPatch is the planner's answer to a failed step
+type PlanPatch struct {
+	Reason     string           `json:"reason"`
+	Operations []PatchOperation `json:"operations"`
+}
+
+// PlanRevision records a version of the plan and how it differs from the
+// previous one. Revision 0 is the plan as first generated.
+type PlanRevision struct {
+	Revision int       `json:"revision"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	// FailedStep is the step whose failure led to the revision
+	FailedStep string     `json:"failed_step,omitempty"`
+	Patch      *PlanPatch `json:"patch,omitempty"`
+	Diff       string     `json:"diff,omitempty"`
+	Plan       Plan       `json:"plan"`
+}
+
+// RevisionSource is implemented by flows that keep the revisions of their plan
+type RevisionSource interface {
+	// PlanRevisions returns the revisions of the current plan, oldest first
+	PlanRevisions() []PlanRevision
+}
+
+// PlanRevisions returns the revisions of the current plan, oldest first
+func (f *PlanningFlow) PlanRevisions() []PlanRevision {
+	f.revisionsMu.Lock()
+	defer f.revisionsMu.Unlock()
+
+	revisions := make([]PlanRevision, len(f.revisions))
+	copy(revisions, f.revisions)
+	return revisions
+}
+
+// resetRevisions starts the revisions of a newly generated plan
+func (f *PlanningFlow) resetRevisions(ctx context.Context) error {
+	f.revisionsMu.Lock()
+	f.revisions = nil
+	f.revisionsMu.Unlock()
+
+	f.planMu.Lock()
+	f.CurrentPlan.Revision = 0
+	f.planMu.Unlock()
+
+	_, err := f.addRevision(ctx, PlanRevision{Reason: "Initial plan"})
+	return err
+}
+
+// addRevision records the current plan as a revision, and saves all
+// revisions to memory
+func (f *PlanningFlow) addRevision(ctx context.Context, revision PlanRevision) (PlanRevision, error) {
+	revision.Plan = *f.PlanSnapshot()
+	revision.Revision = revision.Plan.Revision
+	revision.Time = time.Now()
+
+	f.revisionsMu.Lock()
+	f.revisions = append(f.revisions, revision)
+	revisionsJSON, err := json.Marshal(f.revisions)
+	f.revisionsMu.Unlock()
+	if err != nil {
+		return revision, fmt.Errorf("failed to marshal plan revisions: %w", err)
+	}
+
+	return revision, f.SaveState(ctx, f.stateKey("plan_revisions"), string(revisionsJSON))
+}
+
+// loadRevisions retrieves the stored revisions of the plan from memory
+func (f *PlanningFlow) loadRevisions(ctx context.Context) error {
+	value, err := f.LoadState(ctx, f.stateKey("plan_revisions"))
+	if err != nil {
+		return fmt.Errorf("failed to load plan revisions: %w", err)
+	}
+
+	revisionsJSON, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("plan revisions are not a string")
+	}
+
+	var revisions []PlanRevision
+	if err := json.Unmarshal([]byte(revisionsJSON), &revisions); err != nil {
+		return fmt.Errorf("failed to unmarshal plan revisions: %w", err)
+	}
+
+	f.revisionsMu.Lock()
+	f.revisions = revisions
+	f.revisionsMu.Unlock()
+
+	return nil
+}
+
+// replan asks the planner how to go on after a step has failed, and applies
+// its patch to the plan. It reports whether the plan was revised; it is not
+// once the replan budget is spent or when the planner leaves the plan as is.
+func (f *PlanningFlow) replan(ctx context.Context, failedID string) (bool, error) {
+	plan := f.PlanSnapshot()
+	if plan.Revision >= f.ReplanBudget {
+		return false, nil
+	}
+
+	patch, err := f.requestPatch(ctx, plan, failedID)
+	if err != nil {
+		return false, err
+	}
+	if len(patch.Operations) == 0 {
+		return false, nil
+	}
+
+	// Only the run of the flow changes the plan, so the snapshot is still
+	// current when the patch is applied
+	revised, err := applyPatch(plan, failedID, patch)
+	if err != nil {
+		return false, fmt.Errorf("invalid plan patch: %w", err)
+	}
+	revised.Revision = plan.Revision + 1
+
+	diff := diffPlans(plan, revised)
+	f.planMu.Lock()
+	f.CurrentPlan = revised
+	f.planMu.Unlock()
+	if err := f.savePlan(ctx); err != nil {
+		return true, fmt.Errorf("failed to save plan: %w", err)
+	}
+
+	revision, err := f.addRevision(ctx, PlanRevision{
+		Reason:     patch.Reason,
+		FailedStep: failedID,
+		Patch:      patch,
+		Diff:       diff,
+	})
+	if err != nil {
+		return true, err
+	}
+
+	f.Events.Publish(PlanRevisedEvent{
+		EventInfo: f.eventInfo(ctx, EventPlanRevised),
+		Revision:  revision,
+	})
+
+	return true, nil
+}
+
+// requestPatch runs the planner on the failed step, the completed steps and
+// the rest of the plan
+func (f *PlanningFlow) requestPatch(ctx context.Context, plan *Plan, failedID string) (*PlanPatch, error) {
+	// The instructions go in the input, since the planner sends its own
+	// system prompt
+	instructions := `You are a planning agent. A step of the plan you made has failed, and you decide how the plan goes on.
+
+You can patch the plan with these operations:
+- "insert" adds a new step, such as a fix-up that installs a missing dependency. Give the whole step, with a new ID and its depends_on. Use "after" to place it after a step other than the failed one.
+- "rewrite" changes the fields given in "step" of a step that has not completed, and runs it again. Rewrite the failed step, even without changes, to try it again, for example once a fix-up step it now depends on has run.
+- "drop" removes a step that has not completed. Steps that depended on it depend on its dependencies instead.
+
+Completed and running steps cannot be changed. Steps that still depend on a failed step are skipped. If the failure cannot be fixed, respond with no operations.
+
+Respond with a JSON object in the following format:
+{
+  "reason": "Why the plan changes",
+  "operations": [
+    {
+      "op": "insert",
+      "step": {
+        "id": "fix1",
+        "description": "Description of the fix-up step",
+        "command": "Command to execute (if applicable)",
+        "depends_on": ["step1"]
+      }
+    },
+    {
+      "op": "rewrite",
+      "step_id": "step2",
+      "step": {
+        "command": "Corrected command",
+        "depends_on": ["step1", "fix1"]
+      }
+    },
+    {
+      "op": "drop",
+      "step_id": "step3"
+    }
+  ]
+}`
+
+	plannerRequest := &agent.Request{
+		Input: fmt.Sprintf("%s\n\n%s", instructions, describeFailure(plan, failedID)),
+	}
+
+	ctx = llm.WithUsageScope(ctx, llm.UsageScope{StepID: "replan"})
+	plannerResponse, err := f.PlannerAgent.Run(ctx, plannerRequest)
+	if err != nil {
+		return nil, fmt.Errorf("planner agent error: %w", err)
+	}
+	if !plannerResponse.Success {
+		return nil, fmt.Errorf("planner failed: %s", plannerResponse.Error)
+	}
+
+	return parsePatch(plannerResponse.Output)
+}
+
+// describeFailure describes a failed step and the state of its plan to the
+// planner
+func describeFailure(plan *Plan, failedID string) string {
+	var completed, remaining []string
+	var failed *PlanStep
+	for i := range plan.Steps {
+		step := &plan.Steps[i]
+		switch {
+		case step.ID == failedID:
+			failed = step
+		case step.Status == StepCompleted:
+			completed = append(completed, describeStep(step, true))
+		default:
+			remaining = append(remaining, describeStep(step, false))
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Goal: %s\n\n", plan.Goal)
+	if failed != nil {
+		fmt.Fprintf(&b, "Failed step:\n%s\n", describeStep(failed, true))
+		if failed.Attempts > 1 {
+			fmt.Fprintf(&b, "  Attempts: %d\n", failed.Attempts)
+		}
+		fmt.Fprintf(&b, "  Error: %s\n\n", failed.Error)
+	}
+	if len(completed) > 0 {
+		fmt.Fprintf(&b, "Completed steps:\n%s\n\n", strings.Join(completed, "\n"))
+	}
+	if len(remaining) > 0 {
+		fmt.Fprintf(&b, "Remaining steps:\n%s\n", strings.Join(remaining, "\n"))
+	} else {
+		b.WriteString("No steps remain.\n")
+	}
+
+	return b.String()
+}
+
+// describeStep describes a step on a few lines, with its output if asked
+func describeStep(step *PlanStep, withOutput bool) string {
+	lines := []string{fmt.Sprintf("- %s (%s): %s", step.ID, step.Status, step.Description)}
+	if step.Command != "" {
+		lines = append(lines, fmt.Sprintf("  Command: %s", step.Command))
+	}
+	if len(step.DependsOn) > 0 {
+		lines = append(lines, fmt.Sprintf("  Depends on: %s", strings.Join(step.DependsOn, ", ")))
+	}
+	if withOutput && step.Output != "" {
+		lines = append(lines, "  Output:")
+		for _, line := range strings.Split(step.Output, "\n") {
+			lines = append(lines, "    "+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parsePatch extracts a plan patch from the planner's output
+func parsePatch(output string) (*PlanPatch, error) {
+	jsonStart := strings.Index(output, "{")
+	jsonEnd := strings.LastIndex(output, "}")
+	if jsonStart == -1 || jsonEnd == -1 || jsonEnd <= jsonStart {
+		return nil, fmt.Errorf("could not find valid JSON in output")
+	}
+
+	var patch PlanPatch
+	if err := json.Unmarshal([]byte(output[jsonStart:jsonEnd+1]), &patch); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal plan patch: %w", err)
+	}
+
+	return &patch, nil
+}
+
+// applyPatch returns a copy of a plan with a patch applied. Skipped steps
+// are made pending again, since the steps they were waiting for may have
+// changed; those still blocked are skipped again.
+func applyPatch(plan *Plan, failedID string, patch *PlanPatch) (*Plan, error) {
+	revised := copyPlan(plan)
+
+	for _, op := range patch.Operations {
+		ids := stepIndex(&revised)
+
+		switch op.Op {
+		case PatchInsert:
+			if op.Step == nil || op.Step.ID == "" {
+				return nil, fmt.Errorf("inserted step has no ID")
+			}
+			if _, exists := ids[op.Step.ID]; exists {
+				return nil, fmt.Errorf("duplicate step ID: %s", op.Step.ID)
+			}
+			after := op.After
+			if after == "" {
+				after = failedID
+			}
+			i, exists := ids[after]
+			if !exists {
+				return nil, fmt.Errorf("cannot insert after unknown step %s", after)
+			}
+
+			step := PlanStep{
+				ID:             op.Step.ID,
+				Description:    op.Step.Description,
+				Command:        op.Step.Command,
+				DependsOn:      append([]string(nil), op.Step.DependsOn...),
+				Retries:        op.Step.Retries,
+				TimeoutSeconds: op.Step.TimeoutSeconds,
+				Status:         StepPending,
+			}
+			revised.Steps = append(revised.Steps[:i+1], append([]PlanStep{step}, revised.Steps[i+1:]...)...)
+
+		case PatchRewrite:
+			step, err := changeableStep(&revised, ids, op)
+			if err != nil {
+				return nil, err
+			}
+			if op.Step != nil {
+				if op.Step.Description != "" {
+					step.Description = op.Step.Description
+				}
+				if op.Step.Command != "" {
+					step.Command = op.Step.Command
+				}
+				if op.Step.DependsOn != nil {
+					step.DependsOn = append([]string(nil), op.Step.DependsOn...)
+				}
+				if op.Step.Retries != 0 {
+					step.Retries = op.Step.Retries
+				}
+				if op.Step.TimeoutSeconds != 0 {
+					step.TimeoutSeconds = op.Step.TimeoutSeconds
+				}
+			}
+			*step = PlanStep{
+				ID:             step.ID,
+				Description:    step.Description,
+				Command:        step.Command,
+				DependsOn:      step.DependsOn,
+				Retries:        step.Retries,
+				TimeoutSeconds: step.TimeoutSeconds,
+				Status:         StepPending,
+			}
+
+		case PatchDrop:
+			dropped, err := changeableStep(&revised, ids, op)
+			if err != nil {
+				return nil, err
+			}
+			// Dependents keep waiting for what the dropped step waited for
+			inherited := dropped.DependsOn
+			i := ids[op.StepID]
+			revised.Steps = append(revised.Steps[:i], revised.Steps[i+1:]...)
+			for j := range revised.Steps {
+				revised.Steps[j].DependsOn = replaceDependency(revised.Steps[j].DependsOn, op.StepID, inherited)
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown patch operation: %s", op.Op)
+		}
+	}
+
+	for i := range revised.Steps {
+		if revised.Steps[i].Status == StepSkipped {
+			revised.Steps[i].Status = StepPending
+			revised.Steps[i].Error = ""
+		}
+	}
+
+	if err := checkDependencies(&revised); err != nil {
+		return nil, err
+	}
+
+	return &revised, nil
+}
+
+// changeableStep returns the step a patch operation targets, which must not
+// have completed or be running
+func changeableStep(plan *Plan, ids map[string]int, op PatchOperation) (*PlanStep, error) {
+	i, exists := ids[op.StepID]
+	if !exists {
+		return nil, fmt.Errorf("cannot %s unknown step %s", op.Op, op.StepID)
+	}
+
+	step := &plan.Steps[i]
+	if step.Status == StepCompleted || step.Status == StepRunning {
+		return nil, fmt.Errorf("cannot %s %s step %s", op.Op, step.Status, step.ID)
+	}
+
+	return step, nil
+}
+
+// copyPlan returns a copy of a plan that shares no slices with it
+func copyPlan(plan *Plan) Plan {
+	copied := Plan{Goal: plan.Goal, Revision: plan.Revision, Steps: make([]PlanStep, len(plan.Steps))}
+	for i, step := range plan.Steps {
+		step.DependsOn = append([]string(nil), step.DependsOn...)
+		copied.Steps[i] = step
+	}
+	return copied
+}
+
+// replaceDependency replaces a dependency in a list with others, keeping
+// each dependency once
+func replaceDependency(dependencies []string, dependency string, replacements []string) []string {
+	found := false
+	var replaced []string
+	seen := make(map[string]bool)
+	for _, d := range dependencies {
+		if d == dependency {
+			found = true
+			continue
+		}
+		seen[d] = true
+		replaced = append(replaced, d)
+	}
+	if !found {
+		return dependencies
+	}
+
+	for _, d := range replacements {
+		if !seen[d] {
+			seen[d] = true
+			replaced = append(replaced, d)
+		}
+	}
+	return replaced
+}
+
+// diffPlans describes how the steps of a plan changed, one line per change:
+// "+" for added steps, "-" for removed ones and "~" for changed fields
+func diffPlans(old, revised *Plan) string {
+	oldIDs := stepIndex(old)
+	newIDs := stepIndex(revised)
+
+	var lines []string
+	for _, step := range old.Steps {
+		if _, exists := newIDs[step.ID]; !exists {
+			lines = append(lines, fmt.Sprintf("- %s: %s", step.ID, summarizeStep(step)))
+		}
+	}
+	for _, step := range revised.Steps {
+		i, exists := oldIDs[step.ID]
+		if !exists {
+			lines = append(lines, fmt.Sprintf("+ %s: %s", step.ID, summarizeStep(step)))
+			continue
+		}
+
+		previous := old.Steps[i]
+		changed := func(field, before, after string) {
+			if before != after {
+				lines = append(lines, fmt.Sprintf("~ %s: %s %q -> %q", step.ID, field, before, after))
+			}
+		}
+		changed("description", previous.Description, step.Description)
+		changed("command", previous.Command, step.Command)
+		changed("depends_on", strings.Join(previous.DependsOn, ","), strings.Join(step.DependsOn, ","))
+		changed("retries", fmt.Sprint(previous.Retries), fmt.Sprint(step.Retries))
+		changed("timeout_seconds", fmt.Sprint(previous.TimeoutSeconds), fmt.Sprint(step.TimeoutSeconds))
+		if previous.Status == StepFailed && step.Status == StepPending {
+			lines = append(lines, fmt.Sprintf("~ %s: runs again", step.ID))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// summarizeStep describes a step on one line for a diff
+func summarizeStep(step PlanStep) string {
+	summary := step.Description
+	if step.Command != "" {
+		summary += fmt.Sprintf(" (%s)", step.Command)
+	}
+	if len(step.DependsOn) > 0 {
+		summary += fmt.Sprintf(" after %s", strings.Join(step.DependsOn, ", "))
+	}
+	return summary
+}
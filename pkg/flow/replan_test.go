@@ -0,0 +1,203 @@
+package flow
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+	"github.com/prathyushnallamothu/commandforge/pkg/llm"
+	"github.com/prathyushnallamothu/commandforge/pkg/memory"
+)
+
+// scriptedClient is an LLM client that answers the planner's requests for a
+// plan and for a patch with fixed replies, and records what it was asked
+type scriptedClient struct {
+	plan  string
+	patch string
+
+	mu     sync.Mutex
+	inputs []string
+}
+
+func (c *scriptedClient) ChatCompletion(ctx context.Context, request *llm.ChatCompletionRequest) (*llm.ChatCompletionResponse, error) {
+	input := ""
+	for _, message := range request.Messages {
+		if message.Role == "user" {
+			input = message.Content
+		}
+	}
+
+	c.mu.Lock()
+	c.inputs = append(c.inputs, input)
+	c.mu.Unlock()
+
+	reply := "Done"
+	switch {
+	case strings.Contains(input, `"operations"`):
+		reply = c.patch
+	case strings.Contains(input, `"steps"`):
+		reply = c.plan
+	}
+
+	return &llm.ChatCompletionResponse{
+		Choices: []llm.Choice{{Message: llm.Message{Role: "assistant", Content: reply}}},
+	}, nil
+}
+
+func (c *scriptedClient) GetModelName() string { return "scripted" }
+
+func (c *scriptedClient) GetProvider() string { return "test" }
+
+func newTestPlanningFlow(t *testing.T, client llm.Client) *PlanningFlow {
+	t.Helper()
+
+	mem, err := memory.NewFileMemory(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := NewPlanningFlow(client, mem, agent.NewFactory(client, mem))
+	if err := f.Initialize(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReplanFixesFailedStep(t *testing.T) {
+	client := &scriptedClient{
+		plan: `{
+  "goal": "Build the project",
+  "steps": [
+    {"id": "build", "description": "Build", "command": "exit 3"},
+    {"id": "test", "description": "Test", "command": "true", "depends_on": ["build"]}
+  ]
+}`,
+		patch: `Final Answer: {
+  "reason": "The build command was wrong",
+  "operations": [
+    {"op": "rewrite", "step_id": "build", "step": {"command": "true"}}
+  ]
+}`,
+	}
+	f := newTestPlanningFlow(t, client)
+
+	response, err := f.Run(context.Background(), &FlowRequest{Input: "Build the project"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success {
+		t.Fatalf("flow failed: %s", response.Error)
+	}
+
+	for _, step := range f.CurrentPlan.Steps {
+		if step.Status != StepCompleted {
+			t.Errorf("step %s is %s, want completed", step.ID, step.Status)
+		}
+	}
+	if f.CurrentPlan.Revision != 1 {
+		t.Errorf("plan revision is %d, want 1", f.CurrentPlan.Revision)
+	}
+
+	revisions := f.PlanRevisions()
+	if len(revisions) != 2 {
+		t.Fatalf("got %d revisions, want 2", len(revisions))
+	}
+	if revisions[1].FailedStep != "build" || revisions[1].Reason != "The build command was wrong" {
+		t.Errorf("unexpected revision: %+v", revisions[1])
+	}
+
+	// The planner is told the formats in its input, since agents send their
+	// own system prompt
+	var planInput, patchInput string
+	for _, input := range client.inputs {
+		switch {
+		case strings.Contains(input, `"operations"`):
+			patchInput = input
+		case strings.Contains(input, `"steps"`):
+			planInput = input
+		}
+	}
+	if !strings.Contains(planInput, "depends_on") || !strings.Contains(planInput, "Build the project") {
+		t.Errorf("plan request lacks the plan format or the request:\n%s", planInput)
+	}
+	if !strings.Contains(patchInput, `"rewrite"`) || !strings.Contains(patchInput, "Failed step:") {
+		t.Errorf("patch request lacks the patch format or the failure:\n%s", patchInput)
+	}
+}
+
+func TestReplanWithoutOperationsKeepsPlan(t *testing.T) {
+	client := &scriptedClient{
+		plan:  `{"goal": "Fail", "steps": [{"id": "s1", "description": "Fail", "command": "exit 1"}]}`,
+		patch: `{"reason": "Cannot be fixed", "operations": []}`,
+	}
+	f := newTestPlanningFlow(t, client)
+
+	if _, err := f.Run(context.Background(), &FlowRequest{Input: "Fail"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := f.CurrentPlan.Steps[0].Status; status != StepFailed {
+		t.Errorf("step is %s, want failed", status)
+	}
+	if f.CurrentPlan.Revision != 0 {
+		t.Errorf("plan revision is %d, want 0", f.CurrentPlan.Revision)
+	}
+}
+
+func TestPlanSnapshotDuringRun(t *testing.T) {
+	client := &scriptedClient{
+		plan: `{
+  "goal": "Build the project",
+  "steps": [
+    {"id": "build", "description": "Build", "command": "exit 3"},
+    {"id": "lint", "description": "Lint", "command": "true"},
+    {"id": "test", "description": "Test", "command": "true", "depends_on": ["build", "lint"]}
+  ]
+}`,
+		patch: `{"reason": "The build command was wrong", "operations": [{"op": "rewrite", "step_id": "build", "step": {"command": "true"}}]}`,
+	}
+	f := newTestPlanningFlow(t, client)
+
+	// Read the plan the way the API does while the flow runs, replans and
+	// updates its steps; run with -race to check the reads
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if plan := f.PlanSnapshot(); plan != nil {
+				for _, step := range plan.Steps {
+					_ = step.Status + step.Output + step.Error
+				}
+			}
+		}
+	}()
+
+	response, err := f.Run(context.Background(), &FlowRequest{Input: "Build the project"})
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Success {
+		t.Fatalf("flow failed: %s", response.Error)
+	}
+
+	plan := f.PlanSnapshot()
+	for _, step := range plan.Steps {
+		if step.Status != StepCompleted {
+			t.Errorf("step %s is %s, want completed", step.ID, step.Status)
+		}
+	}
+	if plan.Revision != 1 {
+		t.Errorf("plan revision is %d, want 1", plan.Revision)
+	}
+}
@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+)
+
+// Restore loads the plan and its revisions saved by an earlier run of the
+// flow. A flow that never made a plan has nothing to restore.
+func (f *PlanningFlow) Restore(ctx context.Context) error {
+	if _, err := f.LoadState(ctx, f.stateKey("current_plan")); err != nil {
+		return nil
+	}
+	if err := f.loadPlan(ctx); err != nil {
+		return err
+	}
+
+	if _, err := f.LoadState(ctx, f.stateKey("plan_revisions")); err != nil {
+		return nil
+	}
+	return f.loadRevisions(ctx)
+}
+
+// Resume runs the rest of the saved plan. Completed steps are kept, and
+// steps that were running are reconciled with their commands first.
+func (f *PlanningFlow) Resume(ctx context.Context) (*FlowResponse, error) {
+	if f.PlanSnapshot() == nil {
+		return nil, fmt.Errorf("flow has no plan to resume")
+	}
+
+	f.setState(StateRunning)
+
+	if err := f.reconcileSteps(ctx); err != nil {
+		f.setState(failureState(ctx))
+		return &FlowResponse{
+			Output:  "",
+			Success: false,
+			Error:   fmt.Sprintf("Failed to reconcile plan: %v", err),
+		}, nil
+	}
+
+	result, err := f.executePlan(ctx)
+	if err != nil {
+		f.setState(failureState(ctx))
+		return &FlowResponse{
+			Output:  "",
+			Success: false,
+			Error:   fmt.Sprintf("Failed to execute plan: %v", err),
+		}, nil
+	}
+
+	f.setState(StateComplete)
+
+	return &FlowResponse{
+		Output:  result,
+		Success: true,
+	}, nil
+}
+
+// reconcileSteps settles the steps that were running when the flow stopped
+// with the commands registry. A step whose command finished takes its
+// outcome, one whose command still runs waits for it, and one whose command
+// was interrupted or is gone runs again; the interrupted attempt does not
+// count against its retries.
+func (f *PlanningFlow) reconcileSteps(ctx context.Context) error {
+	var finished []PlanStep
+	f.planMu.Lock()
+	for i := range f.CurrentPlan.Steps {
+		step := &f.CurrentPlan.Steps[i]
+		if step.Status != StepRunning {
+			continue
+		}
+
+		var cmd *executor.BackgroundCommand
+		if step.CommandID != "" {
+			cmd, _ = f.ExecutionPipeline.GetCommand(step.CommandID)
+		}
+		if cmd == nil {
+			step.Status = StepPending
+			step.CommandID = ""
+			step.Attempts = max(step.Attempts-1, 0)
+			continue
+		}
+
+		switch cmd.State() {
+		case executor.CommandRunning:
+			// runSteps starts the step again, which adopts the command
+			step.Status = StepPending
+			step.Attempts = max(step.Attempts-1, 0)
+		case executor.CommandSucceeded:
+			step.Status = StepCompleted
+			step.Output = commandOutput(cmd)
+			step.Error = ""
+			finished = append(finished, *step)
+		case executor.CommandFailed:
+			step.Output = commandOutput(cmd)
+			step.Error = fmt.Sprintf("command exited with code %d", cmd.ExitCode)
+			if step.Attempts <= step.Retries {
+				step.Status = StepPending
+				continue
+			}
+			step.Status = StepFailed
+			finished = append(finished, *step)
+		default:
+			step.Status = StepPending
+			step.CommandID = ""
+			step.Attempts = max(step.Attempts-1, 0)
+		}
+	}
+	f.planMu.Unlock()
+
+	for _, step := range finished {
+		if err := f.stepFinished(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	return f.savePlan(ctx)
+}
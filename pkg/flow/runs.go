@@ -0,0 +1,591 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/agent"
+)
+
+// RunState is the state of a submitted run of a flow
+type RunState string
+
+const (
+	RunQueued    RunState = "queued"
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+	RunCancelled RunState = "cancelled"
+	// RunInterrupted marks a run that was running when the process that ran
+	// it stopped; its flow can be resumed
+	RunInterrupted RunState = "interrupted"
+)
+
+// Default limits of a run queue
+const (
+	DefaultRunWorkers  = 4
+	DefaultRunsPerUser = 2
+	// DefaultMaxFinishedRuns is the number of finished runs kept
+	DefaultMaxFinishedRuns = 1000
+	// DefaultFinishedRunMaxAge is how long finished runs are kept
+	DefaultFinishedRunMaxAge = 7 * 24 * time.Hour
+)
+
+// runQueuePollInterval is how often idle workers look again for runs whose
+// flow was busy with a run started outside the queue
+const runQueuePollInterval = time.Second
+
+// ErrRunFinished is returned when cancelling a run that has finished
+var ErrRunFinished = errors.New("run has finished")
+
+// RunRecord describes a run of a flow submitted to a run queue
+type RunRecord struct {
+	ID     string `json:"id"`
+	FlowID string `json:"flow_id"`
+	// User is the owner of the flow, if it has one
+	User  string `json:"user,omitempty"`
+	Input string `json:"input"`
+	// Priority orders the queued runs; higher runs first
+	Priority    int       `json:"priority,omitempty"`
+	State       RunState  `json:"state"`
+	Output      string    `json:"output,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	FinishedAt  time.Time `json:"finished_at,omitempty"`
+}
+
+// RunStore persists the records of submitted runs
+type RunStore interface {
+	// SaveRun creates or replaces the record of a run
+	SaveRun(record RunRecord) error
+
+	// LoadRuns returns the records of all stored runs
+	LoadRuns() ([]RunRecord, error)
+
+	// DeleteRun removes the record of a run
+	DeleteRun(id string) error
+}
+
+// FileRunStore stores the record of each run in <id>.json in a directory
+type FileRunStore struct {
+	Dir string
+	mu  sync.Mutex
+}
+
+// NewFileRunStore creates a run store in the given directory
+func NewFileRunStore(dir string) (*FileRunStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create run directory: %w", err)
+	}
+
+	return &FileRunStore{Dir: dir}, nil
+}
+
+// path returns the path of the record of a run
+func (s *FileRunStore) path(id string) string {
+	return filepath.Join(s.Dir, filepath.Base(id)+".json")
+}
+
+// SaveRun writes the record of a run, replacing it atomically
+func (s *FileRunStore) SaveRun(record RunRecord) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(record.ID)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write run record: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write run record: %w", err)
+	}
+
+	return nil
+}
+
+// LoadRuns reads the records of all stored runs, oldest first
+func (s *FileRunStore) LoadRuns() ([]RunRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	records := make([]RunRecord, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run record %s: %w", path, err)
+		}
+
+		var record RunRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse run record %s: %w", path, err)
+		}
+		// A record is only trusted under the name it was saved with
+		if s.path(record.ID) != path {
+			return nil, fmt.Errorf("run record %s has the ID of another run: %s", path, record.ID)
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].SubmittedAt.Before(records[j].SubmittedAt)
+	})
+
+	return records, nil
+}
+
+// DeleteRun removes the record of a run
+func (s *FileRunStore) DeleteRun(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete run %s: %w", id, err)
+	}
+
+	return nil
+}
+
+// RunQueue runs the flows submitted to it in the background, with a bounded
+// number of workers. Queued runs start by priority, then in the order they
+// were submitted, as long as their user has fewer runs in progress than the
+// per-user limit and their flow is not already running. Finished runs are
+// kept up to MaxFinished of them, for up to MaxAge; zero limits are not
+// enforced.
+type RunQueue struct {
+	Manager *FlowManager
+	// Workers is the number of runs processed at once
+	Workers int
+	// PerUser limits the runs of each user processed at once; runs
+	// submitted without a user are only limited by the workers
+	PerUser int
+	// MaxFinished is the number of finished runs kept; the ones that
+	// finished first are removed
+	MaxFinished int
+	// MaxAge is how long finished runs are kept
+	MaxAge time.Duration
+
+	store   RunStore
+	runs    map[string]*RunRecord
+	queued  []*RunRecord
+	active  map[string]int
+	busy    map[string]bool
+	stopped bool
+	mu      sync.Mutex
+	wake    *sync.Cond
+}
+
+// NewRunQueue creates a run queue for the flows of a manager
+func NewRunQueue(manager *FlowManager) *RunQueue {
+	q := &RunQueue{
+		Manager:     manager,
+		Workers:     DefaultRunWorkers,
+		PerUser:     DefaultRunsPerUser,
+		MaxFinished: DefaultMaxFinishedRuns,
+		MaxAge:      DefaultFinishedRunMaxAge,
+		runs:        make(map[string]*RunRecord),
+		active:      make(map[string]int),
+		busy:        make(map[string]bool),
+	}
+	q.wake = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// WithLimits sets the number of workers and the per-user limit; zero keeps
+// the default, and a negative per-user limit removes it
+func (q *RunQueue) WithLimits(workers, perUser int) *RunQueue {
+	if workers > 0 {
+		q.Workers = workers
+	}
+	if perUser != 0 {
+		q.PerUser = perUser
+	}
+	return q
+}
+
+// WithRetention sets how many finished runs are kept and for how long; zero
+// limits are not enforced
+func (q *RunQueue) WithRetention(maxFinished int, maxAge time.Duration) *RunQueue {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.MaxFinished = maxFinished
+	q.MaxAge = maxAge
+	q.collect()
+	return q
+}
+
+// PersistRuns saves the records of runs to a store from now on, and queues
+// the runs that were queued in it again. Runs that were in progress when the
+// previous process stopped are marked as interrupted. It should be called
+// before Start.
+func (q *RunQueue) PersistRuns(store RunStore) error {
+	records, err := store.LoadRuns()
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.store = store
+	for _, record := range records {
+		run := record
+		q.runs[run.ID] = &run
+		switch run.State {
+		case RunQueued:
+			q.queued = append(q.queued, &run)
+		case RunRunning:
+			q.update(&run, func(run *RunRecord) {
+				run.State = RunInterrupted
+				run.Error = "Interrupted by a restart; the flow can be resumed"
+				run.FinishedAt = time.Now()
+			})
+		}
+	}
+	q.collect()
+
+	return nil
+}
+
+// Start starts the workers of the queue. They stop once the context is
+// done.
+func (q *RunQueue) Start(ctx context.Context) {
+	for i := 0; i < q.Workers; i++ {
+		go q.work(ctx)
+	}
+
+	go func() {
+		ticker := time.NewTicker(runQueuePollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				q.mu.Lock()
+				q.stopped = true
+				q.wake.Broadcast()
+				q.mu.Unlock()
+				return
+			case <-ticker.C:
+				q.wake.Broadcast()
+			}
+		}
+	}()
+}
+
+// Submit queues a run of a flow. The run counts towards the limit of the
+// flow's owner.
+func (q *RunQueue) Submit(flowID, input string, priority int) (RunRecord, error) {
+	record, err := q.Manager.GetFlowRecord(flowID)
+	if err != nil {
+		return RunRecord{}, err
+	}
+
+	run := &RunRecord{
+		ID:          fmt.Sprintf("run-%d", time.Now().UnixNano()),
+		FlowID:      flowID,
+		User:        record.Owner,
+		Input:       input,
+		Priority:    priority,
+		State:       RunQueued,
+		SubmittedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	q.runs[run.ID] = run
+	q.queued = append(q.queued, run)
+	q.update(run, func(*RunRecord) {})
+	snapshot := *run
+	q.wake.Signal()
+	q.mu.Unlock()
+
+	q.publish(snapshot)
+
+	return snapshot, nil
+}
+
+// GetRun returns the record of a run
+func (q *RunQueue) GetRun(runID string) (RunRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	run, exists := q.runs[runID]
+	if !exists {
+		return RunRecord{}, fmt.Errorf("run with ID %s not found", runID)
+	}
+
+	return *run, nil
+}
+
+// ListRuns returns the records of the runs of a flow, oldest first
+func (q *RunQueue) ListRuns(flowID string) []RunRecord {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	runs := make([]RunRecord, 0)
+	for _, run := range q.runs {
+		if run.FlowID == flowID {
+			runs = append(runs, *run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].SubmittedAt.Before(runs[j].SubmittedAt)
+	})
+
+	return runs
+}
+
+// CancelRun cancels a run. A queued run is taken off the queue, and the flow
+// of a run in progress is cancelled, which ends the run.
+func (q *RunQueue) CancelRun(runID string) error {
+	q.mu.Lock()
+
+	run, exists := q.runs[runID]
+	if !exists {
+		q.mu.Unlock()
+		return fmt.Errorf("run with ID %s not found", runID)
+	}
+
+	switch run.State {
+	case RunQueued:
+		for i, queued := range q.queued {
+			if queued == run {
+				q.queued = append(q.queued[:i], q.queued[i+1:]...)
+				break
+			}
+		}
+		q.update(run, func(run *RunRecord) {
+			run.State = RunCancelled
+			run.FinishedAt = time.Now()
+		})
+		snapshot := *run
+		q.collect()
+		q.mu.Unlock()
+
+		q.publish(snapshot)
+		return nil
+	case RunRunning:
+		flowID := run.FlowID
+		q.mu.Unlock()
+
+		return q.Manager.CancelFlow(flowID)
+	default:
+		q.mu.Unlock()
+		return fmt.Errorf("run with ID %s: %w", runID, ErrRunFinished)
+	}
+}
+
+// work processes queued runs until the context is done
+func (q *RunQueue) work(ctx context.Context) {
+	for {
+		q.mu.Lock()
+		run := q.next()
+		for run == nil && !q.stopped {
+			q.wake.Wait()
+			run = q.next()
+		}
+		if run == nil {
+			q.mu.Unlock()
+			return
+		}
+
+		q.active[run.User]++
+		q.busy[run.FlowID] = true
+		q.update(run, func(run *RunRecord) {
+			run.State = RunRunning
+			run.StartedAt = time.Now()
+		})
+		started := *run
+		q.mu.Unlock()
+
+		q.publish(started)
+		q.process(ctx, run)
+	}
+}
+
+// next takes the queued run to start next off the queue, or returns nil if
+// no run can start
+func (q *RunQueue) next() *RunRecord {
+	if q.stopped {
+		return nil
+	}
+
+	best := -1
+	for i, run := range q.queued {
+		if q.busy[run.FlowID] {
+			continue
+		}
+		if run.User != "" && q.PerUser > 0 && q.active[run.User] >= q.PerUser {
+			continue
+		}
+		// A flow running outside the queue, such as one being resumed, is
+		// waited for
+		if q.Manager.running(run.FlowID) {
+			continue
+		}
+		if best < 0 || run.Priority > q.queued[best].Priority {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+
+	run := q.queued[best]
+	q.queued = append(q.queued[:best], q.queued[best+1:]...)
+
+	return run
+}
+
+// process runs the flow of a run and records the outcome
+func (q *RunQueue) process(ctx context.Context, run *RunRecord) {
+	response, err := q.Manager.RunFlow(ctx, run.FlowID, &FlowRequest{
+		Input: run.Input,
+		User:  run.User,
+	})
+
+	state := RunFailed
+	output, message := "", ""
+	if err != nil {
+		message = err.Error()
+	} else if response != nil {
+		output, message = response.Output, response.Error
+		if response.Success {
+			state = RunSucceeded
+		}
+	}
+	if state == RunFailed {
+		if ctx.Err() != nil {
+			// The queue was stopped, which leaves the flow interrupted
+			state = RunInterrupted
+		} else if flow, err := q.Manager.GetFlow(run.FlowID); err == nil && flow.GetState() == StateCancelled {
+			state = RunCancelled
+		}
+	}
+
+	q.mu.Lock()
+	q.active[run.User]--
+	delete(q.busy, run.FlowID)
+	// The run is not recorded again if its flow was deleted meanwhile
+	_, kept := q.runs[run.ID]
+	if kept {
+		q.update(run, func(run *RunRecord) {
+			run.State = state
+			run.Output = output
+			run.Error = message
+			run.FinishedAt = time.Now()
+		})
+		q.collect()
+	}
+	finished := *run
+	q.wake.Broadcast()
+	q.mu.Unlock()
+
+	if kept {
+		q.publish(finished)
+	}
+}
+
+// ForgetFlow takes the queued runs of a flow off the queue and removes the
+// records of all its runs, once the flow is deleted. A run in progress is
+// not recorded when it ends.
+func (q *RunQueue) ForgetFlow(flowID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := q.queued[:0]
+	for _, run := range q.queued {
+		if run.FlowID != flowID {
+			queued = append(queued, run)
+		}
+	}
+	q.queued = queued
+
+	for _, run := range q.runs {
+		if run.FlowID == flowID {
+			q.remove(run)
+		}
+	}
+}
+
+// collect removes the finished runs the retention limits no longer keep. The
+// queue must be locked.
+func (q *RunQueue) collect() {
+	finished := make([]*RunRecord, 0, len(q.runs))
+	for _, run := range q.runs {
+		if run.State != RunQueued && run.State != RunRunning {
+			finished = append(finished, run)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(finished[j].FinishedAt)
+	})
+
+	for i, run := range finished {
+		expired := q.MaxAge > 0 && time.Since(run.FinishedAt) > q.MaxAge
+		excess := q.MaxFinished > 0 && len(finished)-i > q.MaxFinished
+		if expired || excess {
+			q.remove(run)
+		}
+	}
+}
+
+// remove removes the record of a run from the queue and the store. The queue
+// must be locked.
+func (q *RunQueue) remove(run *RunRecord) {
+	delete(q.runs, run.ID)
+
+	if q.store != nil {
+		if err := q.store.DeleteRun(run.ID); err != nil {
+			fmt.Printf("Error deleting run %s: %v\n", run.ID, err)
+		}
+	}
+}
+
+// update changes the record of a run and saves it to the store. The queue
+// must be locked.
+func (q *RunQueue) update(run *RunRecord, change func(*RunRecord)) {
+	change(run)
+
+	if q.store != nil {
+		if err := q.store.SaveRun(*run); err != nil {
+			// Just log the error, don't interrupt the run
+			fmt.Printf("Error saving run %s: %v\n", run.ID, err)
+		}
+	}
+}
+
+// publish publishes the record of a run on the event bus of its flow
+func (q *RunQueue) publish(run RunRecord) {
+	flow, err := q.Manager.GetFlow(run.FlowID)
+	if err != nil {
+		return
+	}
+	source, ok := flow.(EventSource)
+	if !ok {
+		return
+	}
+
+	info := agent.NewEventInfo(context.Background(), EventFlowRunUpdated)
+	info.FlowID = run.FlowID
+	source.EventBus().Publish(FlowRunEvent{EventInfo: info, Run: run})
+}
@@ -0,0 +1,67 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRunQueueRetention(t *testing.T) {
+	store, err := NewFileRunStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		run := RunRecord{
+			ID:          fmt.Sprintf("run%d", i),
+			FlowID:      "flow1",
+			State:       RunSucceeded,
+			SubmittedAt: now.Add(time.Duration(i) * time.Minute),
+			FinishedAt:  now.Add(time.Duration(i) * time.Minute),
+		}
+		if err := store.SaveRun(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := RunRecord{ID: "old", FlowID: "flow2", State: RunFailed, SubmittedAt: now.Add(-48 * time.Hour), FinishedAt: now.Add(-47 * time.Hour)}
+	queued := RunRecord{ID: "queued", FlowID: "flow1", State: RunQueued, SubmittedAt: now}
+	for _, run := range []RunRecord{old, queued} {
+		if err := store.SaveRun(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := NewRunQueue(nil).WithRetention(2, 24*time.Hour)
+	if err := q.PersistRuns(store); err != nil {
+		t.Fatal(err)
+	}
+
+	// The two runs that finished last are kept, and the queued run
+	for _, id := range []string{"run0", "run1", "run2", "old"} {
+		if _, err := q.GetRun(id); err == nil {
+			t.Errorf("run %s was kept", id)
+		}
+	}
+	for _, id := range []string{"run3", "run4", "queued"} {
+		if _, err := q.GetRun(id); err != nil {
+			t.Errorf("run %s was not kept: %v", id, err)
+		}
+	}
+	if records, err := store.LoadRuns(); err != nil || len(records) != 3 {
+		t.Errorf("store has %d runs, want 3 (%v)", len(records), err)
+	}
+
+	// Deleting the flow takes its runs off the queue and out of the store
+	q.ForgetFlow("flow1")
+	if runs := q.ListRuns("flow1"); len(runs) != 0 {
+		t.Errorf("runs of a forgotten flow: %+v", runs)
+	}
+	if len(q.queued) != 0 {
+		t.Errorf("%d runs are still queued", len(q.queued))
+	}
+	if records, err := store.LoadRuns(); err != nil || len(records) != 0 {
+		t.Errorf("store has %d runs, want none (%v)", len(records), err)
+	}
+}
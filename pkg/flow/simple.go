@@ -48,7 +48,7 @@ func (f *SimpleFlow) Run(ctx context.Context, request *FlowRequest) (*FlowRespon
 	chatResponse, err := f.LLMClient.ChatCompletion(ctx, chatRequest)
 	if err != nil {
 		// Set the flow state to error
-		f.setState(StateError)
+		f.setState(failureState(ctx))
 		return &FlowResponse{
 			Output:  "",
 			Success: false,
@@ -0,0 +1,524 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AnthropicClient implements the LLM client interface for the Anthropic Messages API
+type AnthropicClient struct {
+	APIKey     string
+	Model      string
+	BaseURL    string
+	APIVersion string
+	MaxTokens  int
+	Timeout    time.Duration
+	HTTPClient *http.Client
+}
+
+// NewAnthropicClient creates a new Anthropic client
+func NewAnthropicClient(apiKey, model string) *AnthropicClient {
+	return &AnthropicClient{
+		APIKey:     apiKey,
+		Model:      model,
+		BaseURL:    "https://api.anthropic.com/v1",
+		APIVersion: "2023-06-01",
+		MaxTokens:  4096,
+		Timeout:    60 * time.Second,
+		HTTPClient: &http.Client{},
+	}
+}
+
+// WithTimeout sets the timeout for API requests
+func (c *AnthropicClient) WithTimeout(timeout time.Duration) *AnthropicClient {
+	c.Timeout = timeout
+	return c
+}
+
+// WithBaseURL sets a custom base URL for API requests
+func (c *AnthropicClient) WithBaseURL(baseURL string) *AnthropicClient {
+	c.BaseURL = baseURL
+	return c
+}
+
+// WithMaxTokens sets the default maximum number of tokens to generate
+func (c *AnthropicClient) WithMaxTokens(maxTokens int) *AnthropicClient {
+	c.MaxTokens = maxTokens
+	return c
+}
+
+// GetModelName returns the name of the model being used
+func (c *AnthropicClient) GetModelName() string {
+	return c.Model
+}
+
+// GetProvider returns the name of the LLM provider
+func (c *AnthropicClient) GetProvider() string {
+	return "anthropic"
+}
+
+// anthropicRequest represents a request to the Messages API
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature float64            `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
+}
+
+// anthropicMessage represents a message made up of content blocks
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+// anthropicContentBlock represents a text, tool_use or tool_result block
+type anthropicContentBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+}
+
+// anthropicTool represents a tool definition in the Messages API
+type anthropicTool struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	InputSchema Parameters `json:"input_schema"`
+}
+
+// anthropicResponse represents a response from the Messages API
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Type       string                  `json:"type"`
+	Role       string                  `json:"role"`
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+// anthropicUsage represents token usage in the Messages API
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// anthropicStreamEvent represents a server-sent event from a streamed Messages API call
+type anthropicStreamEvent struct {
+	Type         string                 `json:"type"`
+	Index        int                    `json:"index"`
+	Message      *anthropicResponse     `json:"message,omitempty"`
+	ContentBlock *anthropicContentBlock `json:"content_block,omitempty"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text,omitempty"`
+		PartialJSON string `json:"partial_json,omitempty"`
+		StopReason  string `json:"stop_reason,omitempty"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// ChatCompletion generates a chat completion using the Anthropic Messages API
+func (c *AnthropicClient) ChatCompletion(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	// Override the model with the client's model
+	request.Model = c.Model
+
+	// Create a context with timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	resp, err := c.send(ctxWithTimeout, request, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Parse the response
+	var anthropicResp anthropicResponse
+	if err := json.Unmarshal(body, &anthropicResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return convertFromAnthropicResponse(&anthropicResp), nil
+}
+
+// ChatCompletionStream generates a chat completion using the Anthropic Messages API,
+// delivering content and tool call fragments to the handler as they arrive
+func (c *AnthropicClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	// Override the model with the client's model
+	request.Model = c.Model
+
+	// Create a context with timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	resp, err := c.send(ctxWithTimeout, request, true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response := &ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+	}
+	var content strings.Builder
+	accumulator := NewToolCallAccumulator()
+	finishReason := ""
+
+	// Map content block indexes to tool call indexes
+	toolIndexes := make(map[int]int)
+
+	// Read the event stream line by line
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			var event anthropicStreamEvent
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if jsonErr := json.Unmarshal([]byte(data), &event); jsonErr != nil {
+				return nil, fmt.Errorf("failed to parse stream event: %w", jsonErr)
+			}
+
+			var delta StreamDelta
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					response.ID = event.Message.ID
+					response.Model = event.Message.Model
+					response.Usage.PromptTokens = event.Message.Usage.InputTokens
+				}
+				delta.Role = "assistant"
+			case "content_block_start":
+				if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+					toolIndex := len(toolIndexes)
+					toolIndexes[event.Index] = toolIndex
+					delta.ToolCalls = []ToolCallDelta{{
+						Index:    toolIndex,
+						ID:       event.ContentBlock.ID,
+						Type:     "function",
+						Function: ToolCallFunction{Name: event.ContentBlock.Name},
+					}}
+				}
+			case "content_block_delta":
+				switch event.Delta.Type {
+				case "text_delta":
+					delta.Content = event.Delta.Text
+				case "input_json_delta":
+					delta.ToolCalls = []ToolCallDelta{{
+						Index:    toolIndexes[event.Index],
+						Function: ToolCallFunction{Arguments: event.Delta.PartialJSON},
+					}}
+				}
+			case "message_delta":
+				if event.Delta.StopReason != "" {
+					finishReason = convertAnthropicStopReason(event.Delta.StopReason)
+					delta.FinishReason = finishReason
+				}
+				if event.Usage != nil {
+					response.Usage.CompletionTokens = event.Usage.OutputTokens
+				}
+			case "error":
+				if event.Error != nil {
+					err := fmt.Errorf("Anthropic API error (%s): %s", event.Error.Type, event.Error.Message)
+					if event.Error.Type == "overloaded_error" {
+						// Overload errors mid-stream carry no HTTP status; report them as 529
+						return nil, &APIError{StatusCode: 529, Err: err}
+					}
+					return nil, err
+				}
+				return nil, fmt.Errorf("Anthropic API returned a stream error")
+			}
+
+			// Assemble the message and forward the delta
+			content.WriteString(delta.Content)
+			for _, tc := range delta.ToolCalls {
+				accumulator.Add(tc)
+			}
+			if delta.Role != "" || delta.Content != "" || len(delta.ToolCalls) > 0 || delta.FinishReason != "" {
+				if handlerErr := handler(delta); handlerErr != nil {
+					return nil, handlerErr
+				}
+			}
+
+			if event.Type == "message_stop" {
+				break
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// Tool calls without input still need a valid JSON object as arguments
+	toolCalls := accumulator.ToolCalls()
+	for i := range toolCalls {
+		if strings.TrimSpace(toolCalls[i].Function.Arguments) == "" {
+			toolCalls[i].Function.Arguments = "{}"
+		}
+	}
+
+	response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
+	response.Choices = []Choice{
+		{
+			Index: 0,
+			Message: Message{
+				Role:      "assistant",
+				Content:   content.String(),
+				ToolCalls: toolCalls,
+			},
+			FinishReason: finishReason,
+		},
+	}
+
+	return response, nil
+}
+
+// send converts a request to the Messages API format and posts it, returning
+// the HTTP response on success
+func (c *AnthropicClient) send(ctx context.Context, request *ChatCompletionRequest, stream bool) (*http.Response, error) {
+	anthropicReq, err := convertToAnthropicRequest(request, c.MaxTokens)
+	if err != nil {
+		return nil, err
+	}
+	anthropicReq.Stream = stream
+
+	// Marshal the request to JSON
+	requestBody, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create the HTTP request
+	url := fmt.Sprintf("%s/messages", c.BaseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", c.APIKey)
+	req.Header.Set("anthropic-version", c.APIVersion)
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	// Send the request
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	// Check for error status code
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+
+		var errorResp struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+
+		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
+			return nil, newAPIError(resp, fmt.Errorf("Anthropic API error (%s): %s", errorResp.Error.Type, errorResp.Error.Message))
+		}
+
+		return nil, newAPIError(resp, fmt.Errorf("Anthropic API returned status code %d: %s", resp.StatusCode, string(body)))
+	}
+
+	return resp, nil
+}
+
+// convertToAnthropicRequest converts a chat completion request into the
+// Messages API format. System messages are lifted into the top-level system
+// prompt, tool calls become tool_use blocks and tool responses become
+// tool_result blocks in a user turn. Consecutive messages with the same role
+// are merged because the API requires alternating turns.
+func convertToAnthropicRequest(request *ChatCompletionRequest, defaultMaxTokens int) (*anthropicRequest, error) {
+	anthropicReq := &anthropicRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+	}
+	if anthropicReq.MaxTokens == 0 {
+		anthropicReq.MaxTokens = defaultMaxTokens
+	}
+
+	var systemParts []string
+	for _, msg := range request.Messages {
+		var role string
+		var blocks []anthropicContentBlock
+
+		switch msg.Role {
+		case "system":
+			if msg.Content != "" {
+				systemParts = append(systemParts, msg.Content)
+			}
+			continue
+
+		case "assistant":
+			role = "assistant"
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, tc := range msg.ToolCalls {
+				input := json.RawMessage(tc.Function.Arguments)
+				if strings.TrimSpace(tc.Function.Arguments) == "" || !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{
+					Type:  "tool_use",
+					ID:    tc.ID,
+					Name:  tc.Function.Name,
+					Input: input,
+				})
+			}
+
+		case "tool":
+			role = "user"
+			blocks = append(blocks, anthropicContentBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.Content,
+			})
+
+		default:
+			role = "user"
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: msg.Content})
+			}
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+
+		// Merge with the previous message if it has the same role
+		if n := len(anthropicReq.Messages); n > 0 && anthropicReq.Messages[n-1].Role == role {
+			anthropicReq.Messages[n-1].Content = append(anthropicReq.Messages[n-1].Content, blocks...)
+			continue
+		}
+
+		anthropicReq.Messages = append(anthropicReq.Messages, anthropicMessage{
+			Role:    role,
+			Content: blocks,
+		})
+	}
+
+	if len(anthropicReq.Messages) == 0 {
+		return nil, fmt.Errorf("request contains no user or assistant messages")
+	}
+
+	anthropicReq.System = strings.Join(systemParts, "\n\n")
+
+	// Convert the tool definitions
+	for _, tool := range request.Tools {
+		schema := tool.Function.Parameters
+		if schema.Type == "" {
+			schema.Type = "object"
+		}
+		if schema.Properties == nil {
+			schema.Properties = make(map[string]Property)
+		}
+		anthropicReq.Tools = append(anthropicReq.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	return anthropicReq, nil
+}
+
+// convertFromAnthropicResponse converts a Messages API response into a chat completion response
+func convertFromAnthropicResponse(resp *anthropicResponse) *ChatCompletionResponse {
+	message := Message{Role: "assistant"}
+
+	var textParts []string
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			textParts = append(textParts, block.Text)
+		case "tool_use":
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				ID:   block.ID,
+				Type: "function",
+				Function: ToolCallFunction{
+					Name:      block.Name,
+					Arguments: arguments,
+				},
+			})
+		}
+	}
+	message.Content = strings.Join(textParts, "")
+
+	return &ChatCompletionResponse{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   resp.Model,
+		Choices: []Choice{
+			{
+				Index:        0,
+				Message:      message,
+				FinishReason: convertAnthropicStopReason(resp.StopReason),
+			},
+		},
+		Usage: Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		},
+	}
+}
+
+// convertAnthropicStopReason maps Anthropic stop reasons to OpenAI finish reasons
+func convertAnthropicStopReason(reason string) string {
+	switch reason {
+	case "end_turn", "stop_sequence":
+		return "stop"
+	case "tool_use":
+		return "tool_calls"
+	case "max_tokens":
+		return "length"
+	default:
+		return reason
+	}
+}
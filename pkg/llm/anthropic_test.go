@@ -0,0 +1,377 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAnthropicClient starts a server with the given handler and returns a
+// client that sends its requests there
+func newTestAnthropicClient(t *testing.T, handler http.HandlerFunc) *AnthropicClient {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return NewAnthropicClient("test-key", "claude-test").WithBaseURL(server.URL)
+}
+
+// decodeAnthropicRequest reads the Messages API request sent to the server
+func decodeAnthropicRequest(t *testing.T, r *http.Request) anthropicRequest {
+	t.Helper()
+
+	var request anthropicRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		t.Errorf("failed to decode request: %v", err)
+	}
+	return request
+}
+
+func TestConvertToAnthropicRequest(t *testing.T) {
+	request := &ChatCompletionRequest{
+		Model: "claude-test",
+		Messages: []Message{
+			{Role: "system", Content: "You are helpful."},
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "List the files"},
+			{Role: "user", Content: "in the current directory"},
+			{Role: "assistant", Content: "Let me look.", ToolCalls: []ToolCall{
+				{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "shell", Arguments: `{"command":"ls"}`}},
+				{ID: "call_2", Type: "function", Function: ToolCallFunction{Name: "pwd"}},
+			}},
+			{Role: "tool", ToolCallID: "call_1", Content: "main.go"},
+			{Role: "tool", ToolCallID: "call_2", Content: "/src"},
+			{Role: "user", Content: "Thanks"},
+		},
+		Tools: []ToolDefinition{
+			{Type: "function", Function: Function{Name: "shell", Description: "Run a command"}},
+		},
+	}
+
+	converted, err := convertToAnthropicRequest(request, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if converted.System != "You are helpful.\n\nBe brief." {
+		t.Errorf("system = %q", converted.System)
+	}
+	if converted.MaxTokens != 1024 {
+		t.Errorf("max tokens = %d, want the default of 1024", converted.MaxTokens)
+	}
+
+	// The user turns are merged, and the tool results and the next user
+	// message share one user turn
+	if len(converted.Messages) != 3 {
+		t.Fatalf("got %d messages, want 3: %+v", len(converted.Messages), converted.Messages)
+	}
+
+	first := converted.Messages[0]
+	if first.Role != "user" || len(first.Content) != 2 ||
+		first.Content[0].Text != "List the files" || first.Content[1].Text != "in the current directory" {
+		t.Errorf("unexpected first message: %+v", first)
+	}
+
+	second := converted.Messages[1]
+	if second.Role != "assistant" || len(second.Content) != 3 {
+		t.Fatalf("unexpected second message: %+v", second)
+	}
+	if second.Content[0].Type != "text" || second.Content[0].Text != "Let me look." {
+		t.Errorf("unexpected text block: %+v", second.Content[0])
+	}
+	toolUse := second.Content[1]
+	if toolUse.Type != "tool_use" || toolUse.ID != "call_1" || toolUse.Name != "shell" || string(toolUse.Input) != `{"command":"ls"}` {
+		t.Errorf("unexpected tool_use block: %+v", toolUse)
+	}
+	if input := string(second.Content[2].Input); input != "{}" {
+		t.Errorf("tool call without arguments has input %q, want {}", input)
+	}
+
+	third := converted.Messages[2]
+	if third.Role != "user" || len(third.Content) != 3 {
+		t.Fatalf("unexpected third message: %+v", third)
+	}
+	for i, id := range []string{"call_1", "call_2"} {
+		block := third.Content[i]
+		if block.Type != "tool_result" || block.ToolUseID != id {
+			t.Errorf("block %d is %+v, want a tool_result for %s", i, block, id)
+		}
+	}
+	if third.Content[2].Type != "text" || third.Content[2].Text != "Thanks" {
+		t.Errorf("unexpected text block: %+v", third.Content[2])
+	}
+
+	if len(converted.Tools) != 1 {
+		t.Fatalf("got %d tools, want 1", len(converted.Tools))
+	}
+	if schema := converted.Tools[0].InputSchema; schema.Type != "object" || schema.Properties == nil {
+		t.Errorf("empty tool schema was not filled in: %+v", schema)
+	}
+}
+
+func TestConvertToAnthropicRequestWithoutMessages(t *testing.T) {
+	request := &ChatCompletionRequest{Messages: []Message{{Role: "system", Content: "Only a system prompt"}}}
+
+	if _, err := convertToAnthropicRequest(request, 1024); err == nil {
+		t.Error("expected an error for a request without user or assistant messages")
+	}
+}
+
+func TestAnthropicChatCompletion(t *testing.T) {
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages" {
+			t.Errorf("path = %s, want /messages", r.URL.Path)
+		}
+		if key := r.Header.Get("x-api-key"); key != "test-key" {
+			t.Errorf("x-api-key = %q", key)
+		}
+		if version := r.Header.Get("anthropic-version"); version == "" {
+			t.Error("anthropic-version header is missing")
+		}
+
+		request := decodeAnthropicRequest(t, r)
+		if request.System != "Be brief." || request.Stream {
+			t.Errorf("unexpected request: %+v", request)
+		}
+
+		fmt.Fprint(w, `{
+  "id": "msg_1",
+  "type": "message",
+  "role": "assistant",
+  "model": "claude-test",
+  "content": [
+    {"type": "text", "text": "Running it."},
+    {"type": "tool_use", "id": "toolu_1", "name": "shell", "input": {"command":"ls"}}
+  ],
+  "stop_reason": "tool_use",
+  "usage": {"input_tokens": 12, "output_tokens": 5}
+}`)
+	})
+
+	response, err := client.ChatCompletion(context.Background(), &ChatCompletionRequest{
+		Messages: []Message{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "List the files"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.ID != "msg_1" || len(response.Choices) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	choice := response.Choices[0]
+	if choice.FinishReason != "tool_calls" {
+		t.Errorf("finish reason = %q, want tool_calls", choice.FinishReason)
+	}
+	if choice.Message.Content != "Running it." {
+		t.Errorf("content = %q", choice.Message.Content)
+	}
+	if len(choice.Message.ToolCalls) != 1 {
+		t.Fatalf("got %d tool calls, want 1", len(choice.Message.ToolCalls))
+	}
+	call := choice.Message.ToolCalls[0]
+	if call.ID != "toolu_1" || call.Function.Name != "shell" || call.Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected tool call: %+v", call)
+	}
+	if response.Usage.PromptTokens != 12 || response.Usage.CompletionTokens != 5 || response.Usage.TotalTokens != 17 {
+		t.Errorf("unexpected usage: %+v", response.Usage)
+	}
+}
+
+func TestAnthropicErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		message    string
+		retryable  bool
+		wait       time.Duration
+	}{
+		{
+			name:      "invalid request",
+			status:    http.StatusBadRequest,
+			body:      `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens is required"}}`,
+			message:   "Anthropic API error (invalid_request_error): max_tokens is required",
+			retryable: false,
+		},
+		{
+			name:       "rate limit",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "7",
+			body:       `{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`,
+			message:    "Anthropic API error (rate_limit_error): slow down",
+			retryable:  true,
+			wait:       7 * time.Second,
+		},
+		{
+			name:      "overloaded",
+			status:    529,
+			body:      `{"type":"error","error":{"type":"overloaded_error","message":"overloaded"}}`,
+			message:   "Anthropic API error (overloaded_error): overloaded",
+			retryable: true,
+		},
+		{
+			name:      "body that is not JSON",
+			status:    http.StatusBadGateway,
+			body:      "bad gateway",
+			message:   "Anthropic API returned status code 502: bad gateway",
+			retryable: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			_, err := client.ChatCompletion(context.Background(), &ChatCompletionRequest{
+				Messages: []Message{{Role: "user", Content: "Hello"}},
+			})
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("got %v, want an APIError", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			if err.Error() != tt.message {
+				t.Errorf("message = %q, want %q", err.Error(), tt.message)
+			}
+			if IsRetryable(err) != tt.retryable {
+				t.Errorf("retryable = %v, want %v", IsRetryable(err), tt.retryable)
+			}
+			if apiErr.RetryAfter != tt.wait {
+				t.Errorf("retry after = %v, want %v", apiErr.RetryAfter, tt.wait)
+			}
+		})
+	}
+}
+
+// writeAnthropicEvents writes server-sent events in the Messages API format
+func writeAnthropicEvents(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		var parsed struct {
+			Type string `json:"type"`
+		}
+		json.Unmarshal([]byte(event), &parsed)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", parsed.Type, event)
+		w.(http.Flusher).Flush()
+	}
+}
+
+func TestAnthropicChatCompletionStream(t *testing.T) {
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "text/event-stream" {
+			t.Errorf("Accept = %q", accept)
+		}
+		if request := decodeAnthropicRequest(t, r); !request.Stream {
+			t.Error("request is not marked as streaming")
+		}
+
+		writeAnthropicEvents(w,
+			`{"type":"message_start","message":{"id":"msg_2","model":"claude-test","usage":{"input_tokens":20,"output_tokens":1}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking "}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"files."}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"shell","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"comm"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"and\":\"ls\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"pwd","input":{}}}`,
+			`{"type":"content_block_stop","index":2}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":15}}`,
+			`{"type":"message_stop"}`,
+		)
+	})
+
+	var deltas []StreamDelta
+	response, err := client.ChatCompletionStream(context.Background(), &ChatCompletionRequest{
+		Messages: []Message{{Role: "user", Content: "List the files"}},
+	}, func(delta StreamDelta) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text strings.Builder
+	var arguments strings.Builder
+	for _, delta := range deltas {
+		text.WriteString(delta.Content)
+		for _, call := range delta.ToolCalls {
+			if call.Index == 0 {
+				arguments.WriteString(call.Function.Arguments)
+			}
+		}
+	}
+	if text.String() != "Checking files." {
+		t.Errorf("streamed text = %q", text.String())
+	}
+	if arguments.String() != `{"command":"ls"}` {
+		t.Errorf("streamed arguments = %q", arguments.String())
+	}
+	if last := deltas[len(deltas)-1]; last.FinishReason != "tool_calls" {
+		t.Errorf("last delta = %+v, want the finish reason", last)
+	}
+
+	if response.ID != "msg_2" || len(response.Choices) != 1 {
+		t.Fatalf("unexpected response: %+v", response)
+	}
+	message := response.Choices[0].Message
+	if message.Content != "Checking files." {
+		t.Errorf("content = %q", message.Content)
+	}
+	if len(message.ToolCalls) != 2 {
+		t.Fatalf("got %d tool calls, want 2", len(message.ToolCalls))
+	}
+	if call := message.ToolCalls[0]; call.ID != "toolu_1" || call.Function.Name != "shell" || call.Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected first tool call: %+v", call)
+	}
+	if call := message.ToolCalls[1]; call.ID != "toolu_2" || call.Function.Arguments != "{}" {
+		t.Errorf("tool call without input = %+v, want {} as arguments", call)
+	}
+	if response.Usage.PromptTokens != 20 || response.Usage.CompletionTokens != 15 || response.Usage.TotalTokens != 35 {
+		t.Errorf("unexpected usage: %+v", response.Usage)
+	}
+}
+
+func TestAnthropicChatCompletionStreamError(t *testing.T) {
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeAnthropicEvents(w,
+			`{"type":"message_start","message":{"id":"msg_3","model":"claude-test","usage":{"input_tokens":20}}}`,
+			`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		)
+	})
+
+	_, err := client.ChatCompletionStream(context.Background(), &ChatCompletionRequest{
+		Messages: []Message{{Role: "user", Content: "Hello"}},
+	}, func(StreamDelta) error { return nil })
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 529 {
+		t.Fatalf("got %v, want an APIError with status 529", err)
+	}
+	if !IsRetryable(err) {
+		t.Error("a mid-stream overload should be retryable")
+	}
+}
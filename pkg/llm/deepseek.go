@@ -101,10 +101,10 @@ func (c *DeepSeekClient) ChatCompletion(ctx context.Context, request *ChatComple
 		}
 
 		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
-			return nil, fmt.Errorf("DeepSeek API error (%s): %s", errorResp.Error.Type, errorResp.Error.Message)
+			return nil, newAPIError(resp, fmt.Errorf("DeepSeek API error (%s): %s", errorResp.Error.Type, errorResp.Error.Message))
 		}
 
-		return nil, fmt.Errorf("DeepSeek API returned status code %d: %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, fmt.Errorf("DeepSeek API returned status code %d: %s", resp.StatusCode, string(body)))
 	}
 
 	// Parse the response
@@ -115,3 +115,22 @@ func (c *DeepSeekClient) ChatCompletion(ctx context.Context, request *ChatComple
 
 	return &response, nil
 }
+
+// ChatCompletionStream generates a chat completion using the DeepSeek API,
+// delivering content and tool call fragments to the handler as they arrive
+func (c *DeepSeekClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	// Override the model with the client's model
+	request.Model = c.Model
+
+	// Validate the conversation history
+	if err := validateConversationHistory(request.Messages); err != nil {
+		return nil, fmt.Errorf("invalid conversation history: %w", err)
+	}
+
+	// Create a context with timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/chat/completions", c.BaseURL)
+	return streamOpenAICompatible(ctxWithTimeout, c.HTTPClient, url, c.APIKey, "DeepSeek", request, handler)
+}
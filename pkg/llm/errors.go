@@ -0,0 +1,94 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIError is returned when an LLM provider responds with a non-success
+// status code. It keeps the status and any Retry-After hint so that
+// middleware can decide whether and when to retry.
+type APIError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request may succeed if sent again
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode >= 500
+}
+
+// newAPIError wraps err with the status code and Retry-After header of resp
+func newAPIError(resp *http.Response, err error) *APIError {
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Err:        err,
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
+
+// IsRetryable reports whether an error from an LLM client is transient.
+// Only transport failures, such as refused connections and network
+// timeouts, responses cut off before the end, and rate limits and server
+// errors are retryable. Anything else, such as client errors, a request that
+// cannot be encoded, or the caller's context being cancelled or running out
+// of time, would fail the same way again.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	// Deadline errors are net.Errors too, so they are ruled out first
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,44 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestIsRetryable(t *testing.T) {
+	_, marshalErr := json.Marshal(math.Inf(1))
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"no error", nil, false},
+		{"rate limit", &APIError{StatusCode: 429, Err: errors.New("slow down")}, true},
+		{"server error", fmt.Errorf("wrapped: %w", &APIError{StatusCode: 503, Err: errors.New("unavailable")}), true},
+		{"client error", &APIError{StatusCode: 400, Err: errors.New("bad request")}, false},
+		{"refused connection", fmt.Errorf("failed to send request: %w", &url.Error{
+			Op: "Post", URL: "http://localhost", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+		}), true},
+		{"cut off response", fmt.Errorf("failed to read response body: %w", io.ErrUnexpectedEOF), true},
+		{"cancelled", fmt.Errorf("failed to send request: %w", &url.Error{Op: "Post", URL: "http://localhost", Err: context.Canceled}), false},
+		{"deadline", fmt.Errorf("failed to send request: %w", &url.Error{Op: "Post", URL: "http://localhost", Err: context.DeadlineExceeded}), false},
+		{"marshal error", fmt.Errorf("failed to marshal request: %w", marshalErr), false},
+		{"validation error", errors.New("request contains no user or assistant messages"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.retryable)
+			}
+		})
+	}
+}
@@ -56,11 +56,18 @@ type Property struct {
 
 // ChatCompletionRequest represents a request for chat completion
 type ChatCompletionRequest struct {
-	Model       string           `json:"model"`
-	Messages    []Message        `json:"messages"`
-	Tools       []ToolDefinition `json:"tools,omitempty"`
-	Temperature float64          `json:"temperature,omitempty"`
-	MaxTokens   int              `json:"max_tokens,omitempty"`
+	Model         string           `json:"model"`
+	Messages      []Message        `json:"messages"`
+	Tools         []ToolDefinition `json:"tools,omitempty"`
+	Temperature   float64          `json:"temperature,omitempty"`
+	MaxTokens     int              `json:"max_tokens,omitempty"`
+	Stream        bool             `json:"stream,omitempty"`
+	StreamOptions *StreamOptions   `json:"stream_options,omitempty"`
+}
+
+// StreamOptions controls optional behaviour of streamed completions
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // ChatCompletionResponse represents a response from chat completion
@@ -71,6 +78,12 @@ type ChatCompletionResponse struct {
 	Model   string `json:"model"`
 	Choices []Choice `json:"choices"`
 	Usage   Usage `json:"usage"`
+	// Provider is the provider that served the request, when a client
+	// such as FailoverClient chooses between several
+	Provider string `json:"provider,omitempty"`
+	// Cost is the price of the request in dollars, when a MeteredClient
+	// has priced it
+	Cost float64 `json:"cost,omitempty"`
 }
 
 // Choice represents a completion choice
@@ -98,3 +111,41 @@ type Client interface {
 	// GetProvider returns the name of the LLM provider
 	GetProvider() string
 }
+
+// StreamDelta represents an incremental update received while streaming a chat completion
+type StreamDelta struct {
+	Role         string          `json:"role,omitempty"`
+	Content      string          `json:"content,omitempty"`
+	ToolCalls    []ToolCallDelta `json:"tool_calls,omitempty"`
+	FinishReason string          `json:"finish_reason,omitempty"`
+}
+
+// ToolCallDelta represents a fragment of a tool call in a streamed response.
+// Fragments sharing the same Index belong to the same tool call; the ID and
+// name arrive first and the arguments arrive as partial JSON strings.
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// StreamHandler is called for every delta received from a streaming completion.
+// Returning an error aborts the stream.
+type StreamHandler func(delta StreamDelta) error
+
+// StreamingClient is implemented by LLM clients that can stream completions
+type StreamingClient interface {
+	Client
+
+	// ChatCompletionStream generates a chat completion, invoking handler for each
+	// delta as it arrives, and returns the fully assembled response
+	ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error)
+}
+
+// ModelLister is implemented by LLM clients that can discover the models
+// available to them
+type ModelLister interface {
+	// ListModels returns the names of the available models
+	ListModels(ctx context.Context) ([]string, error)
+}
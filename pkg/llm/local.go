@@ -0,0 +1,573 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Local server APIs supported by LocalClient
+const (
+	// LocalAPIOllama is Ollama's native /api/chat endpoint
+	LocalAPIOllama = "ollama"
+	// LocalAPIOpenAI is a generic OpenAI-compatible /chat/completions endpoint
+	// such as the one served by llama.cpp, vLLM or LM Studio
+	LocalAPIOpenAI = "openai"
+)
+
+// LocalClient implements the LLM client interface for local inference servers.
+// Models without native tool calling are driven through a ReAct-style text
+// protocol and their replies are converted back into tool calls.
+type LocalClient struct {
+	Provider      string
+	API           string
+	APIKey        string
+	Model         string
+	BaseURL       string
+	SupportsTools bool
+	Timeout       time.Duration
+	HTTPClient    *http.Client
+}
+
+// NewOllamaClient creates a client for Ollama's native chat API
+func NewOllamaClient(model string) *LocalClient {
+	return &LocalClient{
+		Provider:      "ollama",
+		API:           LocalAPIOllama,
+		Model:         model,
+		BaseURL:       "http://localhost:11434",
+		SupportsTools: false,
+		Timeout:       300 * time.Second,
+		HTTPClient:    &http.Client{},
+	}
+}
+
+// NewLocalOpenAIClient creates a client for an OpenAI-compatible local server
+func NewLocalOpenAIClient(baseURL, model string) *LocalClient {
+	return &LocalClient{
+		Provider:      "local",
+		API:           LocalAPIOpenAI,
+		Model:         model,
+		BaseURL:       baseURL,
+		SupportsTools: false,
+		Timeout:       300 * time.Second,
+		HTTPClient:    &http.Client{},
+	}
+}
+
+// WithTimeout sets the timeout for API requests
+func (c *LocalClient) WithTimeout(timeout time.Duration) *LocalClient {
+	c.Timeout = timeout
+	return c
+}
+
+// WithBaseURL sets a custom base URL for API requests
+func (c *LocalClient) WithBaseURL(baseURL string) *LocalClient {
+	c.BaseURL = baseURL
+	return c
+}
+
+// WithAPIKey sets an API key for servers that require one
+func (c *LocalClient) WithAPIKey(apiKey string) *LocalClient {
+	c.APIKey = apiKey
+	return c
+}
+
+// WithToolSupport sets whether the model supports native tool calling.
+// When disabled, tool calling is emulated with a ReAct-style text protocol.
+func (c *LocalClient) WithToolSupport(supported bool) *LocalClient {
+	c.SupportsTools = supported
+	return c
+}
+
+// GetModelName returns the name of the model being used
+func (c *LocalClient) GetModelName() string {
+	return c.Model
+}
+
+// GetProvider returns the name of the LLM provider
+func (c *LocalClient) GetProvider() string {
+	return c.Provider
+}
+
+// ListModels returns the names of the models installed on the local server
+func (c *LocalClient) ListModels(ctx context.Context) ([]string, error) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	// Ollama lists installed models under /api/tags, OpenAI-compatible servers under /models
+	url := fmt.Sprintf("%s/models", strings.TrimRight(c.BaseURL, "/"))
+	if c.API == LocalAPIOllama {
+		url = fmt.Sprintf("%s/api/tags", strings.TrimRight(c.BaseURL, "/"))
+	}
+
+	req, err := http.NewRequestWithContext(ctxWithTimeout, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, fmt.Errorf("local server returned status code %d: %s", resp.StatusCode, string(body)))
+	}
+
+	var listing struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("failed to parse model list: %w", err)
+	}
+
+	models := make([]string, 0, len(listing.Models)+len(listing.Data))
+	for _, model := range listing.Models {
+		models = append(models, model.Name)
+	}
+	for _, model := range listing.Data {
+		models = append(models, model.ID)
+	}
+
+	return models, nil
+}
+
+// ChatCompletion generates a chat completion using the local server
+func (c *LocalClient) ChatCompletion(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	// Override the model with the client's model
+	request.Model = c.Model
+
+	// Create a context with timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	localRequest, emulated := c.prepareRequest(request)
+
+	var response *ChatCompletionResponse
+	var err error
+	if c.API == LocalAPIOllama {
+		response, err = c.ollamaChat(ctxWithTimeout, localRequest, nil)
+	} else {
+		response, err = c.openAIChat(ctxWithTimeout, localRequest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if emulated {
+		applyEmulatedToolCalls(response, request.Tools)
+	}
+
+	return response, nil
+}
+
+// ChatCompletionStream generates a chat completion using the local server,
+// delivering content to the handler as it arrives
+func (c *LocalClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	// Override the model with the client's model
+	request.Model = c.Model
+
+	// Create a context with timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	localRequest, emulated := c.prepareRequest(request)
+
+	var response *ChatCompletionResponse
+	var err error
+	if c.API == LocalAPIOllama {
+		response, err = c.ollamaChat(ctxWithTimeout, localRequest, handler)
+	} else {
+		url := fmt.Sprintf("%s/chat/completions", strings.TrimRight(c.BaseURL, "/"))
+		response, err = streamOpenAICompatible(ctxWithTimeout, c.HTTPClient, url, c.APIKey, "Local", localRequest, handler)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if emulated {
+		applyEmulatedToolCalls(response, request.Tools)
+	}
+
+	return response, nil
+}
+
+// prepareRequest returns the request to send to the server and whether tool
+// calling is being emulated through the text protocol
+func (c *LocalClient) prepareRequest(request *ChatCompletionRequest) (*ChatCompletionRequest, bool) {
+	if c.SupportsTools || len(request.Tools) == 0 {
+		return request, false
+	}
+
+	return emulateToolCalling(request), true
+}
+
+// openAIChat sends a blocking request to an OpenAI-compatible local server
+func (c *LocalClient) openAIChat(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	requestBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/chat/completions", strings.TrimRight(c.BaseURL, "/"))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp, fmt.Errorf("local server returned status code %d: %s", resp.StatusCode, string(body)))
+	}
+
+	var response ChatCompletionResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// ollamaMessage represents a message in Ollama's chat API
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+}
+
+// ollamaToolCall represents a tool call in Ollama's chat API, whose
+// arguments are a JSON object rather than an encoded string
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaChatRequest represents a request to Ollama's /api/chat endpoint
+type ollamaChatRequest struct {
+	Model    string                 `json:"model"`
+	Messages []ollamaMessage        `json:"messages"`
+	Tools    []ToolDefinition       `json:"tools,omitempty"`
+	Stream   bool                   `json:"stream"`
+	Options  map[string]interface{} `json:"options,omitempty"`
+}
+
+// ollamaChatResponse represents a response, or a streamed chunk, from /api/chat
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// ollamaChat sends a request to Ollama's native chat API. When a handler is
+// given the response is streamed as newline-delimited JSON chunks.
+func (c *LocalClient) ollamaChat(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	ollamaReq := ollamaChatRequest{
+		Model:  request.Model,
+		Tools:  request.Tools,
+		Stream: handler != nil,
+	}
+
+	options := make(map[string]interface{})
+	if request.Temperature != 0 {
+		options["temperature"] = request.Temperature
+	}
+	if request.MaxTokens > 0 {
+		options["num_predict"] = request.MaxTokens
+	}
+	if len(options) > 0 {
+		ollamaReq.Options = options
+	}
+
+	// Convert the messages; Ollama expects tool call arguments as objects
+	for _, msg := range request.Messages {
+		ollamaMsg := ollamaMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+		for _, tc := range msg.ToolCalls {
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = json.RawMessage(tc.Function.Arguments)
+			if !json.Valid(call.Function.Arguments) {
+				call.Function.Arguments = json.RawMessage("{}")
+			}
+			ollamaMsg.ToolCalls = append(ollamaMsg.ToolCalls, call)
+		}
+		ollamaReq.Messages = append(ollamaReq.Messages, ollamaMsg)
+	}
+
+	requestBody, err := json.Marshal(ollamaReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/api/chat", strings.TrimRight(c.BaseURL, "/"))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.APIKey))
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newAPIError(resp, fmt.Errorf("Ollama returned status code %d: %s", resp.StatusCode, string(body)))
+	}
+
+	message := Message{Role: "assistant"}
+	var content strings.Builder
+	var final ollamaChatResponse
+
+	// Read one JSON object per line; a blocking response is a single object
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("Ollama error: %s", chunk.Error)
+		}
+
+		content.WriteString(chunk.Message.Content)
+		for _, tc := range chunk.Message.ToolCalls {
+			message.ToolCalls = append(message.ToolCalls, ToolCall{
+				ID:   fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), len(message.ToolCalls)),
+				Type: "function",
+				Function: ToolCallFunction{
+					Name:      tc.Function.Name,
+					Arguments: string(tc.Function.Arguments),
+				},
+			})
+		}
+
+		if handler != nil && chunk.Message.Content != "" {
+			if err := handler(StreamDelta{Content: chunk.Message.Content}); err != nil {
+				return nil, err
+			}
+		}
+
+		if chunk.Done {
+			final = chunk
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	message.Content = content.String()
+
+	finishReason := final.DoneReason
+	if len(message.ToolCalls) > 0 {
+		finishReason = "tool_calls"
+	}
+
+	return &ChatCompletionResponse{
+		ID:      fmt.Sprintf("ollama-%d", time.Now().UnixNano()),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   final.Model,
+		Choices: []Choice{
+			{
+				Index:        0,
+				Message:      message,
+				FinishReason: finishReason,
+			},
+		},
+		Usage: Usage{
+			PromptTokens:     final.PromptEvalCount,
+			CompletionTokens: final.EvalCount,
+			TotalTokens:      final.PromptEvalCount + final.EvalCount,
+		},
+	}, nil
+}
+
+// emulatedToolProtocol is appended to the system prompt when a model has no
+// native tool calling support
+const emulatedToolProtocol = `You can use the following tools:
+
+%s
+To use a tool, respond with exactly this format and then stop:
+Thought: <your reasoning>
+Action: <tool name>
+Action Input: <tool arguments as a single-line JSON object>
+
+You will then receive the result as:
+Observation: <tool result>
+
+When you have enough information to respond, reply with:
+Thought: I now have the information needed to answer.
+Final Answer: <your response to the user>`
+
+// emulateToolCalling rewrites a request for a model without native tool
+// support. Tool definitions are described in the system prompt, earlier tool
+// calls are rendered as Action/Action Input text and tool results become
+// Observation messages from the user.
+func emulateToolCalling(request *ChatCompletionRequest) *ChatCompletionRequest {
+	var toolDescriptions strings.Builder
+	for _, tool := range request.Tools {
+		parameters, _ := json.Marshal(tool.Function.Parameters)
+		toolDescriptions.WriteString(fmt.Sprintf("- %s: %s\n  Parameters: %s\n", tool.Function.Name, tool.Function.Description, parameters))
+	}
+	protocol := fmt.Sprintf(emulatedToolProtocol, toolDescriptions.String())
+
+	emulated := *request
+	emulated.Tools = nil
+	emulated.Messages = make([]Message, 0, len(request.Messages)+1)
+
+	hasSystem := false
+	for _, msg := range request.Messages {
+		switch {
+		case msg.Role == "system" && !hasSystem:
+			hasSystem = true
+			emulated.Messages = append(emulated.Messages, Message{
+				Role:    "system",
+				Content: msg.Content + "\n\n" + protocol,
+			})
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			var text strings.Builder
+			text.WriteString(msg.Content)
+			for _, tc := range msg.ToolCalls {
+				if text.Len() > 0 {
+					text.WriteString("\n")
+				}
+				text.WriteString(fmt.Sprintf("Action: %s\nAction Input: %s", tc.Function.Name, tc.Function.Arguments))
+			}
+			emulated.Messages = append(emulated.Messages, Message{Role: "assistant", Content: text.String()})
+		case msg.Role == "tool":
+			emulated.Messages = append(emulated.Messages, Message{
+				Role:    "user",
+				Content: fmt.Sprintf("Observation: %s", msg.Content),
+			})
+		default:
+			emulated.Messages = append(emulated.Messages, Message{Role: msg.Role, Content: msg.Content})
+		}
+	}
+
+	// Add the protocol as a system message if the conversation has none
+	if !hasSystem {
+		emulated.Messages = append([]Message{{Role: "system", Content: protocol}}, emulated.Messages...)
+	}
+
+	return &emulated
+}
+
+// applyEmulatedToolCalls converts an Action/Action Input reply into a tool
+// call on the response message. Replies that contain a final answer, or that
+// name a tool that was not offered, are left as plain text.
+func applyEmulatedToolCalls(response *ChatCompletionResponse, tools []ToolDefinition) {
+	if len(response.Choices) == 0 {
+		return
+	}
+
+	message := &response.Choices[0].Message
+	if len(message.ToolCalls) > 0 || strings.Contains(message.Content, "Final Answer:") {
+		return
+	}
+
+	actionIndex := strings.Index(message.Content, "Action:")
+	inputIndex := strings.Index(message.Content, "Action Input:")
+	if actionIndex == -1 || inputIndex == -1 || inputIndex < actionIndex {
+		return
+	}
+
+	name := strings.TrimSpace(message.Content[actionIndex+len("Action:") : inputIndex])
+	known := false
+	for _, tool := range tools {
+		if tool.Function.Name == name {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return
+	}
+
+	// The arguments run until an Observation the model may have hallucinated
+	arguments := message.Content[inputIndex+len("Action Input:"):]
+	if observationIndex := strings.Index(arguments, "Observation:"); observationIndex != -1 {
+		arguments = arguments[:observationIndex]
+	}
+	arguments = strings.TrimSpace(arguments)
+	arguments = strings.TrimPrefix(arguments, "```json")
+	arguments = strings.Trim(arguments, "`\n ")
+
+	// Use the outermost JSON object if there is text around it
+	if start, end := strings.Index(arguments, "{"), strings.LastIndex(arguments, "}"); start != -1 && end > start {
+		arguments = arguments[start : end+1]
+	}
+	if !json.Valid([]byte(arguments)) {
+		encoded, _ := json.Marshal(map[string]string{"input": arguments})
+		arguments = string(encoded)
+	}
+
+	message.Content = strings.TrimSpace(message.Content[:actionIndex])
+	message.ToolCalls = []ToolCall{
+		{
+			ID:   fmt.Sprintf("call_%d", time.Now().UnixNano()),
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      name,
+				Arguments: arguments,
+			},
+		},
+	}
+	response.Choices[0].FinishReason = "tool_calls"
+}
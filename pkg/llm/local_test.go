@@ -0,0 +1,39 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaSendsAPIKey(t *testing.T) {
+	var authorization []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		fmt.Fprintln(w, `{"model":"llama-test","message":{"role":"assistant","content":"Hi"},"done":true}`)
+	}))
+	defer server.Close()
+
+	request := &ChatCompletionRequest{Messages: []Message{{Role: "user", Content: "Hello"}}}
+
+	client := NewOllamaClient("llama-test").WithBaseURL(server.URL).WithAPIKey("test-key")
+	if _, err := client.ChatCompletion(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ChatCompletionStream(context.Background(), request, func(StreamDelta) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a key, no header is sent
+	client = NewOllamaClient("llama-test").WithBaseURL(server.URL)
+	if _, err := client.ChatCompletion(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Bearer test-key", "Bearer test-key", ""}
+	if fmt.Sprint(authorization) != fmt.Sprint(want) {
+		t.Errorf("Authorization headers = %q, want %q", authorization, want)
+	}
+}
@@ -0,0 +1,337 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps an LLM client to add behaviour around its calls
+type Middleware func(Client) Client
+
+// Chain wraps a client with the given middleware. The first middleware is
+// the outermost, so Chain(c, a, b) calls a, then b, then c.
+func Chain(client Client, middleware ...Middleware) Client {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		client = middleware[i](client)
+	}
+	return client
+}
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries, including Retry-After hints
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after each retry
+	Multiplier float64
+}
+
+// DefaultRetryPolicy returns the default retry policy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: 1 * time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+	}
+}
+
+// backoff returns the wait before the given retry, honouring a Retry-After
+// hint from the provider when it asks for longer than the computed backoff
+func (p RetryPolicy) backoff(retry int, err error) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 0; i < retry; i++ {
+		wait *= p.Multiplier
+	}
+
+	// Add up to 20% jitter so that concurrent callers do not retry in lockstep
+	wait += wait * 0.2 * rand.Float64()
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && float64(apiErr.RetryAfter) > wait {
+		wait = float64(apiErr.RetryAfter)
+	}
+
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+
+	return time.Duration(wait)
+}
+
+// RetryClient retries transient failures with exponential backoff
+type RetryClient struct {
+	Client Client
+	Policy RetryPolicy
+}
+
+// NewRetryClient creates a client that retries transient failures of client
+func NewRetryClient(client Client, policy RetryPolicy) *RetryClient {
+	return &RetryClient{
+		Client: client,
+		Policy: policy,
+	}
+}
+
+// WithRetry returns middleware that retries transient failures
+func WithRetry(policy RetryPolicy) Middleware {
+	return func(client Client) Client {
+		return NewRetryClient(client, policy)
+	}
+}
+
+// GetModelName returns the name of the model being used
+func (c *RetryClient) GetModelName() string {
+	return c.Client.GetModelName()
+}
+
+// GetProvider returns the name of the LLM provider
+func (c *RetryClient) GetProvider() string {
+	return c.Client.GetProvider()
+}
+
+// ChatCompletion generates a chat completion, retrying transient failures
+func (c *RetryClient) ChatCompletion(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return c.do(ctx, func() (*ChatCompletionResponse, bool, error) {
+		response, err := c.Client.ChatCompletion(ctx, request)
+		return response, false, err
+	})
+}
+
+// ChatCompletionStream streams a chat completion, retrying transient failures
+// that happen before any delta has been delivered to the handler
+func (c *RetryClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	return c.do(ctx, func() (*ChatCompletionResponse, bool, error) {
+		started := false
+		response, err := StreamChatCompletion(ctx, c.Client, request, func(delta StreamDelta) error {
+			started = true
+			return handler(delta)
+		})
+		return response, started, err
+	})
+}
+
+// do runs attempt until it succeeds, fails permanently or the retries run
+// out. Attempts that report output as already delivered are not retried.
+func (c *RetryClient) do(ctx context.Context, attempt func() (*ChatCompletionResponse, bool, error)) (*ChatCompletionResponse, error) {
+	for retry := 0; ; retry++ {
+		response, started, err := attempt()
+		if err == nil {
+			return response, nil
+		}
+
+		if started || retry >= c.Policy.MaxRetries || !IsRetryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := c.Policy.backoff(retry, err)
+		log.Printf("%s request failed (%v), retrying in %s", c.GetProvider(), err, wait.Round(time.Millisecond))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// ErrCircuitOpen is returned while a circuit breaker is rejecting requests
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreakerClient stops sending requests to a provider after repeated
+// transient failures. Once the cooldown has passed a single trial request is
+// let through; its success closes the circuit again.
+type CircuitBreakerClient struct {
+	Client           Client
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewCircuitBreakerClient creates a circuit breaker around client
+func NewCircuitBreakerClient(client Client, failureThreshold int, cooldown time.Duration) *CircuitBreakerClient {
+	return &CircuitBreakerClient{
+		Client:           client,
+		FailureThreshold: failureThreshold,
+		Cooldown:         cooldown,
+	}
+}
+
+// WithCircuitBreaker returns middleware that adds a circuit breaker
+func WithCircuitBreaker(failureThreshold int, cooldown time.Duration) Middleware {
+	return func(client Client) Client {
+		return NewCircuitBreakerClient(client, failureThreshold, cooldown)
+	}
+}
+
+// GetModelName returns the name of the model being used
+func (c *CircuitBreakerClient) GetModelName() string {
+	return c.Client.GetModelName()
+}
+
+// GetProvider returns the name of the LLM provider
+func (c *CircuitBreakerClient) GetProvider() string {
+	return c.Client.GetProvider()
+}
+
+// IsOpen reports whether the circuit is currently rejecting requests
+func (c *CircuitBreakerClient) IsOpen() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return !c.openedAt.IsZero() && (c.trial || time.Since(c.openedAt) < c.Cooldown)
+}
+
+// ChatCompletion generates a chat completion unless the circuit is open
+func (c *CircuitBreakerClient) ChatCompletion(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+
+	response, err := c.Client.ChatCompletion(ctx, request)
+	c.record(err)
+	return response, err
+}
+
+// ChatCompletionStream streams a chat completion unless the circuit is open
+func (c *CircuitBreakerClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+
+	response, err := StreamChatCompletion(ctx, c.Client, request, handler)
+	c.record(err)
+	return response, err
+}
+
+// allow checks whether a request may be sent
+func (c *CircuitBreakerClient) allow() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.openedAt.IsZero() {
+		return nil
+	}
+
+	// Only one trial request is allowed once the cooldown has passed
+	if c.trial || time.Since(c.openedAt) < c.Cooldown {
+		return fmt.Errorf("%s: %w", c.Client.GetProvider(), ErrCircuitOpen)
+	}
+
+	c.trial = true
+	return nil
+}
+
+// record updates the circuit with the outcome of a request. Only transient
+// failures count, since client errors say nothing about provider health.
+func (c *CircuitBreakerClient) record(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	wasTrial := c.trial
+	c.trial = false
+
+	// A cancelled or timed out request tells us nothing either way
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	if !IsRetryable(err) {
+		c.failures = 0
+		c.openedAt = time.Time{}
+		return
+	}
+
+	c.failures++
+	if wasTrial || c.failures >= c.FailureThreshold {
+		if c.openedAt.IsZero() || wasTrial {
+			log.Printf("%s circuit breaker opened after %d consecutive failures", c.Client.GetProvider(), c.failures)
+		}
+		c.openedAt = time.Now()
+	}
+}
+
+// FailoverClient sends each request to its clients in order, moving on to
+// the next client when one fails. The provider that served the request is
+// recorded in the response.
+type FailoverClient struct {
+	Clients []Client
+}
+
+// NewFailoverClient creates a client that fails over between clients in order
+func NewFailoverClient(clients ...Client) *FailoverClient {
+	return &FailoverClient{
+		Clients: clients,
+	}
+}
+
+// GetModelName returns the name of the primary client's model
+func (c *FailoverClient) GetModelName() string {
+	return c.Clients[0].GetModelName()
+}
+
+// GetProvider returns the name of the primary client's provider
+func (c *FailoverClient) GetProvider() string {
+	return c.Clients[0].GetProvider()
+}
+
+// ChatCompletion generates a chat completion with the first client that succeeds
+func (c *FailoverClient) ChatCompletion(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return c.do(ctx, func(client Client) (*ChatCompletionResponse, bool, error) {
+		response, err := client.ChatCompletion(ctx, request)
+		return response, false, err
+	})
+}
+
+// ChatCompletionStream streams a chat completion with the first client that
+// succeeds. Once a client has delivered output it is not failed over.
+func (c *FailoverClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	return c.do(ctx, func(client Client) (*ChatCompletionResponse, bool, error) {
+		started := false
+		response, err := StreamChatCompletion(ctx, client, request, func(delta StreamDelta) error {
+			started = true
+			return handler(delta)
+		})
+		return response, started, err
+	})
+}
+
+// do tries each client in order until one succeeds
+func (c *FailoverClient) do(ctx context.Context, attempt func(client Client) (*ChatCompletionResponse, bool, error)) (*ChatCompletionResponse, error) {
+	var failures []string
+	for i, client := range c.Clients {
+		response, started, err := attempt(client)
+		if err == nil {
+			if response.Provider == "" {
+				response.Provider = client.GetProvider()
+			}
+			return response, nil
+		}
+
+		if started || ctx.Err() != nil {
+			return nil, err
+		}
+
+		failures = append(failures, fmt.Sprintf("%s: %v", client.GetProvider(), err))
+		if i < len(c.Clients)-1 {
+			log.Printf("%s request failed (%v), failing over to %s", client.GetProvider(), err, c.Clients[i+1].GetProvider())
+		}
+	}
+
+	return nil, fmt.Errorf("all LLM providers failed: %s", strings.Join(failures, "; "))
+}
@@ -163,10 +163,10 @@ func (c *OpenAIClient) ChatCompletion(ctx context.Context, request *ChatCompleti
 		}
 		
 		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
-			return nil, fmt.Errorf("OpenAI API error (%s): %s", errorResp.Error.Type, errorResp.Error.Message)
+			return nil, newAPIError(resp, fmt.Errorf("OpenAI API error (%s): %s", errorResp.Error.Type, errorResp.Error.Message))
 		}
 		
-		return nil, fmt.Errorf("OpenAI API returned status code %d: %s", resp.StatusCode, string(body))
+		return nil, newAPIError(resp, fmt.Errorf("OpenAI API returned status code %d: %s", resp.StatusCode, string(body)))
 	}
 	
 	// Log the response for debugging (truncated if too large)
@@ -193,6 +193,25 @@ func (c *OpenAIClient) ChatCompletion(ctx context.Context, request *ChatCompleti
 	return &response, nil
 }
 
+// ChatCompletionStream generates a chat completion using the OpenAI API,
+// delivering content and tool call fragments to the handler as they arrive
+func (c *OpenAIClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	// Override the model with the client's model
+	request.Model = c.Model
+
+	// Validate the conversation history
+	if err := validateConversationHistory(request.Messages); err != nil {
+		return nil, fmt.Errorf("invalid conversation history: %w", err)
+	}
+
+	// Create a context with timeout
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/chat/completions", c.BaseURL)
+	return streamOpenAICompatible(ctxWithTimeout, c.HTTPClient, url, c.APIKey, "OpenAI", request, handler)
+}
+
 // ParseToolCalls extracts tool calls from a message
 func ParseToolCalls(message Message) ([]ToolCall, error) {
 	// Log the message for debugging
@@ -0,0 +1,244 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// streamChunk represents a single server-sent event payload from an
+// OpenAI-compatible streaming endpoint
+type streamChunk struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index        int         `json:"index"`
+		Delta        StreamDelta `json:"delta"`
+		FinishReason *string     `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage,omitempty"`
+}
+
+// ToolCallAccumulator assembles complete tool calls from streamed fragments
+type ToolCallAccumulator struct {
+	calls map[int]*ToolCall
+}
+
+// NewToolCallAccumulator creates a new tool call accumulator
+func NewToolCallAccumulator() *ToolCallAccumulator {
+	return &ToolCallAccumulator{
+		calls: make(map[int]*ToolCall),
+	}
+}
+
+// Add merges a tool call fragment into the accumulated tool calls
+func (a *ToolCallAccumulator) Add(delta ToolCallDelta) {
+	call, exists := a.calls[delta.Index]
+	if !exists {
+		call = &ToolCall{Type: "function"}
+		a.calls[delta.Index] = call
+	}
+
+	// The ID, type and name are sent once; the arguments arrive in pieces
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Type != "" {
+		call.Type = delta.Type
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name += delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+}
+
+// ToolCalls returns the assembled tool calls ordered by their stream index
+func (a *ToolCallAccumulator) ToolCalls() []ToolCall {
+	if len(a.calls) == 0 {
+		return nil
+	}
+
+	indexes := make([]int, 0, len(a.calls))
+	for index := range a.calls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	toolCalls := make([]ToolCall, 0, len(indexes))
+	for _, index := range indexes {
+		toolCalls = append(toolCalls, *a.calls[index])
+	}
+
+	return toolCalls
+}
+
+// StreamChatCompletion streams a chat completion if the client supports it.
+// Clients without streaming support fall back to a blocking completion whose
+// content is delivered to the handler as a single delta.
+func StreamChatCompletion(ctx context.Context, client Client, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	if streamingClient, ok := client.(StreamingClient); ok {
+		return streamingClient.ChatCompletionStream(ctx, request, handler)
+	}
+
+	response, err := client.ChatCompletion(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response.Choices) > 0 && response.Choices[0].Message.Content != "" {
+		if err := handler(StreamDelta{
+			Role:    response.Choices[0].Message.Role,
+			Content: response.Choices[0].Message.Content,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// streamOpenAICompatible sends a streaming request to an OpenAI-compatible
+// chat completions endpoint and assembles the final response from the
+// server-sent events it returns
+func streamOpenAICompatible(ctx context.Context, httpClient *http.Client, url, apiKey, providerName string, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	// Copy the request so the caller's request is not marked as streaming
+	streamRequest := *request
+	streamRequest.Stream = true
+	streamRequest.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	// Marshal the request to JSON
+	requestBody, err := json.Marshal(&streamRequest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create the HTTP request
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
+	}
+
+	// Send the request
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check for error status code
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+
+		var errorResp struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+
+		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Error.Message != "" {
+			return nil, newAPIError(resp, fmt.Errorf("%s API error (%s): %s", providerName, errorResp.Error.Type, errorResp.Error.Message))
+		}
+
+		return nil, newAPIError(resp, fmt.Errorf("%s API returned status code %d: %s", providerName, resp.StatusCode, string(body)))
+	}
+
+	response := &ChatCompletionResponse{
+		Object: "chat.completion",
+	}
+	message := Message{Role: "assistant"}
+	finishReason := ""
+	var content strings.Builder
+	accumulator := NewToolCallAccumulator()
+
+	// Read the event stream line by line until the server says it is done
+	reader := bufio.NewReader(resp.Body)
+	for done := false; !done; {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if data == "[DONE]" {
+				done = true
+				continue
+			}
+
+			var chunk streamChunk
+			if jsonErr := json.Unmarshal([]byte(data), &chunk); jsonErr != nil {
+				return nil, fmt.Errorf("failed to parse stream chunk: %w", jsonErr)
+			}
+
+			// Record the response metadata from the first chunk that has it
+			if response.ID == "" {
+				response.ID = chunk.ID
+				response.Created = chunk.Created
+				response.Model = chunk.Model
+			}
+			if chunk.Usage != nil {
+				response.Usage = *chunk.Usage
+			}
+
+			for _, choice := range chunk.Choices {
+				if choice.Index != 0 {
+					continue
+				}
+
+				delta := choice.Delta
+				if delta.Role != "" {
+					message.Role = delta.Role
+				}
+				content.WriteString(delta.Content)
+				for _, tc := range delta.ToolCalls {
+					accumulator.Add(tc)
+				}
+				if choice.FinishReason != nil {
+					finishReason = *choice.FinishReason
+					delta.FinishReason = finishReason
+				}
+
+				if handlerErr := handler(delta); handlerErr != nil {
+					return nil, handlerErr
+				}
+			}
+		}
+
+		// A stream that ends before it is done was cut off, and the
+		// response is incomplete
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s stream ended before it was done: %w", providerName, io.ErrUnexpectedEOF)
+		}
+	}
+
+	// Assemble the final message
+	message.Content = content.String()
+	message.ToolCalls = accumulator.ToolCalls()
+
+	response.Choices = []Choice{
+		{
+			Index:        0,
+			Message:      message,
+			FinishReason: finishReason,
+		},
+	}
+
+	return response, nil
+}
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestStreamClient starts a server that streams the given chunks as
+// server-sent events, and returns a DeepSeek client that sends its requests
+// there
+func newTestStreamClient(t *testing.T, chunks ...string) *DeepSeekClient {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return NewDeepSeekClient("test-key", "deepseek-test").WithBaseURL(server.URL)
+}
+
+func TestChatCompletionStream(t *testing.T) {
+	client := newTestStreamClient(t,
+		`{"id":"chat_1","model":"deepseek-test","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello"}}]}`,
+		`{"id":"chat_1","choices":[{"index":0,"delta":{"content":" there"},"finish_reason":"stop"}]}`,
+		`{"id":"chat_1","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+		`[DONE]`,
+	)
+
+	response, err := client.ChatCompletionStream(context.Background(), &ChatCompletionRequest{
+		Messages: []Message{{Role: "user", Content: "Hi"}},
+	}, func(StreamDelta) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := response.Choices[0].Message; message.Content != "Hello there" || response.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected response: %+v", response.Choices[0])
+	}
+	if response.Usage.TotalTokens != 7 {
+		t.Errorf("unexpected usage: %+v", response.Usage)
+	}
+}
+
+func TestChatCompletionStreamCutOff(t *testing.T) {
+	client := newTestStreamClient(t,
+		`{"id":"chat_2","model":"deepseek-test","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+	)
+
+	_, err := client.ChatCompletionStream(context.Background(), &ChatCompletionRequest{
+		Messages: []Message{{Role: "user", Content: "Hi"}},
+	}, func(StreamDelta) error { return nil })
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("a stream without [DONE] returned %v, want an unexpected EOF", err)
+	}
+	if !IsRetryable(err) {
+		t.Error("a stream that was cut off should be retryable")
+	}
+}
+
+func TestChatCompletionStreamValidatesHistory(t *testing.T) {
+	client := newTestStreamClient(t, `[DONE]`)
+
+	_, err := client.ChatCompletionStream(context.Background(), &ChatCompletionRequest{
+		Messages: []Message{
+			{Role: "user", Content: "Hi"},
+			{Role: "tool", Content: "done", ToolCallID: "call_1"},
+		},
+	}, func(StreamDelta) error { return nil })
+	if err == nil {
+		t.Error("a tool response without its tool call was sent")
+	}
+}
@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// modelLimits describes the context window and tokenizer density of a model family
+type modelLimits struct {
+	contextWindow int
+	charsPerToken float64
+}
+
+// knownModels maps model name prefixes to their limits. The longest matching
+// prefix wins, so dated versions such as gpt-4o-2024-08-06 are covered.
+var knownModels = map[string]modelLimits{
+	"gpt-4o":        {contextWindow: 128000, charsPerToken: 4.0},
+	"gpt-4-turbo":   {contextWindow: 128000, charsPerToken: 4.0},
+	"gpt-4":         {contextWindow: 8192, charsPerToken: 4.0},
+	"gpt-3.5-turbo": {contextWindow: 16385, charsPerToken: 4.0},
+	"o1":            {contextWindow: 200000, charsPerToken: 4.0},
+	"o3":            {contextWindow: 200000, charsPerToken: 4.0},
+	"deepseek":      {contextWindow: 64000, charsPerToken: 3.5},
+	"claude":        {contextWindow: 200000, charsPerToken: 3.5},
+	"llama3":        {contextWindow: 8192, charsPerToken: 3.8},
+	"llama3.1":      {contextWindow: 131072, charsPerToken: 3.8},
+	"qwen2.5":       {contextWindow: 32768, charsPerToken: 3.5},
+	"mistral":       {contextWindow: 32768, charsPerToken: 3.5},
+}
+
+// defaultModelLimits is used for models that are not in the table. The
+// window is deliberately small since unknown models are often local ones.
+var defaultModelLimits = modelLimits{contextWindow: 8192, charsPerToken: 3.5}
+
+// messageOverheadTokens approximates the tokens each message costs for its
+// role and separators, on top of its content
+const messageOverheadTokens = 4
+
+// limitsFor returns the limits of a model
+func limitsFor(model string) modelLimits {
+	model = strings.ToLower(model)
+	if index := strings.LastIndex(model, "/"); index != -1 {
+		// Strip registry prefixes such as "library/" or "meta-llama/"
+		model = model[index+1:]
+	}
+
+	best := ""
+	for prefix := range knownModels {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return defaultModelLimits
+	}
+
+	return knownModels[best]
+}
+
+// ContextWindow returns the context window of a model in tokens
+func ContextWindow(model string) int {
+	return limitsFor(model).contextWindow
+}
+
+// CountTokens estimates the number of tokens a model uses for text. ASCII
+// text is counted with the model family's average characters per token and
+// every other character as a token of its own, which errs on the high side.
+func CountTokens(model string, text string) int {
+	if text == "" {
+		return 0
+	}
+
+	ascii := 0
+	other := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+
+	return int(float64(ascii)/limitsFor(model).charsPerToken+0.5) + other
+}
+
+// CountMessageTokens estimates the number of tokens a model uses for a message
+func CountMessageTokens(model string, message Message) int {
+	tokens := messageOverheadTokens + CountTokens(model, message.Content)
+	for _, tc := range message.ToolCalls {
+		tokens += messageOverheadTokens + CountTokens(model, tc.Function.Name) + CountTokens(model, tc.Function.Arguments)
+	}
+
+	return tokens
+}
+
+// CountMessagesTokens estimates the number of tokens a model uses for a conversation
+func CountMessagesTokens(model string, messages []Message) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += CountMessageTokens(model, message)
+	}
+
+	return tokens
+}
+
+// TruncateToTokens shortens text to roughly maxTokens tokens, keeping its
+// beginning and end and noting how much was removed
+func TruncateToTokens(model string, text string, maxTokens int) string {
+	if CountTokens(model, text) <= maxTokens {
+		return text
+	}
+
+	// Work in runes so that multi-byte characters are not split, and leave
+	// room for the truncation note so that truncated text is not cut again
+	runes := []rune(text)
+	keep := int(float64(maxTokens-20) * limitsFor(model).charsPerToken)
+	if keep < 1 {
+		keep = 1
+	}
+	if keep >= len(runes) {
+		return text
+	}
+
+	head := keep * 3 / 4
+	tail := keep - head
+	removed := len(runes) - head - tail
+
+	return fmt.Sprintf("%s\n\n[... %d characters truncated ...]\n\n%s", string(runes[:head]), removed, string(runes[len(runes)-tail:]))
+}
@@ -83,6 +83,22 @@ func (h *ToolCallingHandler) ProcessToolCalls(ctx context.Context, toolCalls []T
 	return resultMessages, nil
 }
 
+// ExecuteToolCall executes a single tool call and caches its result. A call
+// to an unknown tool returns a result listing the available tools instead of
+// an error, so that the LLM can correct itself.
+func (h *ToolCallingHandler) ExecuteToolCall(ctx context.Context, tc ToolCall) (interface{}, error) {
+	result, err := h.executeTool(ctx, tc)
+	if err != nil {
+		return nil, err
+	}
+
+	if tc.ID != "" {
+		h.cacheResult(tc.ID, result)
+	}
+
+	return result, nil
+}
+
 // executeTool executes a tool based on a tool call
 func (h *ToolCallingHandler) executeTool(ctx context.Context, tc ToolCall) (interface{}, error) {
 	// Get the tool name
@@ -98,6 +114,11 @@ func (h *ToolCallingHandler) executeTool(ctx context.Context, tc ToolCall) (inte
 		}, nil
 	}
 
+	// Check the arguments against the tool's schema before executing it
+	if err := tools.ValidateParams(tool, tc.Args); err != nil {
+		return nil, err
+	}
+
 	// Execute the tool with the provided arguments
 	result, err := tool.Execute(ctx, tc.Args)
 	if err != nil {
@@ -124,102 +145,58 @@ func (h *ToolCallingHandler) GetCachedResult(id string) (interface{}, bool) {
 	return result, exists
 }
 
-// GenerateToolDefinitions generates tool definitions for the LLM
+// GenerateToolDefinitions generates tool definitions for the LLM from the
+// parameter schemas declared by each tool
 func (h *ToolCallingHandler) GenerateToolDefinitions() []ToolDefinition {
-	tools := h.ToolCollection.ListTools()
-	definitions := make([]ToolDefinition, 0, len(tools))
+	toolList := h.ToolCollection.ListTools()
+	definitions := make([]ToolDefinition, 0, len(toolList))
 
-	for _, tool := range tools {
-		// Create a basic tool definition
-		def := ToolDefinition{
+	for _, tool := range toolList {
+		definitions = append(definitions, ToolDefinition{
 			Type: "function",
 			Function: Function{
 				Name:        tool.GetName(),
 				Description: tool.GetDescription(),
-				Parameters: Parameters{
-					Type:       "object",
-					Properties: make(map[string]Property),
-				},
+				Parameters:  ParametersFromSchema(tools.GetToolParameters(tool)),
 			},
-		}
+		})
+	}
 
-		// Add default parameters based on tool type
-		switch tool.GetName() {
-		case "bash":
-			def.Function.Parameters.Properties["command"] = Property{
-				Type:        "string",
-				Description: "The bash command to execute",
-			}
-			def.Function.Parameters.Properties["working_dir"] = Property{
-				Type:        "string",
-				Description: "Optional working directory for the command",
-			}
-			def.Function.Parameters.Properties["streaming"] = Property{
-				Type:        "boolean",
-				Description: "Whether to stream the output in real-time",
-			}
-			def.Function.Parameters.Properties["background"] = Property{
-				Type:        "boolean",
-				Description: "Whether to run the command in the background without waiting for completion",
-			}
-			def.Function.Parameters.Required = []string{"command"}
+	return definitions
+}
 
-		case "python":
-			def.Function.Parameters.Properties["code"] = Property{
-				Type:        "string",
-				Description: "The Python code to execute",
-			}
-			def.Function.Parameters.Properties["working_dir"] = Property{
-				Type:        "string",
-				Description: "Optional working directory for the code execution",
-			}
-			def.Function.Parameters.Required = []string{"code"}
+// ParametersFromSchema converts a tool parameter schema into LLM function parameters
+func ParametersFromSchema(schema tools.ParameterSchema) Parameters {
+	params := Parameters{
+		Type:       schema.Type,
+		Properties: make(map[string]Property, len(schema.Properties)),
+		Required:   schema.Required,
+	}
+	if params.Type == "" {
+		params.Type = "object"
+	}
 
-		case "file":
-			def.Function.Parameters.Properties["operation"] = Property{
-				Type:        "string",
-				Description: "The file operation to perform (read, write, list, delete)",
-				Enum:        []string{"read", "write", "list", "delete"},
-			}
-			def.Function.Parameters.Properties["path"] = Property{
-				Type:        "string",
-				Description: "The path to the file or directory",
-			}
-			def.Function.Parameters.Properties["content"] = Property{
-				Type:        "string",
-				Description: "The content to write to the file (for write operation)",
-			}
-			def.Function.Parameters.Required = []string{"operation"}
+	for name, property := range schema.Properties {
+		params.Properties[name] = propertyFromSchema(property)
+	}
 
-		case "web_search":
-			def.Function.Parameters.Properties["query"] = Property{
-				Type:        "string",
-				Description: "The search query",
-			}
-			def.Function.Parameters.Required = []string{"query"}
+	return params
+}
 
-		case "web_browser":
-			def.Function.Parameters.Properties["url"] = Property{
-				Type:        "string",
-				Description: "The URL to browse",
-			}
-			def.Function.Parameters.Required = []string{"url"}
-			
-		case "command_status":
-			def.Function.Parameters.Properties["command_id"] = Property{
-				Type:        "string",
-				Description: "The ID of the background command to check",
-			}
-			def.Function.Parameters.Required = []string{"command_id"}
-			
-		case "list_commands":
-			// No parameters needed for list_commands
-		}
+// propertyFromSchema converts a tool parameter property into an LLM property
+func propertyFromSchema(property tools.ParameterProperty) Property {
+	converted := Property{
+		Type:        property.Type,
+		Description: property.Description,
+		Enum:        property.Enum,
+	}
 
-		definitions = append(definitions, def)
+	if property.Items != nil {
+		items := propertyFromSchema(*property.Items)
+		converted.Items = &items
 	}
 
-	return definitions
+	return converted
 }
 
 // FormatToolCallsForPrompt formats tool calls for inclusion in a prompt
@@ -255,6 +232,11 @@ func (h *ToolCallingHandler) ExecuteToolByName(ctx context.Context, name string,
 		return nil, fmt.Errorf("tool not found: %s", name)
 	}
 
+	// Check the parameters against the tool's schema
+	if err := tools.ValidateParams(tool, params); err != nil {
+		return nil, err
+	}
+
 	// Execute the tool
 	result, err := tool.Execute(ctx, params)
 	if err != nil {
@@ -0,0 +1,522 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ModelPricing is the price of a model in dollars per million tokens
+type ModelPricing struct {
+	PromptPerMillion     float64 `json:"prompt_per_million"`
+	CompletionPerMillion float64 `json:"completion_per_million"`
+}
+
+// Cost returns the price of the given token usage
+func (p ModelPricing) Cost(usage Usage) float64 {
+	return (float64(usage.PromptTokens)*p.PromptPerMillion + float64(usage.CompletionTokens)*p.CompletionPerMillion) / 1e6
+}
+
+// DefaultPricing returns list prices for commonly used hosted models.
+// Entries from the pricing section of the config take precedence.
+func DefaultPricing() map[string]ModelPricing {
+	return map[string]ModelPricing{
+		"gpt-4o":                   {PromptPerMillion: 2.50, CompletionPerMillion: 10.00},
+		"gpt-4o-mini":              {PromptPerMillion: 0.15, CompletionPerMillion: 0.60},
+		"deepseek-chat":            {PromptPerMillion: 0.27, CompletionPerMillion: 1.10},
+		"claude-3-5-sonnet-latest": {PromptPerMillion: 3.00, CompletionPerMillion: 15.00},
+		"claude-3-5-haiku-latest":  {PromptPerMillion: 0.80, CompletionPerMillion: 4.00},
+	}
+}
+
+// UsageScope identifies what an LLM call was made for. Empty fields are
+// unknown, or match anything when the scope is used as a filter.
+type UsageScope struct {
+	FlowID string `json:"flow_id,omitempty"`
+	StepID string `json:"step_id,omitempty"`
+	RunID  string `json:"run_id,omitempty"`
+	Agent  string `json:"agent,omitempty"`
+}
+
+// matches reports whether the scope matches a filter
+func (s UsageScope) matches(filter UsageScope) bool {
+	return (filter.FlowID == "" || filter.FlowID == s.FlowID) &&
+		(filter.StepID == "" || filter.StepID == s.StepID) &&
+		(filter.RunID == "" || filter.RunID == s.RunID) &&
+		(filter.Agent == "" || filter.Agent == s.Agent)
+}
+
+// usageScopeKey is the context key for the usage scope
+type usageScopeKey struct{}
+
+// WithUsageScope returns a context whose LLM calls are attributed to scope.
+// Non-empty fields override those of any scope already on the context, so
+// flows, steps and agent runs can each add their part.
+func WithUsageScope(ctx context.Context, scope UsageScope) context.Context {
+	current := UsageScopeFromContext(ctx)
+	if scope.FlowID != "" {
+		current.FlowID = scope.FlowID
+	}
+	if scope.StepID != "" {
+		current.StepID = scope.StepID
+	}
+	if scope.RunID != "" {
+		current.RunID = scope.RunID
+	}
+	if scope.Agent != "" {
+		current.Agent = scope.Agent
+	}
+
+	return context.WithValue(ctx, usageScopeKey{}, current)
+}
+
+// UsageScopeFromContext returns the usage scope of a context
+func UsageScopeFromContext(ctx context.Context) UsageScope {
+	scope, _ := ctx.Value(usageScopeKey{}).(UsageScope)
+	return scope
+}
+
+// UsageRecord is the usage of a single LLM call
+type UsageRecord struct {
+	UsageScope
+	Time             time.Time `json:"time"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
+	Cost             float64   `json:"cost"`
+}
+
+// UsageTotals aggregates the usage of a number of LLM calls
+type UsageTotals struct {
+	Calls            int     `json:"calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Add adds the usage and cost of one call to the totals
+func (t *UsageTotals) Add(usage Usage, cost float64) {
+	t.Calls++
+	t.PromptTokens += usage.PromptTokens
+	t.CompletionTokens += usage.CompletionTokens
+	t.TotalTokens += usage.TotalTokens
+	t.Cost += cost
+}
+
+// merge adds other totals to the totals
+func (t *UsageTotals) merge(other UsageTotals) {
+	t.Calls += other.Calls
+	t.PromptTokens += other.PromptTokens
+	t.CompletionTokens += other.CompletionTokens
+	t.TotalTokens += other.TotalTokens
+	t.Cost += other.Cost
+}
+
+// subtract removes other totals from the totals
+func (t *UsageTotals) subtract(other UsageTotals) {
+	t.Calls -= other.Calls
+	t.PromptTokens -= other.PromptTokens
+	t.CompletionTokens -= other.CompletionTokens
+	t.TotalTokens -= other.TotalTokens
+	t.Cost -= other.Cost
+}
+
+// DefaultMaxRecords is how many of the latest calls a ledger keeps records of
+const DefaultMaxRecords = 1000
+
+// usageKey identifies the calls a ledger adds up together
+type usageKey struct {
+	UsageScope
+	Provider string
+	Model    string
+}
+
+// Ledger records the token usage and cost of LLM calls. It keeps running
+// totals of the session and of each model, and records of the latest calls.
+// Totals per scope and model are kept for the calls of each flow until the
+// flow is forgotten, and for other calls as long as the ledger has records
+// of them.
+type Ledger struct {
+	mutex   sync.RWMutex
+	session UsageTotals
+	models  map[string]*UsageTotals
+	totals  map[usageKey]*UsageTotals
+	// kept counts the records of each scope outside flows, whose totals are
+	// dropped with their last record
+	kept       map[usageKey]int
+	records    []UsageRecord
+	maxRecords int
+	pricing    map[string]ModelPricing
+}
+
+// NewLedger creates a ledger that prices calls with the given pricing table
+func NewLedger(pricing map[string]ModelPricing) *Ledger {
+	if pricing == nil {
+		pricing = make(map[string]ModelPricing)
+	}
+
+	return &Ledger{
+		models:     make(map[string]*UsageTotals),
+		totals:     make(map[usageKey]*UsageTotals),
+		kept:       make(map[usageKey]int),
+		records:    make([]UsageRecord, 0),
+		maxRecords: DefaultMaxRecords,
+		pricing:    pricing,
+	}
+}
+
+// WithMaxRecords sets how many of the latest calls the ledger keeps records
+// of. The totals of the session, of models and of flows cover all calls
+// regardless.
+func (l *Ledger) WithMaxRecords(maxRecords int) *Ledger {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.maxRecords = maxRecords
+	l.trim()
+	return l
+}
+
+// Price returns the pricing for a model. Dated model versions such as
+// gpt-4o-mini-2024-07-18 use the longest matching entry.
+func (l *Ledger) Price(model string) (ModelPricing, bool) {
+	if pricing, ok := l.pricing[model]; ok {
+		return pricing, true
+	}
+
+	best := ""
+	for name := range l.pricing {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPricing{}, false
+	}
+
+	return l.pricing[best], true
+}
+
+// Record adds the usage of a call to the ledger and returns the record
+func (l *Ledger) Record(scope UsageScope, provider, model string, usage Usage) UsageRecord {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+
+	pricing, _ := l.Price(model)
+	record := UsageRecord{
+		UsageScope:       scope,
+		Time:             time.Now(),
+		Provider:         provider,
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             pricing.Cost(usage),
+	}
+
+	key := usageKey{UsageScope: scope, Provider: provider, Model: model}
+
+	l.mutex.Lock()
+	l.session.Add(usage, record.Cost)
+	modelTotals, exists := l.models[model]
+	if !exists {
+		modelTotals = &UsageTotals{}
+		l.models[model] = modelTotals
+	}
+	modelTotals.Add(usage, record.Cost)
+	totals, exists := l.totals[key]
+	if !exists {
+		totals = &UsageTotals{}
+		l.totals[key] = totals
+	}
+	totals.Add(usage, record.Cost)
+	if scope.FlowID == "" {
+		l.kept[key]++
+	}
+	l.records = append(l.records, record)
+	l.trim()
+	l.mutex.Unlock()
+
+	return record
+}
+
+// trim drops the oldest records beyond the limit, with the totals of the
+// scopes outside flows that have no records left. The caller must hold the
+// mutex.
+func (l *Ledger) trim() {
+	if l.maxRecords <= 0 || len(l.records) <= l.maxRecords {
+		return
+	}
+
+	dropped := l.records[:len(l.records)-l.maxRecords]
+	for _, record := range dropped {
+		if record.FlowID != "" {
+			continue
+		}
+		key := usageKey{UsageScope: record.UsageScope, Provider: record.Provider, Model: record.Model}
+		if l.kept[key]--; l.kept[key] <= 0 {
+			delete(l.kept, key)
+			delete(l.totals, key)
+		}
+	}
+	l.records = append([]UsageRecord(nil), l.records[len(dropped):]...)
+}
+
+// Records returns the kept records matching a filter, oldest first
+func (l *Ledger) Records(filter UsageScope) []UsageRecord {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	records := make([]UsageRecord, 0)
+	for _, record := range l.records {
+		if record.matches(filter) {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// Totals returns the aggregated usage of the calls matching a filter: all
+// calls of the session for an empty filter, and otherwise those the ledger
+// keeps totals per scope of
+func (l *Ledger) Totals(filter UsageScope) UsageTotals {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if filter == (UsageScope{}) {
+		return l.session
+	}
+
+	var totals UsageTotals
+	for key, group := range l.totals {
+		if key.matches(filter) {
+			totals.merge(*group)
+		}
+	}
+
+	return totals
+}
+
+// TotalsBy returns the aggregated usage of the calls matching a filter that
+// the ledger keeps totals per scope of, grouped by the key returned for each
+// group of calls. The key is given a
+// record of the scope, provider and model of the calls, whose usage and cost
+// are their sums. Groups with an empty key are skipped.
+func (l *Ledger) TotalsBy(filter UsageScope, key func(record UsageRecord) string) map[string]UsageTotals {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	grouped := make(map[string]UsageTotals)
+	for k, group := range l.totals {
+		if !k.matches(filter) {
+			continue
+		}
+
+		name := key(UsageRecord{
+			UsageScope:       k.UsageScope,
+			Provider:         k.Provider,
+			Model:            k.Model,
+			PromptTokens:     group.PromptTokens,
+			CompletionTokens: group.CompletionTokens,
+			TotalTokens:      group.TotalTokens,
+			Cost:             group.Cost,
+		})
+		if name == "" {
+			continue
+		}
+
+		totals := grouped[name]
+		totals.merge(*group)
+		grouped[name] = totals
+	}
+
+	return grouped
+}
+
+// ModelTotals returns the aggregated usage of all calls of the session by model
+func (l *Ledger) ModelTotals() map[string]UsageTotals {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	models := make(map[string]UsageTotals, len(l.models))
+	for model, totals := range l.models {
+		models[model] = *totals
+	}
+
+	return models
+}
+
+// ForgetFlow drops the totals and records of a flow, once it is deleted, and
+// takes its calls out of the session and model totals
+func (l *Ledger) ForgetFlow(flowID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for key, totals := range l.totals {
+		if key.FlowID != flowID {
+			continue
+		}
+		l.session.subtract(*totals)
+		if model, exists := l.models[key.Model]; exists {
+			model.subtract(*totals)
+			if model.Calls <= 0 {
+				delete(l.models, key.Model)
+			}
+		}
+		delete(l.totals, key)
+	}
+
+	records := make([]UsageRecord, 0, len(l.records))
+	for _, record := range l.records {
+		if record.FlowID != flowID {
+			records = append(records, record)
+		}
+	}
+	l.records = records
+}
+
+// Budget limits the tokens or dollars that may be spent. Zero means no limit.
+type Budget struct {
+	MaxTokens int
+	MaxCost   float64
+}
+
+// exceeded reports whether the totals have reached the budget
+func (b Budget) exceeded(totals UsageTotals) bool {
+	return (b.MaxTokens > 0 && totals.TotalTokens >= b.MaxTokens) ||
+		(b.MaxCost > 0 && totals.Cost >= b.MaxCost)
+}
+
+// ErrBudgetExceeded is returned when a call would exceed a usage budget
+var ErrBudgetExceeded = errors.New("usage budget exceeded")
+
+// UsageTracker is implemented by LLM clients that record usage in a ledger
+type UsageTracker interface {
+	// UsageLedger returns the ledger the client records usage in
+	UsageLedger() *Ledger
+}
+
+// MeteredClient records the usage of every call in a ledger and refuses
+// calls once the agent run or flow they belong to has used up its budget
+type MeteredClient struct {
+	Client     Client
+	Ledger     *Ledger
+	RunBudget  Budget
+	FlowBudget Budget
+}
+
+// NewMeteredClient creates a client that records the usage of client in ledger
+func NewMeteredClient(client Client, ledger *Ledger) *MeteredClient {
+	return &MeteredClient{
+		Client: client,
+		Ledger: ledger,
+	}
+}
+
+// WithMetering returns middleware that records usage in ledger
+func WithMetering(ledger *Ledger) Middleware {
+	return func(client Client) Client {
+		return NewMeteredClient(client, ledger)
+	}
+}
+
+// WithRunBudget sets the budget for each agent run
+func (c *MeteredClient) WithRunBudget(budget Budget) *MeteredClient {
+	c.RunBudget = budget
+	return c
+}
+
+// WithFlowBudget sets the budget for each flow
+func (c *MeteredClient) WithFlowBudget(budget Budget) *MeteredClient {
+	c.FlowBudget = budget
+	return c
+}
+
+// GetModelName returns the name of the model being used
+func (c *MeteredClient) GetModelName() string {
+	return c.Client.GetModelName()
+}
+
+// GetProvider returns the name of the LLM provider
+func (c *MeteredClient) GetProvider() string {
+	return c.Client.GetProvider()
+}
+
+// UsageLedger returns the ledger the client records usage in
+func (c *MeteredClient) UsageLedger() *Ledger {
+	return c.Ledger
+}
+
+// ChatCompletion generates a chat completion and records its usage
+func (c *MeteredClient) ChatCompletion(ctx context.Context, request *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	if err := c.checkBudget(ctx); err != nil {
+		return nil, err
+	}
+
+	response, err := c.Client.ChatCompletion(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	c.record(ctx, response)
+	return response, nil
+}
+
+// ChatCompletionStream streams a chat completion and records its usage
+func (c *MeteredClient) ChatCompletionStream(ctx context.Context, request *ChatCompletionRequest, handler StreamHandler) (*ChatCompletionResponse, error) {
+	if err := c.checkBudget(ctx); err != nil {
+		return nil, err
+	}
+
+	response, err := StreamChatCompletion(ctx, c.Client, request, handler)
+	if err != nil {
+		return nil, err
+	}
+
+	c.record(ctx, response)
+	return response, nil
+}
+
+// checkBudget returns an error if the run or flow of the context has used up its budget
+func (c *MeteredClient) checkBudget(ctx context.Context) error {
+	scope := UsageScopeFromContext(ctx)
+
+	if scope.RunID != "" && (c.RunBudget != Budget{}) {
+		totals := c.Ledger.Totals(UsageScope{RunID: scope.RunID})
+		if c.RunBudget.exceeded(totals) {
+			return fmt.Errorf("run %s used %d tokens ($%.4f): %w", scope.RunID, totals.TotalTokens, totals.Cost, ErrBudgetExceeded)
+		}
+	}
+
+	if scope.FlowID != "" && (c.FlowBudget != Budget{}) {
+		totals := c.Ledger.Totals(UsageScope{FlowID: scope.FlowID})
+		if c.FlowBudget.exceeded(totals) {
+			return fmt.Errorf("flow %s used %d tokens ($%.4f): %w", scope.FlowID, totals.TotalTokens, totals.Cost, ErrBudgetExceeded)
+		}
+	}
+
+	return nil
+}
+
+// record adds the usage of a response to the ledger and notes its cost on the response
+func (c *MeteredClient) record(ctx context.Context, response *ChatCompletionResponse) {
+	provider := response.Provider
+	if provider == "" {
+		provider = c.Client.GetProvider()
+	}
+	model := response.Model
+	if model == "" {
+		model = c.Client.GetModelName()
+	}
+
+	record := c.Ledger.Record(UsageScopeFromContext(ctx), provider, model, response.Usage)
+	response.Cost = record.Cost
+}
@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLedgerKeepsTotalsOfEvictedRecords(t *testing.T) {
+	ledger := NewLedger(map[string]ModelPricing{
+		"gpt-4o": {PromptPerMillion: 1e6, CompletionPerMillion: 2e6},
+	}).WithMaxRecords(2)
+
+	flow := UsageScope{FlowID: "flow1", StepID: "step1", RunID: "run1"}
+	for i := 0; i < 5; i++ {
+		ledger.Record(flow, "openai", "gpt-4o", Usage{PromptTokens: 1, CompletionTokens: 2})
+	}
+	ledger.Record(UsageScope{FlowID: "flow2", RunID: "run2"}, "openai", "gpt-4o-mini", Usage{PromptTokens: 10})
+
+	if records := ledger.Records(UsageScope{}); len(records) != 2 {
+		t.Fatalf("kept %d records, want 2", len(records))
+	}
+	if records := ledger.Records(UsageScope{FlowID: "flow1"}); len(records) != 1 {
+		t.Errorf("kept %d records of flow1, want 1", len(records))
+	}
+
+	totals := ledger.Totals(UsageScope{FlowID: "flow1"})
+	want := UsageTotals{Calls: 5, PromptTokens: 5, CompletionTokens: 10, TotalTokens: 15, Cost: 25}
+	if totals != want {
+		t.Errorf("flow1 totals = %+v, want %+v", totals, want)
+	}
+	if totals := ledger.Totals(UsageScope{RunID: "run2"}); totals.Calls != 1 || totals.TotalTokens != 10 {
+		t.Errorf("run2 totals = %+v", totals)
+	}
+	if totals := ledger.Totals(UsageScope{}); totals.Calls != 6 || totals.TotalTokens != 25 {
+		t.Errorf("session totals = %+v", totals)
+	}
+
+	byModel := ledger.TotalsBy(UsageScope{}, func(record UsageRecord) string {
+		return record.Model
+	})
+	if len(byModel) != 2 || byModel["gpt-4o"].Calls != 5 || byModel["gpt-4o-mini"].Calls != 1 {
+		t.Errorf("totals by model = %+v", byModel)
+	}
+	bySteps := ledger.TotalsBy(UsageScope{FlowID: "flow2"}, func(record UsageRecord) string {
+		return record.StepID
+	})
+	if len(bySteps) != 0 {
+		t.Errorf("calls without a step were grouped: %+v", bySteps)
+	}
+}
+
+func TestLedgerForgetFlow(t *testing.T) {
+	ledger := NewLedger(nil)
+	ledger.Record(UsageScope{FlowID: "flow1", RunID: "run1"}, "openai", "gpt-4o", Usage{PromptTokens: 1})
+	ledger.Record(UsageScope{FlowID: "flow2", RunID: "run2"}, "openai", "gpt-4o", Usage{PromptTokens: 2})
+
+	ledger.ForgetFlow("flow1")
+
+	if totals := ledger.Totals(UsageScope{FlowID: "flow1"}); totals.Calls != 0 {
+		t.Errorf("flow1 totals = %+v after it was forgotten", totals)
+	}
+	if records := ledger.Records(UsageScope{FlowID: "flow1"}); len(records) != 0 {
+		t.Errorf("flow1 has %d records after it was forgotten", len(records))
+	}
+	if totals := ledger.Totals(UsageScope{}); totals.Calls != 1 || totals.TotalTokens != 2 {
+		t.Errorf("session totals = %+v, want only flow2", totals)
+	}
+}
+
+func TestLedgerBoundsTotalsOutsideFlows(t *testing.T) {
+	ledger := NewLedger(nil).WithMaxRecords(3)
+	for i := 0; i < 100; i++ {
+		ledger.Record(UsageScope{RunID: fmt.Sprintf("run-%d", i)}, "openai", "gpt-4o", Usage{PromptTokens: 1})
+	}
+
+	if len(ledger.totals) > 3 {
+		t.Errorf("kept totals of %d scopes, want at most 3", len(ledger.totals))
+	}
+	if totals := ledger.Totals(UsageScope{RunID: "run-99"}); totals.Calls != 1 {
+		t.Errorf("latest run totals = %+v", totals)
+	}
+	if totals := ledger.Totals(UsageScope{RunID: "run-0"}); totals.Calls != 0 {
+		t.Errorf("totals of an evicted run = %+v", totals)
+	}
+	if totals := ledger.Totals(UsageScope{}); totals.Calls != 100 {
+		t.Errorf("session totals = %+v, want 100 calls", totals)
+	}
+	if models := ledger.ModelTotals(); models["gpt-4o"].Calls != 100 {
+		t.Errorf("model totals = %+v, want 100 calls", models)
+	}
+}
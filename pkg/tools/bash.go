@@ -13,6 +13,7 @@ type BashTool struct {
 	*BaseTool
 	WorkingDir string
 	Timeout    time.Duration
+	Backend    executor.Backend
 }
 
 // BashResult represents the result of a bash command execution
@@ -24,6 +25,8 @@ type BashResult struct {
 	Duration   float64  `json:"duration"`
 	OutputList []string `json:"output_list,omitempty"`
 	ErrorList  []string `json:"error_list,omitempty"`
+	// Usage is the peak memory, CPU time and output size of the command
+	Usage executor.ResourceUsage `json:"usage"`
 }
 
 // NewBashTool creates a new bash tool
@@ -44,6 +47,50 @@ func (t *BashTool) WithTimeout(timeout time.Duration) *BashTool {
 	return t
 }
 
+// WithBackend sets the backend that commands run on
+func (t *BashTool) WithBackend(backend executor.Backend) *BashTool {
+	t.Backend = backend
+	return t
+}
+
+// GetParameters returns the parameter schema for the bash tool
+func (t *BashTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"command": {
+			Type:        "string",
+			Description: "The bash command to execute",
+		},
+		"working_dir": {
+			Type:        "string",
+			Description: "Optional working directory for the command",
+		},
+		"streaming": {
+			Type:        "boolean",
+			Description: "Whether to stream the output in real-time",
+		},
+		"background": {
+			Type:        "boolean",
+			Description: "Whether to run the command in the background without waiting for completion",
+		},
+		"timeout": {
+			Type:        "number",
+			Description: "Optional timeout in seconds",
+		},
+		"pty": {
+			Type:        "boolean",
+			Description: "Whether to run the command in a pseudo-terminal, for programs that prompt for input or draw progress bars or full-screen output. Send keystrokes to it with the command_control tool.",
+		},
+		"rows": {
+			Type:        "number",
+			Description: "Optional number of rows of the pseudo-terminal (default 24)",
+		},
+		"cols": {
+			Type:        "number",
+			Description: "Optional number of columns of the pseudo-terminal (default 80)",
+		},
+	}, "command")
+}
+
 // Execute runs a bash command
 func (t *BashTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 	// Get the command from parameters
@@ -73,10 +120,21 @@ func (t *BashTool) Execute(ctx context.Context, params map[string]interface{}) (
 		timeout = time.Duration(timeoutSec) * time.Second
 	}
 
+	// Get optional pseudo-terminal settings
+	pty, _ := params["pty"].(bool)
+	rows, _ := params["rows"].(float64)
+	cols, _ := params["cols"].(float64)
+
 	// If background is true, run the command in the background
 	if background {
 		// Start the command in the background
-		bgCmd, err := executor.ExecuteCommandWithStreaming(cmdStr, workingDir)
+		var bgCmd *executor.BackgroundCommand
+		var err error
+		if pty {
+			bgCmd, err = executor.ExecuteCommandInTerminal(t.Backend, cmdStr, workingDir, uint16(rows), uint16(cols))
+		} else {
+			bgCmd, err = executor.ExecuteCommandOnBackend(t.Backend, cmdStr, workingDir)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to start background command: %w", err)
 		}
@@ -94,7 +152,7 @@ func (t *BashTool) Execute(ctx context.Context, params map[string]interface{}) (
 	}
 
 	// Create and execute the command synchronously
-	cmd := executor.NewShellCommand(cmdStr, workingDir).WithTimeout(timeout)
+	cmd := executor.NewShellCommand(cmdStr, workingDir).WithTimeout(timeout).WithBackend(t.Backend)
 	if streaming {
 		cmd = cmd.WithStreaming()
 	}
@@ -114,6 +172,7 @@ func (t *BashTool) Execute(ctx context.Context, params map[string]interface{}) (
 		Duration:   result.Duration,
 		OutputList: result.OutputList,
 		ErrorList:  result.ErrorList,
+		Usage:      result.Usage,
 	}
 
 	return bashResult, nil
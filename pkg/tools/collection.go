@@ -79,6 +79,11 @@ func (c *ToolCollection) ExecuteTool(ctx context.Context, name string, params ma
 		return nil, err
 	}
 	
+	// Check the parameters against the tool's schema
+	if err := ValidateParams(tool, params); err != nil {
+		return nil, err
+	}
+	
 	return tool.Execute(ctx, params)
 }
 
@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+)
+
+// CommandControlTool interacts with running background commands
+type CommandControlTool struct {
+	*BaseTool
+	// Settle is how long to wait after sending input before reading the
+	// command's state
+	Settle time.Duration
+	// GracePeriod is how long cancel waits before killing a command
+	GracePeriod time.Duration
+}
+
+// CommandControlResult represents the result of a command control operation
+type CommandControlResult struct {
+	CommandID string `json:"command_id"`
+	Running   bool   `json:"running"`
+	// ExitCode is only set once the command has finished
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Message  string   `json:"message"`
+	Screen   []string `json:"screen,omitempty"`
+}
+
+// NewCommandControlTool creates a new command control tool
+func NewCommandControlTool() *CommandControlTool {
+	return &CommandControlTool{
+		BaseTool: NewBaseTool(
+			"command_control",
+			"Interact with a running background command: write to its input, for example to answer a [y/N] prompt, send it a signal such as SIGINT (Ctrl-C), cancel it, or for commands started with pty set to true, send keystrokes or resize the terminal. Signals go to the command and every process it started.",
+		),
+		Settle:      300 * time.Millisecond,
+		GracePeriod: 5 * time.Second,
+	}
+}
+
+// WithGracePeriod sets how long cancel waits before killing a command
+func (t *CommandControlTool) WithGracePeriod(grace time.Duration) *CommandControlTool {
+	t.GracePeriod = grace
+	return t
+}
+
+// GetParameters returns the parameter schema for the command control tool
+func (t *CommandControlTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"command_id": {
+			Type:        "string",
+			Description: "The ID of the background command",
+		},
+		"operation": {
+			Type:        "string",
+			Description: "The operation to perform: write input to the command, send it a signal, cancel it (SIGTERM, then SIGKILL after the grace period), send keystrokes to its terminal, or resize its terminal",
+			Enum:        []string{"input", "signal", "cancel", "keys", "resize"},
+		},
+		"input": {
+			Type:        "string",
+			Description: "The text to write to the command's input (required for input unless eof is set)",
+		},
+		"newline": {
+			Type:        "boolean",
+			Description: "Whether to end the input with a newline, as when pressing Enter (default true)",
+		},
+		"eof": {
+			Type:        "boolean",
+			Description: "Whether to close the command's input after writing, for commands that read it to the end",
+		},
+		"signal": {
+			Type:        "string",
+			Description: "The signal to send (required for signal)",
+			Enum:        []string{"SIGINT", "SIGTERM", "SIGKILL"},
+		},
+		"grace_period": {
+			Type:        "number",
+			Description: "Optional number of seconds cancel waits for the command to exit before killing it (default 5)",
+		},
+		"keys": {
+			Type:        "string",
+			Description: "The keystrokes to send (required for keys). Text is typed as is and special keys go in angle brackets, for example \"yes<Enter>\", \"<Up><Enter>\", \"q\" or \"<C-c>\". Available keys: <Enter>, <Tab>, <Esc>, <Space>, <Backspace>, <Delete>, <Up>, <Down>, <Left>, <Right>, <Home>, <End>, <PageUp>, <PageDown>, <lt> for a literal < and <C-x> for Ctrl with a letter.",
+		},
+		"rows": {
+			Type:        "number",
+			Description: "The number of rows of the terminal (required for resize)",
+		},
+		"cols": {
+			Type:        "number",
+			Description: "The number of columns of the terminal (required for resize)",
+		},
+	}, "command_id", "operation")
+}
+
+// Execute performs a command control operation
+func (t *CommandControlTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	cmdID, ok := params["command_id"].(string)
+	if !ok || cmdID == "" {
+		return nil, fmt.Errorf("command_id parameter is required and must be a string")
+	}
+
+	operation, ok := params["operation"].(string)
+	if !ok || operation == "" {
+		return nil, fmt.Errorf("operation parameter is required and must be a string")
+	}
+
+	bgCmd, err := executor.GetCommand(cmdID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CommandControlResult{
+		CommandID: cmdID,
+	}
+
+	switch operation {
+	case "input":
+		input, _ := params["input"].(string)
+		eof, _ := params["eof"].(bool)
+		if input == "" && !eof {
+			return nil, fmt.Errorf("input parameter is required for input")
+		}
+		if newline, ok := params["newline"].(bool); (!ok || newline) && input != "" && !strings.HasSuffix(input, "\n") {
+			input += "\n"
+		}
+		if input != "" {
+			if err := bgCmd.WriteInput(input); err != nil {
+				return nil, err
+			}
+		}
+		if eof {
+			if err := bgCmd.CloseInput(); err != nil {
+				return nil, err
+			}
+		}
+		result.Message = "Input written"
+		t.settle(ctx, bgCmd, t.Settle)
+	case "signal":
+		name, ok := params["signal"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("signal parameter is required for signal")
+		}
+		sig, err := executor.ParseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := bgCmd.Signal(sig); err != nil {
+			return nil, err
+		}
+		result.Message = fmt.Sprintf("%s sent", executor.SignalName(sig))
+		t.settle(ctx, bgCmd, t.Settle)
+	case "cancel":
+		grace := t.GracePeriod
+		if graceSec, ok := params["grace_period"].(float64); ok && graceSec >= 0 {
+			grace = time.Duration(graceSec * float64(time.Second))
+		}
+		if err := bgCmd.Terminate(syscall.SIGTERM, grace); err != nil {
+			return nil, err
+		}
+		result.Message = "Command cancelled"
+		// Wait for the command to exit, or to be killed after the grace period
+		t.settle(ctx, bgCmd, grace+time.Second)
+	case "keys":
+		keys, ok := params["keys"].(string)
+		if !ok || keys == "" {
+			return nil, fmt.Errorf("keys parameter is required for keys")
+		}
+		if err := bgCmd.SendKeys(keys); err != nil {
+			return nil, err
+		}
+		result.Message = "Keys sent"
+		t.settle(ctx, bgCmd, t.Settle)
+	case "resize":
+		rows, _ := params["rows"].(float64)
+		cols, _ := params["cols"].(float64)
+		if rows < 1 || cols < 1 {
+			return nil, fmt.Errorf("rows and cols parameters are required for resize")
+		}
+		if err := bgCmd.Resize(uint16(rows), uint16(cols)); err != nil {
+			return nil, err
+		}
+		result.Message = fmt.Sprintf("Terminal resized to %dx%d", int(rows), int(cols))
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", operation)
+	}
+
+	done, exitCode, _, _, _, _ := bgCmd.GetStatus()
+	result.Running = !done
+	if done {
+		result.ExitCode = &exitCode
+	} else {
+		result.Screen = bgCmd.Screen()
+	}
+
+	return result, nil
+}
+
+// settle gives the command a moment to react before its state is reported
+func (t *CommandControlTool) settle(ctx context.Context, bgCmd *executor.BackgroundCommand, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-bgCmd.Done:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
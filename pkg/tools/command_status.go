@@ -3,6 +3,8 @@ package tools
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/prathyushnallamothu/commandforge/pkg/executor"
 )
@@ -10,18 +12,33 @@ import (
 // CommandStatusTool provides functionality to check the status of background commands
 type CommandStatusTool struct {
 	*BaseTool
+	// TailLines is the number of last lines returned when no lines are asked for
+	TailLines int
+	// MaxLines caps the number of lines returned by one call
+	MaxLines int
 }
 
 // CommandStatusResult represents the result of a command status check
 type CommandStatusResult struct {
-	CommandID  string   `json:"command_id"`
-	Running    bool     `json:"running"`
-	Success    bool     `json:"success"`
-	ExitCode   int      `json:"exit_code,omitempty"`
-	Output     string   `json:"output"`
-	Error      string   `json:"error"`
-	OutputList []string `json:"output_list,omitempty"`
-	ErrorList  []string `json:"error_list,omitempty"`
+	CommandID string `json:"command_id"`
+	Running   bool   `json:"running"`
+	Success   bool   `json:"success"`
+	// State is running, succeeded, failed or interrupted
+	State    executor.CommandState `json:"state"`
+	ExitCode int                   `json:"exit_code,omitempty"`
+	// Output holds the selected lines of stdout and stderr in the order they
+	// were read, each prefixed with its line number
+	Output       string `json:"output"`
+	TotalLines   int    `json:"total_lines"`
+	NextOffset   int    `json:"next_offset,omitempty"`
+	HasMore      bool   `json:"has_more,omitempty"`
+	DroppedLines int    `json:"dropped_lines,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// Usage is the peak memory, CPU time and output size of the command so
+	// far, to spot runaway commands
+	Usage executor.ResourceUsage `json:"usage"`
+	// Screen is what a command running in a pseudo-terminal currently shows
+	Screen []string `json:"screen,omitempty"`
 }
 
 // NewCommandStatusTool creates a new command status tool
@@ -29,11 +46,48 @@ func NewCommandStatusTool() *CommandStatusTool {
 	return &CommandStatusTool{
 		BaseTool: NewBaseTool(
 			"command_status",
-			"Check the status of a background command",
+			"Check the status of a background command and read its output. By default it returns the last 50 lines; use offset and limit to page through the output, tail for the last lines, and pattern and stream to filter them.",
 		),
+		TailLines: 50,
+		MaxLines:  500,
 	}
 }
 
+// GetParameters returns the parameter schema for the command status tool
+func (t *CommandStatusTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"command_id": {
+			Type:        "string",
+			Description: "The ID of the background command to check",
+		},
+		"offset": {
+			Type:        "number",
+			Description: "Optional number of the first line to read, starting at 1, for example the next_offset of a previous call",
+		},
+		"limit": {
+			Type:        "number",
+			Description: "Optional maximum number of lines to read from offset (default and maximum 500)",
+		},
+		"tail": {
+			Type:        "number",
+			Description: "Optional number of last lines to read instead, for example 50",
+		},
+		"pattern": {
+			Type:        "string",
+			Description: "Optional regular expression; only matching lines are returned, like grep",
+		},
+		"stream": {
+			Type:        "string",
+			Description: "Optional stream to read; both are read by default",
+			Enum:        []string{"stdout", "stderr"},
+		},
+		"timestamps": {
+			Type:        "boolean",
+			Description: "Whether to show when each line was written, in seconds since the command started",
+		},
+	}, "command_id")
+}
+
 // Execute checks the status of a background command
 func (t *CommandStatusTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 	// Get the command ID from parameters
@@ -48,18 +102,58 @@ func (t *CommandStatusTool) Execute(ctx context.Context, params map[string]inter
 		return nil, err
 	}
 
-	// Get the status
-	done, exitCode, output, errorStr, outputLines, errorLines := bgCmd.GetStatus()
+	// Build the output query, reading the last lines unless told otherwise
+	query := executor.OutputQuery{
+		Limit: t.MaxLines,
+	}
+	if offset, ok := params["offset"].(float64); ok && offset > 0 {
+		query.Offset = int(offset)
+	}
+	if limit, ok := params["limit"].(float64); ok && limit > 0 && int(limit) < t.MaxLines {
+		query.Limit = int(limit)
+	}
+	if tail, ok := params["tail"].(float64); ok && tail > 0 {
+		query.Tail = min(int(tail), t.MaxLines)
+	} else if query.Offset == 0 {
+		query.Tail = t.TailLines
+	}
+	if pattern, ok := params["pattern"].(string); ok {
+		query.Pattern = pattern
+	}
+	if stream, ok := params["stream"].(string); ok {
+		query.Stream = executor.OutputStream(stream)
+	}
+	timestamps, _ := params["timestamps"].(bool)
+
+	// Get the status before the output, so that a finished command's
+	// output is complete
+	done, exitCode, _, _, _, _ := bgCmd.GetStatus()
+
+	page, err := bgCmd.ReadOutput(query)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create a structured result with proper fields
 	result := &CommandStatusResult{
-		CommandID:  cmdID,
-		Running:    !done,
-		ExitCode:   exitCode,
-		Output:     output,
-		Error:      errorStr,
-		OutputList: outputLines,
-		ErrorList:  errorLines,
+		CommandID:    cmdID,
+		Running:      !done,
+		State:        bgCmd.State(),
+		ExitCode:     exitCode,
+		Output:       formatOutputLines(page.Lines, bgCmd.StartTime, timestamps),
+		TotalLines:   page.TotalLines,
+		HasMore:      page.HasMore,
+		DroppedLines: page.DroppedLines,
+		Usage:        bgCmd.Usage(),
+	}
+	if page.HasMore {
+		result.NextOffset = page.NextOffset
+		result.Message = fmt.Sprintf("More output follows. Use offset %d to read it.", page.NextOffset)
+	} else if query.Tail > 0 && len(page.Lines) == query.Tail && page.Lines[0].Number > 1 {
+		result.Message = fmt.Sprintf("Showing the last lines of %d. Use offset and limit to read earlier ones.", page.TotalLines)
+	}
+	if !done {
+		result.Screen = bgCmd.Screen()
 	}
 
 	// Set success field based on the two-tier approach from StartIt application
@@ -75,3 +169,24 @@ func (t *CommandStatusTool) Execute(ctx context.Context, params map[string]inter
 
 	return result, nil
 }
+
+// formatOutputLines numbers output lines and marks the ones written to stderr
+func formatOutputLines(lines []executor.OutputLine, start time.Time, timestamps bool) string {
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%d", line.Number)
+		if timestamps {
+			fmt.Fprintf(&b, " [+%.3fs]", line.Time.Sub(start).Seconds())
+		}
+		if line.Stream == executor.StreamStderr {
+			b.WriteString(" [stderr]")
+		}
+		b.WriteString(": ")
+		b.WriteString(line.Text)
+	}
+
+	return b.String()
+}
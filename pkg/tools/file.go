@@ -32,6 +32,25 @@ func NewFileTool(baseDir string) *FileTool {
 	}
 }
 
+// GetParameters returns the parameter schema for the file tool
+func (t *FileTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"operation": {
+			Type:        "string",
+			Description: "The file operation to perform (read, write, list, delete)",
+			Enum:        []string{"read", "write", "list", "delete"},
+		},
+		"path": {
+			Type:        "string",
+			Description: "The path to the file or directory",
+		},
+		"content": {
+			Type:        "string",
+			Description: "The content to write to the file (for write operation)",
+		},
+	}, "operation")
+}
+
 // Execute performs file operations
 func (t *FileTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 	// Get the operation from parameters
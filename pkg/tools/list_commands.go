@@ -13,7 +13,7 @@ type ListCommandsTool struct {
 
 // ListCommandsResult represents the result of listing commands
 type ListCommandsResult struct {
-	Commands []string `json:"commands"`
+	Commands []executor.CommandRecord `json:"commands"`
 }
 
 // NewListCommandsTool creates a new list commands tool
@@ -21,18 +21,36 @@ func NewListCommandsTool() *ListCommandsTool {
 	return &ListCommandsTool{
 		BaseTool: NewBaseTool(
 			"list_commands",
-			"List all background commands",
+			"List background commands with their state, oldest first. Commands that were running when the program last stopped are listed as interrupted.",
 		),
 	}
 }
 
+// GetParameters returns the parameter schema for the list commands tool
+func (t *ListCommandsTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"state": {
+			Type:        "string",
+			Description: "Optional state to list only the commands in it",
+			Enum:        []string{"running", "succeeded", "failed", "interrupted"},
+		},
+	})
+}
+
 // Execute lists all background commands
 func (t *ListCommandsTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
-	// Get the list of commands
-	commands := executor.ListCommands()
+	// Get the state to filter by
+	var state executor.CommandState
+	if name, ok := params["state"].(string); ok && name != "" {
+		var err error
+		state, err = executor.ParseCommandState(name)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Return the list
 	return &ListCommandsResult{
-		Commands: commands,
+		Commands: executor.ListCommandRecords(state),
 	}, nil
 }
@@ -15,6 +15,7 @@ type PythonTool struct {
 	*BaseTool
 	WorkingDir string
 	Timeout    time.Duration
+	Backend    executor.Backend
 	PythonPath string
 }
 
@@ -27,6 +28,8 @@ type PythonResult struct {
 	Duration   float64  `json:"duration"`
 	OutputList []string `json:"output_list,omitempty"`
 	ErrorList  []string `json:"error_list,omitempty"`
+	// Usage is the peak memory, CPU time and output size of the code
+	Usage executor.ResourceUsage `json:"usage"`
 }
 
 // NewPythonTool creates a new Python execution tool
@@ -54,6 +57,34 @@ func (t *PythonTool) WithPythonPath(pythonPath string) *PythonTool {
 	return t
 }
 
+// WithBackend sets the backend that commands run on
+func (t *PythonTool) WithBackend(backend executor.Backend) *PythonTool {
+	t.Backend = backend
+	return t
+}
+
+// GetParameters returns the parameter schema for the Python tool
+func (t *PythonTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"code": {
+			Type:        "string",
+			Description: "The Python code to execute",
+		},
+		"working_dir": {
+			Type:        "string",
+			Description: "Optional working directory for the code execution",
+		},
+		"streaming": {
+			Type:        "boolean",
+			Description: "Whether to stream the output in real-time",
+		},
+		"timeout": {
+			Type:        "number",
+			Description: "Optional timeout in seconds",
+		},
+	}, "code")
+}
+
 // Execute runs Python code
 func (t *PythonTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 	// Get the code from parameters
@@ -99,7 +130,7 @@ func (t *PythonTool) Execute(ctx context.Context, params map[string]interface{})
 		t.PythonPath,
 		[]string{filepath.Base(tempFile.Name())},
 		workingDir,
-	).WithTimeout(timeout)
+	).WithTimeout(timeout).WithBackend(t.Backend)
 
 	if streaming {
 		cmd = cmd.WithStreaming()
@@ -120,6 +151,7 @@ func (t *PythonTool) Execute(ctx context.Context, params map[string]interface{})
 		Duration:   result.Duration,
 		OutputList: result.OutputList,
 		ErrorList:  result.ErrorList,
+		Usage:      result.Usage,
 	}
 
 	return pythonResult, nil
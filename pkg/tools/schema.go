@@ -0,0 +1,169 @@
+package tools
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ParameterSchema describes the parameters a tool accepts as a JSON schema object
+type ParameterSchema struct {
+	Type       string                       `json:"type"`
+	Properties map[string]ParameterProperty `json:"properties"`
+	Required   []string                     `json:"required,omitempty"`
+}
+
+// ParameterProperty describes a single tool parameter
+type ParameterProperty struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description"`
+	Enum        []string           `json:"enum,omitempty"`
+	Items       *ParameterProperty `json:"items,omitempty"`
+}
+
+// SchemaProvider is implemented by tools that declare their parameter schema.
+// Tools that do not implement it are presented to the LLM without parameters
+// and their arguments are passed through unchecked.
+type SchemaProvider interface {
+	// GetParameters returns the schema for the tool's parameters
+	GetParameters() ParameterSchema
+}
+
+// NewParameterSchema creates an object schema with the given properties and required names
+func NewParameterSchema(properties map[string]ParameterProperty, required ...string) ParameterSchema {
+	if properties == nil {
+		properties = make(map[string]ParameterProperty)
+	}
+
+	return ParameterSchema{
+		Type:       "object",
+		Properties: properties,
+		Required:   required,
+	}
+}
+
+// GetToolParameters returns the parameter schema for a tool, or an empty
+// object schema if the tool does not declare one
+func GetToolParameters(tool Tool) ParameterSchema {
+	if provider, ok := tool.(SchemaProvider); ok {
+		return provider.GetParameters()
+	}
+
+	return NewParameterSchema(nil)
+}
+
+// ValidateParams checks tool arguments against the tool's declared schema.
+// Unknown parameters are allowed so that tools can accept optional extras.
+func ValidateParams(tool Tool, params map[string]interface{}) error {
+	provider, ok := tool.(SchemaProvider)
+	if !ok {
+		return nil
+	}
+
+	schema := provider.GetParameters()
+	var problems []string
+
+	// Check that all required parameters are present
+	for _, name := range schema.Required {
+		if value, exists := params[name]; !exists || value == nil {
+			problems = append(problems, fmt.Sprintf("missing required parameter %q", name))
+		}
+	}
+
+	// Check the type of every declared parameter that was provided
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, declared := schema.Properties[name]
+		if !declared || params[name] == nil {
+			continue
+		}
+		if err := validateValue(name, property, params[name]); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid arguments for tool %s: %s", tool.GetName(), strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// validateValue checks a single value against a property schema
+func validateValue(name string, property ParameterProperty, value interface{}) error {
+	switch property.Type {
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("parameter %q must be a string", name)
+		}
+		if len(property.Enum) > 0 && !containsString(property.Enum, str) {
+			return fmt.Errorf("parameter %q must be one of [%s]", name, strings.Join(property.Enum, ", "))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("parameter %q must be a boolean", name)
+		}
+	case "number":
+		if _, ok := toFloat(value); !ok {
+			return fmt.Errorf("parameter %q must be a number", name)
+		}
+	case "integer":
+		number, ok := toFloat(value)
+		if !ok || number != math.Trunc(number) {
+			return fmt.Errorf("parameter %q must be an integer", name)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("parameter %q must be an array", name)
+		}
+		if property.Items != nil {
+			for i, item := range items {
+				if err := validateValue(fmt.Sprintf("%s[%d]", name, i), *property.Items, item); err != nil {
+					return err
+				}
+			}
+		}
+	case "object":
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("parameter %q must be an object", name)
+		}
+	}
+
+	return nil
+}
+
+// toFloat converts a JSON-decoded or Go numeric value to a float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// containsString reports whether a slice contains a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/prathyushnallamothu/commandforge/pkg/executor"
+)
+
+// ShellSessionTool runs commands in persistent shell sessions, which keep
+// their working directory, variables and activated environments between calls
+type ShellSessionTool struct {
+	*BaseTool
+	Pool    *executor.SessionPool
+	Timeout time.Duration
+}
+
+// ShellSessionResult represents the result of a shell session operation
+type ShellSessionResult struct {
+	SessionID string `json:"session_id,omitempty"`
+	Output    string `json:"output,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// ExitCode is only set once the command has finished
+	ExitCode *int     `json:"exit_code,omitempty"`
+	Complete bool     `json:"complete"`
+	Closed   bool     `json:"closed,omitempty"`
+	Sessions []string `json:"sessions,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// NewShellSessionTool creates a new shell session tool with its own pool of
+// sessions starting in the working directory
+func NewShellSessionTool(workingDir string) *ShellSessionTool {
+	return &ShellSessionTool{
+		BaseTool: NewBaseTool(
+			"shell_session",
+			"Run commands in a persistent shell session that keeps the working directory, environment variables and activated virtualenvs between commands. Open a session, send commands to it, read the output of long-running commands and close it when done.",
+		),
+		Pool:    executor.NewSessionPool(workingDir),
+		Timeout: 30 * time.Second,
+	}
+}
+
+// WithBackend sets the backend that sessions run on
+func (t *ShellSessionTool) WithBackend(backend executor.Backend) *ShellSessionTool {
+	t.Pool.WithBackend(backend)
+	return t
+}
+
+// WithIdleTimeout sets how long a session may be unused before it is closed
+func (t *ShellSessionTool) WithIdleTimeout(timeout time.Duration) *ShellSessionTool {
+	t.Pool.WithIdleTimeout(timeout)
+	return t
+}
+
+// WithTimeout sets how long send and read wait for a command to finish
+func (t *ShellSessionTool) WithTimeout(timeout time.Duration) *ShellSessionTool {
+	t.Timeout = timeout
+	return t
+}
+
+// GetParameters returns the parameter schema for the shell session tool
+func (t *ShellSessionTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"operation": {
+			Type:        "string",
+			Description: "The operation to perform: open a session, send a command, read more output of a running command, send keystrokes to a terminal session, resize a terminal session, close a session, or list the open sessions",
+			Enum:        []string{"open", "send", "read", "keys", "resize", "close", "list"},
+		},
+		"session_id": {
+			Type:        "string",
+			Description: "The ID of the session, returned by open (required for send, read, keys, resize and close)",
+		},
+		"command": {
+			Type:        "string",
+			Description: "The shell command to send (required for send)",
+		},
+		"keys": {
+			Type:        "string",
+			Description: "The keystrokes to send (required for keys), for example to answer a prompt. Text is typed as is and special keys go in angle brackets, for example \"yes<Enter>\", \"<Up><Enter>\" or \"<C-c>\".",
+		},
+		"working_dir": {
+			Type:        "string",
+			Description: "Optional directory to open the session in",
+		},
+		"pty": {
+			Type:        "boolean",
+			Description: "Whether to open the session in a pseudo-terminal, for programs that prompt for input, such as passwords or confirmations, or only work in a terminal",
+		},
+		"rows": {
+			Type:        "number",
+			Description: "The number of rows of the pseudo-terminal (required for resize, optional for open, default 24)",
+		},
+		"cols": {
+			Type:        "number",
+			Description: "The number of columns of the pseudo-terminal (required for resize, optional for open, default 80)",
+		},
+		"timeout": {
+			Type:        "number",
+			Description: "Optional number of seconds send, read and keys wait for the command to finish",
+		},
+	}, "operation")
+}
+
+// Execute performs a shell session operation
+func (t *ShellSessionTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+	operation, ok := params["operation"].(string)
+	if !ok || operation == "" {
+		return nil, fmt.Errorf("operation parameter is required and must be a string")
+	}
+
+	timeout := t.Timeout
+	if timeoutSec, ok := params["timeout"].(float64); ok && timeoutSec > 0 {
+		timeout = time.Duration(timeoutSec * float64(time.Second))
+	}
+
+	switch operation {
+	case "open":
+		dir, _ := params["working_dir"].(string)
+		var id string
+		var err error
+		if pty, _ := params["pty"].(bool); pty {
+			rows, _ := params["rows"].(float64)
+			cols, _ := params["cols"].(float64)
+			id, err = t.Pool.OpenTerminal(dir, uint16(rows), uint16(cols))
+		} else {
+			id, err = t.Pool.Open(dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &ShellSessionResult{
+			SessionID: id,
+			Complete:  true,
+			Message:   fmt.Sprintf("Session %s opened", id),
+		}, nil
+	case "list":
+		return &ShellSessionResult{
+			Sessions: t.Pool.List(),
+			Complete: true,
+		}, nil
+	}
+
+	id, ok := params["session_id"].(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("session_id parameter is required for %s", operation)
+	}
+
+	switch operation {
+	case "send":
+		command, ok := params["command"].(string)
+		if !ok || command == "" {
+			return nil, fmt.Errorf("command parameter is required for send")
+		}
+		session, err := t.Pool.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		result, err := session.Run(command, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return newShellSessionResult(id, result), nil
+	case "read":
+		session, err := t.Pool.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		result, err := session.Read(timeout)
+		if err != nil {
+			return nil, err
+		}
+		return newShellSessionResult(id, result), nil
+	case "keys":
+		keys, ok := params["keys"].(string)
+		if !ok || keys == "" {
+			return nil, fmt.Errorf("keys parameter is required for keys")
+		}
+		session, err := t.Pool.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := session.SendKeys(keys); err != nil {
+			return nil, err
+		}
+		// The keys usually go to a program waiting for input, so only wait
+		// long for its output if asked to
+		if _, ok := params["timeout"].(float64); !ok {
+			timeout = 2 * time.Second
+		}
+		result, err := session.Read(timeout)
+		if err != nil {
+			return nil, err
+		}
+		return newShellSessionResult(id, result), nil
+	case "resize":
+		rows, _ := params["rows"].(float64)
+		cols, _ := params["cols"].(float64)
+		if rows < 1 || cols < 1 {
+			return nil, fmt.Errorf("rows and cols parameters are required for resize")
+		}
+		session, err := t.Pool.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := session.Resize(uint16(rows), uint16(cols)); err != nil {
+			return nil, err
+		}
+		return &ShellSessionResult{
+			SessionID: id,
+			Complete:  true,
+			Message:   fmt.Sprintf("Terminal resized to %dx%d", int(rows), int(cols)),
+		}, nil
+	case "close":
+		if err := t.Pool.Close(id); err != nil {
+			return nil, err
+		}
+		return &ShellSessionResult{
+			SessionID: id,
+			Complete:  true,
+			Closed:    true,
+			Message:   fmt.Sprintf("Session %s closed", id),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// Close closes every session of the tool
+func (t *ShellSessionTool) Close() error {
+	return t.Pool.CloseAll()
+}
+
+// newShellSessionResult converts the result of a session read
+func newShellSessionResult(id string, result *executor.SessionResult) *ShellSessionResult {
+	converted := &ShellSessionResult{
+		SessionID: id,
+		Output:    strings.Join(result.Output, "\n"),
+		Error:     strings.Join(result.Errors, "\n"),
+		Complete:  result.Complete,
+		Closed:    result.Closed,
+	}
+
+	if result.Complete {
+		exitCode := result.ExitCode
+		converted.ExitCode = &exitCode
+	} else {
+		converted.Message = "The command is still running. Use the read operation to get more of its output, or the keys operation to answer a prompt in a terminal session."
+	}
+	if result.Closed {
+		converted.Message = "The shell has exited. Open a new session to run more commands."
+	}
+
+	return converted
+}
@@ -208,89 +208,87 @@ func paramsToStruct(params map[string]interface{}) (*BrowserParams, error) {
 	return &browserParams, nil
 }
 
+// GetParameters returns the parameter schema for the web browser tool
+func (t *WebBrowserTool) GetParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"action": {
+			Type: "string",
+			Enum: []string{
+				string(ActionNavigate),
+				string(ActionScreenshot),
+				string(ActionClick),
+				string(ActionType),
+				string(ActionScroll),
+				string(ActionWait),
+				string(ActionExtractText),
+				string(ActionHandleDialog),
+				string(ActionMultiStep),
+			},
+			Description: "The browser action to perform (defaults to navigate)",
+		},
+		"url": {
+			Type:        "string",
+			Description: "The URL to navigate to",
+		},
+		"text": {
+			Type:        "string",
+			Description: "The text to type",
+		},
+		"coordinates": {
+			Type:        "array",
+			Items:       &ParameterProperty{Type: "integer"},
+			Description: "The coordinates to click at [x, y]",
+		},
+		"direction": {
+			Type:        "string",
+			Enum:        []string{"up", "down", "left", "right"},
+			Description: "The direction to scroll",
+		},
+		"amount": {
+			Type:        "integer",
+			Description: "The amount to scroll",
+		},
+		"duration": {
+			Type:        "number",
+			Description: "The duration to wait in seconds",
+		},
+		"selector": {
+			Type:        "string",
+			Description: "The CSS selector to extract text from",
+		},
+		"captcha_type": {
+			Type:        "string",
+			Enum:        []string{"recaptcha", "hcaptcha", "generic"},
+			Description: "The type of CAPTCHA to handle (recaptcha, hcaptcha, or generic)",
+		},
+		"dialog_type": {
+			Type:        "string",
+			Enum:        []string{"cookie", "popup", "notification", "generic"},
+			Description: "The type of dialog to handle (cookie consent, popup, etc.)",
+		},
+		"steps": {
+			Type:        "array",
+			Items:       &ParameterProperty{Type: "object"},
+			Description: "Steps for multi-step navigation",
+		},
+		"retries": {
+			Type:        "integer",
+			Description: "Number of retries for an action",
+		},
+		"wait_time": {
+			Type:        "number",
+			Description: "Time to wait after an action in seconds",
+		},
+	})
+}
+
 // GetToolDefinition returns the JSON schema for the tool
 func (t *WebBrowserTool) GetToolDefinition() (string, error) {
 	// Define the tool schema
 	schema := map[string]interface{}{
 		"name":        t.Name,
 		"description": t.Description,
-		"parameters": map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"action": map[string]interface{}{
-					"type": "string",
-					"enum": []string{
-						string(ActionNavigate),
-						string(ActionScreenshot),
-						string(ActionClick),
-						string(ActionType),
-						string(ActionScroll),
-						string(ActionWait),
-						string(ActionExtractText),
-						string(ActionHandleDialog),
-						string(ActionMultiStep),
-					},
-					"description": "The browser action to perform",
-				},
-				"url": map[string]interface{}{
-					"type":        "string",
-					"description": "The URL to navigate to",
-				},
-				"text": map[string]interface{}{
-					"type":        "string",
-					"description": "The text to type",
-				},
-				"coordinates": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"type": "integer",
-					},
-					"description": "The coordinates to click at [x, y]",
-				},
-				"direction": map[string]interface{}{
-					"type":        "string",
-					"enum":        []string{"up", "down", "left", "right"},
-					"description": "The direction to scroll",
-				},
-				"amount": map[string]interface{}{
-					"type":        "integer",
-					"description": "The amount to scroll",
-				},
-				"duration": map[string]interface{}{
-					"type":        "number",
-					"description": "The duration to wait in seconds",
-				},
-				"selector": map[string]interface{}{
-					"type":        "string",
-					"description": "The CSS selector to extract text from",
-				},
-				"captcha_type": map[string]interface{}{
-					"type":        "string",
-					"enum":        []string{"recaptcha", "hcaptcha", "generic"},
-					"description": "The type of CAPTCHA to handle (recaptcha, hcaptcha, or generic)",
-				},
-				"dialog_type": map[string]interface{}{
-					"type":        "string",
-					"enum":        []string{"cookie", "popup", "notification", "generic"},
-					"description": "The type of dialog to handle (cookie consent, popup, etc.)",
-				},
-				"steps": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"type": "object",
-					},
-					"description": "Steps for multi-step navigation",
-				},
-				"retries": map[string]interface{}{
-					"type":        "integer",
-					"description": "Number of retries for an action",
-				},
-				"wait_time": map[string]interface{}{
-					"type":        "number",
-					"description": "Time to wait after an action in seconds",
-				},
-			},
-		},
+		"parameters":  t.GetParameters(),
 	}
 
 	// Convert to JSON
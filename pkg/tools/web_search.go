@@ -49,6 +49,11 @@ func NewWebSearchTool(apiKey string) *WebSearchTool {
 	}
 }
 
+// GetParameters returns the parameter schema for the fallback web search tool
+func (t *FallbackWebSearchTool) GetParameters() ParameterSchema {
+	return webSearchParameters()
+}
+
 // WithMaxResults sets the maximum number of results to return
 func (t *WebSearchTool) WithMaxResults(max int) *WebSearchTool {
 	t.MaxResults = max
@@ -61,6 +66,25 @@ func (t *WebSearchTool) WithTimeout(timeout time.Duration) *WebSearchTool {
 	return t
 }
 
+// GetParameters returns the parameter schema for the web search tool
+func (t *WebSearchTool) GetParameters() ParameterSchema {
+	return webSearchParameters()
+}
+
+// webSearchParameters returns the parameter schema shared by the web search tools
+func webSearchParameters() ParameterSchema {
+	return NewParameterSchema(map[string]ParameterProperty{
+		"query": {
+			Type:        "string",
+			Description: "The search query",
+		},
+		"domain": {
+			Type:        "string",
+			Description: "Optional domain to restrict the search to",
+		},
+	}, "query")
+}
+
 // Execute performs a web search using Tavily API
 func (t *WebSearchTool) Execute(ctx context.Context, params map[string]interface{}) (interface{}, error) {
 	// Log the incoming parameters for debugging